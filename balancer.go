@@ -0,0 +1,143 @@
+package konsul
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// Balancer selects the instance Instancer yields among the current instances
+// of the service. Instancer uses a round-robin Balancer by default, and custom
+// load-balancing strategies can be provided with InstancerConfig.Balancer.
+//
+// Select is invoked concurrently by every caller of Instancer and must be safe
+// for concurrent use. It's only invoked with at least one instance, and must
+// return one of them. The instances must not be modified or retained, since
+// Instancer replaces them on each refresh rather than modifying them in place.
+type Balancer interface {
+	// Select returns one of the instances. The key is provided to
+	// Instancer.NextFor, and is empty when an instance is selected with
+	// Instancer.Next or Instancer.Instance. Balancers that don't route by key
+	// ignore it.
+	Select(instances []string, key string) string
+}
+
+// BalancerFunc is a func type that implements the Balancer interface.
+type BalancerFunc func(instances []string, key string) string
+
+func (f BalancerFunc) Select(instances []string, key string) string {
+	return f(instances, key)
+}
+
+// NewRoundRobinBalancer creates a Balancer selecting the instances in turn.
+// It's lock-free and doesn't allocate, making it suitable for selecting an
+// instance on every request in hot paths.
+func NewRoundRobinBalancer() Balancer {
+	return &roundRobinBalancer{}
+}
+
+type roundRobinBalancer struct {
+	counter atomic.Uint64
+}
+
+func (b *roundRobinBalancer) Select(instances []string, _ string) string {
+	old := b.counter.Add(1) - 1
+	return instances[old%uint64(len(instances))]
+}
+
+// NewRandomBalancer creates a Balancer selecting an instance at random.
+func NewRandomBalancer() Balancer {
+	return BalancerFunc(func(instances []string, _ string) string {
+		return instances[rand.Intn(len(instances))]
+	})
+}
+
+// NewLeastRecentlyUsedBalancer creates a Balancer selecting the instance that
+// was selected the longest time ago, preferring instances that were never
+// selected, such as instances that were just added. Unlike round-robin it
+// spreads the load evenly as instances are added and removed.
+func NewLeastRecentlyUsedBalancer() Balancer {
+	return &lruBalancer{used: make(map[string]uint64)}
+}
+
+type lruBalancer struct {
+	mu sync.Mutex
+	// The sequence of the last selection of each instance.
+	used map[string]uint64
+	seq  uint64
+}
+
+func (b *lruBalancer) Select(instances []string, _ string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Forget the instances that were removed once they outnumber the current
+	// instances so the map doesn't grow as instances come and go.
+	if len(b.used) > 2*len(instances) {
+		used := make(map[string]uint64, len(instances))
+		for _, instance := range instances {
+			if seq, ok := b.used[instance]; ok {
+				used[instance] = seq
+			}
+		}
+		b.used = used
+	}
+
+	selected := instances[0]
+	oldest := b.used[selected]
+	for _, instance := range instances[1:] {
+		if seq := b.used[instance]; seq < oldest {
+			selected, oldest = instance, seq
+		}
+	}
+	b.seq++
+	b.used[selected] = b.seq
+	return selected
+}
+
+// NewConsistentHashBalancer creates a Balancer selecting the instance by hashing
+// the key provided to Instancer.NextFor, such as a user or tenant ID, so that
+// requests for the same key are routed to the same instance. When instances
+// are added or removed only the keys of the instances affected are routed
+// elsewhere.
+//
+// The instance is selected with rendezvous (highest random weight) hashing,
+// which doesn't require maintaining a hash ring as instances change. Every
+// instance selected without a key is the same instance.
+func NewConsistentHashBalancer() Balancer {
+	return BalancerFunc(func(instances []string, key string) string {
+		selected := instances[0]
+		highest := rendezvousWeight(selected, key)
+		for _, instance := range instances[1:] {
+			if w := rendezvousWeight(instance, key); w > highest {
+				selected, highest = instance, w
+			}
+		}
+		return selected
+	})
+}
+
+// rendezvousWeight returns the weight of the instance for the key, the 64-bit
+// FNV-1a hash of both separated by a zero byte, mixed to spread similar
+// instances and keys evenly. It's computed inline rather than with hash/fnv to
+// avoid allocating.
+func rendezvousWeight(instance string, key string) uint64 {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+	h := uint64(offset)
+	for i := 0; i < len(instance); i++ {
+		h ^= uint64(instance[i])
+		h *= prime
+	}
+	h *= prime // zero byte separator
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= prime
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return h
+}
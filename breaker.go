@@ -0,0 +1,237 @@
+package konsul
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is a sentinel error value returned, wrapped, by operations
+// rejected without contacting Consul because the CircuitBreaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed allows every operation. The CircuitBreaker opens once
+	// FailureThreshold consecutive operations fail.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects every operation with ErrCircuitOpen until
+	// OpenTimeout elapses.
+	CircuitOpen
+	// CircuitHalfOpen allows a single operation to probe if Consul recovered.
+	// The CircuitBreaker closes if it succeeds and opens again otherwise.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// CircuitBreakerConfig holds the configuration for a CircuitBreaker.
+type CircuitBreakerConfig struct {
+	// The number of consecutive failed operations opening the CircuitBreaker.
+	// If not provided it defaults to 5.
+	FailureThreshold int
+	// The time the CircuitBreaker stays open before allowing an operation to
+	// probe if Consul recovered. If not provided it defaults to 30s.
+	OpenTimeout time.Duration
+	// An optional func classifying errors as failures. Errors that aren't
+	// failures, such as denied permissions, show Consul is reachable and count
+	// as successes. If not provided IsTransient is used.
+	IsFailure func(err error) bool
+	// An optional callback invoked when the state of the CircuitBreaker
+	// changes. It's invoked while the CircuitBreaker is locked, so it must not
+	// use the CircuitBreaker.
+	OnStateChange func(from CircuitState, to CircuitState)
+	// The Clock used to measure OpenTimeout. If not provided SystemClock is
+	// used.
+	Clock Clock
+}
+
+func (c CircuitBreakerConfig) validate() error {
+	if c.FailureThreshold < 0 {
+		return invalidConfig("circuit breaker FailureThreshold cannot be negative")
+	}
+	if c.OpenTimeout < 0 {
+		return invalidConfig("circuit breaker OpenTimeout cannot be negative")
+	}
+	return nil
+}
+
+// CircuitBreaker fails operations fast while Consul is unavailable rather than
+// letting every call block and time out. A single CircuitBreaker is typically
+// shared by the KVClient and Instancers of an application, since they depend
+// on the same Consul agent:
+//
+//	breaker, err := konsul.NewCircuitBreaker(konsul.CircuitBreakerConfig{})
+//	kv := konsul.NewKVClient(client, konsul.WithCircuitBreaker(breaker))
+//	instancer, err := konsul.NewInstancer(config, konsul.WithCircuitBreaker(breaker))
+//
+// While the CircuitBreaker is open Instancer waits for OpenTimeout to elapse
+// before refreshing its instances and keeps serving the instances it last
+// received. Waiting isn't a failed refresh, so it doesn't count toward the
+// Restart policy of the Instancer. The outcome of its refreshes is recorded,
+// but since blocking queries can take minutes they never act as the probe of a
+// half-open CircuitBreaker.
+//
+// A nil *CircuitBreaker allows every operation. CircuitBreaker is safe for
+// concurrent use.
+type CircuitBreaker struct {
+	cfg CircuitBreakerConfig
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker creates a closed CircuitBreaker. If the configuration is
+// invalid an error wrapping ErrInvalidConfig is returned.
+func NewCircuitBreaker(cfg CircuitBreakerConfig) (*CircuitBreaker, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.FailureThreshold == 0 {
+		cfg.FailureThreshold = 5
+	}
+	if cfg.OpenTimeout == 0 {
+		cfg.OpenTimeout = 30 * time.Second
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = IsTransient
+	}
+	cfg.Clock = ClockOrSystem(cfg.Clock)
+	return &CircuitBreaker{cfg: cfg}, nil
+}
+
+// State returns the current state of the CircuitBreaker.
+func (b *CircuitBreaker) State() CircuitState {
+	if b == nil {
+		return CircuitClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitOpen && b.cfg.Clock.Now().Sub(b.openedAt) >= b.cfg.OpenTimeout {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// Allow returns ErrCircuitOpen if an operation isn't allowed. Otherwise, the
+// operation should be executed and its outcome reported with Record.
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if b.cfg.Clock.Now().Sub(b.openedAt) < b.cfg.OpenTimeout {
+			return ErrCircuitOpen
+		}
+		b.transition(CircuitHalfOpen)
+		b.probing = true
+		return nil
+	case CircuitHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Record reports the outcome of an operation allowed by Allow. A nil error, or
+// an error that isn't classified as a failure, is a success.
+func (b *CircuitBreaker) Record(err error) {
+	if b == nil {
+		return
+	}
+	failed := err != nil && b.cfg.IsFailure(err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		b.probing = false
+		if b.state != CircuitClosed {
+			b.transition(CircuitClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.cfg.FailureThreshold {
+		b.probing = false
+		b.openedAt = b.cfg.Clock.Now()
+		if b.state != CircuitOpen {
+			b.transition(CircuitOpen)
+		}
+	}
+}
+
+// openRemaining returns the time left until OpenTimeout elapses if the
+// CircuitBreaker is open, otherwise zero.
+func (b *CircuitBreaker) openRemaining() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != CircuitOpen {
+		return 0
+	}
+	return b.cfg.OpenTimeout - b.cfg.Clock.Now().Sub(b.openedAt)
+}
+
+// wait blocks until the CircuitBreaker is no longer open, or returns ctx.Err()
+// once ctx is done. Unlike Allow it doesn't claim the probe of a half-open
+// CircuitBreaker, which is used by long-running blocking queries that would
+// otherwise hold the probe for minutes.
+func (b *CircuitBreaker) wait(ctx context.Context) error {
+	for {
+		remaining := b.openRemaining()
+		if remaining <= 0 {
+			return nil
+		}
+		select {
+		case <-b.cfg.Clock.After(remaining):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Do executes the operation if it's allowed and records its outcome. If the
+// operation isn't allowed ErrCircuitOpen is returned.
+func (b *CircuitBreaker) Do(fn func() error) error {
+	if err := b.Allow(); err != nil {
+		return err
+	}
+	err := fn()
+	b.Record(err)
+	return err
+}
+
+func (b *CircuitBreaker) transition(to CircuitState) {
+	from := b.state
+	b.state = to
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(from, to)
+	}
+}
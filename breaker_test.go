@@ -0,0 +1,37 @@
+package konsul
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker_WaitForOpenTimeout(t *testing.T) {
+	breaker, err := NewCircuitBreaker(CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenTimeout:      50 * time.Millisecond,
+		IsFailure:        func(error) bool { return true },
+	})
+	if err != nil {
+		t.Fatalf("failed to create circuit breaker: %v", err)
+	}
+	breaker.Record(errors.New("unavailable"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := breaker.wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	start := time.Now()
+	if err := breaker.wait(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("expected to wait for OpenTimeout, waited %v", elapsed)
+	}
+	if state := breaker.State(); state != CircuitHalfOpen {
+		t.Errorf("expected %v, got %v", CircuitHalfOpen, state)
+	}
+}
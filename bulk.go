@@ -0,0 +1,103 @@
+package konsul
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// GetMany retrieves multiple key-values using transactions, avoiding a round
+// trip per key. Keys that don't exist are omitted from the returned map. Up to
+// MaxTxnOps keys are retrieved per transaction, so the key-values of more keys
+// may not be a consistent snapshot. If an error occurs communicating with
+// Consul a non-nil error value will be returned.
+func (c KVClient) GetMany(keys []string) (map[string]KeyValue, error) {
+	kvs := make(map[string]KeyValue, len(keys))
+	for _, batch := range batchKeys(keys, MaxTxnOps) {
+		txn := c.Txn()
+		for _, key := range batch {
+			txn.add(&api.KVTxnOp{Verb: api.KVGetOrEmpty, Key: key})
+		}
+		result, err := txn.Commit()
+		if err == nil && !result.Committed {
+			err = &KVError{Op: OpGet, Err: fmt.Errorf("failed to retrieve keys: %v", result.Errors)}
+		}
+		if err != nil {
+			c.logger.Debug("failed to retrieve KVs from Consul", "keys", len(batch), "error", err)
+			return nil, err
+		}
+		for _, kv := range result.KeyValues {
+			// Keys that don't exist are returned empty with a zero index.
+			if kv.ModifyIndex() != 0 {
+				kvs[kv.Key()] = kv
+			}
+		}
+	}
+	c.logger.Debug("retrieved KVs from Consul", "keys", len(keys), "found", len(kvs))
+	return kvs, nil
+}
+
+// PutMany sets the values of multiple keys using transactions, avoiding a
+// round trip per key. Values are validated against the Schemas of the KVClient
+// before any is written. When there are at most MaxTxnOps keys they're written
+// atomically: either every value is written or none are. More keys are written
+// in batches of MaxTxnOps, each applied atomically, so a failure may leave
+// earlier batches written. When the KVClient keeps history each value is
+// written along with a snapshot, so only up to MaxTxnOps/2 (32) keys are
+// written atomically and larger maps are written in batches of that size. If
+// the operation fails a non-nil error value is returned.
+func (c KVClient) PutMany(kvs map[string][]byte) error {
+	keys := make([]string, 0, len(kvs))
+	for key, value := range kvs {
+		if err := c.schemas.Validate(key, value); err != nil {
+			return &KVError{Op: OpPut, Key: key, Err: err}
+		}
+		keys = append(keys, key)
+	}
+	size := MaxTxnOps
+	if c.history != nil {
+		size = MaxTxnOps / 2
+	}
+	for _, batch := range batchKeys(keys, size) {
+		txn := c.Txn()
+		for _, key := range batch {
+			txn.Put(key, kvs[key])
+		}
+		result, err := txn.Commit()
+		if err == nil && !result.Committed {
+			err = &KVError{Op: OpPut, Err: fmt.Errorf("failed to put keys: %v", result.Errors)}
+		}
+		if err != nil {
+			c.logger.Debug("failed to put KVs in Consul", "keys", len(batch), "error", err)
+			return err
+		}
+	}
+	c.logger.Debug("put KVs in Consul", "keys", len(keys))
+	return nil
+}
+
+// batchKeys sorts and deduplicates the keys and splits them in batches of at
+// most size keys.
+func batchKeys(keys []string, size int) [][]string {
+	sorted := make([]string, len(keys))
+	copy(sorted, keys)
+	sort.Strings(sorted)
+	unique := sorted[:0]
+	for i, key := range sorted {
+		if i == 0 || key != sorted[i-1] {
+			unique = append(unique, key)
+		}
+	}
+
+	var batches [][]string
+	for len(unique) > 0 {
+		n := len(unique)
+		if n > size {
+			n = size
+		}
+		batches = append(batches, unique[:n])
+		unique = unique[n:]
+	}
+	return batches
+}
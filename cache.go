@@ -0,0 +1,418 @@
+package konsul
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// CacheConfig holds the configuration for a CachedKVClient.
+type CacheConfig struct {
+	// The time a retrieved key-value is served from the cache before it's
+	// retrieved from Consul again. If not provided it defaults to 30s.
+	TTL time.Duration
+	// An optional interval at which every cached key is refreshed in the
+	// background, so reads are served from the cache without waiting for
+	// Consul. When 0 keys are only retrieved when read after their TTL
+	// expires.
+	RefreshInterval time.Duration
+	// When true an expired key-value is served if retrieving it from Consul
+	// fails, such as when Consul is unavailable, rather than returning the
+	// error.
+	StaleOnError bool
+	// An optional limit of how long after its TTL expires a key-value is served
+	// when StaleOnError is enabled. If not provided stale key-values are served
+	// regardless of their age.
+	MaxStale time.Duration
+	// The logger used to log cache misses and refreshes at Debug level, and
+	// stale key-values being served at Warn level. If not provided
+	// CachedKVClient doesn't log.
+	Logger Logger
+	// The Clock used to expire key-values and schedule refreshes. If not
+	// provided SystemClock is used.
+	Clock Clock
+}
+
+func (c CacheConfig) validate() error {
+	if c.TTL < 0 {
+		return invalidConfig("cache TTL cannot be negative")
+	}
+	if c.RefreshInterval < 0 {
+		return invalidConfig("cache RefreshInterval cannot be negative")
+	}
+	if c.MaxStale < 0 {
+		return invalidConfig("cache MaxStale cannot be negative")
+	}
+	return nil
+}
+
+// CachedKVClient is a read-through cache in front of a KV, serving Get from
+// memory for keys retrieved within the TTL, which greatly reduces the load on
+// Consul when the same keys are read on every request. Keys that don't exist
+// are cached as well.
+//
+//	kv, err := konsul.NewCachedKVClient(client, konsul.CacheConfig{
+//		TTL:          time.Minute,
+//		StaleOnError: true,
+//	})
+//	defer kv.Close()
+//
+// Writes made through CachedKVClient are passed to the underlying KV and
+// invalidate the cached keys they modify, but changes made by other clients
+// are only observed once the TTL expires or the key is refreshed. Reads made
+// with QueryOptions, as well as List, Keys, GetLarge, and GetMany, bypass the
+// cache. Cached reads ignore allowStale.
+//
+// The zero-value of CachedKVClient is not usable. Use NewCachedKVClient to
+// create and initialize a CachedKVClient.
+type CachedKVClient struct {
+	KV
+
+	cfg    CacheConfig
+	logger hclog.Logger
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	// Invalidations are versioned so values retrieved before a key was
+	// invalidated aren't stored after it. version is incremented on every
+	// invalidation, recorded per key in versions, or in prefixVersion for
+	// invalidated prefixes.
+	version       uint64
+	versions      map[string]uint64
+	prefixVersion uint64
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ KV = (*CachedKVClient)(nil)
+
+type cacheEntry struct {
+	kv      KeyValue
+	fetched time.Time
+}
+
+// NewCachedKVClient creates a CachedKVClient caching the reads of the KV, and
+// starts refreshing cached keys in the background if RefreshInterval is
+// provided. If the KV is nil or the configuration is invalid an error wrapping
+// ErrInvalidConfig is returned.
+func NewCachedKVClient(kv KV, cfg CacheConfig) (*CachedKVClient, error) {
+	if kv == nil {
+		return nil, invalidConfig("a KV must be provided to cache")
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = 30 * time.Second
+	}
+	cfg.Clock = ClockOrSystem(cfg.Clock)
+	logger := hclog.NewNullLogger()
+	if cfg.Logger != nil {
+		logger = HclogAdapter(cfg.Logger)
+	}
+
+	c := &CachedKVClient{
+		KV:       kv,
+		cfg:      cfg,
+		logger:   logger,
+		entries:  make(map[string]cacheEntry),
+		versions: make(map[string]uint64),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if cfg.RefreshInterval > 0 {
+		go c.refresh()
+	} else {
+		close(c.done)
+	}
+	return c, nil
+}
+
+// Close stops refreshing cached keys in the background. The CachedKVClient
+// remains usable, but no longer refreshes keys.
+func (c *CachedKVClient) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		<-c.done
+	})
+}
+
+// Get retrieves a key-value, serving it from the cache if it was retrieved
+// within the TTL. If retrieving the key-value fails and StaleOnError is
+// enabled, the expired key-value is served instead of the error. Requests with
+// QueryOptions bypass the cache.
+func (c *CachedKVClient) Get(key string, allowStale bool, opts ...QueryOption) (KeyValue, error) {
+	if len(opts) > 0 {
+		return c.KV.Get(key, allowStale, opts...)
+	}
+
+	now := c.cfg.Clock.Now()
+	c.mu.Lock()
+	entry, cached := c.entries[key]
+	version := c.keyVersion(key)
+	c.mu.Unlock()
+	if cached && now.Sub(entry.fetched) < c.cfg.TTL {
+		return entry.kv, nil
+	}
+
+	c.logger.Debug("cache miss, retrieving KV", "key", key)
+	kv, err := c.KV.Get(key, allowStale)
+	if err != nil {
+		if cached && c.servable(entry, now) {
+			c.logger.Warn("failed to retrieve KV, serving stale value from cache",
+				"key", key, "age", now.Sub(entry.fetched), "error", err)
+			return entry.kv, nil
+		}
+		return KeyValue{}, err
+	}
+	c.store(key, kv, now, version)
+	return kv, nil
+}
+
+// MustGet retrieves a key-value like Get. If an error occurs, or the key doesn't
+// exist this will panic.
+func (c *CachedKVClient) MustGet(key string, allowStale bool, opts ...QueryOption) KeyValue {
+	kv, err := c.Get(key, allowStale, opts...)
+	if err != nil {
+		panic(fmt.Errorf("error retrieving key %s from Consul: %w", key, err))
+	}
+	if kv.base == nil {
+		panic(fmt.Errorf("key %s doesn't exist", key))
+	}
+	return kv
+}
+
+// GetOrDefault retrieves the value of a key like Get, returning fallback if
+// the key doesn't exist.
+func (c *CachedKVClient) GetOrDefault(key string, fallback []byte) ([]byte, error) {
+	kv, err := c.Get(key, false)
+	if err != nil {
+		return nil, err
+	}
+	if kv.base == nil {
+		return fallback, nil
+	}
+	return kv.RawValue(), nil
+}
+
+// GetOrPut retrieves a key-value, setting its value to initial first if the key
+// doesn't exist, and invalidates it.
+func (c *CachedKVClient) GetOrPut(key string, initial []byte) (KeyValue, error) {
+	defer c.Invalidate(key)
+	return c.KV.GetOrPut(key, initial)
+}
+
+// Invalidate removes the key from the cache, so the next Get retrieves it from
+// Consul.
+func (c *CachedKVClient) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+	c.version++
+	c.versions[key] = c.version
+}
+
+// InvalidatePrefix removes every key under the prefix from the cache.
+func (c *CachedKVClient) InvalidatePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+	c.version++
+	c.prefixVersion = c.version
+}
+
+// Len returns the number of key-values in the cache, including expired ones.
+func (c *CachedKVClient) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Put sets the value of the key and invalidates it.
+func (c *CachedKVClient) Put(key string, value []byte, opts ...WriteOption) error {
+	defer c.Invalidate(key)
+	return c.KV.Put(key, value, opts...)
+}
+
+// MustPut sets the value of the key and invalidates it. If the operation fails
+// this will panic.
+func (c *CachedKVClient) MustPut(key string, value []byte, opts ...WriteOption) {
+	defer c.Invalidate(key)
+	c.KV.MustPut(key, value, opts...)
+}
+
+// PutJSON sets the value of the key encoded as JSON and invalidates it.
+func (c *CachedKVClient) PutJSON(key string, v any, opts ...WriteOption) error {
+	defer c.Invalidate(key)
+	return c.KV.PutJSON(key, v, opts...)
+}
+
+// MustPutJSON sets the value of the key encoded as JSON and invalidates it. If
+// the operation fails this will panic.
+func (c *CachedKVClient) MustPutJSON(key string, v any, opts ...WriteOption) {
+	defer c.Invalidate(key)
+	c.KV.MustPutJSON(key, v, opts...)
+}
+
+// PutYAML sets the value of the key encoded as YAML and invalidates it.
+func (c *CachedKVClient) PutYAML(key string, v any, opts ...WriteOption) error {
+	defer c.Invalidate(key)
+	return c.KV.PutYAML(key, v, opts...)
+}
+
+// MustPutYAML sets the value of the key encoded as YAML and invalidates it. If
+// the operation fails this will panic.
+func (c *CachedKVClient) MustPutYAML(key string, v any, opts ...WriteOption) {
+	defer c.Invalidate(key)
+	c.KV.MustPutYAML(key, v, opts...)
+}
+
+// PutCAS sets the value of the key using a Check-And-Set operation and
+// invalidates it.
+func (c *CachedKVClient) PutCAS(key string, value []byte, modifyIndex uint64) (bool, error) {
+	defer c.Invalidate(key)
+	return c.KV.PutCAS(key, value, modifyIndex)
+}
+
+// Delete removes the key and invalidates it.
+func (c *CachedKVClient) Delete(key string, opts ...WriteOption) error {
+	defer c.Invalidate(key)
+	return c.KV.Delete(key, opts...)
+}
+
+// DeleteCAS removes the key using a Check-And-Set operation and invalidates it.
+func (c *CachedKVClient) DeleteCAS(key string, modifyIndex uint64) (bool, error) {
+	defer c.Invalidate(key)
+	return c.KV.DeleteCAS(key, modifyIndex)
+}
+
+// DeleteTree removes every key under the prefix and invalidates them.
+func (c *CachedKVClient) DeleteTree(prefix string) error {
+	defer c.InvalidatePrefix(prefix)
+	return c.KV.DeleteTree(prefix)
+}
+
+// Acquire sets the value of the key and locks it with the session, and
+// invalidates it.
+func (c *CachedKVClient) Acquire(key string, value []byte, sessionID string, opts ...WriteOption) (bool, error) {
+	defer c.Invalidate(key)
+	return c.KV.Acquire(key, value, sessionID, opts...)
+}
+
+// Release unlocks the key held by the session and invalidates it.
+func (c *CachedKVClient) Release(key string, sessionID string, opts ...WriteOption) (bool, error) {
+	defer c.Invalidate(key)
+	return c.KV.Release(key, sessionID, opts...)
+}
+
+// PutLarge sets the value of the key, splitting it in chunks if needed, and
+// invalidates it.
+func (c *CachedKVClient) PutLarge(key string, value []byte) error {
+	defer c.Invalidate(key)
+	return c.KV.PutLarge(key, value)
+}
+
+// DeleteLarge removes the key and its chunks and invalidates it.
+func (c *CachedKVClient) DeleteLarge(key string) error {
+	defer c.Invalidate(key)
+	return c.KV.DeleteLarge(key)
+}
+
+// PutMany sets the values of the keys and invalidates them.
+func (c *CachedKVClient) PutMany(kvs map[string][]byte) error {
+	defer func() {
+		for key := range kvs {
+			c.Invalidate(key)
+		}
+	}()
+	return c.KV.PutMany(kvs)
+}
+
+// Increment atomically adds delta to the counter stored in the key and
+// invalidates it.
+func (c *CachedKVClient) Increment(key string, delta int64) (int64, error) {
+	defer c.Invalidate(key)
+	return c.KV.Increment(key, delta)
+}
+
+// store caches the key-value unless the key was invalidated since version was
+// read with keyVersion, in which case the key-value may predate the write that
+// invalidated it.
+func (c *CachedKVClient) store(key string, kv KeyValue, fetched time.Time, version uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keyVersion(key) != version {
+		return
+	}
+	c.entries[key] = cacheEntry{kv: kv, fetched: fetched}
+}
+
+// keyVersion returns the version of the last invalidation of the key. The
+// lock must be held by the caller.
+func (c *CachedKVClient) keyVersion(key string) uint64 {
+	version := c.versions[key]
+	if c.prefixVersion > version {
+		return c.prefixVersion
+	}
+	return version
+}
+
+// servable returns true if the expired entry can be served because retrieving
+// the key failed.
+func (c *CachedKVClient) servable(entry cacheEntry, now time.Time) bool {
+	if !c.cfg.StaleOnError {
+		return false
+	}
+	return c.cfg.MaxStale == 0 || now.Sub(entry.fetched) < c.cfg.TTL+c.cfg.MaxStale
+}
+
+// refresh retrieves every cached key each RefreshInterval until Close is
+// called. Keys that fail to be retrieved keep their cached value until it
+// expires.
+func (c *CachedKVClient) refresh() {
+	defer close(c.done)
+	ticker := c.cfg.Clock.NewTicker(c.cfg.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C():
+		}
+
+		c.mu.Lock()
+		keys := make([]string, 0, len(c.entries))
+		for key := range c.entries {
+			keys = append(keys, key)
+		}
+		c.mu.Unlock()
+
+		for _, key := range keys {
+			now := c.cfg.Clock.Now()
+			c.mu.Lock()
+			_, cached := c.entries[key]
+			version := c.keyVersion(key)
+			c.mu.Unlock()
+			if !cached {
+				continue
+			}
+			kv, err := c.KV.Get(key, false)
+			if err != nil {
+				c.logger.Debug("failed to refresh cached KV", "key", key, "error", err)
+				continue
+			}
+			// Keys invalidated while being refreshed are left out.
+			c.store(key, kv, now, version)
+		}
+		c.logger.Debug("refreshed cached KVs", "count", len(keys))
+	}
+}
@@ -0,0 +1,51 @@
+package konsul
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// racyKV is a KV whose Get calls during after reading the value, allowing a
+// write to be interleaved with the read.
+type racyKV struct {
+	KV
+	value  string
+	during func()
+}
+
+func (kv *racyKV) Get(key string, _ bool, _ ...QueryOption) (KeyValue, error) {
+	value := kv.value
+	if kv.during != nil {
+		during := kv.during
+		kv.during = nil
+		during()
+	}
+	return KeyValue{base: &api.KVPair{Key: key, Value: []byte(value)}}, nil
+}
+
+func (kv *racyKV) Put(_ string, value []byte, _ ...WriteOption) error {
+	kv.value = string(value)
+	return nil
+}
+
+func TestCachedKVClient_GetDoesNotCacheValueInvalidatedDuringFetch(t *testing.T) {
+	kv := &racyKV{value: "old"}
+	cache, err := NewCachedKVClient(kv, CacheConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	kv.during = func() {
+		if err := cache.Put("key", []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := cache.Get("key", false); string(got.RawValue()) != "old" {
+		t.Fatalf("expected the value retrieved before the write, got %q", got.RawValue())
+	}
+	if got, _ := cache.Get("key", false); string(got.RawValue()) != "new" {
+		t.Errorf("expected the value written through the cache, got %q", got.RawValue())
+	}
+}
@@ -0,0 +1,448 @@
+package konsul
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/api/watch"
+	"github.com/hashicorp/go-hclog"
+)
+
+// ErrClientClosed is a sentinel error value indicating the Client has been
+// closed.
+var ErrClientClosed = errors.New("client is closed")
+
+// TokenSource supplies the ACL token sent with each request to Consul, allowing
+// tokens to be rotated without recreating the Client.
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// StaticToken is a TokenSource always returning the same token.
+type StaticToken string
+
+// Token returns the token.
+func (t StaticToken) Token() (string, error) {
+	return string(t), nil
+}
+
+// ClientConfig holds the configuration properties of a Client. The zero-value
+// is valid and connects to the local Consul agent using api.DefaultConfig.
+type ClientConfig struct {
+	// The configuration of the Consul api Client. If not provided
+	// api.DefaultConfig is used.
+	Config *api.Config
+	// An optional namespace used for all requests. Namespaces require Consul
+	// Enterprise.
+	Namespace string
+	// An optional source of the ACL token sent with each request. The token of
+	// Config, if set, takes precedence.
+	TokenSource TokenSource
+	// The logger shared by the components of the Client. If not provided a
+	// default logger will be used.
+	Logger Logger
+	// An optional minimum level for messages logged by the components of the
+	// Client. The zero-value hclog.NoLevel doesn't filter any messages.
+	LogLevel hclog.Level
+	// Optional instrumentation hooks shared by the components of the Client.
+	Metrics Metrics
+}
+
+// Client composes the konsul components behind a single object sharing the same
+// Consul api Client, logger, and metrics. Watches, Instancers, service
+// registrations, and locks created through Client are tracked so that Close can
+// tear them all down.
+//
+// The zero-value of Client is not usable. Use NewClient to create and
+// initialize a new instance of Client.
+type Client struct {
+	client *api.Client
+	kv     *KVClient
+	logger hclog.Logger
+	shared []Option
+
+	mu            sync.Mutex
+	closed        bool
+	watches       sync.WaitGroup
+	plans         map[*watch.Plan]struct{}
+	instancers    map[string]*Instancer
+	uncached      map[*Instancer]struct{}
+	registrations map[string]struct{}
+	locks         map[*api.Lock]struct{}
+}
+
+// NewClient creates and initializes a new Client. If the Consul api Client
+// cannot be created a non-nil error is returned.
+func NewClient(config ClientConfig) (*Client, error) {
+	// The configuration is copied so the caller's configuration, and the
+	// http.Client it references, are never modified.
+	cfg := api.DefaultConfig()
+	if config.Config != nil {
+		copied := *config.Config
+		cfg = &copied
+	}
+	if config.Namespace != "" {
+		cfg.Namespace = config.Namespace
+	}
+	if config.TokenSource != nil && cfg.HttpClient != nil {
+		// The http.Client provided may be shared, such as http.DefaultClient,
+		// so the token is only applied to a copy of it.
+		httpClient := *cfg.HttpClient
+		cfg.HttpClient = &httpClient
+	}
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Consul client: %w", err)
+	}
+	if config.TokenSource != nil {
+		// The api Client shares the http.Client referenced by the configuration,
+		// which is either the copy made above or created by api.NewClient, so
+		// wrapping its transport applies the token to every request.
+		cfg.HttpClient.Transport = &tokenTransport{
+			base:   cfg.HttpClient.Transport,
+			source: config.TokenSource,
+		}
+	}
+
+	shared := []Option{
+		WithLogger(config.Logger),
+		WithLogLevel(config.LogLevel),
+		WithMetrics(config.Metrics),
+	}
+	kv, err := TryNewKVClient(client, shared[0], shared[1], shared[2])
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		client:        client,
+		kv:            kv,
+		logger:        withLevel(HclogAdapter(config.Logger), config.LogLevel),
+		shared:        shared,
+		plans:         make(map[*watch.Plan]struct{}),
+		instancers:    make(map[string]*Instancer),
+		uncached:      make(map[*Instancer]struct{}),
+		registrations: make(map[string]struct{}),
+		locks:         make(map[*api.Lock]struct{}),
+	}, nil
+}
+
+// API returns the underlying Consul api Client.
+func (c *Client) API() *api.Client {
+	return c.client
+}
+
+// KV returns the KVClient sharing the configuration of the Client.
+func (c *Client) KV() *KVClient {
+	return c.kv
+}
+
+// Peering returns a PeeringClient using the Consul api Client.
+func (c *Client) Peering() *PeeringClient {
+	return &PeeringClient{client: c.client}
+}
+
+// Watch watches a key like the Watch function, using the shared configuration
+// of the Client. The options provided take precedence over the shared
+// configuration. Unlike the Watch function, Watch returns a nil error once the
+// Client is closed.
+func (c *Client) Watch(key string, cfg encoding.BinaryUnmarshaler, opts ...WatchOption) error {
+	return c.WatchContext(context.Background(), key, cfg, opts...)
+}
+
+// WatchContext watches a key like Watch until the context is cancelled or the
+// Client is closed, at which point it returns nil.
+func (c *Client) WatchContext(ctx context.Context, key string, cfg encoding.BinaryUnmarshaler, opts ...WatchOption) error {
+	w, err := newKeyWatch(c.client, key, cfg, BuildWatchOptions(c.watchOptions(opts)...))
+	if err != nil {
+		return err
+	}
+	if err := w.fetch(c.client); err != nil {
+		return err
+	}
+	return c.runWatchPlan(ctx, key, w.plan, w.logger, w.opts.Errors)
+}
+
+// WatchPrefix watches every key under a prefix like the WatchPrefix function,
+// using the shared configuration of the Client, until the context is cancelled
+// or the Client is closed, at which point it returns nil.
+func (c *Client) WatchPrefix(ctx context.Context, prefix string, handler PrefixHandler, opts ...WatchOption) error {
+	options := BuildWatchOptions(c.watchOptions(opts)...)
+	plan, logger, err := newPrefixWatchPlan(c.client, prefix, handler, options)
+	if err != nil {
+		return err
+	}
+	return c.runWatchPlan(ctx, prefix, plan, logger, options.Errors)
+}
+
+// runWatchPlan runs the plan, tracking it so it's stopped when the Client is
+// closed.
+func (c *Client) runWatchPlan(ctx context.Context, key string, plan *watch.Plan, logger hclog.Logger,
+	errs chan<- error) error {
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClientClosed
+	}
+	c.plans[plan] = struct{}{}
+	c.watches.Add(1)
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.plans, plan)
+		c.mu.Unlock()
+		c.watches.Done()
+	}()
+	return runWatchPlan(ctx, c.client, key, plan, logger, errs)
+}
+
+// Instancer returns an Instancer for the service, creating it if the Client
+// doesn't already have one for the service with the same tags, peer, filtering,
+// health policy, and backend. Instancers configured with a Balancer, restart
+// policy, or error hooks are specific to the caller and are never shared. The
+// options provided take precedence over the shared configuration. The
+// Instancer is closed when the Client is closed.
+func (c *Client) Instancer(service string, opts ...InstancerOption) (*Instancer, error) {
+	config := InstancerConfig{
+		Client:  c.client,
+		Service: service,
+	}
+	for _, opt := range c.shared {
+		opt.applyInstancer(&config)
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt.applyInstancer(&config)
+		}
+	}
+	id, cacheable := instancerKey(config)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	if !cacheable {
+		instancer, err := NewInstancer(config)
+		if err != nil {
+			return nil, err
+		}
+		c.uncached[instancer] = struct{}{}
+		return instancer, nil
+	}
+	if instancer, ok := c.instancers[id]; ok && !instancer.closed.Load() {
+		return instancer, nil
+	}
+	instancer, err := NewInstancer(config)
+	if err != nil {
+		return nil, err
+	}
+	c.instancers[id] = instancer
+	return instancer, nil
+}
+
+// instancerKey returns the key identifying Instancers created with the config,
+// built from every field that affects the query or the instances yielded. It
+// returns false if the config holds state specific to the caller, in which
+// case the Instancer must not be shared.
+func instancerKey(config InstancerConfig) (string, bool) {
+	if config.Balancer != nil || config.Restart != nil || config.OnPlanError != nil || config.OnError != nil {
+		return "", false
+	}
+	fields := []string{
+		config.Service,
+		config.Tag,
+		config.Peer,
+		strconv.FormatBool(config.PassingOnly),
+		config.HealthPolicy.String(),
+		strconv.FormatBool(config.AllowStale),
+		config.Backend.String(),
+		config.Filter,
+		encodeTagSet(config.Tags),
+		encodeTagSet(config.AnyTags),
+		config.TagExpression,
+	}
+	var b strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			b.WriteByte('|')
+		}
+		// Quoting keeps the fields unambiguous when they contain the separator.
+		b.WriteString(strconv.Quote(field))
+	}
+	return b.String(), true
+}
+
+// encodeTagSet encodes the tags independent of their order, since it doesn't
+// affect the instances matched.
+func encodeTagSet(tags []string) string {
+	sorted := make([]string, len(tags))
+	for i, tag := range tags {
+		sorted[i] = strconv.Quote(tag)
+	}
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// Register registers the service with the local Consul agent. Services
+// registered through the Client are deregistered when the Client is closed.
+func (c *Client) Register(reg *api.AgentServiceRegistration) error {
+	if reg == nil || reg.Name == "" {
+		return invalidConfig("a service registration with a name must be provided")
+	}
+	id := reg.ID
+	if id == "" {
+		id = reg.Name
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClientClosed
+	}
+	if err := c.client.Agent().ServiceRegister(reg); err != nil {
+		return fmt.Errorf("error registering service %s: %w", id, err)
+	}
+	c.registrations[id] = struct{}{}
+	c.logger.Info("registered service", "service", reg.Name, "id", id)
+	return nil
+}
+
+// Deregister deregisters the service with the provided ID, or name if the
+// service was registered without an ID, from the local Consul agent.
+func (c *Client) Deregister(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deregister(id)
+}
+
+func (c *Client) deregister(id string) error {
+	if err := c.client.Agent().ServiceDeregister(id); err != nil {
+		return fmt.Errorf("error deregistering service %s: %w", id, err)
+	}
+	delete(c.registrations, id)
+	c.logger.Info("deregistered service", "id", id)
+	return nil
+}
+
+// Lock creates a lock on the key using a Consul session. The lock isn't held
+// until Lock is called on the returned api.Lock. Locks created through the
+// Client are released when the Client is closed.
+func (c *Client) Lock(key string) (*api.Lock, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	lock, err := c.client.LockKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating lock on key %s: %w", key, err)
+	}
+	c.locks[lock] = struct{}{}
+	return lock, nil
+}
+
+// Close tears down everything created through the Client, in order:
+//
+//  1. Services registered are deregistered so no new traffic is routed to the
+//     application.
+//  2. Locks held are released.
+//  3. Watches are stopped, returning a nil error to their callers, and Close
+//     waits for the calls to Watch to return.
+//  4. Instancers are closed.
+//
+// Close continues tearing down after a failure and returns the first error
+// encountered, logging the rest. Subsequent calls to Close have no effect.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+
+	var first error
+	record := func(err error) {
+		if err == nil {
+			return
+		}
+		c.logger.Error("error closing client", "error", err)
+		if first == nil {
+			first = err
+		}
+	}
+
+	for id := range c.registrations {
+		record(c.deregister(id))
+	}
+	for lock := range c.locks {
+		if err := lock.Unlock(); err != nil && err != api.ErrLockNotHeld {
+			record(fmt.Errorf("error releasing lock: %w", err))
+		}
+	}
+	for plan := range c.plans {
+		stopPlan(plan)
+	}
+	instancers := make([]*Instancer, 0, len(c.instancers)+len(c.uncached))
+	for _, instancer := range c.instancers {
+		instancers = append(instancers, instancer)
+	}
+	for instancer := range c.uncached {
+		instancers = append(instancers, instancer)
+	}
+	c.mu.Unlock()
+
+	// Watch removes its plan once it returns, so the lock must be released
+	// while waiting. No plans or Instancers are added once the Client is
+	// closed.
+	c.watches.Wait()
+	for _, instancer := range instancers {
+		instancer.Close()
+	}
+	return first
+}
+
+func (c *Client) watchOptions(opts []WatchOption) []WatchOption {
+	all := make([]WatchOption, 0, len(c.shared)+len(opts))
+	for _, opt := range c.shared {
+		all = append(all, opt)
+	}
+	return append(all, opts...)
+}
+
+// tokenTransport sets the ACL token from a TokenSource on requests that don't
+// already carry one.
+type tokenTransport struct {
+	base   http.RoundTripper
+	source TokenSource
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("X-Consul-Token") == "" {
+		token, err := t.source.Token()
+		if err != nil {
+			if req.Body != nil {
+				_ = req.Body.Close()
+			}
+			return nil, fmt.Errorf("error retrieving Consul ACL token: %w", err)
+		}
+		if token != "" {
+			req = req.Clone(req.Context())
+			req.Header.Set("X-Consul-Token", token)
+		}
+	}
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,32 @@
+package konsul
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestNewClient_LeavesConfigUnchanged(t *testing.T) {
+	shared := &http.Client{}
+	cfg := api.DefaultConfig()
+	cfg.HttpClient = shared
+
+	for i := 0; i < 2; i++ {
+		client, err := NewClient(ClientConfig{
+			Config:      cfg,
+			Namespace:   "team",
+			TokenSource: StaticToken("secret"),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		client.Close()
+	}
+	if shared.Transport != nil {
+		t.Errorf("expected the shared http.Client not to be modified, got transport %T", shared.Transport)
+	}
+	if cfg.Namespace != "" {
+		t.Errorf("expected the namespace of the config not to be modified, got %q", cfg.Namespace)
+	}
+}
@@ -0,0 +1,102 @@
+package konsul
+
+import (
+	"time"
+)
+
+// Clock provides the current time and timers to time-based logic such as
+// retries, debouncing, and renewals, allowing tests to advance time
+// deterministically rather than relying on real sleeps. The konsultest package
+// provides a Clock that is advanced manually.
+//
+// Components accepting a Clock use SystemClock when one isn't provided.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time on
+	// the returned channel.
+	After(d time.Duration) <-chan time.Time
+	// NewTimer creates a Timer that sends the current time on its channel after
+	// at least the duration.
+	NewTimer(d time.Duration) Timer
+	// NewTicker creates a Ticker that sends the current time on its channel
+	// each time the duration elapses.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a single event created by a Clock, like time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is delivered.
+	C() <-chan time.Time
+	// Stop prevents the Timer from firing. It returns false if the Timer has
+	// already expired or been stopped.
+	Stop() bool
+	// Reset changes the Timer to expire after the duration. It returns true if
+	// the Timer had been active.
+	Reset(d time.Duration) bool
+}
+
+// Ticker delivers ticks at intervals, like time.Ticker.
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+	// Stop turns off the Ticker.
+	Stop()
+}
+
+// SystemClock is the Clock backed by the time package.
+var SystemClock Clock = systemClock{}
+
+// ClockOrSystem returns the provided Clock or SystemClock if it's nil.
+func ClockOrSystem(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTimer struct {
+	t *time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t systemTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+func (t systemTimer) Reset(d time.Duration) bool {
+	return t.t.Reset(d)
+}
+
+type systemTicker struct {
+	t *time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t systemTicker) Stop() {
+	t.t.Stop()
+}
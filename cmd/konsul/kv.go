@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"gopkg.in/yaml.v3"
+
+	"github.com/jkratz55/konsul"
+)
+
+// errUsage indicates the arguments of a command were invalid and its usage has
+// been printed.
+var errUsage = errors.New("invalid usage")
+
+type kvCommand struct {
+	client *api.Client
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (c *kvCommand) flags(name string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet("kv "+name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: konsul kv %s %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func (c *kvCommand) parse(fs *flag.FlagSet, args []string, nargs int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	if fs.NArg() != nargs {
+		fs.Usage()
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+func (c *kvCommand) get(args []string) error {
+	fs := c.flags("get", "[-stale] <key>")
+	stale := fs.Bool("stale", false, "allow any Consul server to answer the query")
+	args, err := c.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	kv, err := konsul.NewKVClient(c.client).Get(args[0], *stale)
+	if err != nil {
+		return fmt.Errorf("error retrieving key %s from Consul: %w", args[0], err)
+	}
+	if kv.Unwrap() == nil {
+		return fmt.Errorf("key %s doesn't exist", args[0])
+	}
+	_, err = c.stdout.Write(kv.RawValue())
+	return err
+}
+
+func (c *kvCommand) put(args []string) error {
+	fs := c.flags("put", "[-format json|yaml] <key> <value | @file | ->")
+	format := fs.String("format", "", "validate the value is valid json or yaml before putting it")
+	args, err := c.parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	value, err := c.readValue(args[1])
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "":
+	case "json":
+		if !json.Valid(value) {
+			return fmt.Errorf("value for key %s is not valid JSON", args[0])
+		}
+	case "yaml":
+		var v any
+		if err := yaml.Unmarshal(value, &v); err != nil {
+			return fmt.Errorf("value for key %s is not valid YAML: %w", args[0], err)
+		}
+	default:
+		return fmt.Errorf("unsupported format %s, expected json or yaml", *format)
+	}
+
+	if err := konsul.NewKVClient(c.client).Put(args[0], value); err != nil {
+		return fmt.Errorf("failed to put key %s in Consul: %w", args[0], err)
+	}
+	return nil
+}
+
+// readValue returns the value argument, the content of a file if prefixed with
+// @, or stdin for -.
+func (c *kvCommand) readValue(arg string) ([]byte, error) {
+	switch {
+	case arg == "-":
+		data, err := io.ReadAll(c.stdin)
+		if err != nil {
+			return nil, fmt.Errorf("error reading value from stdin: %w", err)
+		}
+		return data, nil
+	case strings.HasPrefix(arg, "@"):
+		data, err := os.ReadFile(arg[1:])
+		if err != nil {
+			return nil, fmt.Errorf("error reading value from file: %w", err)
+		}
+		return data, nil
+	default:
+		return []byte(arg), nil
+	}
+}
+
+func (c *kvCommand) export(args []string) error {
+	fs := c.flags("export", "[-format json|yaml] <prefix>")
+	format := fs.String("format", konsul.CodecJSON, "format of the exported document, json or yaml")
+	args, err := c.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	data, err := konsul.NewKVClient(c.client).Export(args[0], *format)
+	if err != nil {
+		return fmt.Errorf("error exporting keys with prefix %s from Consul: %w", args[0], err)
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	_, err = c.stdout.Write(data)
+	return err
+}
+
+func (c *kvCommand) importKVs(args []string) error {
+	fs := c.flags("import", "[-mode merge|overwrite|skip-existing] [-source-prefix prefix] [-target-prefix prefix] [-dry-run] [-format json|yaml] [file | -]")
+	mode := fs.String("mode", konsul.ImportMerge.String(), "how keys that already exist are treated: merge, overwrite, or skip-existing")
+	sourcePrefix := fs.String("source-prefix", "", "prefix removed from the keys of the document")
+	targetPrefix := fs.String("target-prefix", "", "prefix prepended to the keys of the document, required by -mode overwrite")
+	dryRun := fs.Bool("dry-run", false, "print the changes without writing anything")
+	format := fs.String("format", "", "format of the document, json or yaml (detected if not provided)")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errUsage
+	}
+	importMode, err := parseImportMode(*mode)
+	if err != nil {
+		return err
+	}
+
+	var in io.Reader = c.stdin
+	if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("error opening file: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	doc, err := io.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("error reading exported keys: %w", err)
+	}
+
+	result, err := konsul.NewKVClient(c.client).Import(doc, konsul.ImportOptions{
+		Codec:        *format,
+		Mode:         importMode,
+		SourcePrefix: *sourcePrefix,
+		TargetPrefix: *targetPrefix,
+		DryRun:       *dryRun,
+	})
+	if result != nil {
+		c.printImport(result, *dryRun)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to import keys in Consul: %w", err)
+	}
+	return nil
+}
+
+// parseImportMode returns the konsul.ImportMode with the provided name.
+func parseImportMode(name string) (konsul.ImportMode, error) {
+	for _, mode := range []konsul.ImportMode{konsul.ImportMerge, konsul.ImportOverwrite, konsul.ImportSkipExisting} {
+		if mode.String() == name {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported import mode %s, expected merge, overwrite, or skip-existing", name)
+}
+
+// printImport prints the keys changed by an import, marked like kv diff, and a
+// summary of the changes.
+func (c *kvCommand) printImport(result *konsul.ImportResult, dryRun bool) {
+	for _, key := range result.Created {
+		fmt.Fprintf(c.stdout, "+ %s\n", key)
+	}
+	for _, key := range result.Updated {
+		fmt.Fprintf(c.stdout, "~ %s\n", key)
+	}
+	for _, key := range result.Deleted {
+		fmt.Fprintf(c.stdout, "- %s\n", key)
+	}
+	verb := "imported"
+	if dryRun {
+		verb = "would import"
+	}
+	fmt.Fprintf(c.stdout, "%s: %d created, %d updated, %d unchanged, %d deleted\n", verb,
+		len(result.Created), len(result.Updated), len(result.Unchanged), len(result.Deleted))
+}
+
+func (c *kvCommand) diff(args []string) error {
+	fs := c.flags("diff", "[-dc-a dc] [-dc-b dc] <prefix-a> <prefix-b>")
+	dcA := fs.String("dc-a", "", "datacenter of prefix-a")
+	dcB := fs.String("dc-b", "", "datacenter of prefix-b")
+	stale := fs.Bool("stale", false, "allow any Consul server to answer the queries")
+	args, err := c.parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	a, err := c.list(args[0], *dcA, *stale)
+	if err != nil {
+		return err
+	}
+	b, err := c.list(args[1], *dcB, *stale)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		va, inA := a[key]
+		vb, inB := b[key]
+		switch {
+		case !inB:
+			fmt.Fprintf(c.stdout, "- %s\n", key)
+		case !inA:
+			fmt.Fprintf(c.stdout, "+ %s\n", key)
+		case va != vb:
+			fmt.Fprintf(c.stdout, "~ %s\n", key)
+		}
+	}
+	return nil
+}
+
+// list returns the values of the keys under the prefix by their key relative
+// to the prefix.
+func (c *kvCommand) list(prefix string, dc string, stale bool) (map[string]string, error) {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	pairs, _, err := c.client.KV().List(prefix, &api.QueryOptions{
+		Datacenter: dc,
+		AllowStale: stale,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing keys with prefix %s from Consul: %w", prefix, err)
+	}
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		values[strings.TrimPrefix(pair.Key, prefix)] = string(pair.Value)
+	}
+	return values, nil
+}
+
+func (c *kvCommand) watch(args []string) error {
+	fs := c.flags("watch", "<key>")
+	args, err := c.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	return konsul.Watch(c.client, args[0], &printer{w: c.stdout}, konsul.WatchOptions{
+		Logger: hclog.NewNullLogger(),
+	})
+}
+
+// printer is an encoding.BinaryUnmarshaler writing each value to w.
+type printer struct {
+	w io.Writer
+}
+
+func (p *printer) UnmarshalBinary(data []byte) error {
+	if _, err := p.w.Write(data); err != nil {
+		return err
+	}
+	if len(data) == 0 || data[len(data)-1] != '\n' {
+		_, err := io.WriteString(p.w, "\n")
+		return err
+	}
+	return nil
+}
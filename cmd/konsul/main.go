@@ -0,0 +1,113 @@
+// Command konsul is a CLI for operating on Consul KV store with the same
+// semantics the konsul library uses in code.
+//
+// Usage:
+//
+//	konsul [global flags] kv get <key>
+//	konsul [global flags] kv put [-format json|yaml] <key> <value | @file | ->
+//	konsul [global flags] kv export [-format json|yaml] <prefix>
+//	konsul [global flags] kv import [-mode merge|overwrite|skip-existing] [-source-prefix prefix]
+//		[-target-prefix prefix] [-dry-run] [-format json|yaml] [file | -]
+//	konsul [global flags] kv diff [-dc-a dc] [-dc-b dc] <prefix-a> <prefix-b>
+//	konsul [global flags] kv watch <key>
+//
+// The Consul address, token, and datacenter default to the standard
+// CONSUL_HTTP_ADDR, CONSUL_HTTP_TOKEN, and related environment variables.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const usage = `Usage: konsul [global flags] <command> [args]
+
+Commands:
+  kv get      Print the value of a key
+  kv put      Set the value of a key, optionally validating it as JSON or YAML
+  kv export   Export the keys under a prefix as JSON or YAML
+  kv import   Import keys exported with kv export, optionally under another prefix
+  kv diff     Show the differences between two prefixes or datacenters
+  kv watch    Print the value of a key each time it changes
+
+Global flags:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	global := flag.NewFlagSet("konsul", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	addr := global.String("http-addr", "", "address of the Consul HTTP API (defaults to CONSUL_HTTP_ADDR)")
+	token := global.String("token", "", "ACL token (defaults to CONSUL_HTTP_TOKEN)")
+	dc := global.String("datacenter", "", "datacenter to query (defaults to the agent's datacenter)")
+	global.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		global.PrintDefaults()
+	}
+	if err := global.Parse(args); err != nil {
+		return 2
+	}
+
+	rest := global.Args()
+	if len(rest) < 2 || rest[0] != "kv" {
+		global.Usage()
+		return 2
+	}
+
+	cfg := api.DefaultConfig()
+	if *addr != "" {
+		cfg.Address = *addr
+	}
+	if *token != "" {
+		cfg.Token = *token
+	}
+	if *dc != "" {
+		cfg.Datacenter = *dc
+	}
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "error creating Consul client: %s\n", err)
+		return 1
+	}
+
+	cmd := &kvCommand{
+		client: client,
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+	}
+	var runErr error
+	switch rest[1] {
+	case "get":
+		runErr = cmd.get(rest[2:])
+	case "put":
+		runErr = cmd.put(rest[2:])
+	case "export":
+		runErr = cmd.export(rest[2:])
+	case "import":
+		runErr = cmd.importKVs(rest[2:])
+	case "diff":
+		runErr = cmd.diff(rest[2:])
+	case "watch":
+		runErr = cmd.watch(rest[2:])
+	default:
+		fmt.Fprintf(stderr, "unknown command kv %s\n\n", rest[1])
+		global.Usage()
+		return 2
+	}
+	if runErr != nil {
+		if runErr == errUsage {
+			return 2
+		}
+		fmt.Fprintln(stderr, runErr)
+		return 1
+	}
+	return 0
+}
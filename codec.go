@@ -0,0 +1,335 @@
+package konsul
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/hcl"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// Names of the codecs registered by default.
+const (
+	CodecJSON = "json"
+	CodecYAML = "yaml"
+	CodecHCL  = "hcl"
+	CodecTOML = "toml"
+	// CodecMsgpack encodes values as MessagePack. Struct fields are named
+	// with their msgpack struct tags.
+	CodecMsgpack = "msgpack"
+	// CodecProto encodes values implementing proto.Message in the Protocol
+	// Buffers binary format.
+	CodecProto = "protobuf"
+)
+
+// ErrUnknownCodec is a sentinel error value indicating no Codec is registered
+// with the provided name or content type.
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// Codec encodes and decodes values stored in Consul, allowing formats other than
+// JSON and YAML, such as msgpack, protobuf, or CBOR, to be used by KVClient and
+// Watch.
+//
+// Implementations must be safe for concurrent use.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type codecRegistry struct {
+	mu           sync.RWMutex
+	names        map[string]Codec
+	contentTypes map[string]Codec
+}
+
+var codecs = &codecRegistry{
+	names: map[string]Codec{
+		CodecJSON:    jsonCodec{},
+		CodecYAML:    yamlCodec{},
+		CodecHCL:     hclCodec{},
+		CodecTOML:    tomlCodec{},
+		CodecMsgpack: msgpackCodec{},
+		CodecProto:   protoCodec{},
+	},
+	contentTypes: map[string]Codec{
+		"application/json":       jsonCodec{},
+		"application/yaml":       yamlCodec{},
+		"application/x-yaml":     yamlCodec{},
+		"text/yaml":              yamlCodec{},
+		"application/hcl":        hclCodec{},
+		"application/toml":       tomlCodec{},
+		"application/msgpack":    msgpackCodec{},
+		"application/x-msgpack":  msgpackCodec{},
+		"application/protobuf":   protoCodec{},
+		"application/x-protobuf": protoCodec{},
+	},
+}
+
+// RegisterCodec registers the Codec with the provided name and, optionally, the
+// content types it handles, such as "application/msgpack". Registering a Codec
+// with the name or a content type of an existing Codec replaces it, including
+// the built-in json and yaml codecs used by PutJSON and PutYAML.
+//
+// RegisterCodec is intended to be called during initialization. Registering a
+// nil Codec or a Codec without a name will cause a panic.
+func RegisterCodec(name string, codec Codec, contentTypes ...string) {
+	if codec == nil {
+		panic("cannot register nil Codec, illegal use of api")
+	}
+	if strings.TrimSpace(name) == "" {
+		panic("a Codec must be registered with a name, illegal use of api")
+	}
+	codecs.mu.Lock()
+	defer codecs.mu.Unlock()
+	codecs.names[strings.ToLower(name)] = codec
+	for _, ct := range contentTypes {
+		codecs.contentTypes[mediaType(ct)] = codec
+	}
+}
+
+// LookupCodec returns the Codec registered with the provided name or content
+// type. Names and content types are case-insensitive, and parameters of the
+// content type such as charset are ignored. If no Codec is registered an error
+// wrapping ErrUnknownCodec is returned.
+func LookupCodec(nameOrContentType string) (Codec, error) {
+	codecs.mu.RLock()
+	defer codecs.mu.RUnlock()
+	if codec, ok := codecs.names[strings.ToLower(nameOrContentType)]; ok {
+		return codec, nil
+	}
+	if codec, ok := codecs.contentTypes[mediaType(nameOrContentType)]; ok {
+		return codec, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, nameOrContentType)
+}
+
+// mediaType normalizes a content type by removing its parameters.
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mt
+}
+
+// Decoder returns an encoding.BinaryUnmarshaler decoding values into v with the
+// Codec registered with the provided name or content type, allowing values in
+// any registered format to be watched with Watch:
+//
+//	cfg := &AppConfig{}
+//	err := konsul.Watch(client, "config/app", konsul.Decoder("msgpack", cfg))
+//
+// When codec is empty the format of each value is detected with DetectCodec.
+// The Codec is looked up each time a value is decoded.
+func Decoder(codec string, v any) encoding.BinaryUnmarshaler {
+	return &codecDecoder{codec: codec, v: v}
+}
+
+type codecDecoder struct {
+	codec string
+	v     any
+}
+
+func (d *codecDecoder) UnmarshalBinary(data []byte) error {
+	name := d.codec
+	if name == "" {
+		name = DetectCodec(data)
+	}
+	codec, err := LookupCodec(name)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(data, d.v)
+}
+
+// DecoderFunc decodes data into v, allowing values to be decoded with custom
+// logic, such as a format without a registered Codec.
+type DecoderFunc func(data []byte, v any) error
+
+// DecodeWith returns an encoding.BinaryUnmarshaler decoding values into v with
+// the function, allowing types that cannot implement encoding.BinaryUnmarshaler,
+// such as third-party structs, to be watched with Watch.
+func DecodeWith(fn DecoderFunc, v any) encoding.BinaryUnmarshaler {
+	if fn == nil {
+		panic("cannot provide nil DecoderFunc, illegal use of api")
+	}
+	return &funcDecoder{fn: fn, v: v}
+}
+
+type funcDecoder struct {
+	fn DecoderFunc
+	v  any
+}
+
+func (d *funcDecoder) UnmarshalBinary(data []byte) error {
+	return d.fn(data, d.v)
+}
+
+// FromText returns an encoding.BinaryUnmarshaler refreshing v with its
+// UnmarshalText method, allowing types implementing encoding.TextUnmarshaler,
+// such as net.IP or big.Int, to be watched with Watch.
+func FromText(v encoding.TextUnmarshaler) encoding.BinaryUnmarshaler {
+	return &textDecoder{v: v}
+}
+
+type textDecoder struct {
+	v encoding.TextUnmarshaler
+}
+
+func (d *textDecoder) UnmarshalBinary(data []byte) error {
+	return d.v.UnmarshalText(data)
+}
+
+// DetectCodec returns the name of the text format of the value: CodecJSON if
+// it's valid JSON, CodecYAML if it's a YAML mapping, CodecTOML if it's a TOML
+// document, and CodecYAML otherwise. TOML documents are rarely valid YAML
+// mappings so they're told apart, but the detection is a heuristic and the
+// codec should be configured explicitly when the format is known.
+func DetectCodec(value []byte) string {
+	if json.Valid(value) {
+		return CodecJSON
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(value, &doc); err == nil {
+		return CodecYAML
+	}
+	var table map[string]any
+	if err := toml.Unmarshal(value, &table); err == nil {
+		return CodecTOML
+	}
+	return CodecYAML
+}
+
+// JSONOptions configures how PutJSON encodes values as JSON. The zero-value
+// encodes values indented with tabs and with HTML characters escaped, like
+// json.MarshalIndent.
+type JSONOptions struct {
+	// Encodes values without any insignificant whitespace. Together with the
+	// map keys being sorted by encoding/json this produces deterministic JSON
+	// suitable for diffing.
+	Compact bool
+	// The string used to indent values when not Compact. If not provided a
+	// tab is used.
+	Indent string
+	// Disables escaping &, <, and > to \u0026, \u003c, and \u003e.
+	DisableHTMLEscape bool
+	// An optional function to encode values, such as jsoniter or a canonical
+	// JSON encoder. When provided the other options are ignored.
+	Marshal func(v any) ([]byte, error)
+}
+
+func (o JSONOptions) isZero() bool {
+	return !o.Compact && o.Indent == "" && !o.DisableHTMLEscape && o.Marshal == nil
+}
+
+// configuredJSONCodec encodes values according to JSONOptions, decoding with
+// encoding/json.
+type configuredJSONCodec struct {
+	opts JSONOptions
+}
+
+func (c configuredJSONCodec) Marshal(v any) ([]byte, error) {
+	if c.opts.Marshal != nil {
+		return c.opts.Marshal(v)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(!c.opts.DisableHTMLEscape)
+	if !c.opts.Compact {
+		indent := c.opts.Indent
+		if indent == "" {
+			indent = "\t"
+		}
+		enc.SetIndent("", indent)
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	// Encoder terminates each value with a newline, which json.Marshal doesn't
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (configuredJSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.MarshalIndent(v, "", "\t")
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type yamlCodec struct{}
+
+func (yamlCodec) Marshal(v any) ([]byte, error) {
+	return yaml.Marshal(v)
+}
+
+func (yamlCodec) Unmarshal(data []byte, v any) error {
+	return yaml.Unmarshal(data, v)
+}
+
+// hclCodec decodes HCL (version 1) values. HashiCorp's HCL library doesn't
+// support encoding Go values so Marshal always fails.
+type hclCodec struct{}
+
+func (hclCodec) Marshal(any) ([]byte, error) {
+	return nil, errors.New("encoding values as HCL isn't supported")
+}
+
+func (hclCodec) Unmarshal(data []byte, v any) error {
+	return hcl.Unmarshal(data, v)
+}
+
+type tomlCodec struct{}
+
+func (tomlCodec) Marshal(v any) ([]byte, error) {
+	return toml.Marshal(v)
+}
+
+func (tomlCodec) Unmarshal(data []byte, v any) error {
+	return toml.Unmarshal(data, v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// protoCodec encodes values in the Protocol Buffers binary format. Values must
+// implement proto.Message.
+type protoCodec struct{}
+
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("value of type %T isn't a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("value of type %T isn't a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
@@ -0,0 +1,210 @@
+package konsul
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Composite holds a configuration of type T assembled from several keys, each
+// bound to a field of T with a konsul struct tag holding the key and an
+// optional codec:
+//
+//	type AppConfig struct {
+//		DB    DBConfig    `konsul:"config/app/db"`
+//		Cache CacheConfig `konsul:"config/app/cache,yaml"`
+//	}
+//
+//	cfg, err := konsul.NewComposite[AppConfig]()
+//	cfg.Subscribe(func(c *AppConfig) { ... })
+//	go func() {
+//		if err := cfg.Watch(ctx, client); err != nil {
+//			panic(err)
+//		}
+//	}()
+//
+// The keys are watched with a single watch of their longest common prefix, so
+// they should share a prefix to avoid watching the whole KV store, and keys
+// changed together are applied together: each change produces a new T
+// holding every piece, swapped atomically like Store, and subscribers are
+// notified once. Values returned by Load must be treated as immutable.
+//
+// Composite is safe for concurrent use. The zero-value of Composite is not
+// usable, use NewComposite to create a Composite.
+type Composite[T any] struct {
+	fields []compositeField
+	prefix string
+	value  atomic.Pointer[T]
+
+	mu   sync.Mutex
+	subs map[int]func(*T)
+	seq  int
+}
+
+// compositeField is a field of the configuration bound to a key.
+type compositeField struct {
+	key   string
+	codec string
+	index []int
+}
+
+// NewComposite creates a Composite for T, binding the fields of T tagged with a
+// konsul struct tag to their keys. Fields without a codec in their tag are
+// decoded with the format detected by DetectCodec. If T isn't a struct, has no
+// tagged fields, or binds the same key twice an error wrapping
+// ErrInvalidConfig is returned.
+func NewComposite[T any]() (*Composite[T], error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Struct {
+		return nil, invalidConfig(fmt.Sprintf("composite configuration must be a struct, got %s", typ))
+	}
+	c := &Composite[T]{subs: make(map[int]func(*T))}
+	seen := make(map[string]struct{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag, ok := field.Tag.Lookup("konsul")
+		if !ok || tag == "-" {
+			continue
+		}
+		if !field.IsExported() {
+			return nil, invalidConfig(fmt.Sprintf("field %s bound to a key must be exported", field.Name))
+		}
+		key, codec, _ := strings.Cut(tag, ",")
+		if key == "" {
+			return nil, invalidConfig(fmt.Sprintf("field %s must be bound to a key", field.Name))
+		}
+		if _, ok := seen[key]; ok {
+			return nil, invalidConfig(fmt.Sprintf("key %s is bound to more than one field", key))
+		}
+		seen[key] = struct{}{}
+		c.fields = append(c.fields, compositeField{key: key, codec: codec, index: field.Index})
+	}
+	if len(c.fields) == 0 {
+		return nil, invalidConfig(fmt.Sprintf("%s has no fields bound to keys with a konsul struct tag", typ))
+	}
+	c.prefix = commonPrefix(c.Keys())
+	c.value.Store(new(T))
+	return c, nil
+}
+
+// Keys returns the keys bound to the fields of T, sorted.
+func (c *Composite[T]) Keys() []string {
+	keys := make([]string, len(c.fields))
+	for i, f := range c.fields {
+		keys[i] = f.key
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Load returns the current configuration. Fields whose key doesn't exist hold
+// their zero-value.
+func (c *Composite[T]) Load() *T {
+	return c.value.Load()
+}
+
+// Subscribe registers a callback invoked with the new configuration each time
+// any of its keys change. Callbacks are invoked sequentially on the goroutine
+// watching the keys and should return quickly. The returned function
+// unregisters the callback.
+func (c *Composite[T]) Subscribe(fn func(cfg *T)) (unsubscribe func()) {
+	if fn == nil {
+		panic("cannot provide nil callback, illegal use of api")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	id := c.seq
+	c.subs[id] = fn
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.subs, id)
+	}
+}
+
+// Watch watches the keys until the context is cancelled, at which point it
+// returns nil, like WatchPrefixContext. Keys whose value cannot be decoded leave
+// their field unchanged while the other keys changed are still applied, and the
+// failure is reported like an unmarshalling failure of Watch. Deleted keys
+// reset their field to its zero-value.
+func (c *Composite[T]) Watch(ctx context.Context, client *api.Client, opts ...WatchOption) error {
+	return WatchPrefixContext(ctx, client, c.prefix, c.apply, opts...)
+}
+
+// apply assembles a new configuration from the current one and the changes to
+// its keys.
+func (c *Composite[T]) apply(change PrefixChange) error {
+	next := *c.value.Load()
+	v := reflect.ValueOf(&next).Elem()
+	changed := false
+	var first error
+	for _, kv := range change.Changed {
+		f, ok := c.field(kv.Key())
+		if !ok {
+			continue
+		}
+		target := reflect.New(v.FieldByIndex(f.index).Type())
+		if err := Decoder(f.codec, target.Interface()).UnmarshalBinary(kv.RawValue()); err != nil {
+			if first == nil {
+				first = fmt.Errorf("failed to decode value for key %s: %w", kv.Key(), err)
+			}
+			continue
+		}
+		v.FieldByIndex(f.index).Set(target.Elem())
+		changed = true
+	}
+	for _, key := range change.Deleted {
+		if f, ok := c.field(key); ok {
+			field := v.FieldByIndex(f.index)
+			field.Set(reflect.Zero(field.Type()))
+			changed = true
+		}
+	}
+	if !changed {
+		return first
+	}
+	c.value.Store(&next)
+
+	c.mu.Lock()
+	subs := make([]func(*T), 0, len(c.subs))
+	for _, fn := range c.subs {
+		subs = append(subs, fn)
+	}
+	c.mu.Unlock()
+	for _, fn := range subs {
+		fn(&next)
+	}
+	return first
+}
+
+// field returns the field bound to the key.
+func (c *Composite[T]) field(key string) (compositeField, bool) {
+	for _, f := range c.fields {
+		if f.key == key {
+			return f, true
+		}
+	}
+	return compositeField{}, false
+}
+
+// commonPrefix returns the longest prefix ending with a slash shared by the
+// keys, or an empty string if they don't share one.
+func commonPrefix(keys []string) string {
+	prefix := keys[0]
+	for _, key := range keys[1:] {
+		for !strings.HasPrefix(key, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		return prefix[:i+1]
+	}
+	return ""
+}
@@ -0,0 +1,181 @@
+package konsul
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is an algorithm used to compress values stored in Consul.
+type Compression int
+
+const (
+	// CompressionNone disables compression.
+	CompressionNone Compression = iota
+	// CompressionGzip compresses values with gzip, which is widely supported by
+	// other tools reading the KV store.
+	CompressionGzip
+	// CompressionZstd compresses values with zstd, which is faster and usually
+	// compresses better than gzip.
+	CompressionZstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Compression(%d)", int(c))
+	}
+}
+
+// DefaultCompressionMinSize is the size in bytes values must reach to be
+// compressed when CompressionOptions.MinSize isn't provided.
+const DefaultCompressionMinSize = 1024
+
+// maxDecompressedSize bounds the size of decompressed values, protecting
+// against values crafted to decompress to huge sizes.
+const maxDecompressedSize = 64 << 20
+
+// CompressionOptions configures the compression of values stored in Consul,
+// which keeps large values such as JSON documents under the 512KB limit Consul
+// imposes on values.
+//
+// Compressed values are detected from their gzip or zstd header and
+// decompressed whenever an Algorithm is configured, regardless of which
+// Algorithm was used to compress them, so readers must be configured with
+// compression as well.
+type CompressionOptions struct {
+	// The algorithm used to compress values put in Consul.
+	Algorithm Compression
+	// The size in bytes values must reach to be compressed, as compressing
+	// small values wastes CPU for little or no gain. If not provided
+	// DefaultCompressionMinSize is used. Values are stored uncompressed if
+	// compressing them doesn't reduce their size.
+	MinSize int
+}
+
+func (o CompressionOptions) validate() error {
+	switch o.Algorithm {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+	default:
+		return invalidConfig(fmt.Sprintf("unknown compression algorithm %s", o.Algorithm))
+	}
+	if o.MinSize < 0 {
+		return invalidConfig("compression MinSize cannot be negative")
+	}
+	return nil
+}
+
+func (o CompressionOptions) enabled() bool {
+	return o.Algorithm != CompressionNone
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compress compresses the value with the configured algorithm if it's at
+// least MinSize bytes and compressing it reduces its size.
+func compress(opts CompressionOptions, value []byte) ([]byte, error) {
+	minSize := opts.MinSize
+	if minSize == 0 {
+		minSize = DefaultCompressionMinSize
+	}
+	if !opts.enabled() || len(value) < minSize {
+		return value, nil
+	}
+
+	var compressed []byte
+	switch opts.Algorithm {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(value); err != nil {
+			return nil, fmt.Errorf("error compressing value with gzip: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("error compressing value with gzip: %w", err)
+		}
+		compressed = buf.Bytes()
+	case CompressionZstd:
+		enc, err := zstdEncoder()
+		if err != nil {
+			return nil, fmt.Errorf("error compressing value with zstd: %w", err)
+		}
+		compressed = enc.EncodeAll(value, nil)
+	}
+	if len(compressed) >= len(value) {
+		return value, nil
+	}
+	return compressed, nil
+}
+
+// decompress decompresses the value if it has a gzip or zstd header. Other
+// values are returned unchanged.
+func decompress(value []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(value, gzipMagic):
+		r, err := gzip.NewReader(bytes.NewReader(value))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing gzip value: %w", err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing gzip value: %w", err)
+		}
+		if len(data) > maxDecompressedSize {
+			return nil, fmt.Errorf("decompressed value exceeds %d bytes", maxDecompressedSize)
+		}
+		return data, nil
+	case bytes.HasPrefix(value, zstdMagic):
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing zstd value: %w", err)
+		}
+		data, err := dec.DecodeAll(value, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing zstd value: %w", err)
+		}
+		return data, nil
+	default:
+		return value, nil
+	}
+}
+
+// The zstd encoder and decoder are expensive to create but safe for concurrent
+// use with EncodeAll and DecodeAll, so they're shared.
+var (
+	zstdEncOnce sync.Once
+	zstdEnc     *zstd.Encoder
+	zstdEncErr  error
+	zstdDecOnce sync.Once
+	zstdDec     *zstd.Decoder
+	zstdDecErr  error
+)
+
+func zstdEncoder() (*zstd.Encoder, error) {
+	zstdEncOnce.Do(func() {
+		zstdEnc, zstdEncErr = zstd.NewWriter(nil)
+	})
+	return zstdEnc, zstdEncErr
+}
+
+func zstdDecoder() (*zstd.Decoder, error) {
+	zstdDecOnce.Do(func() {
+		zstdDec, zstdDecErr = zstd.NewReader(nil,
+			zstd.WithDecoderConcurrency(0),
+			zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	})
+	return zstdDec, zstdDecErr
+}
@@ -0,0 +1,68 @@
+package konsul
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// newTestConsul starts an HTTP server answering the Consul API endpoints used
+// by konsul with fixed responses, and returns a Consul api Client configured
+// to communicate with it. Blocking queries block until they're cancelled, so
+// background goroutines stay parked in a query like they would against Consul.
+// The server is closed and the idle connections of the client are closed when
+// the test completes.
+func newTestConsul(t *testing.T) *api.Client {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/health/service/", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, r, []*api.ServiceEntry{{
+			Node:    &api.Node{Node: "node-1", Address: "10.0.0.1"},
+			Service: &api.AgentService{ID: "orders-1", Service: "orders", Port: 8080},
+		}})
+	})
+	mux.HandleFunc("/v1/kv/", func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		respond(w, r, []*api.KVPair{{Key: key, Value: []byte(`{"debug":true}`), ModifyIndex: 1}})
+	})
+	mux.HandleFunc("/v1/session/create", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, r, map[string]string{"ID": "session-1"})
+	})
+	mux.HandleFunc("/v1/session/renew/", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, r, []*api.SessionEntry{{ID: "session-1"}})
+	})
+	mux.HandleFunc("/v1/session/destroy/", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, r, true)
+	})
+	srv := httptest.NewServer(mux)
+
+	transport := &http.Transport{}
+	client, err := api.NewClient(&api.Config{
+		Address:   strings.TrimPrefix(srv.URL, "http://"),
+		Transport: transport,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(func() {
+		transport.CloseIdleConnections()
+		srv.Close()
+	})
+	return client
+}
+
+// respond writes the body as JSON at index 1, blocking until the request is
+// cancelled if it's a blocking query waiting for a change past index 1.
+func respond(w http.ResponseWriter, r *http.Request, body any) {
+	if index := r.URL.Query().Get("index"); index != "" && index != "0" {
+		<-r.Context().Done()
+		return
+	}
+	w.Header().Set("X-Consul-Index", "1")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,75 @@
+package konsul
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// casAttempts bounds the Check-And-Set operations made by helpers such as
+// Increment before giving up when the key is under heavy contention.
+const casAttempts = 16
+
+// Increment atomically adds delta to the counter stored in the key and returns
+// its new value. Counters are stored as base 10 integers, so they can be read
+// and set with Get and Put like any other key. A key that doesn't exist is
+// treated as 0 and created, and a negative delta decrements the counter.
+//
+// The counter is read and written with a Check-And-Set operation, which is
+// repeated while other clients modify the key concurrently. If the counter is
+// still modified after several attempts an error wrapping
+// ErrConcurrentModification is returned. If the value of the key isn't an
+// integer, the counter would overflow, or the operation fails a non-nil error
+// value is returned.
+func (c KVClient) Increment(key string, delta int64) (int64, error) {
+	for attempt := 1; attempt <= casAttempts; attempt++ {
+		kv, err := c.Get(key, false)
+		if err != nil {
+			return 0, err
+		}
+		var current int64
+		if kv.base != nil {
+			if current, err = parseCounter(kv.RawValue()); err != nil {
+				return 0, &KVError{Op: OpPut, Key: key, Err: err}
+			}
+		}
+		next, err := addCounter(current, delta)
+		if err != nil {
+			return 0, &KVError{Op: OpPut, Key: key, Err: err}
+		}
+		ok, err := c.PutCAS(key, []byte(strconv.FormatInt(next, 10)), kv.ModifyIndex())
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			c.logger.Debug("incremented counter in Consul", "key", key, "delta", delta, "value", next)
+			return next, nil
+		}
+		c.logger.Debug("counter modified concurrently, retrying", "key", key, "attempt", attempt)
+	}
+	return 0, &KVError{Op: OpPut, Key: key, Err: fmt.Errorf("%w: gave up incrementing counter after %d attempts",
+		ErrConcurrentModification, casAttempts)}
+}
+
+// parseCounter parses the value of a counter. An empty value is 0.
+func parseCounter(value []byte) (int64, error) {
+	s := strings.TrimSpace(string(value))
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value %q isn't an integer counter", s)
+	}
+	return n, nil
+}
+
+// addCounter adds delta to the counter, returning an error if the result
+// overflows.
+func addCounter(current int64, delta int64) (int64, error) {
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, fmt.Errorf("adding %d to counter %d overflows", delta, current)
+	}
+	return current + delta, nil
+}
@@ -0,0 +1,285 @@
+// Package debug provides an http.Handler rendering the state konsul holds in a
+// running application, such as the current value of watched configuration,
+// the health of watches, the instances yielded by Instancers, and the status
+// of service registrations. It's intended for quick operational inspection and
+// is typically mounted at /debug/konsul.
+//
+//	dh := debug.NewHandler(debug.Options{})
+//	store := konsul.NewStore[AppConfig](konsul.CodecJSON)
+//	go konsul.Watch(client, "config/app", store, konsul.WatchOptions{
+//		WatchNotification: dh.TrackWatch("config/app", func() any { return store.Load() }),
+//	})
+//	dh.TrackInstancer(instancer)
+//	http.Handle("/debug/konsul", dh)
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/jkratz55/konsul"
+	"github.com/jkratz55/konsul/log/redact"
+)
+
+// Options holds optional configuration properties for Handler.
+type Options struct {
+	// Configuration to redact sensitive values from watched configuration
+	// before it's rendered. If not provided redact.DefaultConfig is used.
+	Redact redact.Config
+	// When true the current value of watched configuration isn't rendered,
+	// only the health of the watches.
+	HideConfig bool
+}
+
+// Handler is an http.Handler rendering the state of tracked watches, Instancers,
+// and service registrations as JSON.
+//
+// The zero-value of Handler is not usable. Use NewHandler to create and
+// initialize a Handler.
+type Handler struct {
+	redact     redact.Config
+	hideConfig bool
+
+	mu            sync.Mutex
+	watches       map[string]*watchState
+	instancers    []*konsul.Instancer
+	registrations []registration
+}
+
+type watchState struct {
+	snapshot    func() any
+	updates     uint64
+	failures    uint64
+	lastUpdate  time.Time
+	lastSuccess time.Time
+	lastErr     error
+}
+
+type registration struct {
+	client    *api.Client
+	serviceID string
+}
+
+// NewHandler creates and initializes a Handler with the provided options.
+func NewHandler(opts Options) *Handler {
+	if opts.Redact.IsZero() {
+		opts.Redact = redact.DefaultConfig()
+	}
+	return &Handler{
+		redact:     opts.Redact,
+		hideConfig: opts.HideConfig,
+		watches:    make(map[string]*watchState),
+	}
+}
+
+// TrackWatch tracks a watched key and the configuration it's unmarshalled into.
+// The returned konsul.WatchNotificationFunc must be provided as the
+// WatchNotification option of Watch to record the outcome of each change. If a
+// WatchNotificationFunc is already in use, invoke the returned func from it.
+//
+// The configuration is rendered from the value returned by snapshot, which is
+// called while the Handler is served, concurrently with the watch applying
+// changes. It must therefore return a value that isn't modified by the watch,
+// such as the Load method of a konsul.Store. The value is marshalled to JSON so
+// only exported fields are included. If snapshot is nil the configuration
+// isn't rendered.
+func (h *Handler) TrackWatch(key string, snapshot func() any) konsul.WatchNotificationFunc {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.watches[key] = &watchState{snapshot: snapshot}
+	return func(key string, err error) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		state, ok := h.watches[key]
+		if !ok {
+			state = &watchState{}
+			h.watches[key] = state
+		}
+		now := time.Now()
+		state.lastUpdate = now
+		state.lastErr = err
+		if err != nil {
+			state.failures++
+			return
+		}
+		state.updates++
+		state.lastSuccess = now
+	}
+}
+
+// TrackInstancer tracks an Instancer rendering the instances it currently
+// yields.
+func (h *Handler) TrackInstancer(instancer *konsul.Instancer) {
+	if instancer == nil {
+		panic("cannot provide nil Instancer, illegal use of api")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.instancers = append(h.instancers, instancer)
+}
+
+// TrackRegistration tracks the registration of the service with the provided
+// ID with the local Consul agent. The registration status and aggregated health
+// are queried from the agent each time the Handler is served.
+func (h *Handler) TrackRegistration(client *api.Client, serviceID string) {
+	if client == nil {
+		panic("cannot provide nil consul api.Client, illegal use of api")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.registrations = append(h.registrations, registration{
+		client:    client,
+		serviceID: serviceID,
+	})
+}
+
+// State is the state rendered by Handler.
+type State struct {
+	Watches       []WatchState        `json:"watches"`
+	Instancers    []InstancerState    `json:"instancers"`
+	Registrations []RegistrationState `json:"registrations"`
+}
+
+// WatchState is the state of a watched key.
+type WatchState struct {
+	Key         string     `json:"key"`
+	Healthy     bool       `json:"healthy"`
+	Updates     uint64     `json:"updates"`
+	Failures    uint64     `json:"failures"`
+	LastUpdate  *time.Time `json:"lastUpdate,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	Config      any        `json:"config,omitempty"`
+}
+
+// InstancerState is a snapshot of the instances yielded by an Instancer.
+type InstancerState struct {
+	Service   string   `json:"service"`
+	Closed    bool     `json:"closed"`
+	Instances []string `json:"instances"`
+}
+
+// RegistrationState is the status of a service registration.
+type RegistrationState struct {
+	ServiceID  string `json:"serviceId"`
+	Registered bool   `json:"registered"`
+	Status     string `json:"status,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// State returns a snapshot of the tracked state.
+func (h *Handler) State() State {
+	h.mu.Lock()
+	watches := make([]WatchState, 0, len(h.watches))
+	for key, w := range h.watches {
+		ws := WatchState{
+			Key:      key,
+			Healthy:  !w.lastSuccess.IsZero() && w.lastErr == nil,
+			Updates:  w.updates,
+			Failures: w.failures,
+		}
+		if !w.lastUpdate.IsZero() {
+			t := w.lastUpdate
+			ws.LastUpdate = &t
+		}
+		if !w.lastSuccess.IsZero() {
+			t := w.lastSuccess
+			ws.LastSuccess = &t
+		}
+		if w.lastErr != nil {
+			ws.LastError = redact.Value(h.redact, w.lastErr.Error()).(string)
+		}
+		if !h.hideConfig && w.snapshot != nil {
+			if cfg := w.snapshot(); cfg != nil {
+				ws.Config = h.renderConfig(cfg)
+			}
+		}
+		watches = append(watches, ws)
+	}
+	instancers := make([]*konsul.Instancer, len(h.instancers))
+	copy(instancers, h.instancers)
+	registrations := make([]registration, len(h.registrations))
+	copy(registrations, h.registrations)
+	h.mu.Unlock()
+
+	sort.Slice(watches, func(i, j int) bool {
+		return watches[i].Key < watches[j].Key
+	})
+
+	state := State{
+		Watches:       watches,
+		Instancers:    make([]InstancerState, 0, len(instancers)),
+		Registrations: make([]RegistrationState, 0, len(registrations)),
+	}
+	for _, i := range instancers {
+		state.Instancers = append(state.Instancers, instancerState(i))
+	}
+	// Agent queries are performed without holding the lock since they make
+	// network calls.
+	for _, r := range registrations {
+		state.Registrations = append(state.Registrations, registrationState(r))
+	}
+	return state
+}
+
+// ServeHTTP renders the tracked state as JSON.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := json.MarshalIndent(h.State(), "", "\t")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// renderConfig converts the configuration to a generic representation through
+// JSON so that sensitive fields can be redacted.
+func (h *Handler) renderConfig(cfg any) any {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return map[string]any{"error": "failed to render config: " + err.Error()}
+	}
+	var generic any
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return map[string]any{"error": "failed to render config: " + err.Error()}
+	}
+	return redact.Value(h.redact, generic)
+}
+
+func instancerState(i *konsul.Instancer) (state InstancerState) {
+	state.Service = i.Service()
+	// Instances panics if the Instancer has been closed
+	defer func() {
+		if r := recover(); r != nil {
+			state.Closed = true
+			state.Instances = []string{}
+		}
+	}()
+	state.Instances = i.Instances()
+	return state
+}
+
+func registrationState(r registration) RegistrationState {
+	state := RegistrationState{ServiceID: r.serviceID}
+	status, info, err := r.client.Agent().AgentHealthServiceByID(r.serviceID)
+	if err != nil {
+		state.Error = err.Error()
+		return state
+	}
+	state.Registered = info != nil
+	if info != nil {
+		state.Status = status
+	}
+	return state
+}
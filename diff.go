@@ -0,0 +1,208 @@
+package konsul
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ChangeKind is the kind of a Change reported by Diff.
+type ChangeKind int
+
+const (
+	// ChangeAdded is a path present in the local value but not in Consul.
+	ChangeAdded ChangeKind = iota
+	// ChangeRemoved is a path present in Consul but not in the local value.
+	ChangeRemoved
+	// ChangeModified is a path whose value differs between Consul and the local
+	// value.
+	ChangeModified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	default:
+		return fmt.Sprintf("ChangeKind(%d)", int(k))
+	}
+}
+
+// Change is a difference between the value stored in Consul and a local value.
+type Change struct {
+	// The path of the field that changed, such as "database.hosts[1]". Fields
+	// whose name contains dots or brackets are quoted, such as
+	// `labels["app.kubernetes.io/name"]`. An empty path is the whole value.
+	Path string
+	Kind ChangeKind
+	// The decoded value in Consul, nil if the change is ChangeAdded.
+	Old any
+	// The decoded local value, nil if the change is ChangeRemoved.
+	New any
+}
+
+func (c Change) String() string {
+	path := c.Path
+	if path == "" {
+		path = "<root>"
+	}
+	switch c.Kind {
+	case ChangeAdded:
+		return fmt.Sprintf("+ %s: %v", path, c.New)
+	case ChangeRemoved:
+		return fmt.Sprintf("- %s: %v", path, c.Old)
+	default:
+		return fmt.Sprintf("~ %s: %v -> %v", path, c.Old, c.New)
+	}
+}
+
+// Diff encodes v and compares it to the value of the key, returning the paths
+// that changed, which is useful to detect drift or only Put values that
+// changed:
+//
+//	changes, err := konsul.Diff(kv, "config/app", cfg)
+//	if err == nil && len(changes) > 0 {
+//		err = kv.PutJSON("config/app", cfg)
+//	}
+//
+// The value is encoded with the Codec provided by WithCodec. If not provided
+// the format of the stored value is detected like Get does, and JSON is used
+// if the key doesn't exist. Changes are sorted by path. If the key doesn't
+// exist a single ChangeAdded for the whole value is returned.
+//
+// If v cannot be encoded or the key cannot be retrieved a non-nil error value
+// is returned.
+func Diff(kv KV, key string, v any, opts ...GetOption) ([]Change, error) {
+	var options GetOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	pair, err := kv.Get(key, options.AllowStale, options.Query...)
+	if err != nil {
+		return nil, err
+	}
+	var current []byte
+	if pair.Unwrap() != nil {
+		current = pair.RawValue()
+	}
+
+	codec := options.Codec
+	if codec == "" {
+		codec = CodecJSON
+		if current != nil && !json.Valid(current) {
+			codec = CodecYAML
+		}
+	}
+	changes, err := DiffValue(codec, current, v)
+	if err != nil {
+		return nil, &KVError{Op: OpGet, Key: key, Err: err}
+	}
+	return changes, nil
+}
+
+// DiffValue encodes v with the Codec registered with the provided name or
+// content type and compares it to the encoded value current, a nil current
+// meaning the value doesn't exist. Values decoded into maps and slices, such
+// as JSON and YAML, are compared field by field. Otherwise, the encoded values
+// are compared, reporting at most a single change for the whole value.
+func DiffValue(codec string, current []byte, v any) ([]Change, error) {
+	enc, err := LookupCodec(codec)
+	if err != nil {
+		return nil, err
+	}
+	desired, err := enc.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding value with codec %s: %w", codec, err)
+	}
+
+	var newValue any
+	if err := enc.Unmarshal(desired, &newValue); err != nil {
+		// Codecs such as protobuf can't decode into an interface, so the
+		// encoded values are compared.
+		return diffBytes(current, desired), nil
+	}
+	if current == nil {
+		return []Change{{Kind: ChangeAdded, New: newValue}}, nil
+	}
+	var oldValue any
+	if err := enc.Unmarshal(current, &oldValue); err != nil {
+		// The stored value isn't valid for the codec, so it's replaced as a
+		// whole.
+		return []Change{{Kind: ChangeModified, Old: string(current), New: newValue}}, nil
+	}
+
+	var changes []Change
+	diffTree("", oldValue, newValue, &changes)
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+func diffBytes(current []byte, desired []byte) []Change {
+	switch {
+	case current == nil:
+		return []Change{{Kind: ChangeAdded, New: desired}}
+	case !bytes.Equal(current, desired):
+		return []Change{{Kind: ChangeModified, Old: current, New: desired}}
+	default:
+		return nil
+	}
+}
+
+// diffTree recursively compares maps and slices decoded by a Codec, appending
+// the changes below the path.
+func diffTree(path string, oldValue any, newValue any, changes *[]Change) {
+	switch o := oldValue.(type) {
+	case map[string]any:
+		if n, ok := newValue.(map[string]any); ok {
+			for key, ov := range o {
+				if nv, ok := n[key]; ok {
+					diffTree(fieldPath(path, key), ov, nv, changes)
+				} else {
+					*changes = append(*changes, Change{Path: fieldPath(path, key), Kind: ChangeRemoved, Old: ov})
+				}
+			}
+			for key, nv := range n {
+				if _, ok := o[key]; !ok {
+					*changes = append(*changes, Change{Path: fieldPath(path, key), Kind: ChangeAdded, New: nv})
+				}
+			}
+			return
+		}
+	case []any:
+		if n, ok := newValue.([]any); ok {
+			for i := 0; i < len(o) || i < len(n); i++ {
+				elemPath := fmt.Sprintf("%s[%d]", path, i)
+				switch {
+				case i >= len(n):
+					*changes = append(*changes, Change{Path: elemPath, Kind: ChangeRemoved, Old: o[i]})
+				case i >= len(o):
+					*changes = append(*changes, Change{Path: elemPath, Kind: ChangeAdded, New: n[i]})
+				default:
+					diffTree(elemPath, o[i], n[i], changes)
+				}
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(oldValue, newValue) {
+		*changes = append(*changes, Change{Path: path, Kind: ChangeModified, Old: oldValue, New: newValue})
+	}
+}
+
+func fieldPath(path string, key string) string {
+	if key == "" || strings.ContainsAny(key, ".[]\"") {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
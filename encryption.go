@@ -0,0 +1,207 @@
+package konsul
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrDecryptionFailed is a sentinel error value indicating an encrypted value
+// couldn't be decrypted, either because its key isn't available or the value
+// is corrupted or was tampered with.
+var ErrDecryptionFailed = errors.New("decryption failed")
+
+// KeyProvider provides the key-encryption keys used to encrypt values stored in
+// Consul. Keys must be 16, 24, or 32 bytes long to select AES-128, AES-192, or
+// AES-256.
+//
+// Values are encrypted with envelope encryption: each value is encrypted with
+// a random data key using AES-GCM, and the data key is encrypted with the
+// key-encryption key and stored alongside the value with the ID of the key.
+// This allows key-encryption keys to be rotated, or to live in a KMS, without
+// re-encrypting every value at once.
+//
+// Implementations must be safe for concurrent use.
+type KeyProvider interface {
+	// EncryptionKey returns the key used to encrypt new values and its ID.
+	EncryptionKey() (id string, key []byte, err error)
+	// DecryptionKey returns the key with the ID, used to decrypt values
+	// encrypted with it.
+	DecryptionKey(id string) ([]byte, error)
+}
+
+// StaticKey is a KeyProvider using a single key.
+type StaticKey struct {
+	// The ID of the key stored with encrypted values. It must not be longer
+	// than 255 bytes.
+	ID string
+	// The key, 16, 24, or 32 bytes long.
+	Key []byte
+}
+
+var _ KeyProvider = StaticKey{}
+
+func (k StaticKey) EncryptionKey() (string, []byte, error) {
+	return k.ID, k.Key, nil
+}
+
+func (k StaticKey) DecryptionKey(id string) ([]byte, error) {
+	if id != k.ID {
+		return nil, fmt.Errorf("unknown encryption key %q", id)
+	}
+	return k.Key, nil
+}
+
+// KeyRing is a KeyProvider supporting key rotation. New values are encrypted
+// with the Primary key while values encrypted with any key of the KeyRing can
+// be decrypted.
+type KeyRing struct {
+	// The ID of the key used to encrypt new values.
+	Primary string
+	// The keys by ID. IDs must not be longer than 255 bytes.
+	Keys map[string][]byte
+}
+
+var _ KeyProvider = KeyRing{}
+
+func (k KeyRing) EncryptionKey() (string, []byte, error) {
+	key, ok := k.Keys[k.Primary]
+	if !ok {
+		return "", nil, fmt.Errorf("primary encryption key %q is not in the key ring", k.Primary)
+	}
+	return k.Primary, key, nil
+}
+
+func (k KeyRing) DecryptionKey(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", id)
+	}
+	return key, nil
+}
+
+// encryptedMagic prefixes values encrypted by konsul, allowing encrypted and
+// plaintext values to coexist while migrating. The leading NUL byte never
+// appears in text formats such as JSON or YAML.
+var encryptedMagic = []byte{0x00, 'k', 'e', 0x01}
+
+// dataKeySize is the size of the random data key, selecting AES-256.
+const dataKeySize = 32
+
+// isEncrypted returns true if the value was encrypted by encrypt.
+func isEncrypted(value []byte) bool {
+	return bytes.HasPrefix(value, encryptedMagic)
+}
+
+// encrypt encrypts the value with a random data key, which is encrypted with
+// the encryption key of the KeyProvider. The result is laid out as:
+//
+//	magic | len(id) uint8 | id | len(wrapped) uint16 | wrapped data key | sealed value
+//
+// where the wrapped data key and the sealed value are each prefixed by their
+// AES-GCM nonce.
+func encrypt(keys KeyProvider, value []byte) ([]byte, error) {
+	id, kek, err := keys.EncryptionKey()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving encryption key: %w", err)
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("encryption key ID %q is longer than 255 bytes", id)
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("error generating data key: %w", err)
+	}
+	wrapped, err := seal(kek, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting data key with key %q: %w", id, err)
+	}
+	sealed, err := seal(dataKey, value)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting value: %w", err)
+	}
+
+	out := make([]byte, 0, len(encryptedMagic)+1+len(id)+2+len(wrapped)+len(sealed))
+	out = append(out, encryptedMagic...)
+	out = append(out, byte(len(id)))
+	out = append(out, id...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(wrapped)))
+	out = append(out, wrapped...)
+	out = append(out, sealed...)
+	return out, nil
+}
+
+// decrypt decrypts a value encrypted by encrypt. Values that aren't encrypted
+// are returned unchanged. Failures wrap ErrDecryptionFailed.
+func decrypt(keys KeyProvider, value []byte) ([]byte, error) {
+	if !isEncrypted(value) {
+		return value, nil
+	}
+	rest := value[len(encryptedMagic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0])+2 {
+		return nil, fmt.Errorf("%w: truncated header", ErrDecryptionFailed)
+	}
+	idLen := int(rest[0])
+	id := string(rest[1 : 1+idLen])
+	rest = rest[1+idLen:]
+	n := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < n {
+		return nil, fmt.Errorf("%w: truncated data key", ErrDecryptionFailed)
+	}
+	wrapped, sealed := rest[:n], rest[n:]
+
+	kek, err := keys.DecryptionKey(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecryptionFailed, err)
+	}
+	dataKey, err := open(kek, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error decrypting data key with key %q: %s", ErrDecryptionFailed, id, err)
+	}
+	plaintext, err := open(dataKey, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecryptionFailed, err)
+	}
+	return plaintext, nil
+}
+
+// seal encrypts the plaintext with AES-GCM, prefixing it with a random nonce.
+func seal(key, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts a ciphertext produced by seal.
+func open(key, ciphertext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,34 @@
+package konsul
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecrypt_LongestKeyID(t *testing.T) {
+	keys := StaticKey{ID: strings.Repeat("k", 255), Key: bytes.Repeat([]byte{1}, 32)}
+	value := []byte(`{"debug":true}`)
+
+	encrypted, err := encrypt(keys, value)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	decrypted, err := decrypt(keys, encrypted)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if !bytes.Equal(decrypted, value) {
+		t.Errorf("expected %q, got %q", value, decrypted)
+	}
+}
+
+func TestDecrypt_TruncatedHeader(t *testing.T) {
+	keys := StaticKey{ID: "key", Key: bytes.Repeat([]byte{1}, 32)}
+	value := append(append([]byte{}, encryptedMagic...), 0xFF, 'k')
+
+	if _, err := decrypt(keys, value); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("expected ErrDecryptionFailed, got %v", err)
+	}
+}
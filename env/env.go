@@ -0,0 +1,133 @@
+// Package env materializes the keys under a prefix in Consul KV store as
+// environment variables, allowing envconfig style loaders and child processes
+// to consume configuration stored in Consul unchanged.
+//
+// By default keys are mangled into environment variable names by removing the
+// prefix, upper casing, and replacing any character that isn't a letter, digit,
+// or underscore with an underscore. With the prefix "config/app" the key
+// "config/app/db/max-conns" becomes DB_MAX_CONNS.
+package env
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/jkratz55/konsul"
+)
+
+// Options holds optional configuration properties for mapping keys to
+// environment variables.
+type Options struct {
+	// Determines how Consul client interacts with Consul servers. When true any
+	// Consul server can be queried. Otherwise, all queries go to the leader.
+	AllowStale bool
+	// An optional prefix added to every environment variable name, such as
+	// "APP_".
+	VarPrefix string
+	// Maps a key, relative to the prefix, to an environment variable name. If
+	// not provided Mangle is used. VarPrefix is added to the returned name.
+	KeyFunc func(key string) string
+	// When true, Setenv overwrites environment variables that are already set.
+	// Otherwise, existing environment variables take precedence over Consul.
+	Overwrite bool
+}
+
+// Mangle converts a key to an environment variable name by upper casing it and
+// replacing any character that isn't a letter, digit, or underscore with an
+// underscore. Names starting with a digit are prefixed with an underscore.
+func Mangle(key string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(key) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	name := b.String()
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// Map retrieves the keys under prefix and returns them as a map of environment
+// variable names to values. Folders and keys mapping to an empty name are
+// skipped.
+//
+// If the keys cannot be retrieved from Consul, or multiple keys map to the same
+// environment variable name, a non-nil error is returned.
+func Map(client *api.Client, prefix string, opts Options) (map[string]string, error) {
+	if client == nil {
+		panic("cannot provide nil consul api.Client, illegal use of api")
+	}
+	if opts.KeyFunc == nil {
+		opts.KeyFunc = Mangle
+	}
+
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	pairs, _, err := client.KV().List(prefix, &api.QueryOptions{
+		AllowStale: opts.AllowStale,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing keys with prefix %s from Consul: %w", prefix, err)
+	}
+	pairs, err = konsul.ReassembleChunks(pairs)
+	if err != nil {
+		return nil, fmt.Errorf("error reassembling chunked values under prefix %s: %w", prefix, err)
+	}
+
+	vars := make(map[string]string, len(pairs))
+	keys := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		if strings.HasSuffix(pair.Key, "/") {
+			continue
+		}
+		name := opts.KeyFunc(strings.TrimPrefix(pair.Key, prefix))
+		if name == "" {
+			continue
+		}
+		name = opts.VarPrefix + name
+		if other, ok := keys[name]; ok {
+			return nil, fmt.Errorf("keys %s and %s both map to environment variable %s", other, pair.Key, name)
+		}
+		keys[name] = pair.Key
+		vars[name] = string(pair.Value)
+	}
+	return vars, nil
+}
+
+// Setenv retrieves the keys under prefix and sets them as environment variables
+// of the current process. Unless Options.Overwrite is true, environment
+// variables that are already set are left unchanged.
+func Setenv(client *api.Client, prefix string, opts Options) error {
+	vars, err := Map(client, prefix, opts)
+	if err != nil {
+		return err
+	}
+	for name, value := range vars {
+		if _, exists := os.LookupEnv(name); exists && !opts.Overwrite {
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return fmt.Errorf("error setting environment variable %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Environ returns the variables in the "NAME=value" form used by os.Environ and
+// exec.Cmd, sorted by name. To pass the variables to a child process along with
+// the environment of the current process, append the result to os.Environ().
+func Environ(vars map[string]string) []string {
+	env := make([]string, 0, len(vars))
+	for name, value := range vars {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
@@ -0,0 +1,209 @@
+package konsul
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Error handling policy
+//
+// konsul reports failures by returning errors. Functions and methods that panic
+// instead are either prefixed with Must, or predate this policy and have an
+// error-returning variant:
+//
+//	NewKVClient                          ->  TryNewKVClient
+//	NewPeeringClient                     ->  TryNewPeeringClient
+//	MustNewInstancer                     ->  NewInstancer
+//	Instancer.Instance                   ->  Instancer.Next
+//	Instancer.Instances                  ->  Instancer.List
+//	Instancer.RegisterListener           ->  Instancer.AddListener
+//
+// The panicking variants are thin wrappers around the error-returning ones and
+// remain for compatibility.
+//
+// Errors that occur on background goroutines, where they cannot be returned,
+// are handled according to the configuration of the component. Instancer
+// retries failed refreshes and reports them on Instancer.Errors, including when
+// its InstancerConfig.Restart policy gives up, and only panics then when
+// InstancerConfig.PanicOnPlanError is true. Watch only
+// panics on unmarshalling failures when WatchOptions.PanicOnUnmarshalFailure is
+// true. Watch reports these failures on WatchOptions.Errors instead when it's
+// provided, along with failed queries.
+//
+// Failures communicating with Consul are reported as a *KVError, *WatchError,
+// or *DiscoveryError identifying the operation that failed. These can be
+// classified with errors.Is against ErrPermissionDenied and ErrKeyNotFound,
+// and with IsTransient to decide if retrying may succeed.
+
+var (
+	// ErrInvalidConfig is a sentinel error value indicating the configuration or
+	// arguments provided are invalid. Errors returned for invalid configuration
+	// wrap ErrInvalidConfig.
+	ErrInvalidConfig = errors.New("invalid configuration")
+	// ErrInstancerClosed is a sentinel error value indicating the Instancer has
+	// been closed.
+	ErrInstancerClosed = errors.New("instancer is closed")
+	// ErrNoInstances is a sentinel error value indicating there are no instances
+	// of the service.
+	ErrNoInstances = errors.New("no instances available")
+	// ErrDuplicateListener is a sentinel error value indicating the
+	// InstanceListener is already registered with the Instancer.
+	ErrDuplicateListener = errors.New("listener is already registered")
+)
+
+func invalidConfig(msg string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidConfig, msg)
+}
+
+// ErrPermissionDenied is a sentinel error value indicating the ACL token used
+// doesn't grant the permissions required by the operation. KVError, WatchError,
+// and DiscoveryError match ErrPermissionDenied with errors.Is when Consul
+// denies the request.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// KVError records a failed operation against the Consul KV store along with the
+// key and the operation that failed, such as OpGet or OpPut.
+//
+// KVError matches ErrPermissionDenied and ErrKeyNotFound with errors.Is when
+// Consul responds accordingly, and the underlying error, such as a
+// *ValidationError, can be retrieved with errors.As.
+type KVError struct {
+	Op  string
+	Key string
+	Err error
+}
+
+func (e *KVError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("kv %s: %s", e.Op, e.Err)
+	}
+	return fmt.Sprintf("kv %s %s: %s", e.Op, e.Key, e.Err)
+}
+
+func (e *KVError) Unwrap() error {
+	return e.Err
+}
+
+func (e *KVError) Is(target error) bool {
+	return matchStatus(e.Err, target)
+}
+
+// WatchError records a failure watching a key, such as the initial fetch of the
+// key failing or a query the watch retries with backoff failing. For watches of
+// other types, such as WatchNodes, Key names the watch instead.
+type WatchError struct {
+	Key string
+	Err error
+}
+
+func (e *WatchError) Error() string {
+	return fmt.Sprintf("watch %s: %s", e.Key, e.Err)
+}
+
+func (e *WatchError) Unwrap() error {
+	return e.Err
+}
+
+func (e *WatchError) Is(target error) bool {
+	return matchStatus(e.Err, target)
+}
+
+// RollbackError is reported to WatchNotification when a change fails to be
+// applied and Watch restored the last known good value, as configured by
+// WatchOptions.RollbackOnFailure.
+type RollbackError struct {
+	Key string
+	// The error the change failed with.
+	Err error
+	// The last value successfully applied, which cfg was refreshed with again.
+	LastGood []byte
+	// A non-nil error if refreshing cfg with LastGood failed as well, in which
+	// case cfg may be left partially updated.
+	RollbackErr error
+}
+
+func (e *RollbackError) Error() string {
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("failed to apply value for key %s: %s, and rolling back failed: %s", e.Key, e.Err, e.RollbackErr)
+	}
+	return fmt.Sprintf("failed to apply value for key %s, rolled back to last known good value: %s", e.Key, e.Err)
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// DiscoveryError records a failure discovering the instances of a service.
+type DiscoveryError struct {
+	Service string
+	Err     error
+}
+
+func (e *DiscoveryError) Error() string {
+	return fmt.Sprintf("discovery %s: %s", e.Service, e.Err)
+}
+
+func (e *DiscoveryError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DiscoveryError) Is(target error) bool {
+	return matchStatus(e.Err, target)
+}
+
+// matchStatus reports if the HTTP status Consul responded with corresponds to
+// the target sentinel error.
+func matchStatus(err error, target error) bool {
+	var statusErr api.StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	switch target {
+	case ErrPermissionDenied:
+		return statusErr.Code == http.StatusForbidden
+	case ErrKeyNotFound:
+		return statusErr.Code == http.StatusNotFound
+	default:
+		return false
+	}
+}
+
+// IsTransient reports if the error is likely temporary, such as a network
+// failure, timeout, rate limiting, or Consul being unavailable or without a
+// leader, in which case retrying the operation may succeed. Errors such as
+// invalid configuration, denied permissions, and invalid values aren't
+// transient.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr api.StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		default:
+			return false
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
@@ -0,0 +1,348 @@
+package konsul
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hashicorp/consul/api"
+	"gopkg.in/yaml.v3"
+)
+
+// ExportedKV is a key-value of a document produced by Export. Encoded as JSON
+// it's compatible with the format of consul kv export, where the value is
+// base64 encoded.
+type ExportedKV struct {
+	Key   string `json:"key" yaml:"key"`
+	Flags uint64 `json:"flags" yaml:"flags"`
+	Value []byte `json:"value" yaml:"value"`
+}
+
+// exportedKVYAML is the YAML representation of ExportedKV. Values are encoded
+// as strings so documents stay readable, or tagged as !!binary and base64
+// encoded if they aren't valid UTF-8.
+type exportedKVYAML struct {
+	Key   string    `yaml:"key"`
+	Flags uint64    `yaml:"flags"`
+	Value yaml.Node `yaml:"value"`
+}
+
+func (kv ExportedKV) MarshalYAML() (any, error) {
+	value := yaml.Node{Kind: yaml.ScalarNode, Value: string(kv.Value)}
+	if !utf8.Valid(kv.Value) {
+		value.Tag = "!!binary"
+		value.Value = base64.StdEncoding.EncodeToString(kv.Value)
+	}
+	return exportedKVYAML{Key: kv.Key, Flags: kv.Flags, Value: value}, nil
+}
+
+func (kv *ExportedKV) UnmarshalYAML(node *yaml.Node) error {
+	var raw exportedKVYAML
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+	var value string
+	if err := raw.Value.Decode(&value); err != nil {
+		return fmt.Errorf("invalid value of key %s: %w", raw.Key, err)
+	}
+	*kv = ExportedKV{Key: raw.Key, Flags: raw.Flags, Value: []byte(value)}
+	return nil
+}
+
+// ImportMode determines how Import treats keys that already exist.
+type ImportMode int
+
+const (
+	// ImportMerge writes every key of the document, updating keys that
+	// already exist, and leaves keys that aren't in the document untouched.
+	ImportMerge ImportMode = iota
+	// ImportOverwrite makes the keys under ImportOptions.TargetPrefix identical
+	// to the document: keys of the document are written and keys under the
+	// prefix that aren't in the document are deleted.
+	ImportOverwrite
+	// ImportSkipExisting only writes the keys of the document that don't
+	// exist, leaving existing keys untouched.
+	ImportSkipExisting
+)
+
+func (m ImportMode) String() string {
+	switch m {
+	case ImportMerge:
+		return "merge"
+	case ImportOverwrite:
+		return "overwrite"
+	case ImportSkipExisting:
+		return "skip-existing"
+	default:
+		return fmt.Sprintf("ImportMode(%d)", int(m))
+	}
+}
+
+// ImportOptions holds optional configuration properties for Import.
+type ImportOptions struct {
+	// The name or content type of the Codec the document is decoded with. If
+	// not provided documents that are valid JSON are decoded as JSON, and YAML
+	// otherwise.
+	Codec string
+	// How keys that already exist are treated. Defaults to ImportMerge.
+	Mode ImportMode
+	// Optional prefixes rewriting the keys of the document, allowing a tree to
+	// be promoted between environments: SourcePrefix is removed from each key
+	// and TargetPrefix is prepended. Keys not starting with SourcePrefix are
+	// rejected. TargetPrefix is required by ImportOverwrite, as it scopes the
+	// keys that are deleted; set both prefixes to the same value to overwrite
+	// without rewriting keys.
+	SourcePrefix string
+	TargetPrefix string
+	// When true the changes Import would make are returned without writing
+	// anything.
+	DryRun bool
+}
+
+func (o ImportOptions) validate() error {
+	switch o.Mode {
+	case ImportMerge, ImportSkipExisting:
+	case ImportOverwrite:
+		if o.TargetPrefix == "" {
+			return invalidConfig("a TargetPrefix must be provided to import with ImportOverwrite")
+		}
+	default:
+		return invalidConfig(fmt.Sprintf("unknown import mode %s", o.Mode))
+	}
+	return nil
+}
+
+// ImportResult holds the keys changed by Import, or that would be changed in a
+// dry run. Keys are sorted lexically.
+type ImportResult struct {
+	// Keys of the document that didn't exist.
+	Created []string
+	// Keys of the document that existed with a different value or flags.
+	Updated []string
+	// Keys of the document left untouched because their value and flags are
+	// already up-to-date, or they exist and the mode is ImportSkipExisting.
+	Unchanged []string
+	// Keys under TargetPrefix deleted because they aren't in the document.
+	Deleted []string
+}
+
+// Export produces a portable document of every key-value under the prefix,
+// encoded with the Codec registered with the provided name or content type,
+// such as CodecJSON or CodecYAML. The document is a list of ExportedKV sorted
+// by key. Values are exported decrypted and decompressed, so the document can
+// be imported by a KVClient configured with different encryption keys. Values
+// stored with PutLarge are exported whole rather than as chunks.
+//
+// Use Import to restore the document, possibly under another prefix. If an
+// error occurs a non-nil error value is returned.
+func (c KVClient) Export(prefix string, codec string) ([]byte, error) {
+	enc, err := LookupCodec(codec)
+	if err != nil {
+		return nil, &KVError{Op: OpList, Key: prefix, Err: err}
+	}
+	kvs, err := c.List(prefix, false)
+	if err != nil {
+		return nil, err
+	}
+	exported := make([]ExportedKV, 0, len(kvs))
+	for _, kv := range kvs {
+		if IsChunkKey(kv.Key()) {
+			continue
+		}
+		value := kv.RawValue()
+		if manifest, chunked, _ := parseChunkManifest(value); chunked {
+			if value, err = c.GetLarge(kv.Key()); err != nil {
+				return nil, err
+			}
+			c.logger.Debug("exporting large KV", "key", kv.Key(), "chunks", manifest.Chunks)
+		}
+		exported = append(exported, ExportedKV{
+			Key:   kv.Key(),
+			Flags: kv.Flags(),
+			Value: value,
+		})
+	}
+	sort.Slice(exported, func(i, j int) bool { return exported[i].Key < exported[j].Key })
+	data, err := enc.Marshal(exported)
+	if err != nil {
+		return nil, &KVError{Op: OpList, Key: prefix, Err: fmt.Errorf("error encoding export with codec %s: %w", codec, err)}
+	}
+	return data, nil
+}
+
+// Import restores a document produced by Export, or by consul kv export. Keys
+// are written in transactions of up to MaxTxnOps operations, so documents of
+// at most MaxTxnOps changes are applied atomically, while larger documents may
+// be partially applied if an error occurs. Values larger than ChunkSize are
+// written with PutLarge after the transactions. Values are validated against
+// the Schemas of the KVClient before anything is written, and keys whose value
+// and flags are up-to-date aren't written.
+//
+// If the options are invalid an error wrapping ErrInvalidConfig is returned. If
+// an error occurs a non-nil error value is returned along with the changes made
+// before the error.
+func (c KVClient) Import(doc []byte, opts ImportOptions) (*ImportResult, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	kvs, err := decodeExport(doc, opts)
+	if err != nil {
+		return nil, &KVError{Op: OpPut, Err: err}
+	}
+	for _, kv := range kvs {
+		if err := c.schemas.Validate(kv.Key, kv.Value); err != nil {
+			return nil, &KVError{Op: OpPut, Key: kv.Key, Err: err}
+		}
+	}
+
+	keys := make([]string, len(kvs))
+	for i, kv := range kvs {
+		keys[i] = kv.Key
+	}
+	current, err := c.GetMany(keys)
+	if err != nil {
+		return nil, err
+	}
+	for key, kv := range current {
+		if _, chunked, _ := parseChunkManifest(kv.RawValue()); chunked {
+			value, err := c.GetLarge(key)
+			if err != nil {
+				return nil, err
+			}
+			current[key] = WrapKVPair(&api.KVPair{Key: key, Flags: kv.Flags(), Value: value})
+		}
+	}
+
+	result := &ImportResult{}
+	var writes []ExportedKV
+	for _, kv := range kvs {
+		existing, ok := current[kv.Key]
+		switch {
+		case !ok:
+			result.Created = append(result.Created, kv.Key)
+		case opts.Mode == ImportSkipExisting ||
+			(bytes.Equal(existing.RawValue(), kv.Value) && existing.Flags() == kv.Flags):
+			result.Unchanged = append(result.Unchanged, kv.Key)
+			continue
+		default:
+			result.Updated = append(result.Updated, kv.Key)
+		}
+		writes = append(writes, kv)
+	}
+	if opts.Mode == ImportOverwrite {
+		existing, err := c.Keys(opts.TargetPrefix, "")
+		if err != nil {
+			return nil, err
+		}
+		imported := make(map[string]struct{}, len(kvs))
+		for _, kv := range kvs {
+			imported[kv.Key] = struct{}{}
+		}
+		for _, key := range existing {
+			if _, ok := imported[key]; !ok && !IsChunkKey(key) {
+				result.Deleted = append(result.Deleted, key)
+			}
+		}
+	}
+
+	if opts.DryRun {
+		return result, nil
+	}
+	if err := c.applyImport(writes, result.Deleted); err != nil {
+		return result, err
+	}
+	c.logger.Debug("imported KVs in Consul",
+		"created", len(result.Created),
+		"updated", len(result.Updated),
+		"unchanged", len(result.Unchanged),
+		"deleted", len(result.Deleted))
+	return result, nil
+}
+
+// applyImport writes and deletes the keys in transactions of up to MaxTxnOps
+// operations.
+func (c KVClient) applyImport(writes []ExportedKV, deletes []string) error {
+	var ops []*api.KVTxnOp
+	var large []ExportedKV
+	txn := c.Txn()
+	for _, kv := range writes {
+		value := txn.encode(kv.Key, kv.Value)
+		if len(value) > ChunkSize {
+			large = append(large, kv)
+			continue
+		}
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVSet, Key: kv.Key, Value: value, Flags: kv.Flags})
+	}
+	for _, key := range deletes {
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVDeleteTree, Key: key + "/.chunks/"})
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVDelete, Key: key})
+	}
+	if txn.err != nil {
+		return &KVError{Op: OpPut, Err: txn.err}
+	}
+
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > MaxTxnOps {
+			n = MaxTxnOps
+		}
+		batch := c.Txn()
+		for _, op := range ops[:n] {
+			batch.add(op)
+		}
+		result, err := batch.Commit()
+		if err == nil && !result.Committed {
+			err = &KVError{Op: OpTxn, Err: fmt.Errorf("failed to import keys: %v", result.Errors)}
+		}
+		if err != nil {
+			c.logger.Debug("failed to import KVs in Consul", "error", err)
+			return err
+		}
+		ops = ops[n:]
+	}
+	for _, kv := range large {
+		if err := c.PutLarge(kv.Key, kv.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeExport decodes the document and rewrites its keys from SourcePrefix to
+// TargetPrefix, sorted by key.
+func decodeExport(doc []byte, opts ImportOptions) ([]ExportedKV, error) {
+	codec := opts.Codec
+	if codec == "" {
+		codec = CodecYAML
+		if json.Valid(doc) {
+			codec = CodecJSON
+		}
+	}
+	dec, err := LookupCodec(codec)
+	if err != nil {
+		return nil, err
+	}
+	var kvs []ExportedKV
+	if err := dec.Unmarshal(doc, &kvs); err != nil {
+		return nil, fmt.Errorf("error decoding export with codec %s: %w", codec, err)
+	}
+
+	seen := make(map[string]struct{}, len(kvs))
+	for i, kv := range kvs {
+		if !strings.HasPrefix(kv.Key, opts.SourcePrefix) {
+			return nil, fmt.Errorf("key %s doesn't start with source prefix %s", kv.Key, opts.SourcePrefix)
+		}
+		key := opts.TargetPrefix + strings.TrimPrefix(kv.Key, opts.SourcePrefix)
+		if _, ok := seen[key]; ok {
+			return nil, fmt.Errorf("duplicate key %s", key)
+		}
+		seen[key] = struct{}{}
+		kvs[i].Key = key
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs, nil
+}
@@ -0,0 +1,324 @@
+// Package feature provides feature flags backed by Consul's KV store.
+//
+// Flags are either stored together in a key as a JSON object mapping the name
+// of each flag to its value, or one per key under a prefix, where the key
+// relative to the prefix is the name of the flag. The value of a flag is a
+// boolean, a percentage of subjects the flag is enabled for, a variant, or an
+// object combining them:
+//
+//	{
+//		"new-checkout": true,
+//		"search-v2": 25,
+//		"theme": "dark",
+//		"pricing": {"enabled": true, "percentage": 10, "variant": "b"}
+//	}
+//
+// Flags watches the key or prefix and exposes typed accessors reading the
+// latest flags without locking:
+//
+//	flags, err := feature.New(feature.Config{
+//		Client: client,
+//		Key:    "config/app/flags",
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	go func() {
+//		if err := flags.Run(ctx); err != nil {
+//			panic(err)
+//		}
+//	}()
+//	...
+//	if flags.IsEnabled("new-checkout") {
+//		...
+//	}
+package feature
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/jkratz55/konsul"
+)
+
+// Flag is the value of a feature flag.
+type Flag struct {
+	// True if the flag is enabled for every subject.
+	Enabled bool `json:"enabled"`
+	// The percentage of subjects, between 0 and 100, the flag is enabled for
+	// when it isn't enabled for every subject.
+	Percentage float64 `json:"percentage"`
+	// The variant of the flag, such as the variant of an experiment.
+	Variant string `json:"variant"`
+}
+
+// UnmarshalJSON decodes a flag from a boolean, a number holding its
+// percentage, a string holding its variant, or an object.
+func (f *Flag) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return fmt.Errorf("empty flag")
+	}
+	switch data[0] {
+	case 't', 'f':
+		*f = Flag{}
+		return json.Unmarshal(data, &f.Enabled)
+	case '"':
+		*f = Flag{}
+		return json.Unmarshal(data, &f.Variant)
+	case '{':
+		type flag Flag
+		var v flag
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		*f = Flag(v)
+	default:
+		*f = Flag{}
+		if err := json.Unmarshal(data, &f.Percentage); err != nil {
+			return err
+		}
+	}
+	if f.Percentage < 0 || f.Percentage > 100 {
+		return fmt.Errorf("percentage %v isn't between 0 and 100", f.Percentage)
+	}
+	return nil
+}
+
+// Config holds the configuration properties to create Flags.
+type Config struct {
+	// The Consul api Client to use to communicate with Consul. This is a required
+	// field.
+	Client *api.Client
+	// The key holding every flag as a JSON object. Exactly one of Key and
+	// Prefix must be specified.
+	Key string
+	// The prefix the flags are stored under, one per key. Exactly one of Key
+	// and Prefix must be specified.
+	Prefix string
+	// Optional options customizing how the key or prefix is watched, such as
+	// konsul.WithLogger.
+	Options []konsul.WatchOption
+}
+
+func (c *Config) validate() error {
+	if c.Client == nil {
+		return fmt.Errorf("%w: cannot provide nil consul api.Client", konsul.ErrInvalidConfig)
+	}
+	if (strings.TrimSpace(c.Key) == "") == (strings.TrimSpace(c.Prefix) == "") {
+		return fmt.Errorf("%w: exactly one of a key or a prefix must be specified", konsul.ErrInvalidConfig)
+	}
+	return nil
+}
+
+// Flags holds feature flags watched in Consul's KV store.
+//
+// Flags is safe for concurrent use. The zero-value of Flags is not usable. Use
+// New to create and initialize Flags.
+type Flags struct {
+	client *api.Client
+	key    string
+	prefix string
+	opts   []konsul.WatchOption
+
+	flags atomic.Pointer[map[string]Flag]
+
+	mu   sync.Mutex
+	subs map[string]map[int]func(Flag)
+	seq  int
+	// The flags under the prefix by key. Only accessed by the prefix handler,
+	// which is invoked sequentially.
+	byKey map[string]Flag
+}
+
+// New creates and initializes Flags with the provided configuration. No flags
+// are loaded until Run is called. If the configuration is invalid an error
+// wrapping konsul.ErrInvalidConfig is returned.
+func New(config Config) (*Flags, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	f := &Flags{
+		client: config.Client,
+		key:    config.Key,
+		prefix: config.Prefix,
+		opts:   config.Options,
+		subs:   make(map[string]map[int]func(Flag)),
+		byKey:  make(map[string]Flag),
+	}
+	f.flags.Store(&map[string]Flag{})
+	return f, nil
+}
+
+// MustNew creates and initializes Flags like New. If the configuration is
+// invalid this will panic.
+func MustNew(config Config) *Flags {
+	f, err := New(config)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Run watches the flags until the context is cancelled, at which point it
+// returns nil. Values that cannot be decoded are reported like unmarshalling
+// failures of konsul.Watch, and the flags are left unchanged. Run is blocking
+// and only returns on an error or once the context is cancelled.
+func (f *Flags) Run(ctx context.Context) error {
+	if f.key != "" {
+		return konsul.WatchContext(ctx, f.client, f.key, (*document)(f), f.opts...)
+	}
+	return konsul.WatchPrefixContext(ctx, f.client, f.prefix, f.handlePrefix, f.opts...)
+}
+
+// Lookup returns the flag with the name and true, or false if the flag doesn't
+// exist.
+func (f *Flags) Lookup(name string) (Flag, bool) {
+	flag, ok := (*f.flags.Load())[name]
+	return flag, ok
+}
+
+// IsEnabled returns true if the flag is enabled for every subject. Flags that
+// don't exist are disabled.
+func (f *Flags) IsEnabled(name string) bool {
+	flag, _ := f.Lookup(name)
+	return flag.Enabled
+}
+
+// IsEnabledFor returns true if the flag is enabled for the subject, such as a
+// user or tenant ID, either because it's enabled for every subject or because
+// the subject falls within its percentage. A subject consistently falls within
+// the percentage of a flag, so it keeps seeing the same behavior as the
+// percentage grows.
+func (f *Flags) IsEnabledFor(name string, subject string) bool {
+	flag, _ := f.Lookup(name)
+	if flag.Enabled {
+		return true
+	}
+	if flag.Percentage <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(subject))
+	return float64(h.Sum32()%10000)/100 < flag.Percentage
+}
+
+// Percentage returns the percentage of subjects the flag is enabled for, which
+// is 100 if it's enabled for every subject, or 0 if the flag doesn't exist.
+func (f *Flags) Percentage(name string) float64 {
+	flag, _ := f.Lookup(name)
+	if flag.Enabled {
+		return 100
+	}
+	return flag.Percentage
+}
+
+// Variant returns the variant of the flag, or def if the flag doesn't exist or
+// has no variant.
+func (f *Flags) Variant(name string, def string) string {
+	flag, _ := f.Lookup(name)
+	if flag.Variant == "" {
+		return def
+	}
+	return flag.Variant
+}
+
+// Subscribe registers a callback invoked with the new value of the flag each
+// time it changes, or the zero-value if the flag is removed. Callbacks are
+// invoked sequentially on the goroutine watching the flags and should return
+// quickly. The returned function unregisters the callback.
+func (f *Flags) Subscribe(name string, fn func(Flag)) (unsubscribe func()) {
+	if fn == nil {
+		panic("cannot provide nil callback, illegal use of api")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	id := f.seq
+	if f.subs[name] == nil {
+		f.subs[name] = make(map[int]func(Flag))
+	}
+	f.subs[name][id] = fn
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.subs[name], id)
+		if len(f.subs[name]) == 0 {
+			delete(f.subs, name)
+		}
+	}
+}
+
+// update replaces the flags and notifies the subscribers of the flags that
+// changed.
+func (f *Flags) update(flags map[string]Flag) {
+	old := *f.flags.Swap(&flags)
+
+	f.mu.Lock()
+	var notify []func()
+	for name, subs := range f.subs {
+		flag, ok := flags[name]
+		prev, existed := old[name]
+		if ok == existed && flag == prev {
+			continue
+		}
+		for _, fn := range subs {
+			fn := fn
+			notify = append(notify, func() { fn(flag) })
+		}
+	}
+	f.mu.Unlock()
+
+	for _, fn := range notify {
+		fn()
+	}
+}
+
+// handlePrefix applies the changes to the flags under the prefix.
+func (f *Flags) handlePrefix(change konsul.PrefixChange) error {
+	var first error
+	for _, kv := range change.Changed {
+		var flag Flag
+		if err := json.Unmarshal(kv.RawValue(), &flag); err != nil {
+			if first == nil {
+				first = fmt.Errorf("invalid flag %s: %w", kv.Key(), err)
+			}
+			continue
+		}
+		f.byKey[kv.Key()] = flag
+	}
+	for _, key := range change.Deleted {
+		delete(f.byKey, key)
+	}
+	flags := make(map[string]Flag, len(f.byKey))
+	for key, flag := range f.byKey {
+		flags[change.Relative(key)] = flag
+	}
+	f.update(flags)
+	return first
+}
+
+// document decodes the JSON object holding every flag into the Flags.
+type document Flags
+
+func (d *document) UnmarshalBinary(data []byte) error {
+	var flags map[string]Flag
+	if err := json.Unmarshal(data, &flags); err != nil {
+		return fmt.Errorf("invalid flags: %w", err)
+	}
+	if flags == nil {
+		flags = make(map[string]Flag)
+	}
+	(*Flags)(d).update(flags)
+	return nil
+}
@@ -0,0 +1,67 @@
+package konsul
+
+import (
+	"github.com/hashicorp/consul/api"
+)
+
+// Flags is a bitmask of user-defined flags stored with a key. Consul doesn't
+// interpret flags, so applications can use them to mark keys, such as values
+// that are encrypted or in a given format:
+//
+//	const (
+//		FlagEncrypted konsul.Flags = 1 << iota
+//		FlagProto
+//	)
+//
+//	err := kv.Put("config/app", data, konsul.WithFlags(FlagEncrypted|FlagProto))
+//	...
+//	if pair.HasFlag(FlagEncrypted) {
+//		...
+//	}
+type Flags uint64
+
+// Has returns true if every flag of flag is set.
+func (f Flags) Has(flag Flags) bool {
+	return f&flag == flag
+}
+
+// Set returns the flags with the flags of flag set.
+func (f Flags) Set(flag Flags) Flags {
+	return f | flag
+}
+
+// Clear returns the flags with the flags of flag cleared.
+func (f Flags) Clear(flag Flags) Flags {
+	return f &^ flag
+}
+
+// HasFlag returns true if every flag of flag is set on the KeyValue. An empty
+// KeyValue has no flags set.
+func (kv KeyValue) HasFlag(flag Flags) bool {
+	return Flags(kv.Flags()).Has(flag)
+}
+
+// WithFlags sets the flags stored with the key by Put, PutJSON, PutYAML,
+// PutEncoded, and Acquire. Without it keys are written with no flags set,
+// clearing the flags of existing keys.
+func WithFlags(flags Flags) WriteOption {
+	return flagsOption(flags)
+}
+
+type flagsOption Flags
+
+func (flagsOption) applyWrite(*api.WriteOptions) {}
+
+// WriteFlags returns the flags set with WithFlags by the options and true, or
+// false if the options don't set flags. It's useful for implementations of KV,
+// such as fakes, that need to honor the options.
+func WriteFlags(opts ...WriteOption) (Flags, bool) {
+	var flags Flags
+	var ok bool
+	for _, opt := range opts {
+		if f, isFlags := opt.(flagsOption); isFlags {
+			flags, ok = Flags(f), true
+		}
+	}
+	return flags, ok
+}
@@ -0,0 +1,84 @@
+// Package flagset populates a standard library flag.FlagSet from keys in Consul
+// KV store, allowing CLI configured services to be overridden centrally.
+package flagset
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/jkratz55/konsul"
+)
+
+// Options holds optional configuration properties for Bind.
+type Options struct {
+	// Determines how Consul client interacts with Consul servers. When true any
+	// Consul server can be queried. Otherwise, all queries go to the leader.
+	AllowStale bool
+	// Maps the name of a flag to the key under the prefix holding its value.
+	// If not provided the flag name is used as is, so the flag "port" is read
+	// from the key "<prefix>/port".
+	KeyFunc func(name string) string
+}
+
+// Bind sets the value of each flag in the FlagSet from the key under prefix
+// named after the flag. Flags without a corresponding key are left unchanged.
+//
+// Bind should be called before the FlagSet is parsed. The values from Consul
+// replace the defaults of the flags, including the defaults displayed in usage,
+// while flags provided on the command line still take precedence. Since the
+// flags aren't set through the FlagSet, FlagSet.Visit only visits flags set on
+// the command line.
+//
+// If the keys cannot be retrieved from Consul, or a value is invalid for its
+// flag, a non-nil error is returned.
+func Bind(client *api.Client, fs *flag.FlagSet, prefix string, opts Options) error {
+	if client == nil {
+		panic("cannot provide nil consul api.Client, illegal use of api")
+	}
+	if fs == nil {
+		panic("cannot provide nil flag.FlagSet, illegal use of api")
+	}
+	if opts.KeyFunc == nil {
+		opts.KeyFunc = func(name string) string {
+			return name
+		}
+	}
+
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	pairs, _, err := client.KV().List(prefix, &api.QueryOptions{
+		AllowStale: opts.AllowStale,
+	})
+	if err != nil {
+		return fmt.Errorf("error listing keys with prefix %s from Consul: %w", prefix, err)
+	}
+	pairs, err = konsul.ReassembleChunks(pairs)
+	if err != nil {
+		return fmt.Errorf("error reassembling chunked values under prefix %s: %w", prefix, err)
+	}
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		values[strings.TrimPrefix(pair.Key, prefix)] = string(pair.Value)
+	}
+
+	var bindErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if bindErr != nil {
+			return
+		}
+		value, ok := values[opts.KeyFunc(f.Name)]
+		if !ok {
+			return
+		}
+		value = strings.TrimSpace(value)
+		if err := f.Value.Set(value); err != nil {
+			bindErr = fmt.Errorf("invalid value %q for flag -%s from key %s: %w",
+				value, f.Name, prefix+opts.KeyFunc(f.Name), err)
+			return
+		}
+		f.DefValue = f.Value.String()
+	})
+	return bindErr
+}
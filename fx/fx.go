@@ -0,0 +1,174 @@
+// Package fx provides konsul components to applications built with uber/fx,
+// standardizing how konsul is integrated in large codebases.
+//
+// Module provides the Consul api Client, KVClient, Watcher, and Registry, while
+// Instancer, Watch, and Register add Instancers, watched configuration, and
+// service registrations bound to the application lifecycle.
+//
+//	app := fx.New(
+//		konsulfx.Module,
+//		konsulfx.Instancer(konsul.InstancerConfig{Service: "payments", PassingOnly: true}),
+//		konsulfx.Register(&registry.Service{Name: "orders", Nodes: nodes}),
+//		fx.Invoke(func(p struct {
+//			fx.In
+//			Payments *konsul.Instancer `name:"payments"`
+//		}) {
+//			...
+//		}),
+//	)
+//
+// The Consul api Client is created with api.DefaultConfig unless an *api.Config
+// is supplied, for example with fx.Supply. Similarly konsul.KVClientOptions and
+// registry.Options are used by Module when supplied.
+package fx
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+	"go.uber.org/fx"
+
+	"github.com/jkratz55/konsul"
+	"github.com/jkratz55/konsul/registry"
+)
+
+// Module provides a Consul api Client, a *konsul.KVClient also provided as
+// konsul.KV, a konsul.Watcher also provided as konsul.ContextWatcher, and a
+// registry.Registry backed by Consul.
+var Module = fx.Module("konsul",
+	fx.Provide(
+		NewClient,
+		NewKVClient,
+		func(c *konsul.KVClient) konsul.KV { return c },
+		fx.Annotate(NewWatcher, fx.As(new(konsul.Watcher), new(konsul.ContextWatcher))),
+		fx.Annotate(NewRegistry, fx.As(new(registry.Registry))),
+	),
+)
+
+// ClientParams are the dependencies of NewClient.
+type ClientParams struct {
+	fx.In
+
+	Config *api.Config `optional:"true"`
+}
+
+// NewClient creates a Consul api Client from the supplied *api.Config, or
+// api.DefaultConfig if one isn't supplied.
+func NewClient(p ClientParams) (*api.Client, error) {
+	cfg := p.Config
+	if cfg == nil {
+		cfg = api.DefaultConfig()
+	}
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Consul client: %w", err)
+	}
+	return client, nil
+}
+
+// KVClientParams are the dependencies of NewKVClient.
+type KVClientParams struct {
+	fx.In
+
+	Client  *api.Client
+	Options konsul.KVClientOptions `optional:"true"`
+}
+
+// NewKVClient creates a KVClient with the supplied konsul.KVClientOptions, if
+// any.
+func NewKVClient(p KVClientParams) *konsul.KVClient {
+	return konsul.NewKVClient(p.Client, p.Options)
+}
+
+// NewWatcher creates a konsul.Watcher using the Consul api Client.
+func NewWatcher(client *api.Client) (konsul.ClientWatcher, error) {
+	return konsul.NewWatcher(client)
+}
+
+// RegistryParams are the dependencies of NewRegistry.
+type RegistryParams struct {
+	fx.In
+
+	Client  *api.Client
+	Options registry.Options `optional:"true"`
+}
+
+// NewRegistry creates a ConsulRegistry with the supplied registry.Options, if
+// any.
+func NewRegistry(p RegistryParams) *registry.ConsulRegistry {
+	return registry.NewConsulRegistry(p.Client, p.Options)
+}
+
+// Instancer provides a *konsul.Instancer for the configured service, named
+// after the service so multiple Instancers can be provided. If the Client of
+// the configuration is nil the Consul api Client from the container is used.
+// The Instancer is closed when the application stops.
+func Instancer(config konsul.InstancerConfig) fx.Option {
+	return fx.Provide(fx.Annotate(
+		func(lc fx.Lifecycle, client *api.Client) (*konsul.Instancer, error) {
+			if config.Client == nil {
+				config.Client = client
+			}
+			instancer, err := konsul.NewInstancer(config)
+			if err != nil {
+				return nil, err
+			}
+			lc.Append(fx.Hook{
+				OnStop: func(context.Context) error {
+					instancer.Close()
+					return nil
+				},
+			})
+			return instancer, nil
+		},
+		fx.ResultTags(fmt.Sprintf(`name:"%s"`, config.Service)),
+	))
+}
+
+// Watch watches the key with the konsul.ContextWatcher from the container once
+// the application starts, refreshing cfg on each change, and stops watching
+// the key when the application stops. If the watch stops with an error the
+// application is shut down, since cfg would no longer be updated.
+func Watch(key string, cfg encoding.BinaryUnmarshaler, opts ...konsul.WatchOption) fx.Option {
+	return fx.Invoke(func(lc fx.Lifecycle, sd fx.Shutdowner, watcher konsul.ContextWatcher) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				go func() {
+					defer close(done)
+					if err := watcher.WatchContext(ctx, key, cfg, opts...); err != nil {
+						_ = sd.Shutdown(fx.ExitCode(1))
+					}
+				}()
+				return nil
+			},
+			OnStop: func(stopCtx context.Context) error {
+				cancel()
+				select {
+				case <-done:
+					return nil
+				case <-stopCtx.Done():
+					return stopCtx.Err()
+				}
+			},
+		})
+	})
+}
+
+// Register registers the service with the registry.Registry from the container
+// when the application starts and deregisters it when the application stops.
+func Register(svc *registry.Service, opts ...registry.RegisterOption) fx.Option {
+	return fx.Invoke(func(lc fx.Lifecycle, reg registry.Registry) {
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				return reg.Register(svc, opts...)
+			},
+			OnStop: func(context.Context) error {
+				return reg.Deregister(svc)
+			},
+		})
+	})
+}
@@ -0,0 +1,80 @@
+package konsul
+
+import (
+	"encoding/json"
+)
+
+// GetOptions holds optional configuration properties for Get.
+type GetOptions struct {
+	// The name or content type of the Codec used to decode the value. If not
+	// provided the format is detected: values that are valid JSON are decoded
+	// as JSON, and YAML otherwise.
+	Codec string
+	// Determines how Consul client interacts with Consul servers. When true any
+	// Consul server can be queried. Otherwise, all queries go to the leader.
+	AllowStale bool
+	// Optional options customizing the request to Consul.
+	Query []QueryOption
+}
+
+// GetOption configures Get.
+type GetOption func(opts *GetOptions)
+
+// WithCodec sets the name or content type of the Codec Get decodes the value
+// with, disabling format detection.
+func WithCodec(codec string) GetOption {
+	return func(opts *GetOptions) {
+		opts.Codec = codec
+	}
+}
+
+// WithStale allows Get to query any Consul server rather than only the leader.
+func WithStale() GetOption {
+	return func(opts *GetOptions) {
+		opts.AllowStale = true
+	}
+}
+
+// WithQuery adds options customizing the request Get sends to Consul, such as
+// the datacenter or namespace.
+func WithQuery(opts ...QueryOption) GetOption {
+	return func(o *GetOptions) {
+		o.Query = append(o.Query, opts...)
+	}
+}
+
+// Get retrieves the key and decodes its value into a T in a single call,
+// replacing the pattern of calling Get and UnmarshalValueJSON.
+//
+//	cfg, err := konsul.Get[AppConfig](kv, "config/app")
+//
+// If the key doesn't exist a *KVError matching ErrKeyNotFound is returned. If
+// the value cannot be decoded a *KVError wrapping the decoding error is
+// returned.
+func Get[T any](kv KV, key string, opts ...GetOption) (T, error) {
+	var options GetOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var v T
+	pair, err := kv.Get(key, options.AllowStale, options.Query...)
+	if err != nil {
+		return v, err
+	}
+	if pair.Unwrap() == nil {
+		return v, &KVError{Op: OpGet, Key: key, Err: ErrKeyNotFound}
+	}
+
+	codec := options.Codec
+	if codec == "" {
+		codec = CodecYAML
+		if json.Valid(pair.RawValue()) {
+			codec = CodecJSON
+		}
+	}
+	if err := pair.UnmarshalValue(codec, &v); err != nil {
+		return v, &KVError{Op: OpGet, Key: key, Err: err}
+	}
+	return v, nil
+}
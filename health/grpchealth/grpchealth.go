@@ -0,0 +1,160 @@
+// Package grpchealth serves the standard grpc.health.v1 Health service with a
+// status reflecting konsul derived conditions, such as a config watch being
+// healthy or required dependencies being discovered. This allows gRPC and
+// Kubernetes health checking to mirror the state the application sees in
+// Consul.
+package grpchealth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/jkratz55/konsul"
+	khealth "github.com/jkratz55/konsul/health"
+)
+
+// Config holds the configuration properties to create a Server.
+type Config struct {
+	// The checks determining the serving status. The status is SERVING while
+	// all checks pass and NOT_SERVING otherwise. This is a required field.
+	Checks map[string]khealth.Check
+	// The gRPC service names whose status is updated along with the overall
+	// status of the server, which is the empty service name. If not provided
+	// only the overall status is updated.
+	Services []string
+	// How often the checks are run. If not provided a default of 10 seconds is
+	// used.
+	Interval time.Duration
+	// The logger used to log changes in status. If not provided a default
+	// logger will be used.
+	Logger konsul.Logger
+	// The Clock used to schedule the checks. If not provided
+	// konsul.SystemClock is used.
+	Clock konsul.Clock
+}
+
+func (c *Config) validate() {
+	if len(c.Checks) == 0 {
+		panic("at least one check must be provided, illegal use of api")
+	}
+	if c.Interval <= 0 {
+		c.Interval = 10 * time.Second
+	}
+}
+
+// Server is a grpc.health.v1 Health server whose serving status is determined
+// by periodically running checks.
+//
+// The zero-value of Server is not usable. Use NewServer to create and initialize
+// a Server.
+type Server struct {
+	hs       *health.Server
+	checks   map[string]khealth.Check
+	services []string
+	interval time.Duration
+	logger   hclog.Logger
+	clock    konsul.Clock
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	mu       sync.Mutex
+	serving  bool
+}
+
+// NewServer creates and initializes a Server with the provided configuration.
+// If the configuration is invalid (misusing the API) this will panic.
+//
+// The checks are run immediately and then periodically on a new goroutine until
+// Stop is called.
+func NewServer(config Config) *Server {
+	config.validate()
+	s := &Server{
+		hs:       health.NewServer(),
+		checks:   config.Checks,
+		services: config.Services,
+		interval: config.Interval,
+		logger:   konsul.HclogAdapter(config.Logger),
+		clock:    konsul.ClockOrSystem(config.Clock),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	s.update()
+	go s.run()
+	return s
+}
+
+// Register registers the Health service with the provided gRPC server.
+func (s *Server) Register(gs *grpc.Server) {
+	healthpb.RegisterHealthServer(gs, s.hs)
+}
+
+// HealthServer returns the underlying Health service implementation, which is
+// useful when the Health service needs to be registered manually.
+func (s *Server) HealthServer() healthpb.HealthServer {
+	return s.hs
+}
+
+// Serving returns true if all the checks passed the last time they were run.
+func (s *Server) Serving() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.serving
+}
+
+// Stop stops running the checks and sets all services to NOT_SERVING, which is
+// useful while the application is shutting down. Stop waits for checks in
+// progress to complete.
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+		s.hs.Shutdown()
+	})
+}
+
+func (s *Server) run() {
+	defer close(s.done)
+	ticker := s.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			s.update()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Server) update() {
+	err := khealth.Run(s.checks)
+	status := healthpb.HealthCheckResponse_SERVING
+	if err != nil {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	s.mu.Lock()
+	changed := s.serving != (err == nil)
+	s.serving = err == nil
+	s.mu.Unlock()
+
+	if changed {
+		if err != nil {
+			s.logger.Warn("health checks failing, gRPC health status is NOT_SERVING",
+				"error", err)
+		} else {
+			s.logger.Info("health checks passing, gRPC health status is SERVING")
+		}
+	}
+
+	s.hs.SetServingStatus("", status)
+	for _, svc := range s.services {
+		s.hs.SetServingStatus(svc, status)
+	}
+}
@@ -0,0 +1,159 @@
+// Package health provides checks reflecting the state of konsul and Consul, such
+// as whether a config watch is healthy or the instances of a dependency have
+// been discovered. The checks are used by integrations exposing the health of
+// an application, such as the grpchealth package.
+package health
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/jkratz55/konsul"
+)
+
+// Check reports whether a condition is healthy. A nil error indicates healthy.
+//
+// Checks are invoked periodically and should return quickly.
+type Check func() error
+
+// Run runs all the provided checks and returns an error joining the errors of
+// each failing check prefixed with its name. If all checks pass nil is
+// returned.
+func Run(checks map[string]Check) error {
+	var failures []string
+	for name, check := range checks {
+		if err := check(); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	sort.Strings(failures)
+	return fmt.Errorf("health checks failed: %s", strings.Join(failures, "; "))
+}
+
+// InstancesDiscovered returns a Check that passes while the Instancer has at
+// least one instance of the service it's monitoring. This is useful to report
+// an application unhealthy when a required dependency can't be discovered.
+func InstancesDiscovered(instancer *konsul.Instancer) Check {
+	if instancer == nil {
+		panic("cannot provide nil Instancer, illegal use of api")
+	}
+	return func() (err error) {
+		// Instances panics if the Instancer has been closed
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("instancer is closed: %v", r)
+			}
+		}()
+		if len(instancer.Instances()) == 0 {
+			return errors.New("no instances discovered")
+		}
+		return nil
+	}
+}
+
+// ServiceRegistered returns a Check that passes while the service with the
+// provided ID is registered with the local Consul agent.
+func ServiceRegistered(client *api.Client, serviceID string) Check {
+	if client == nil {
+		panic("cannot provide nil consul api.Client, illegal use of api")
+	}
+	return func() error {
+		svc, _, err := client.Agent().Service(serviceID, nil)
+		if err != nil {
+			return fmt.Errorf("error retrieving service %s from Consul agent: %w", serviceID, err)
+		}
+		if svc == nil {
+			return fmt.Errorf("service %s is not registered", serviceID)
+		}
+		return nil
+	}
+}
+
+// WatchTracker tracks the outcome of the most recent change handled by one or
+// more Watch calls. Its Notify method is a konsul.WatchNotificationFunc and is
+// provided as the WatchNotification option of Watch.
+//
+// The zero-value of WatchTracker is ready to use.
+type WatchTracker struct {
+	mu      sync.Mutex
+	errs    map[string]error
+	stopped map[string]error
+}
+
+// Notify records the outcome of a change to a watched key.
+func (t *WatchTracker) Notify(key string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.errs == nil {
+		t.errs = make(map[string]error)
+	}
+	t.errs[key] = err
+}
+
+// Stopped records that the Watch of a key returned, meaning changes to the key
+// are no longer applied. It should be called with the error returned by Watch.
+//
+//	go func() {
+//		err := konsul.Watch(client, "config/app", cfg, konsul.WatchOptions{
+//			WatchNotification: tracker.Notify,
+//		})
+//		tracker.Stopped("config/app", err)
+//	}()
+func (t *WatchTracker) Stopped(key string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped == nil {
+		t.stopped = make(map[string]error)
+	}
+	if err == nil {
+		err = errors.New("watch stopped")
+	}
+	t.stopped[key] = err
+}
+
+// Check returns a Check that passes when the most recent change of every
+// watched key was applied successfully and no watch has stopped. The Check
+// fails until at least one change has been recorded, since Watch notifies when
+// the initial value of a key is loaded.
+func (t *WatchTracker) Check() Check {
+	alive := t.Alive()
+	return func() error {
+		if err := alive(); err != nil {
+			return err
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if len(t.errs) == 0 {
+			return errors.New("no watched keys loaded")
+		}
+		for key, err := range t.errs {
+			if err != nil {
+				return fmt.Errorf("failed to apply change to key %s: %w", key, err)
+			}
+		}
+		return nil
+	}
+}
+
+// Alive returns a Check that passes until a watch has been recorded as stopped
+// with Stopped. Unlike Check it doesn't fail before the keys are loaded or when
+// a change fails to apply, making it suitable for liveness probes since a
+// stopped watch can only be recovered by restarting the process.
+func (t *WatchTracker) Alive() Check {
+	return func() error {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for key, err := range t.stopped {
+			return fmt.Errorf("watch of key %s stopped: %w", key, err)
+		}
+		return nil
+	}
+}
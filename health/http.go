@@ -0,0 +1,67 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// Response is the JSON body written by the handler returned by Handler.
+type Response struct {
+	// Either "ok" or "unavailable".
+	Status string `json:"status"`
+	// The outcome of each check by name, either "ok" or the error of the check.
+	Checks map[string]string `json:"checks"`
+}
+
+// Handler returns an http.Handler running the provided checks on each request.
+// It responds with 200 OK when all checks pass and 503 Service Unavailable
+// otherwise, along with a JSON Response detailing each check.
+//
+// Handler is intended for Kubernetes style readiness and liveness probes, so
+// probes capture config and discovery failures rather than only process
+// liveness. Readiness should include conditions that can recover, such as the
+// first config not being loaded or a required dependency having no instances,
+// while liveness should only include conditions that require a restart, such
+// as a watch that stopped.
+//
+//	mux.Handle("/readyz", health.Handler(map[string]health.Check{
+//		"config":   tracker.Check(),
+//		"payments": health.InstancesDiscovered(payments),
+//		"register": health.ServiceRegistered(client, "orders-1"),
+//	}))
+//	mux.Handle("/livez", health.Handler(map[string]health.Check{
+//		"watch": tracker.Alive(),
+//	}))
+func Handler(checks map[string]Check) http.Handler {
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := Response{
+			Status: "ok",
+			Checks: make(map[string]string, len(names)),
+		}
+		for _, name := range names {
+			if err := checks[name](); err != nil {
+				resp.Status = "unavailable"
+				resp.Checks[name] = err.Error()
+			} else {
+				resp.Checks[name] = "ok"
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if resp.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if r.Method == http.MethodHead {
+			return
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+}
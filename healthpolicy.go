@@ -0,0 +1,65 @@
+package konsul
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// HealthPolicy determines which instances Instancer yields based on the
+// aggregated status of their health checks. It only applies when PassingOnly
+// isn't set, since otherwise Consul only returns passing instances.
+type HealthPolicy int
+
+const (
+	// AnyHealth yields every instance regardless of the status of its health
+	// checks. This is the default policy.
+	AnyHealth HealthPolicy = iota
+	// ExcludeCritical yields passing and warning instances, never critical
+	// instances or instances in maintenance.
+	ExcludeCritical
+	// PreferPassing yields the passing instances, falling back to warning
+	// instances when none are passing, and never critical instances or
+	// instances in maintenance.
+	PreferPassing
+)
+
+// String returns the name of the policy.
+func (p HealthPolicy) String() string {
+	switch p {
+	case AnyHealth:
+		return "any-health"
+	case ExcludeCritical:
+		return "exclude-critical"
+	case PreferPassing:
+		return "prefer-passing"
+	default:
+		return fmt.Sprintf("HealthPolicy(%d)", int(p))
+	}
+}
+
+// apply returns the instances the policy yields among the instances.
+func (p HealthPolicy) apply(instances []Instance) []Instance {
+	if p == AnyHealth {
+		return instances
+	}
+	// The instances are kept in the order Consul returned them.
+	var passing, warning, healthy []Instance
+	for _, instance := range instances {
+		switch instance.Status {
+		case api.HealthPassing:
+			passing = append(passing, instance)
+			healthy = append(healthy, instance)
+		case api.HealthWarning:
+			warning = append(warning, instance)
+			healthy = append(healthy, instance)
+		}
+	}
+	if p != PreferPassing {
+		return healthy
+	}
+	if len(passing) > 0 {
+		return passing
+	}
+	return warning
+}
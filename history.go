@@ -0,0 +1,213 @@
+package konsul
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// historySegment separates a key from the snapshots of its previous values.
+const historySegment = "/.history/"
+
+// historyTimeFormat formats the timestamps of snapshots with a fixed width so
+// their keys sort chronologically.
+const historyTimeFormat = "20060102T150405.000000000Z"
+
+// HistoryOptions configures KVClient to keep the history of the values of keys.
+// Consul doesn't keep previous values, so when history is enabled every value
+// written with Put, PutJSON, PutYAML, PutEncoded, PutCAS, PutMany, or the Put
+// and PutCAS operations of a Txn is also written, in the same transaction, to a
+// timestamped snapshot under the key:
+//
+//	config/app
+//	config/app/.history/20240501T093000.000000000Z
+//
+// Snapshots are regular keys, so they're returned by List and Keys for
+// prefixes containing them, and are kept when the key is deleted. Values
+// written by other operations, such as PutLarge or Import, aren't recorded.
+// Use KVClient.History to retrieve snapshots.
+type HistoryOptions struct {
+	// The maximum number of snapshots kept per key. Older snapshots are
+	// deleted after a value is written. If not provided every snapshot is
+	// kept.
+	Retain int
+	// The Clock used to timestamp snapshots. If not provided SystemClock is
+	// used.
+	Clock Clock
+}
+
+func (o *HistoryOptions) validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.Retain < 0 {
+		return invalidConfig("history Retain cannot be negative")
+	}
+	return nil
+}
+
+// HistoryEntry is a snapshot of a previous value of a key.
+type HistoryEntry struct {
+	// The time the value was written.
+	Time time.Time
+	// The key of the snapshot in Consul.
+	Key   string
+	Value []byte
+}
+
+// History retrieves up to n of the most recent snapshots of the values of the
+// key, newest first, including the current value. When n isn't positive every
+// snapshot is returned. Snapshots are only recorded when the KVClient is
+// configured with HistoryOptions. If an error occurs communicating with Consul
+// a non-nil error value is returned.
+func (c KVClient) History(key string, n int) ([]HistoryEntry, error) {
+	// Consul returns keys sorted, which is chronological for snapshots.
+	keys, err := c.Keys(key+historySegment, "")
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 && len(keys) > n {
+		keys = keys[len(keys)-n:]
+	}
+	kvs, err := c.GetMany(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]HistoryEntry, 0, len(keys))
+	for i := len(keys) - 1; i >= 0; i-- {
+		kv, ok := kvs[keys[i]]
+		if !ok {
+			// Pruned since the keys were listed.
+			continue
+		}
+		ts, err := time.Parse(historyTimeFormat, strings.TrimPrefix(keys[i], key+historySegment))
+		if err != nil {
+			c.logger.Debug("ignoring key with invalid history timestamp", "key", keys[i], "error", err)
+			continue
+		}
+		entries = append(entries, HistoryEntry{Time: ts, Key: keys[i], Value: kv.RawValue()})
+	}
+	return entries, nil
+}
+
+// putWithHistory writes the encoded value of the key along with a snapshot in a
+// single transaction, and then prunes old snapshots. When cas is true the value
+// is only written if the ModifyIndex of the key matches, in which case false is
+// returned if it doesn't.
+func (c KVClient) putWithHistory(kv *api.KVPair, cas bool, w *api.WriteOptions) (bool, error) {
+	verb := api.KVSet
+	if cas {
+		verb = api.KVCAS
+	}
+	snapshot := kv.Key + c.snapshotSuffix()
+	ops := api.TxnOps{
+		&api.TxnOp{KV: &api.KVTxnOp{Verb: verb, Key: kv.Key, Value: kv.Value, Flags: kv.Flags, Index: kv.ModifyIndex}},
+		&api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVSet, Key: snapshot, Value: kv.Value, Flags: kv.Flags}},
+	}
+	q := historyQueryOptions(w)
+	var ok bool
+	var resp *api.TxnResponse
+	err := c.do(q.Context(), OpPut, kv.Key, func() (err error) {
+		ok, resp, _, err = c.client.Txn().Txn(ops, q)
+		return err
+	})
+	if err != nil {
+		return false, &KVError{Op: OpPut, Key: kv.Key, Err: err}
+	}
+	if !ok {
+		if cas {
+			return false, nil
+		}
+		return false, &KVError{Op: OpPut, Key: kv.Key, Err: fmt.Errorf("failed to write value and snapshot: %v", resp.Errors)}
+	}
+	c.pruneHistory(kv.Key, q)
+	return true, nil
+}
+
+// withSnapshots returns the operations of a transaction with a snapshot of the
+// value of each recorded operation written right after it, along with the
+// index of the operation of the transaction each returned operation belongs
+// to and the keys of the snapshots.
+func (c KVClient) withSnapshots(ops api.TxnOps, recorded []int) (api.TxnOps, []int, map[string]bool) {
+	suffix := c.snapshotSuffix()
+	expanded := make(api.TxnOps, 0, len(ops)+len(recorded))
+	indexes := make([]int, 0, len(ops)+len(recorded))
+	snapshots := make(map[string]bool, len(recorded))
+	next := 0
+	for i, op := range ops {
+		expanded = append(expanded, op)
+		indexes = append(indexes, i)
+		if next < len(recorded) && recorded[next] == i {
+			next++
+			snapshot := op.KV.Key + suffix
+			expanded = append(expanded, &api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVSet, Key: snapshot, Value: op.KV.Value, Flags: op.KV.Flags}})
+			indexes = append(indexes, i)
+			snapshots[snapshot] = true
+		}
+	}
+	return expanded, indexes, snapshots
+}
+
+// snapshotSuffix returns the suffix of the keys of snapshots written now.
+func (c KVClient) snapshotSuffix() string {
+	return historySegment + ClockOrSystem(c.history.Clock).Now().UTC().Format(historyTimeFormat)
+}
+
+// pruneHistory deletes the oldest snapshots of the key beyond Retain. Failures
+// are logged since the value was already written.
+func (c KVClient) pruneHistory(key string, q *api.QueryOptions) {
+	if c.history.Retain == 0 {
+		return
+	}
+	var keys []string
+	err := c.do(q.Context(), OpList, key+historySegment, func() (err error) {
+		keys, _, err = c.client.KV().Keys(key+historySegment, "", q)
+		return err
+	})
+	if err != nil {
+		c.logger.Debug("failed to list history of KV", "key", key, "error", err)
+		return
+	}
+	if len(keys) <= c.history.Retain {
+		return
+	}
+	expired := keys[:len(keys)-c.history.Retain]
+	for len(expired) > 0 {
+		n := len(expired)
+		if n > MaxTxnOps {
+			n = MaxTxnOps
+		}
+		ops := make(api.TxnOps, 0, n)
+		for _, k := range expired[:n] {
+			ops = append(ops, &api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVDelete, Key: k}})
+		}
+		err := c.do(q.Context(), OpDelete, key+historySegment, func() (err error) {
+			_, _, _, err = c.client.Txn().Txn(ops, q)
+			return err
+		})
+		if err != nil {
+			c.logger.Debug("failed to prune history of KV", "key", key, "error", err)
+			return
+		}
+		expired = expired[n:]
+	}
+	c.logger.Debug("pruned history of KV", "key", key, "deleted", len(keys)-c.history.Retain)
+}
+
+// historyQueryOptions converts the WriteOptions of a write to the QueryOptions
+// used by transactions, which Consul sends as queries.
+func historyQueryOptions(w *api.WriteOptions) *api.QueryOptions {
+	if w == nil {
+		return nil
+	}
+	q := &api.QueryOptions{
+		Namespace:  w.Namespace,
+		Partition:  w.Partition,
+		Datacenter: w.Datacenter,
+		Token:      w.Token,
+	}
+	return q.WithContext(w.Context())
+}
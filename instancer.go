@@ -1,7 +1,9 @@
 package konsul
 
 import (
+	"context"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -9,6 +11,8 @@ import (
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/api/watch"
 	"github.com/hashicorp/go-hclog"
+
+	"github.com/jkratz55/konsul/internal/blocking"
 )
 
 // InstanceListener is a type the listens for changes from Instancer. An InstanceListener
@@ -18,42 +22,176 @@ type InstanceListener interface {
 	OnChange(instances []string)
 }
 
+// WatchBackend determines how changes to the instances of a service are
+// received from Consul.
+type WatchBackend int
+
+const (
+	// BlockingQuery performs long-blocking queries against the Consul servers.
+	// This is the default backend.
+	BlockingQuery WatchBackend = iota
+	// AgentCache serves queries from the cache of the local Consul agent, which
+	// keeps a single subscription per query regardless of how many clients are
+	// watching. When streaming is enabled on the agent (use_streaming_backend,
+	// the default since Consul 1.10) the cache is populated with streaming
+	// subscriptions rather than blocking queries, dramatically reducing the load
+	// on the servers for applications watching many services.
+	//
+	// Consul only supports the agent cache and streaming for service health
+	// queries, so it applies to Instancer. Watching keys with Watch always uses
+	// blocking queries.
+	AgentCache
+)
+
+// String returns the name of the backend.
+func (b WatchBackend) String() string {
+	switch b {
+	case BlockingQuery:
+		return "blocking-query"
+	case AgentCache:
+		return "agent-cache"
+	default:
+		return fmt.Sprintf("WatchBackend(%d)", int(b))
+	}
+}
+
 // InstancerConfig is a type holding the configuration properties to create and
 // initialize an Instancer.
 type InstancerConfig struct {
 	// The Consul api Client to use to communicate with Consul. This is a required
-	// field. Providing a nil value will lead to a panic.
+	// field. Providing a nil value will lead to an error.
 	Client *api.Client
 	// The registered service in Consul to monitor and load balance. This is a
-	// required field. The default zero value will lead to a panic.
+	// required field. The default zero value will lead to an error.
 	Service string
 	// An optional tag to limit the instances Instancer should consider. If this
 	// value is the non zero-value only instances that have this tag will be
 	// considered.
 	Tag string
+	// Optional tags instances must all have to be considered, in addition to
+	// Tag, such as a version and a zone.
+	Tags []string
+	// Optional tags instances must have at least one of to be considered.
+	AnyTags []string
+	// An optional expression the tags of instances must match to be
+	// considered, combining tags with & (and), | (or), ! (not), and
+	// parentheses, & taking precedence over |:
+	//
+	//	v2 & (us-east | us-west) & !canary
+	//
+	// AnyTags and TagExpression are evaluated server-side like Filter.
+	TagExpression string
 	// Specifies if Instancer should only consider passing/healthy instances. In
 	// nearly all cases this should be set to true.
 	PassingOnly bool
+	// Determines which instances are considered based on the aggregated status
+	// of their health checks when PassingOnly isn't set, such as PreferPassing
+	// to fall back to warning instances when none are passing. If not provided
+	// AnyHealth is used, considering every instance.
+	HealthPolicy HealthPolicy
 	// Determines how Consul client interacts with Consul servers. When true any
 	// Consul server can be queried. Otherwise, all queries go to the leader.
 	AllowStale bool
+	// An optional name of a cluster peer the service is imported from. When set
+	// Instancer will yield the instances of the service in the peered cluster
+	// rather than the local cluster.
+	Peer string
+	// An optional filter expression narrowing the instances server-side, such
+	// as `Service.Meta.version == "2"` or `"canary" not in Service.Tags`. The
+	// expression is evaluated by Consul against each service entry, see
+	// https://developer.hashicorp.com/consul/api-docs/features/filtering.
+	// Invalid expressions are rejected by Consul when the instances are
+	// queried.
+	Filter string
 	// A logger to log internal behavior of Instancer. If a logger is not provided
-	// a default one will be used configured at INFO level.
-	Logger hclog.Logger
+	// a default one will be used configured at INFO level. Any Logger can be
+	// used, including hclog.Logger and *slog.Logger.
+	Logger Logger
+	// An optional minimum level for messages logged by Instancer, allowing its
+	// verbosity to be tuned independently of other subsystems sharing the same
+	// logger. Messages below this level are discarded before reaching Logger.
+	// The zero-value hclog.NoLevel doesn't filter any messages.
+	LogLevel hclog.Level
+	// Optional instrumentation hooks invoked each time the instances of the
+	// service are refreshed.
+	Metrics Metrics
+	// Specifies if AddListener should reject listeners already registered
+	// with ErrDuplicateListener, rather than notifying them once per
+	// registration.
+	RejectDuplicateListeners bool
+	// The Balancer selecting the instance yielded by Instance, Next, and
+	// NextFor. If not provided the instances are selected round-robin with
+	// NewRoundRobinBalancer.
+	Balancer Balancer
+	// The backend used to receive changes to the instances of the service. If
+	// not provided BlockingQuery is used.
+	Backend WatchBackend
+	// An optional callback invoked if the Restart policy gives up refreshing
+	// the instances, after which the instances are no longer refreshed and the
+	// instances last received are served. The error is also reported like
+	// every failed refresh, on Errors and to OnError, and logged. Without a
+	// Restart policy failed refreshes are retried indefinitely and the plan
+	// never stops on its own.
+	OnPlanError func(err error)
+	// An optional callback invoked with a *DiscoveryError each time refreshing
+	// the instances fails, including when the Restart policy gives up. Failed
+	// refreshes are retried, serving the instances last received in the
+	// meantime. It's invoked on the goroutine executing the plan and should
+	// return quickly.
+	OnError func(err error)
+	// When true Instancer panics if the Restart policy gives up refreshing the
+	// instances and OnPlanError isn't provided, rather than continuing to run
+	// with instances that could be out of date. The error cannot be returned
+	// from the background goroutine executing the plan, so the panic can't be
+	// recovered by the caller.
+	PanicOnPlanError bool
+	// An optional policy retrying failed refreshes of the instances. Without a
+	// policy the watch plan retries them indefinitely with its own backoff,
+	// growing quadratically up to 3 minutes. With a policy the plan is stopped
+	// when a refresh fails and recreated after the backoff of the policy.
+	// MaxAttempts limits the consecutive failed refreshes, zero retrying
+	// indefinitely, and only errors Retryable returns true for are retried if
+	// it's provided, otherwise every error is. Once the policy gives up the
+	// error is handled like OnPlanError describes. Note MaxAttempts counts
+	// differently than for KVClient, where values below 2 disable retries.
+	// While the CircuitBreaker is open refreshes wait for it rather than fail,
+	// so they aren't counted toward MaxAttempts.
+	Restart *RetryPolicy
+	// An optional CircuitBreaker, typically shared with KVClient. While it's
+	// open the instances aren't refreshed until OpenTimeout elapses, and the
+	// instances last received are served.
+	CircuitBreaker *CircuitBreaker
 }
 
-func (ic *InstancerConfig) validate() {
+// Validate returns an error wrapping ErrInvalidConfig if the configuration is
+// invalid.
+func (ic *InstancerConfig) Validate() error {
 	if ic.Client == nil {
-		panic("cannot provide nil consul api.Client, illegal use of api")
+		return invalidConfig("cannot provide nil consul api.Client")
 	}
 	if strings.TrimSpace(ic.Service) == "" {
-		panic("a consul service must be specified to load balance/monitor, illegal use of api")
+		return invalidConfig("a consul service must be specified to load balance/monitor")
+	}
+	if ic.Backend != BlockingQuery && ic.Backend != AgentCache {
+		return invalidConfig(fmt.Sprintf("unknown watch backend %s", ic.Backend))
 	}
-	if ic.Logger == nil {
-		ic.Logger = hclog.Default()
+	if ic.HealthPolicy < AnyHealth || ic.HealthPolicy > PreferPassing {
+		return invalidConfig(fmt.Sprintf("unknown health policy %s", ic.HealthPolicy))
 	}
+	if _, err := ic.filter(); err != nil {
+		return invalidConfig(err.Error())
+	}
+	if ic.Restart != nil {
+		if err := ic.Restart.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// instancerErrorsBuffer is the capacity of the Errors channel of Instancer.
+const instancerErrorsBuffer = 16
+
 // Instancer is a client-side loadbalancer implementation based on Consul services.
 // Instancer yields instances of a service registered in Consul and watches for
 // changes. When changes are detected Instancer updates its internal cache of
@@ -65,81 +203,304 @@ type Instancer struct {
 	client  *api.Client
 	mutex   sync.RWMutex
 	logger  hclog.Logger
-	plan    *watch.Plan
+	metrics Metrics
+	cancel  context.CancelFunc
 	service string
+	// Closed once the goroutine executing the plan exits.
+	done chan struct{}
 
-	instances []string
+	// The plan currently executing, replaced when it's restarted.
+	planMu sync.Mutex
+	plan   *watch.Plan
+
+	errors     chan error
+	errorsOnce sync.Once
+	onError    func(err error)
+
+	// The consecutive failed queries, and the error the current plan was
+	// stopped with by the Restart policy, if it gives up. Only accessed by the
+	// goroutine executing the plan, which invokes its Watcher.
+	failures int
+	stopErr  error
+	giveUp   bool
+
+	// The instances are replaced (copy-on-write) on each refresh and never
+	// modified in place, allowing Instance to select an instance without
+	// locking or allocating. The mutex only guards listeners and
+	// subscriptions.
+	instances atomic.Pointer[[]string]
+	closed    atomic.Bool
 	listeners []InstanceListener
-	counter   uint64
+	details   []Instance
+	subs      map[int]chan []Instance
+	subSeq    int
+	balancer  Balancer
+
+	// Set if AddListener rejects listeners already registered.
+	rejectDuplicates bool
+	healthPolicy     HealthPolicy
 }
 
 // NewInstancer initializes a new Instancer with the provided configuration. If
-// the configuration is invalid (misusing the API) this will panic. If the watch
-// plan cannot be parsed this will return a non-nil error. Upon creating the
-// Instancer it will begin to watch Consul for changes immediately.
+// the configuration is invalid, or the watch plan cannot be parsed, this will
+// return a non-nil error. Upon creating the Instancer it will begin to watch
+// Consul for changes immediately.
 //
-// In the event the plan stops executing due to an error a panic will occur rather
-// than continuing to run in a state where instances could be out of date/invalid.
-func NewInstancer(config InstancerConfig) (*Instancer, error) {
-	// Validates the configuration provided is valid and panics if the api is
-	// being misused
-	config.validate()
-
-	params := map[string]any{
-		"type":        "service",
-		"service":     config.Service,
-		"passingonly": config.PassingOnly,
-		"stale":       config.AllowStale,
+// Failed refreshes of the instances are retried indefinitely, or according to
+// InstancerConfig.Restart if provided. In the event the Restart policy gives up
+// the error is reported on Errors and logged, and the instances last received
+// are served from then on. Provide InstancerConfig.OnPlanError to handle it, or
+// set InstancerConfig.PanicOnPlanError to panic instead.
+//
+// The options are applied to the configuration before it's validated, allowing
+// the optional properties to be provided as InstancerOption values:
+//
+//	instancer, err := konsul.NewInstancer(konsul.InstancerConfig{
+//		Client:  client,
+//		Service: "orders",
+//	}, konsul.WithPassingOnly(), konsul.WithLogger(logger))
+func NewInstancer(config InstancerConfig, opts ...InstancerOption) (*Instancer, error) {
+	for _, opt := range opts {
+		if opt != nil {
+			opt.applyInstancer(&config)
+		}
 	}
-	if config.Tag != "" {
-		params["tag"] = config.Tag
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
-
-	plan, err := watch.Parse(params)
-	if err != nil {
-		return nil, fmt.Errorf("error creating watch plan for service %s: %w", config.Service, err)
+	if config.Logger == nil {
+		config.Logger = hclog.Default()
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	instancer := &Instancer{
 		client:    config.Client,
 		mutex:     sync.RWMutex{},
-		logger:    config.Logger,
-		plan:      plan,
-		instances: make([]string, 0),
+		logger:    withLevel(HclogAdapter(config.Logger), config.LogLevel),
+		metrics:   metricsOrNop(config.Metrics),
+		cancel:    cancel,
 		listeners: make([]InstanceListener, 0),
-		counter:   0,
+		subs:      make(map[int]chan []Instance),
 		service:   config.Service,
+		done:      make(chan struct{}),
+		errors:    make(chan error, instancerErrorsBuffer),
+		onError:   config.OnError,
+		balancer:  config.Balancer,
 	}
+	instancer.rejectDuplicates = config.RejectDuplicateListeners
+	instancer.healthPolicy = config.HealthPolicy
+	if instancer.balancer == nil {
+		instancer.balancer = NewRoundRobinBalancer()
+	}
+	instancer.instances.Store(&[]string{})
 
-	plan.Handler = instancer.handler
+	plan, err := instancer.newPlan(ctx, config)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	instancer.plan = plan
 
 	go func() {
+		defer close(instancer.done)
 		instancer.logger.Info("Instancer is starting...",
 			"Service", config.Service,
 			"Tag", config.Tag,
+			"Tags", config.Tags,
+			"AnyTags", config.AnyTags,
+			"TagExpression", config.TagExpression,
 			"PassingOnly", config.PassingOnly,
-			"AllowStale", config.AllowStale)
-		if err := plan.RunWithClientAndHclog(instancer.client, instancer.logger); err != nil {
-			// If the plan stops running unexpected behavior may occur within the
-			// application that is hard to troubleshoot/debug. In this case it's
-			// better to panic rather than continuing running in a potentially bad
-			// state without the callers' knowledge.
-			instancer.logger.Error("plan encountered an error while executing",
-				"err", err,
-				"service", instancer.service)
-			panic(fmt.Errorf("plan stopped running due to error: %w", err))
-		}
+			"AllowStale", config.AllowStale,
+			"Peer", config.Peer,
+			"Filter", config.Filter,
+			"Backend", config.Backend,
+			"HealthPolicy", config.HealthPolicy)
+		instancer.run(ctx, config, plan)
 	}()
 
 	return instancer, nil
 }
 
-// Close stops the Instancer and the underlying Consul watch plan. After Close is
-// called Instancer is not usable.
+// run executes the plan until the Instancer is closed. The plan only stops on
+// its own when the Restart policy stops it after a failed query, in which case
+// it's recreated after backing off, or the Instancer gives up once the policy
+// is exhausted.
+func (i *Instancer) run(ctx context.Context, config InstancerConfig, plan *watch.Plan) {
+	var policy RetryPolicy
+	if config.Restart != nil {
+		policy = config.Restart.withDefaults()
+	}
+	for {
+		if err := plan.RunWithClientAndHclog(i.client, i.logger); err != nil && i.stopErr == nil {
+			i.stopErr, i.giveUp = err, true
+		}
+		if ctx.Err() != nil || i.stopErr == nil {
+			return
+		}
+		err := i.stopErr
+		i.stopErr = nil
+
+		if i.giveUp {
+			i.logger.Error("plan stopped after failing to refresh instances",
+				"error", err,
+				"service", i.service,
+				"failures", i.failures)
+			err = &DiscoveryError{
+				Service: i.service,
+				Err:     fmt.Errorf("plan stopped running due to error: %w", err),
+			}
+			i.reportError(err)
+			i.fail(config, err)
+			return
+		}
+
+		backoff := policy.backoff(i.failures)
+		i.logger.Warn("restarting plan after error",
+			"service", i.service,
+			"failures", i.failures,
+			"backoff", backoff)
+		select {
+		case <-policy.Clock.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+
+		var perr error
+		plan, perr = i.newPlan(ctx, config)
+		if perr != nil {
+			err = &DiscoveryError{Service: i.service, Err: perr}
+			i.reportError(err)
+			i.fail(config, err)
+			return
+		}
+		// The plan is swapped while holding planMu so that Close either sees
+		// the new plan or the plan never runs.
+		i.planMu.Lock()
+		if ctx.Err() != nil {
+			i.planMu.Unlock()
+			return
+		}
+		i.plan = plan
+		i.planMu.Unlock()
+	}
+}
+
+// fail handles the error the plan stopped executing with once it's no longer
+// restarted, after it has been reported. The instances are no longer refreshed
+// so the error is logged as well, and panics if the caller opted in to it
+// rather than continuing to run with instances that could be out of date.
+func (i *Instancer) fail(config InstancerConfig, err error) {
+	if config.OnPlanError != nil {
+		config.OnPlanError(err)
+		return
+	}
+	if config.PanicOnPlanError {
+		panic(err)
+	}
+	i.logger.Error("no longer refreshing instances, serving the instances last received",
+		"service", i.service,
+		"error", err)
+}
+
+// newPlan creates the watch plan refreshing the instances of the Instancer,
+// reporting the errors of its queries.
+//
+// Without a Restart policy failed queries are returned to the plan, which
+// retries them indefinitely with its own backoff. With a Restart policy the
+// plan is stopped instead once a query fails, so run backs off according to
+// the policy and recreates the plan, or gives up once MaxAttempts consecutive
+// queries failed or the error isn't Retryable.
+func (i *Instancer) newPlan(ctx context.Context, config InstancerConfig) (*watch.Plan, error) {
+	plan, err := newServicePlan(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	watcher := plan.Watcher
+	plan.Watcher = func(p *watch.Plan) (watch.BlockingParamVal, any, error) {
+		val, result, err := watcher(p)
+		if err == nil {
+			i.failures = 0
+			return val, result, nil
+		}
+		if ctx.Err() != nil {
+			return val, result, err
+		}
+		i.failures++
+		i.reportError(&DiscoveryError{
+			Service: i.service,
+			Err:     fmt.Errorf("failed to refresh instances: %w", err),
+		})
+		if restart := config.Restart; restart != nil {
+			i.stopErr = err
+			i.giveUp = (restart.MaxAttempts > 0 && i.failures >= restart.MaxAttempts) ||
+				(restart.Retryable != nil && !restart.Retryable(err))
+			p.Stop()
+		}
+		return val, result, err
+	}
+	plan.Handler = i.handler
+	return plan, nil
+}
+
+// reportError invokes the OnError callback with the error and sends it to the
+// Errors channel without blocking.
+func (i *Instancer) reportError(err error) {
+	if i.onError != nil {
+		i.onError(err)
+	}
+	select {
+	case i.errors <- err:
+	default:
+		i.logger.Debug("dropped error since the Errors channel is full",
+			"service", i.service,
+			"error", err)
+	}
+}
+
+// MustNewInstancer initializes a new Instancer like NewInstancer, but panics if
+// an error occurs.
+func MustNewInstancer(config InstancerConfig, opts ...InstancerOption) *Instancer {
+	instancer, err := NewInstancer(config, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return instancer
+}
+
+// Service returns the name of the service the Instancer is monitoring.
+func (i *Instancer) Service() string {
+	return i.service
+}
+
+// Close stops the Instancer and the underlying Consul watch plan, waiting for
+// the goroutine executing the plan to exit. After Close is called Instancer is
+// not usable. Close must not be called from an InstanceListener.
 func (i *Instancer) Close() {
+	i.closed.Store(true)
+	i.cancel()
+	i.planMu.Lock()
 	i.plan.Stop()
-	i.instances = make([]string, 0)
+	i.planMu.Unlock()
+	<-i.done
+	i.errorsOnce.Do(func() {
+		close(i.errors)
+	})
+	i.instances.Store(&[]string{})
+	i.mutex.Lock()
 	i.listeners = make([]InstanceListener, 0)
+	i.details = nil
+	i.closeSubscriptions()
+	i.mutex.Unlock()
+}
+
+// Errors returns a channel receiving a *DiscoveryError each time refreshing the
+// instances fails, including when the watch plan stops executing, like
+// InstancerConfig.OnError. The channel is buffered and errors are dropped
+// rather than blocking the Instancer when it's full, so it doesn't need to be
+// drained. The channel is shared by every caller and is closed once the
+// Instancer is closed.
+func (i *Instancer) Errors() <-chan error {
+	return i.errors
 }
 
 // RegisterListener registers an InstanceListener with an Instancer to be notified
@@ -148,57 +509,145 @@ func (i *Instancer) Close() {
 // the current instances of the Instancer.
 //
 // Note: RegisterListener doesn't prevent the same InstanceListener from being
-// registered multiple times. In such cases its OnChange method will be invoked
-// multiple times.
+// registered multiple times unless InstancerConfig.RejectDuplicateListeners is
+// set. In such cases its OnChange method will be invoked multiple times.
+// Listeners are removed with DeregisterListener.
 //
-// This will panic if the Instancer has been closed.
+// This will panic if the Instancer has been closed or the listener is rejected
+// as a duplicate, use AddListener to have an error returned instead.
 func (i *Instancer) RegisterListener(l InstanceListener) {
+	if err := i.AddListener(l); err != nil {
+		if err == ErrInstancerClosed {
+			panic("Instancer is closed/stopped")
+		}
+		panic(err)
+	}
+}
+
+// AddListener registers an InstanceListener like RegisterListener, but returns
+// ErrInstancerClosed if the Instancer has been closed, and ErrDuplicateListener
+// if the listener is already registered while
+// InstancerConfig.RejectDuplicateListeners is set.
+func (i *Instancer) AddListener(l InstanceListener) error {
 	i.mutex.Lock()
 	defer i.mutex.Unlock()
-	if i.plan.IsStopped() {
-		panic("Instancer is closed/stopped")
+	if i.closed.Load() {
+		return ErrInstancerClosed
+	}
+	if i.rejectDuplicates {
+		for _, listener := range i.listeners {
+			if sameListener(listener, l) {
+				return ErrDuplicateListener
+			}
+		}
 	}
 	i.listeners = append(i.listeners, l)
 	i.logger.Debug(fmt.Sprintf("Registered InstanceListener of type %T", l),
 		"service", i.service)
 
 	// Upon registration the InstanceListener is notified of the current instances
-	instancesCopy := make([]string, len(i.instances))
-	copy(instancesCopy, i.instances)
-	l.OnChange(instancesCopy)
+	l.OnChange(i.copyInstances())
+	return nil
+}
+
+// DeregisterListener removes every registration of the InstanceListener,
+// returning true if it was registered. Once DeregisterListener returns the
+// listener is no longer notified. Listeners are matched with ==, so listeners
+// of types that aren't comparable, such as structs holding slices or maps,
+// cannot be removed and should be registered as pointers instead.
+// DeregisterListener must not be called from an InstanceListener.
+func (i *Instancer) DeregisterListener(l InstanceListener) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	listeners := make([]InstanceListener, 0, len(i.listeners))
+	for _, listener := range i.listeners {
+		if !sameListener(listener, l) {
+			listeners = append(listeners, listener)
+		}
+	}
+	removed := len(listeners) < len(i.listeners)
+	if removed {
+		i.listeners = listeners
+		i.logger.Debug(fmt.Sprintf("Deregistered InstanceListener of type %T", l),
+			"service", i.service)
+	}
+	return removed
 }
 
-// Instance return a single instance round-robin load balanced along with a boolean
-// value. If there are no instances the boolean value will be false. Otherwise, it
-// will be true to indicate an instance was returned.
+// sameListener returns true if the listeners are equal, without panicking on
+// listeners of types that aren't comparable.
+func sameListener(a, b InstanceListener) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
+// Instance return a single instance load balanced by the Balancer of the
+// Instancer, round-robin by default, along with a boolean value. If there are
+// no instances the boolean value will be false. Otherwise, it will be true to
+// indicate an instance was returned.
+//
+// With the default Balancer Instance is lock-free and doesn't allocate, making
+// it suitable for selecting an instance on every request in hot paths.
 //
-// This will panic if the Instancer has been closed.
+// This will panic if the Instancer has been closed, use Next to have an error
+// returned instead.
 func (i *Instancer) Instance() (string, bool) {
-	if i.plan.IsStopped() {
+	instance, err := i.Next()
+	if err == ErrInstancerClosed {
 		panic("Instancer is closed/stopped")
 	}
-	i.mutex.RLock()
-	defer i.mutex.RUnlock()
+	return instance, err == nil
+}
+
+// Next returns a single instance load balanced like Instance. If there are no
+// instances ErrNoInstances is returned, and if the Instancer has been closed
+// ErrInstancerClosed is returned.
+func (i *Instancer) Next() (string, error) {
+	return i.NextFor("")
+}
 
-	if len(i.instances) == 0 {
-		return "", false
+// NextFor returns a single instance load balanced like Next, providing the key
+// to the Balancer, such as a user or tenant ID routed by the Balancer created
+// with NewConsistentHashBalancer. Balancers that don't route by key ignore it.
+func (i *Instancer) NextFor(key string) (string, error) {
+	if i.closed.Load() {
+		return "", ErrInstancerClosed
+	}
+	instances := *i.instances.Load()
+	if len(instances) == 0 {
+		return "", ErrNoInstances
 	}
-	old := atomic.AddUint64(&i.counter, 1) - 1
-	idx := old % uint64(len(i.instances))
-	return i.instances[idx], true
+	return i.balancer.Select(instances, key), nil
 }
 
 // Instances returns a copy of the current set of instances
 //
-// This will panic if the Instancer has been closed.
+// This will panic if the Instancer has been closed, use List to have an error
+// returned instead.
 func (i *Instancer) Instances() []string {
-	if i.plan.IsStopped() {
+	instances, err := i.List()
+	if err != nil {
 		panic("Instancer is closed/stopped")
 	}
-	i.mutex.RLock()
-	defer i.mutex.RUnlock()
-	instances := make([]string, len(i.instances))
-	copy(instances, i.instances)
+	return instances
+}
+
+// List returns a copy of the current set of instances like Instances, but
+// returns ErrInstancerClosed if the Instancer has been closed.
+func (i *Instancer) List() ([]string, error) {
+	if i.closed.Load() {
+		return nil, ErrInstancerClosed
+	}
+	return i.copyInstances(), nil
+}
+
+func (i *Instancer) copyInstances() []string {
+	current := *i.instances.Load()
+	instances := make([]string, len(current))
+	copy(instances, current)
 	return instances
 }
 
@@ -209,23 +658,24 @@ func (i *Instancer) handler(_ uint64, data any) {
 	case []*api.ServiceEntry:
 		i.mutex.Lock()
 		defer i.mutex.Unlock()
-		instances := make([]string, len(d))
+		details := make([]Instance, len(d))
 		for j, entry := range d {
-			addr := entry.Node.Address
-			if entry.Service.Address != "" {
-				addr = entry.Service.Address
-			}
-			instances[j] = fmt.Sprintf("%s:%d", addr, entry.Service.Port)
+			details[j] = newInstance(entry)
+		}
+		details = i.healthPolicy.apply(details)
+		instances := make([]string, len(details))
+		for j, instance := range details {
+			instances[j] = instance.Address
 		}
-		i.instances = instances
+		i.instances.Store(&instances)
 		i.logger.Info("Instances refreshed",
 			"service", i.service,
 			"instances", instances)
+		i.metrics.InstancesChanged(i.service, len(instances))
 
 		// Notify listeners if there are any
 		if len(i.listeners) > 0 {
-			instancesCopy := make([]string, len(i.instances))
-			copy(instancesCopy, i.instances)
+			instancesCopy := i.copyInstances()
 			i.logger.Debug("Notifying all registered listeners",
 				"service", i.service)
 			for _, listener := range i.listeners {
@@ -234,8 +684,72 @@ func (i *Instancer) handler(_ uint64, data any) {
 			i.logger.Debug("All registered listeners have been notified",
 				"service", i.service)
 		}
+		i.publish(details)
 
 	default:
 		i.logger.Error(fmt.Sprintf("handler receieved unexpected type, expected *[]api.ServiceEntry but got %T", data))
 	}
 }
+
+// newServicePlan creates the watch plan for the instances of the configured
+// service. Blocking queries made by the plan are bound to ctx.
+func newServicePlan(ctx context.Context, config InstancerConfig) (*watch.Plan, error) {
+	params := map[string]any{
+		"type":        "service",
+		"service":     config.Service,
+		"passingonly": config.PassingOnly,
+		"stale":       config.AllowStale,
+	}
+	if config.Tag != "" {
+		params["tag"] = config.Tag
+	}
+
+	plan, err := watch.Parse(params)
+	if err != nil {
+		return nil, fmt.Errorf("error creating watch plan for service %s: %w", config.Service, err)
+	}
+
+	// The service watch provided by the Consul watch package doesn't support
+	// all the query options Instancer needs (such as querying a peer) so the
+	// Watcher is replaced with one that performs the blocking query itself.
+	plan.Watcher = serviceWatcher(ctx, config)
+	return plan, nil
+}
+
+// serviceWatcher returns a watch.WatcherFunc that performs a blocking query for
+// the healthy instances of the configured service. The blocking query is bound
+// to ctx so that an in-flight query is aborted when the Instancer is closed.
+func serviceWatcher(ctx context.Context, config InstancerConfig) watch.WatcherFunc {
+	tags := config.tags()
+	// The filter was validated with the configuration.
+	filter, _ := config.filter()
+
+	// The watch Plan tracks the last index internally but doesn't expose it to
+	// custom watchers, so the index is tracked here to perform blocking queries.
+	var index blocking.Index
+	return func(_ *watch.Plan) (watch.BlockingParamVal, any, error) {
+		// While the circuit breaker is open the query waits for it rather
+		// than failing, so an open circuit isn't a failed refresh counted
+		// toward the Restart policy.
+		if err := config.CircuitBreaker.wait(ctx); err != nil {
+			return nil, nil, err
+		}
+		opts := &api.QueryOptions{
+			AllowStale: config.AllowStale,
+			WaitIndex:  index.Wait(),
+			Peer:       config.Peer,
+			Filter:     filter,
+			UseCache:   config.Backend == AgentCache,
+		}
+		entries, meta, err := config.Client.Health().ServiceMultipleTags(config.Service, tags,
+			config.PassingOnly, opts.WithContext(ctx))
+		if ctx.Err() == nil {
+			config.CircuitBreaker.Record(err)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		index.Update(meta.LastIndex)
+		return watch.WaitIndexVal(meta.LastIndex), entries, nil
+	}
+}
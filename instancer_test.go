@@ -0,0 +1,132 @@
+package konsul
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// newBenchmarkInstancer returns an Instancer yielding n instances without
+// executing a plan, isolating the read path from Consul.
+func newBenchmarkInstancer(n int) *Instancer {
+	i := &Instancer{balancer: NewRoundRobinBalancer()}
+	instances := benchmarkInstances(n)
+	i.instances.Store(&instances)
+	return i
+}
+
+func benchmarkInstances(n int) []string {
+	instances := make([]string, n)
+	for j := range instances {
+		instances[j] = fmt.Sprintf("10.0.0.%d:8080", j+1)
+	}
+	return instances
+}
+
+// rwMutexInstancer is the read path Instance used before it was made lock-free,
+// kept as the baseline of BenchmarkInstance.
+type rwMutexInstancer struct {
+	mutex     sync.RWMutex
+	instances []string
+	counter   uint64
+}
+
+func (i *rwMutexInstancer) Instance() (string, bool) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	if len(i.instances) == 0 {
+		return "", false
+	}
+	old := atomic.AddUint64(&i.counter, 1) - 1
+	idx := old % uint64(len(i.instances))
+	return i.instances[idx], true
+}
+
+func BenchmarkInstance(b *testing.B) {
+	b.Run("atomic", func(b *testing.B) {
+		i := newBenchmarkInstancer(5)
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			i.Instance()
+		}
+	})
+	b.Run("atomic-parallel", func(b *testing.B) {
+		i := newBenchmarkInstancer(5)
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				i.Instance()
+			}
+		})
+	})
+	b.Run("rwmutex", func(b *testing.B) {
+		i := &rwMutexInstancer{instances: benchmarkInstances(5)}
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			i.Instance()
+		}
+	})
+	b.Run("rwmutex-parallel", func(b *testing.B) {
+		i := &rwMutexInstancer{instances: benchmarkInstances(5)}
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				i.Instance()
+			}
+		})
+	})
+}
+
+func TestInstancerKey(t *testing.T) {
+	base := InstancerConfig{Service: "api"}
+	baseKey, ok := instancerKey(base)
+	if !ok {
+		t.Fatal("expected the base config to be cacheable")
+	}
+
+	tests := []struct {
+		name   string
+		option InstancerOption
+	}{
+		{"filter", WithFilter(`Service.Meta.v == "2"`)},
+		{"tags", WithTags("primary", "v2")},
+		{"any tags", WithAnyTags("primary", "v2")},
+		{"tag expression", WithTagExpression("primary && !canary")},
+		{"health policy", WithHealthPolicy(ExcludeCritical)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := base
+			tt.option.applyInstancer(&config)
+			key, ok := instancerKey(config)
+			if !ok {
+				t.Fatal("expected the config to be cacheable")
+			}
+			if key == baseKey {
+				t.Errorf("expected %s to change the key %q", tt.name, key)
+			}
+		})
+	}
+
+	a, b := base, base
+	WithTags("primary", "v2").applyInstancer(&a)
+	WithTags("v2", "primary").applyInstancer(&b)
+	keyA, _ := instancerKey(a)
+	keyB, _ := instancerKey(b)
+	if keyA != keyB {
+		t.Errorf("expected the order of tags not to change the key, got %q and %q", keyA, keyB)
+	}
+
+	for name, option := range map[string]InstancerOption{
+		"balancer": WithBalancer(NewRoundRobinBalancer()),
+		"restart":  WithRestart(RetryPolicy{}),
+	} {
+		config := base
+		option.applyInstancer(&config)
+		if _, ok := instancerKey(config); ok {
+			t.Errorf("expected a config with a %s not to be cacheable", name)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Package blocking tracks the index of Consul blocking queries. It is shared by
+// the packages performing blocking queries themselves rather than with a watch
+// plan.
+package blocking
+
+// Index is the index a blocking query waits on, tracked across queries so each
+// query blocks until the data changes since the previous one.
+//
+// The zero-value of Index is ready to use, making the first query return
+// immediately. Index is not safe for concurrent use.
+type Index struct {
+	last uint64
+}
+
+// Wait returns the index the next query should wait on, the WaitIndex of its
+// QueryOptions.
+func (i *Index) Wait() uint64 {
+	return i.last
+}
+
+// Seed sets the index, typically to the LastIndex of data retrieved without a
+// blocking query, so the next query blocks until the data changes since.
+func (i *Index) Seed(index uint64) {
+	i.last = index
+}
+
+// Update records the LastIndex returned by a query and returns true if it
+// differs from the index the query waited on, in which case the data may have
+// changed. Blocking queries can also return without a change when they time
+// out.
+//
+// If the index goes backwards Consul recommends resetting the index and
+// starting over, so the next query returns immediately.
+func (i *Index) Update(index uint64) bool {
+	prev := i.last
+	if index < prev {
+		i.last = 0
+	} else {
+		i.last = index
+	}
+	return index != prev
+}
@@ -0,0 +1,175 @@
+package konsultest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jkratz55/konsul"
+)
+
+// Clock is a konsul.Clock whose time only changes when Advance or Set is
+// called, allowing time-based logic to be tested deterministically. Timers and
+// tickers created by Clock fire during the call to Advance or Set that moves
+// the time past their deadline.
+//
+// The zero-value of Clock is not usable. Use NewClock to create a Clock.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*clockWaiter
+}
+
+var _ konsul.Clock = (*Clock)(nil)
+
+// NewClock creates a Clock set to the provided time.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the current time of the Clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the time of the Clock forward by the duration, firing the
+// timers and tickers whose deadline has passed in order.
+func (c *Clock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set sets the time of the Clock, firing the timers and tickers whose deadline
+// has passed in order. Moving the time backwards doesn't fire anything.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		sort.Slice(c.waiters, func(i, j int) bool {
+			return c.waiters[i].deadline.Before(c.waiters[j].deadline)
+		})
+		if len(c.waiters) == 0 || c.waiters[0].deadline.After(t) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.deadline
+		w.fire(c.now)
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+		} else {
+			c.waiters = c.waiters[1:]
+		}
+	}
+	if t.After(c.now) {
+		c.now = t
+	}
+}
+
+// Waiters returns the number of timers and tickers that haven't fired or been
+// stopped, which is useful to wait for the code under test to start waiting
+// before calling Advance.
+func (c *Clock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// After returns a channel receiving the time once the Clock is advanced by at
+// least the duration.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer creates a Timer firing once the Clock is advanced by at least the
+// duration.
+func (c *Clock) NewTimer(d time.Duration) konsul.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &clockWaiter{clock: c, ch: make(chan time.Time, 1)}
+	c.schedule(w, d)
+	return w
+}
+
+// NewTicker creates a Ticker firing each time the Clock is advanced past the
+// next multiple of the duration. A non-positive duration will cause a panic,
+// like time.NewTicker.
+func (c *Clock) NewTicker(d time.Duration) konsul.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &clockWaiter{clock: c, ch: make(chan time.Time, 1), period: d}
+	c.schedule(w, d)
+	return clockTicker{w}
+}
+
+func (c *Clock) schedule(w *clockWaiter, d time.Duration) {
+	w.deadline = c.now.Add(d)
+	if d <= 0 && w.period == 0 {
+		w.fire(c.now)
+		return
+	}
+	c.waiters = append(c.waiters, w)
+}
+
+// remove unschedules the waiter, returning true if it was scheduled.
+func (c *Clock) remove(w *clockWaiter) bool {
+	for i, waiter := range c.waiters {
+		if waiter == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// clockWaiter is a timer or, when period is positive, a ticker scheduled on a
+// Clock.
+type clockWaiter struct {
+	clock    *Clock
+	ch       chan time.Time
+	deadline time.Time
+	period   time.Duration
+}
+
+func (w *clockWaiter) fire(now time.Time) {
+	// Like time.Ticker, ticks are dropped if the previous tick hasn't been
+	// received.
+	select {
+	case w.ch <- now:
+	default:
+	}
+}
+
+func (w *clockWaiter) C() <-chan time.Time {
+	return w.ch
+}
+
+func (w *clockWaiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.clock.remove(w)
+}
+
+func (w *clockWaiter) Reset(d time.Duration) bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	active := w.clock.remove(w)
+	w.clock.schedule(w, d)
+	return active
+}
+
+// clockTicker adapts clockWaiter to konsul.Ticker.
+type clockTicker struct {
+	w *clockWaiter
+}
+
+func (t clockTicker) C() <-chan time.Time {
+	return t.w.ch
+}
+
+func (t clockTicker) Stop() {
+	t.w.Stop()
+}
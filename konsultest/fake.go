@@ -0,0 +1,752 @@
+package konsultest
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"gopkg.in/yaml.v3"
+
+	"github.com/jkratz55/konsul"
+)
+
+// Fake is an in-memory fake of the Consul KV store implementing konsul.KV,
+// konsul.Watcher, and konsul.PrefixWatcher, allowing application code depending on konsul to be unit
+// tested hermetically and deterministically.
+//
+// Changes are delivered to watches synchronously by the call making the change,
+// so once Put, Set, or Trigger returns every watch of the key has been
+// refreshed. Since Watch blocks, tests typically start it on a goroutine and
+// use WaitForWatch before making changes.
+//
+//	fake := konsultest.NewFake()
+//	fake.Set("config/app", `{"debug": false}`)
+//	go app.Run(fake, fake)
+//	fake.WaitForWatch(t, "config/app", time.Second)
+//	fake.Set("config/app", `{"debug": true}`)
+//
+// The zero-value of Fake is not usable. Use NewFake to create and initialize a
+// Fake.
+type Fake struct {
+	mu      sync.Mutex
+	kvs     map[string]*api.KVPair
+	index   uint64
+	watches map[string][]*fakeWatch
+	err     error
+	closed  chan struct{}
+	added   chan struct{}
+
+	// Watches of prefixes, invoked with the changes to the keys under them.
+	prefixWatches []*fakePrefixWatch
+}
+
+var (
+	_ konsul.KV             = (*Fake)(nil)
+	_ konsul.Watcher        = (*Fake)(nil)
+	_ konsul.ContextWatcher = (*Fake)(nil)
+)
+
+type fakeWatch struct {
+	cfg      encoding.BinaryUnmarshaler
+	opts     konsul.WatchOptions
+	lastGood []byte
+	// Set when a failed change left cfg partially refreshed, so it no longer
+	// holds lastGood.
+	dirty bool
+}
+
+// NewFake creates and initializes an empty Fake.
+func NewFake() *Fake {
+	return &Fake{
+		kvs:     make(map[string]*api.KVPair),
+		watches: make(map[string][]*fakeWatch),
+		closed:  make(chan struct{}),
+		added:   make(chan struct{}),
+	}
+}
+
+// SetError causes every subsequent operation, including Watch, to fail with the
+// provided error until SetError is called with nil. This simulates Consul being
+// unavailable. The error is wrapped in a *konsul.KVError or *konsul.WatchError
+// like the errors returned by KVClient and Watch.
+func (f *Fake) SetError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+// Set sets the value of a key and refreshes its watches. Unlike Put, Set ignores
+// the error configured with SetError.
+func (f *Fake) Set(key string, value string) {
+	f.set(key, []byte(value))
+}
+
+// Trigger refreshes the watches of a key with its current value, as if the key
+// had been modified with the same value. Trigger has no effect if the key
+// doesn't exist.
+func (f *Fake) Trigger(key string) {
+	f.mu.Lock()
+	kv, ok := f.kvs[key]
+	if ok {
+		kv = clone(kv)
+	}
+	f.mu.Unlock()
+	if ok {
+		f.notify(key, kv)
+	}
+}
+
+// Watches returns the number of active watches of a key.
+func (f *Fake) Watches(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.watches[key])
+}
+
+// WaitForWatch blocks until the key, or a prefix watched with WatchPrefix, is
+// being watched, failing the test if it isn't watched within the timeout.
+func (f *Fake) WaitForWatch(t testing.TB, key string, timeout time.Duration) {
+	t.Helper()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		f.mu.Lock()
+		watched := len(f.watches[key]) > 0 || f.prefixWatchesLocked(key) > 0
+		added := f.added
+		f.mu.Unlock()
+		if watched {
+			return
+		}
+		select {
+		case <-added:
+		case <-deadline.C:
+			t.Fatalf("konsultest: key %s wasn't watched within %s", key, timeout)
+			return
+		}
+	}
+}
+
+// Close stops all watches, causing every blocked call to Watch and WatchPrefix
+// to return nil.
+func (f *Fake) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	select {
+	case <-f.closed:
+	default:
+		close(f.closed)
+	}
+}
+
+// Watch refreshes cfg with the value of the key each time it changes until
+// Close is called. If the key exists cfg is refreshed with its current value
+// before Watch blocks, like Watch against Consul. With FailFast, Watch returns
+// an error matching konsul.ErrKeyNotFound if the key doesn't exist.
+func (f *Fake) Watch(key string, cfg encoding.BinaryUnmarshaler, options ...konsul.WatchOption) error {
+	return f.WatchContext(context.Background(), key, cfg, options...)
+}
+
+// WatchContext refreshes cfg like Watch until the context is cancelled or
+// Close is called.
+func (f *Fake) WatchContext(ctx context.Context, key string, cfg encoding.BinaryUnmarshaler, options ...konsul.WatchOption) error {
+	w := &fakeWatch{cfg: cfg, opts: konsul.BuildWatchOptions(options...)}
+
+	f.mu.Lock()
+	if f.err != nil {
+		err := f.err
+		f.mu.Unlock()
+		return &konsul.WatchError{Key: key, Err: err}
+	}
+	kv, ok := f.kvs[key]
+	if ok {
+		kv = clone(kv)
+	}
+	f.mu.Unlock()
+	if w.opts.FailFast || w.opts.OnInitialFetch != nil {
+		var err error
+		if !ok {
+			err = &konsul.WatchError{Key: key, Err: konsul.ErrKeyNotFound}
+		} else if _, err = f.apply(key, w, kv); err != nil {
+			err = &konsul.WatchError{Key: key, Err: err}
+		}
+		if w.opts.OnInitialFetch != nil {
+			w.opts.OnInitialFetch(key, err)
+		}
+		if err != nil && w.opts.FailFast {
+			return err
+		}
+	} else if ok {
+		f.deliver(key, w, kv)
+	}
+
+	f.mu.Lock()
+	f.watches[key] = append(f.watches[key], w)
+	close(f.added)
+	f.added = make(chan struct{})
+	closed := f.closed
+	f.mu.Unlock()
+
+	select {
+	case <-closed:
+	case <-ctx.Done():
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	watches := f.watches[key]
+	for i := range watches {
+		if watches[i] == w {
+			f.watches[key] = append(watches[:i], watches[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Get retrieves a key from the fake. An empty KeyValue is returned if the key
+// doesn't exist.
+func (f *Fake) Get(key string, _ bool, _ ...konsul.QueryOption) (konsul.KeyValue, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return konsul.KeyValue{}, &konsul.KVError{Op: konsul.OpGet, Key: key, Err: f.err}
+	}
+	kv, ok := f.kvs[key]
+	if !ok {
+		return konsul.KeyValue{}, nil
+	}
+	return konsul.WrapKVPair(clone(kv)), nil
+}
+
+// List retrieves the keys under the prefix from the fake, sorted by key.
+func (f *Fake) List(prefix string, _ bool, _ ...konsul.QueryOption) ([]konsul.KeyValue, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, &konsul.KVError{Op: konsul.OpList, Key: prefix, Err: f.err}
+	}
+	keys := make([]string, 0)
+	for key := range f.kvs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	kvs := make([]konsul.KeyValue, len(keys))
+	for i, key := range keys {
+		kvs[i] = konsul.WrapKVPair(clone(f.kvs[key]))
+	}
+	return kvs, nil
+}
+
+// MustGet retrieves a key from the fake, panicking if the key doesn't exist or
+// an error was configured with SetError.
+func (f *Fake) MustGet(key string, allowStale bool, opts ...konsul.QueryOption) konsul.KeyValue {
+	kv, err := f.Get(key, allowStale, opts...)
+	if err != nil {
+		panic(fmt.Errorf("error retrieving key %s from Consul: %w", key, err))
+	}
+	if kv.Unwrap() == nil {
+		panic(fmt.Errorf("key %s doesn't exist", key))
+	}
+	return kv
+}
+
+// GetOrDefault returns the value of a key, or fallback if it doesn't exist.
+func (f *Fake) GetOrDefault(key string, fallback []byte) ([]byte, error) {
+	kv, err := f.Get(key, false)
+	if err != nil {
+		return nil, err
+	}
+	if kv.Unwrap() == nil {
+		return fallback, nil
+	}
+	return kv.RawValue(), nil
+}
+
+// GetOrPut returns the key-value of a key, atomically setting its value to
+// initial first if it doesn't exist.
+func (f *Fake) GetOrPut(key string, initial []byte) (konsul.KeyValue, error) {
+	f.mu.Lock()
+	if f.err != nil {
+		err := f.err
+		f.mu.Unlock()
+		return konsul.KeyValue{}, &konsul.KVError{Op: konsul.OpGet, Key: key, Err: err}
+	}
+	if kv, ok := f.kvs[key]; ok {
+		snapshot := clone(kv)
+		f.mu.Unlock()
+		return konsul.WrapKVPair(snapshot), nil
+	}
+	snapshot := f.setLocked(key, initial)
+	f.mu.Unlock()
+
+	f.notify(key, snapshot)
+	return konsul.WrapKVPair(clone(snapshot)), nil
+}
+
+// Put sets the value of a key and refreshes its watches. Flags set with
+// konsul.WithFlags are stored with the key.
+func (f *Fake) Put(key string, value []byte, opts ...konsul.WriteOption) error {
+	f.mu.Lock()
+	if f.err != nil {
+		err := f.err
+		f.mu.Unlock()
+		return &konsul.KVError{Op: konsul.OpPut, Key: key, Err: err}
+	}
+	flags, _ := konsul.WriteFlags(opts...)
+	f.setLocked(key, value)
+	f.kvs[key].Flags = uint64(flags)
+	snapshot := clone(f.kvs[key])
+	f.mu.Unlock()
+
+	f.notify(key, snapshot)
+	return nil
+}
+
+// MustPut sets the value of a key, panicking if an error was configured with
+// SetError.
+func (f *Fake) MustPut(key string, value []byte, opts ...konsul.WriteOption) {
+	if err := f.Put(key, value, opts...); err != nil {
+		panic(fmt.Errorf("failed to put KV with key %s in Consul: %w", key, err))
+	}
+}
+
+// PutJSON marshals the value as JSON and sets it as the value of a key.
+func (f *Fake) PutJSON(key string, v any, opts ...konsul.WriteOption) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return &konsul.KVError{Op: konsul.OpPut, Key: key, Err: fmt.Errorf("error marshalling value to JSON: %w", err)}
+	}
+	return f.Put(key, data, opts...)
+}
+
+// MustPutJSON marshals the value as JSON and sets it as the value of a key,
+// panicking on error.
+func (f *Fake) MustPutJSON(key string, v any, opts ...konsul.WriteOption) {
+	if err := f.PutJSON(key, v, opts...); err != nil {
+		panic(err)
+	}
+}
+
+// PutYAML marshals the value as YAML and sets it as the value of a key.
+func (f *Fake) PutYAML(key string, v any, opts ...konsul.WriteOption) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return &konsul.KVError{Op: konsul.OpPut, Key: key, Err: fmt.Errorf("error marshalling value to YAML: %w", err)}
+	}
+	return f.Put(key, data, opts...)
+}
+
+// MustPutYAML marshals the value as YAML and sets it as the value of a key,
+// panicking on error.
+func (f *Fake) MustPutYAML(key string, v any, opts ...konsul.WriteOption) {
+	if err := f.PutYAML(key, v, opts...); err != nil {
+		panic(err)
+	}
+}
+
+// Delete removes a key. Watches of the key aren't refreshed, like Watch against
+// Consul, while watches of prefixes the key is under are invoked with the
+// deletion.
+func (f *Fake) Delete(key string, _ ...konsul.WriteOption) error {
+	f.mu.Lock()
+	if f.err != nil {
+		err := f.err
+		f.mu.Unlock()
+		return &konsul.KVError{Op: konsul.OpDelete, Key: key, Err: err}
+	}
+	_, ok := f.kvs[key]
+	delete(f.kvs, key)
+	f.index++
+	f.mu.Unlock()
+
+	if ok {
+		f.notifyDeleted(key)
+	}
+	return nil
+}
+
+// PutCAS sets the value of a key if its ModifyIndex matches modifyIndex, or if
+// modifyIndex is 0 and the key doesn't exist, refreshing its watches. It
+// returns true if the value was written.
+func (f *Fake) PutCAS(key string, value []byte, modifyIndex uint64) (bool, error) {
+	f.mu.Lock()
+	if f.err != nil {
+		err := f.err
+		f.mu.Unlock()
+		return false, &konsul.KVError{Op: konsul.OpPut, Key: key, Err: err}
+	}
+	if !f.matches(key, modifyIndex) {
+		f.mu.Unlock()
+		return false, nil
+	}
+	snapshot := f.setLocked(key, value)
+	f.mu.Unlock()
+
+	f.notify(key, snapshot)
+	return true, nil
+}
+
+// DeleteCAS removes a key if its ModifyIndex matches modifyIndex. It returns
+// true if the key was deleted.
+func (f *Fake) DeleteCAS(key string, modifyIndex uint64) (bool, error) {
+	f.mu.Lock()
+	if f.err != nil {
+		err := f.err
+		f.mu.Unlock()
+		return false, &konsul.KVError{Op: konsul.OpDelete, Key: key, Err: err}
+	}
+	kv, ok := f.kvs[key]
+	if !ok || kv.ModifyIndex != modifyIndex {
+		f.mu.Unlock()
+		return false, nil
+	}
+	delete(f.kvs, key)
+	f.index++
+	f.mu.Unlock()
+
+	f.notifyDeleted(key)
+	return true, nil
+}
+
+// Acquire sets the value of a key and locks it with the session if it isn't
+// held by another session. Sessions aren't validated, any non-empty session ID
+// is accepted.
+func (f *Fake) Acquire(key string, value []byte, sessionID string, opts ...konsul.WriteOption) (bool, error) {
+	f.mu.Lock()
+	if f.err != nil {
+		err := f.err
+		f.mu.Unlock()
+		return false, &konsul.KVError{Op: konsul.OpAcquire, Key: key, Err: err}
+	}
+	if sessionID == "" {
+		f.mu.Unlock()
+		return false, &konsul.KVError{Op: konsul.OpAcquire, Key: key, Err: fmt.Errorf("%w: a session ID must be provided", konsul.ErrInvalidConfig)}
+	}
+	if kv, ok := f.kvs[key]; ok && kv.Session != "" && kv.Session != sessionID {
+		f.mu.Unlock()
+		return false, nil
+	}
+	f.setLocked(key, value)
+	kv := f.kvs[key]
+	flags, _ := konsul.WriteFlags(opts...)
+	kv.Flags = uint64(flags)
+	if kv.Session != sessionID {
+		kv.Session = sessionID
+		kv.LockIndex++
+	}
+	snapshot := clone(kv)
+	f.mu.Unlock()
+
+	f.notify(key, snapshot)
+	return true, nil
+}
+
+// Release unlocks a key held by the session and clears its value, like
+// KVClient.Release. It returns false if the key isn't held by the session.
+func (f *Fake) Release(key string, sessionID string, _ ...konsul.WriteOption) (bool, error) {
+	f.mu.Lock()
+	if f.err != nil {
+		err := f.err
+		f.mu.Unlock()
+		return false, &konsul.KVError{Op: konsul.OpRelease, Key: key, Err: err}
+	}
+	if kv, ok := f.kvs[key]; !ok || sessionID == "" || kv.Session != sessionID {
+		f.mu.Unlock()
+		return false, nil
+	}
+	f.setLocked(key, nil)
+	kv := f.kvs[key]
+	kv.Session = ""
+	snapshot := clone(kv)
+	f.mu.Unlock()
+
+	f.notify(key, snapshot)
+	return true, nil
+}
+
+// PutLarge sets the value of a key like Put. Since the Fake doesn't limit the
+// size of values, values aren't split in chunks.
+func (f *Fake) PutLarge(key string, value []byte) error {
+	return f.Put(key, value)
+}
+
+// GetLarge returns the value of a key set with PutLarge. If the key doesn't
+// exist a *konsul.KVError matching konsul.ErrKeyNotFound is returned.
+func (f *Fake) GetLarge(key string) ([]byte, error) {
+	kv, err := f.Get(key, false)
+	if err != nil {
+		return nil, err
+	}
+	if kv.Unwrap() == nil {
+		return nil, &konsul.KVError{Op: konsul.OpGet, Key: key, Err: konsul.ErrKeyNotFound}
+	}
+	return kv.RawValue(), nil
+}
+
+// DeleteLarge removes a key set with PutLarge.
+func (f *Fake) DeleteLarge(key string) error {
+	return f.Delete(key)
+}
+
+// GetMany returns the key-values of the keys that exist.
+func (f *Fake) GetMany(keys []string) (map[string]konsul.KeyValue, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, &konsul.KVError{Op: konsul.OpGet, Err: f.err}
+	}
+	kvs := make(map[string]konsul.KeyValue, len(keys))
+	for _, key := range keys {
+		if kv, ok := f.kvs[key]; ok {
+			kvs[key] = konsul.WrapKVPair(clone(kv))
+		}
+	}
+	return kvs, nil
+}
+
+// PutMany sets the values of the keys atomically and refreshes their watches.
+func (f *Fake) PutMany(kvs map[string][]byte) error {
+	f.mu.Lock()
+	if f.err != nil {
+		err := f.err
+		f.mu.Unlock()
+		return &konsul.KVError{Op: konsul.OpPut, Err: err}
+	}
+	snapshots := make(map[string]*api.KVPair, len(kvs))
+	for key, value := range kvs {
+		snapshots[key] = f.setLocked(key, value)
+	}
+	f.mu.Unlock()
+
+	for key, snapshot := range snapshots {
+		f.notify(key, snapshot)
+	}
+	return nil
+}
+
+// Increment atomically adds delta to the integer counter stored in the key,
+// treating a key that doesn't exist as 0, and refreshes its watches.
+func (f *Fake) Increment(key string, delta int64) (int64, error) {
+	f.mu.Lock()
+	if f.err != nil {
+		err := f.err
+		f.mu.Unlock()
+		return 0, &konsul.KVError{Op: konsul.OpPut, Key: key, Err: err}
+	}
+	var current int64
+	if kv, ok := f.kvs[key]; ok {
+		if s := strings.TrimSpace(string(kv.Value)); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				f.mu.Unlock()
+				return 0, &konsul.KVError{Op: konsul.OpPut, Key: key, Err: fmt.Errorf("value %q isn't an integer counter", s)}
+			}
+			current = n
+		}
+	}
+	next := current + delta
+	snapshot := f.setLocked(key, []byte(strconv.FormatInt(next, 10)))
+	f.mu.Unlock()
+
+	f.notify(key, snapshot)
+	return next, nil
+}
+
+// DeleteTree removes every key under the prefix. An empty prefix is rejected,
+// like KVClient.DeleteTree.
+func (f *Fake) DeleteTree(prefix string) error {
+	keys, err := f.DeleteTreeDryRun(prefix)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	for _, key := range keys {
+		delete(f.kvs, key)
+	}
+	f.index++
+	f.mu.Unlock()
+
+	f.notifyDeleted(keys...)
+	return nil
+}
+
+// DeleteTreeDryRun returns the keys DeleteTree would remove for the prefix,
+// sorted lexically.
+func (f *Fake) DeleteTreeDryRun(prefix string) ([]string, error) {
+	if prefix == "" {
+		return nil, &konsul.KVError{Op: konsul.OpDelete, Err: fmt.Errorf("%w: a prefix must be provided to delete a tree", konsul.ErrInvalidConfig)}
+	}
+	return f.Keys(prefix, "")
+}
+
+// matches reports if a Check-And-Set operation with modifyIndex applies to the
+// key. The caller must hold f.mu.
+func (f *Fake) matches(key string, modifyIndex uint64) bool {
+	kv, ok := f.kvs[key]
+	if modifyIndex == 0 {
+		return !ok
+	}
+	return ok && kv.ModifyIndex == modifyIndex
+}
+
+// Keys returns the keys under the prefix, sorted lexically. If separator is
+// provided keys are truncated after the first separator following the prefix
+// and deduplicated, like KVClient.Keys.
+func (f *Fake) Keys(prefix string, separator string, _ ...konsul.QueryOption) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, &konsul.KVError{Op: konsul.OpList, Key: prefix, Err: f.err}
+	}
+	seen := make(map[string]struct{})
+	keys := make([]string, 0)
+	for key := range f.kvs {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if separator != "" {
+			if i := strings.Index(key[len(prefix):], separator); i >= 0 {
+				key = key[:len(prefix)+i+len(separator)]
+			}
+		}
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (f *Fake) set(key string, value []byte) {
+	f.mu.Lock()
+	snapshot := f.setLocked(key, value)
+	f.mu.Unlock()
+
+	f.notify(key, snapshot)
+}
+
+// setLocked sets the value of the key and returns a copy of the KVPair. The
+// caller must hold f.mu.
+func (f *Fake) setLocked(key string, value []byte) *api.KVPair {
+	f.index++
+	kv, ok := f.kvs[key]
+	if !ok {
+		kv = &api.KVPair{Key: key, CreateIndex: f.index}
+		f.kvs[key] = kv
+	}
+	kv.Value = append([]byte(nil), value...)
+	kv.ModifyIndex = f.index
+	return clone(kv)
+}
+
+func (f *Fake) notify(key string, kv *api.KVPair) {
+	f.mu.Lock()
+	watches := make([]*fakeWatch, len(f.watches[key]))
+	copy(watches, f.watches[key])
+	f.mu.Unlock()
+	for _, w := range watches {
+		f.deliver(key, w, kv)
+	}
+	f.notifyPrefixes(key, kv)
+}
+
+// deliver refreshes a watch the same way the handler of Watch does.
+func (f *Fake) deliver(key string, w *fakeWatch, kv *api.KVPair) {
+	// Like Watch, changes identical to the value applied are skipped.
+	if !w.dirty && w.lastGood != nil && bytes.Equal(kv.Value, w.lastGood) {
+		return
+	}
+	old := w.lastGood
+	unmarshalFailed, err := f.apply(key, w, kv)
+	if w.opts.Metrics != nil {
+		w.opts.Metrics.WatchUpdate(key, err)
+	}
+	if w.opts.WatchNotification != nil {
+		w.opts.WatchNotification(key, err)
+	}
+	if w.opts.OnChange != nil {
+		w.opts.OnChange(konsul.WatchChange{
+			Key:         key,
+			Old:         old,
+			New:         clone(kv).Value,
+			ModifyIndex: kv.ModifyIndex,
+			Err:         err,
+		})
+	}
+	if err != nil {
+		sendError(w.opts.Errors, err)
+		if unmarshalFailed && w.opts.Errors == nil && w.opts.PanicOnUnmarshalFailure {
+			panic(err)
+		}
+	}
+}
+
+// sendError sends the error to errs without blocking, like Watch, dropping it
+// if errs is full.
+func sendError(errs chan<- error, err error) {
+	if errs == nil {
+		return
+	}
+	select {
+	case errs <- err:
+	default:
+	}
+}
+
+// apply validates the value and refreshes the target of a watch with it,
+// returning true along with the error if the target failed to unmarshal the
+// value.
+func (f *Fake) apply(key string, w *fakeWatch, kv *api.KVPair) (bool, error) {
+	if err := validate(key, kv.Value, w.opts); err != nil {
+		return false, err
+	}
+	err := w.cfg.UnmarshalBinary(clone(kv).Value)
+	if err == nil {
+		w.lastGood, w.dirty = clone(kv).Value, false
+		return false, nil
+	}
+	if w.opts.RollbackOnFailure && w.lastGood != nil {
+		rerr := &konsul.RollbackError{
+			Key:         key,
+			Err:         err,
+			LastGood:    w.lastGood,
+			RollbackErr: w.cfg.UnmarshalBinary(w.lastGood),
+		}
+		w.dirty = rerr.RollbackErr != nil
+		return true, rerr
+	}
+	w.dirty = true
+	return true, err
+}
+
+// validate validates the value of a change with the Schemas and the Validate
+// callback of the options, like Watch.
+func validate(key string, value []byte, opts konsul.WatchOptions) error {
+	if err := opts.Schemas.Validate(key, value); err != nil {
+		return err
+	}
+	if opts.Validate != nil {
+		if err := opts.Validate(key, value); err != nil {
+			return &konsul.ValidationError{Key: key, Err: err}
+		}
+	}
+	return nil
+}
+
+func clone(kv *api.KVPair) *api.KVPair {
+	c := *kv
+	c.Value = append([]byte(nil), kv.Value...)
+	return &c
+}
@@ -0,0 +1,159 @@
+package konsultest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/jkratz55/konsul"
+)
+
+type fakePrefixWatch struct {
+	prefix  string
+	handler konsul.PrefixHandler
+	opts    konsul.WatchOptions
+}
+
+var _ konsul.PrefixWatcher = (*Fake)(nil)
+
+// WatchPrefix invokes the handler with the changes to the keys under the prefix
+// each time they change until Close is called. If keys exist under the prefix
+// the handler is invoked with them before WatchPrefix blocks, like WatchPrefix
+// against Consul. Unlike Watch, deleted keys are reported to the handler.
+func (f *Fake) WatchPrefix(prefix string, handler konsul.PrefixHandler, options ...konsul.WatchOption) error {
+	return f.WatchPrefixContext(context.Background(), prefix, handler, options...)
+}
+
+// WatchPrefixContext invokes the handler like WatchPrefix until the context is
+// cancelled or Close is called.
+func (f *Fake) WatchPrefixContext(ctx context.Context, prefix string, handler konsul.PrefixHandler,
+	options ...konsul.WatchOption) error {
+
+	if handler == nil {
+		return fmt.Errorf("%w: cannot provide nil PrefixHandler", konsul.ErrInvalidConfig)
+	}
+	w := &fakePrefixWatch{prefix: prefix, handler: handler, opts: konsul.BuildWatchOptions(options...)}
+
+	kvs, err := f.List(prefix, false)
+	if err != nil {
+		return &konsul.WatchError{Key: prefix, Err: err}
+	}
+	if len(kvs) > 0 {
+		f.deliverPrefix(w, konsul.PrefixChange{Prefix: prefix, Changed: kvs})
+	}
+
+	f.mu.Lock()
+	f.prefixWatches = append(f.prefixWatches, w)
+	close(f.added)
+	f.added = make(chan struct{})
+	closed := f.closed
+	f.mu.Unlock()
+
+	select {
+	case <-closed:
+	case <-ctx.Done():
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.prefixWatches {
+		if f.prefixWatches[i] == w {
+			f.prefixWatches = append(f.prefixWatches[:i], f.prefixWatches[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// PrefixWatches returns the number of active watches of a prefix.
+func (f *Fake) PrefixWatches(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.prefixWatchesLocked(prefix)
+}
+
+// prefixWatchesLocked returns the number of active watches of a prefix. The
+// caller must hold f.mu.
+func (f *Fake) prefixWatchesLocked(prefix string) int {
+	n := 0
+	for _, w := range f.prefixWatches {
+		if w.prefix == prefix {
+			n++
+		}
+	}
+	return n
+}
+
+// notifyPrefixes invokes the handlers of the prefix watches the key is under
+// with its change.
+func (f *Fake) notifyPrefixes(key string, kv *api.KVPair) {
+	f.mu.Lock()
+	watches := make([]*fakePrefixWatch, 0, len(f.prefixWatches))
+	for _, w := range f.prefixWatches {
+		if strings.HasPrefix(key, w.prefix) {
+			watches = append(watches, w)
+		}
+	}
+	f.mu.Unlock()
+	for _, w := range watches {
+		f.deliverPrefix(w, konsul.PrefixChange{Prefix: w.prefix, Changed: []konsul.KeyValue{konsul.WrapKVPair(clone(kv))}})
+	}
+}
+
+// notifyDeleted reports the deleted keys to the prefix watches they're under.
+func (f *Fake) notifyDeleted(keys ...string) {
+	f.mu.Lock()
+	watches := make([]*fakePrefixWatch, len(f.prefixWatches))
+	copy(watches, f.prefixWatches)
+	f.mu.Unlock()
+	for _, w := range watches {
+		change := konsul.PrefixChange{Prefix: w.prefix}
+		for _, key := range keys {
+			if strings.HasPrefix(key, w.prefix) {
+				change.Deleted = append(change.Deleted, key)
+			}
+		}
+		if len(change.Deleted) > 0 {
+			f.deliverPrefix(w, change)
+		}
+	}
+}
+
+// deliverPrefix invokes the handler of a prefix watch the same way the handler
+// of WatchPrefix does, leaving keys with invalid values out of the change.
+func (f *Fake) deliverPrefix(w *fakePrefixWatch, change konsul.PrefixChange) {
+	var err error
+	changed := make([]konsul.KeyValue, 0, len(change.Changed))
+	for _, kv := range change.Changed {
+		if verr := validate(kv.Key(), kv.RawValue(), w.opts); verr != nil {
+			if err == nil {
+				err = fmt.Errorf("key %s: %w", kv.Key(), verr)
+			}
+			continue
+		}
+		changed = append(changed, kv)
+	}
+	change.Changed = changed
+	var herr error
+	if len(change.Changed) > 0 || len(change.Deleted) > 0 {
+		if herr = w.handler(change); herr != nil && err == nil {
+			err = herr
+		}
+	} else if err == nil {
+		return
+	}
+	if w.opts.Metrics != nil {
+		w.opts.Metrics.WatchUpdate(w.prefix, err)
+	}
+	if w.opts.WatchNotification != nil {
+		w.opts.WatchNotification(w.prefix, err)
+	}
+	if err != nil {
+		sendError(w.opts.Errors, err)
+		if herr != nil && w.opts.Errors == nil && w.opts.PanicOnUnmarshalFailure {
+			panic(herr)
+		}
+	}
+}
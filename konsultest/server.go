@@ -0,0 +1,253 @@
+// Package konsultest provides utilities for testing code depending on konsul
+// and Consul.
+//
+// Server starts a real Consul dev agent for end-to-end tests of Watch,
+// Instancer, and the other konsul types, either in a container or from a local
+// consul binary.
+//
+//	func TestWatch(t *testing.T) {
+//		srv := konsultest.NewServer(t, konsultest.Options{})
+//		srv.SetKV(t, "config/app", `{"debug": true}`)
+//		...
+//	}
+package konsultest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// DefaultImage is the container image used when Options.Image isn't provided.
+const DefaultImage = "hashicorp/consul:1.15"
+
+// Options holds optional configuration properties for NewServer.
+type Options struct {
+	// The container image of Consul. If not provided DefaultImage is used.
+	Image string
+	// The container runtime CLI used to start Consul, such as "docker" or
+	// "podman". If not provided "docker" is used.
+	Runtime string
+	// The path of a consul binary. When provided, or when no container runtime
+	// is available but a consul binary is on the PATH, the dev agent is run as a
+	// local process rather than a container.
+	Binary string
+	// The maximum time to wait for Consul to elect a leader. If not provided 30
+	// seconds is used.
+	StartTimeout time.Duration
+}
+
+// Server is a Consul dev agent started for a test. The agent is stopped when
+// the test and all its subtests complete.
+type Server struct {
+	// A Consul api Client configured to communicate with the agent.
+	Client *api.Client
+	// The address of the HTTP API of the agent as host:port.
+	Addr string
+}
+
+// NewServer starts a Consul dev agent and waits for it to be ready. The test is
+// skipped if neither a container runtime nor a consul binary is available, and
+// fails if the agent cannot be started.
+func NewServer(t testing.TB, opts Options) *Server {
+	t.Helper()
+	if opts.Image == "" {
+		opts.Image = DefaultImage
+	}
+	if opts.Runtime == "" {
+		opts.Runtime = "docker"
+	}
+	if opts.StartTimeout == 0 {
+		opts.StartTimeout = 30 * time.Second
+	}
+
+	var addr string
+	var err error
+	switch {
+	case opts.Binary != "":
+		addr, err = startProcess(t, opts.Binary)
+	case available(opts.Runtime):
+		addr, err = startContainer(t, opts.Runtime, opts.Image)
+	case available("consul"):
+		addr, err = startProcess(t, "consul")
+	default:
+		t.Skipf("konsultest: neither %s nor a consul binary is available", opts.Runtime)
+	}
+	if err != nil {
+		t.Fatalf("konsultest: failed to start Consul: %s", err)
+	}
+
+	client, err := api.NewClient(&api.Config{Address: addr})
+	if err != nil {
+		t.Fatalf("konsultest: failed to create Consul client: %s", err)
+	}
+	if err := waitForLeader(client, opts.StartTimeout); err != nil {
+		t.Fatalf("konsultest: Consul at %s didn't become ready: %s", addr, err)
+	}
+	return &Server{
+		Client: client,
+		Addr:   addr,
+	}
+}
+
+// SetKV sets the value of a key, failing the test on error.
+func (s *Server) SetKV(t testing.TB, key string, value string) {
+	t.Helper()
+	_, err := s.Client.KV().Put(&api.KVPair{Key: key, Value: []byte(value)}, nil)
+	if err != nil {
+		t.Fatalf("konsultest: failed to set key %s: %s", key, err)
+	}
+}
+
+// SetKVs sets the value of each key in the map, failing the test on error.
+func (s *Server) SetKVs(t testing.TB, kvs map[string]string) {
+	t.Helper()
+	for key, value := range kvs {
+		s.SetKV(t, key, value)
+	}
+}
+
+// DeleteKV deletes a key, failing the test on error.
+func (s *Server) DeleteKV(t testing.TB, key string) {
+	t.Helper()
+	if _, err := s.Client.KV().Delete(key, nil); err != nil {
+		t.Fatalf("konsultest: failed to delete key %s: %s", key, err)
+	}
+}
+
+// RegisterService registers a service with the agent, failing the test on
+// error. Services registered without a check are healthy.
+func (s *Server) RegisterService(t testing.TB, reg *api.AgentServiceRegistration) {
+	t.Helper()
+	if err := s.Client.Agent().ServiceRegister(reg); err != nil {
+		t.Fatalf("konsultest: failed to register service %s: %s", reg.Name, err)
+	}
+}
+
+// DeregisterService deregisters the service with the provided ID, failing the
+// test on error.
+func (s *Server) DeregisterService(t testing.TB, serviceID string) {
+	t.Helper()
+	if err := s.Client.Agent().ServiceDeregister(serviceID); err != nil {
+		t.Fatalf("konsultest: failed to deregister service %s: %s", serviceID, err)
+	}
+}
+
+func available(bin string) bool {
+	_, err := exec.LookPath(bin)
+	return err == nil
+}
+
+// startContainer runs Consul in a container publishing the HTTP API on a random
+// local port, and returns the address of the HTTP API.
+func startContainer(t testing.TB, runtime string, image string) (string, error) {
+	out, err := run(runtime, "run", "-d", "--rm", "-p", "127.0.0.1::8500",
+		image, "agent", "-dev", "-client", "0.0.0.0")
+	if err != nil {
+		return "", err
+	}
+	id := strings.TrimSpace(out)
+	t.Cleanup(func() {
+		_, _ = run(runtime, "rm", "-f", id)
+	})
+
+	out, err = run(runtime, "port", id, "8500/tcp")
+	if err != nil {
+		return "", err
+	}
+	// The output can include a mapping per address family, the first is used.
+	addr := strings.TrimSpace(strings.SplitN(out, "\n", 2)[0])
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", fmt.Errorf("unexpected port mapping %q: %w", addr, err)
+	}
+	return addr, nil
+}
+
+// startProcess runs a Consul dev agent as a local process listening on random
+// ports, and returns the address of the HTTP API.
+func startProcess(t testing.TB, binary string) (string, error) {
+	ports, err := freePorts(4)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command(binary, "agent", "-dev",
+		"-bind", "127.0.0.1",
+		"-client", "127.0.0.1",
+		"-http-port", strconv.Itoa(ports[0]),
+		"-server-port", strconv.Itoa(ports[1]),
+		"-serf-lan-port", strconv.Itoa(ports[2]),
+		"-serf-wan-port", strconv.Itoa(ports[3]),
+		"-dns-port", "-1",
+		"-grpc-port", "-1")
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("error starting %s: %w", binary, err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		if t.Failed() {
+			t.Logf("konsultest: Consul output:\n%s", output.String())
+		}
+	})
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(ports[0])), nil
+}
+
+// freePorts returns n ports that were free at the time of the call.
+func freePorts(n int) ([]int, error) {
+	ports := make([]int, 0, n)
+	listeners := make([]net.Listener, 0, n)
+	defer func() {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("error finding free port: %w", err)
+		}
+		listeners = append(listeners, l)
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
+
+func run(bin string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(bin, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s: %w: %s", bin, strings.Join(args, " "), err,
+			strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func waitForLeader(client *api.Client, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var lastErr error
+	for time.Now().Before(deadline) {
+		leader, err := client.Status().Leader()
+		if err == nil && leader != "" {
+			return nil
+		}
+		if err == nil {
+			err = errors.New("no leader elected")
+		}
+		lastErr = err
+		time.Sleep(250 * time.Millisecond)
+	}
+	return fmt.Errorf("timed out after %s: %w", timeout, lastErr)
+}
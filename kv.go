@@ -1,12 +1,16 @@
 package konsul
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	"sort"
 
 	"github.com/hashicorp/consul/api"
-	"gopkg.in/yaml.v3"
+	"github.com/hashicorp/go-hclog"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/jkratz55/konsul/log/redact"
 )
 
 var (
@@ -93,14 +97,14 @@ func (kv KeyValue) IsEmpty() bool {
 // result in the value pointed to by v. If v is nil or not a pointer, UnmarshalValueJSON
 // returns an InvalidUnmarshalError.
 func (kv KeyValue) UnmarshalValueJSON(v any) error {
-	return json.Unmarshal(kv.base.Value, v)
+	return kv.UnmarshalValue(CodecJSON, v)
 }
 
 // MustUnmarshalValueJSON parses the JSON-encoded data of the KeyValue and stores the
 // result in the value pointed to by v. If an error occurs during unmarshalling this
 // will panic.
 func (kv KeyValue) MustUnmarshalValueJSON(v any) {
-	if err := json.Unmarshal(kv.base.Value, v); err != nil {
+	if err := kv.UnmarshalValueJSON(v); err != nil {
 		panic(fmt.Errorf("failed to unmarshal KV value as JSON: %w", err))
 	}
 }
@@ -109,57 +113,276 @@ func (kv KeyValue) MustUnmarshalValueJSON(v any) {
 // result in the value pointed to by v. If v is nil or not a pointer, UnmarshalValueYAML
 // returns an error.
 func (kv KeyValue) UnmarshalValueYAML(v any) error {
-	return yaml.Unmarshal(kv.base.Value, v)
+	return kv.UnmarshalValue(CodecYAML, v)
 }
 
 // MustUnmarshalValueYAML parses the YAML-encoded data of the KeyValue and stores the
 // result in the value pointed to by v. If an error occurs during unmarshalling this
 // will panic.
 func (kv KeyValue) MustUnmarshalValueYAML(v any) {
-	if err := yaml.Unmarshal(kv.base.Value, v); err != nil {
+	if err := kv.UnmarshalValueYAML(v); err != nil {
 		panic(fmt.Errorf("failed to unmarshal KV value as YAML: %w", err))
 	}
 }
 
+// UnmarshalValueHCL parses the HCL-encoded data of the KeyValue and stores the
+// result in the value pointed to by v. Structs are decoded using the hcl struct
+// tags of their fields.
+func (kv KeyValue) UnmarshalValueHCL(v any) error {
+	return kv.UnmarshalValue(CodecHCL, v)
+}
+
+// MustUnmarshalValueHCL parses the HCL-encoded data of the KeyValue and stores
+// the result in the value pointed to by v. If an error occurs during
+// unmarshalling this will panic.
+func (kv KeyValue) MustUnmarshalValueHCL(v any) {
+	if err := kv.UnmarshalValueHCL(v); err != nil {
+		panic(fmt.Errorf("failed to unmarshal KV value as HCL: %w", err))
+	}
+}
+
+// UnmarshalValueMsgpack parses the MessagePack-encoded data of the KeyValue and
+// stores the result in the value pointed to by v. Structs are decoded using the
+// msgpack struct tags of their fields.
+func (kv KeyValue) UnmarshalValueMsgpack(v any) error {
+	return kv.UnmarshalValue(CodecMsgpack, v)
+}
+
+// MustUnmarshalValueMsgpack parses the MessagePack-encoded data of the KeyValue
+// and stores the result in the value pointed to by v. If an error occurs during
+// unmarshalling this will panic.
+func (kv KeyValue) MustUnmarshalValueMsgpack(v any) {
+	if err := kv.UnmarshalValueMsgpack(v); err != nil {
+		panic(fmt.Errorf("failed to unmarshal KV value as MessagePack: %w", err))
+	}
+}
+
+// UnmarshalValueProto parses the Protocol Buffers encoded data of the KeyValue
+// into the message m.
+func (kv KeyValue) UnmarshalValueProto(m proto.Message) error {
+	return kv.UnmarshalValue(CodecProto, m)
+}
+
+// MustUnmarshalValueProto parses the Protocol Buffers encoded data of the
+// KeyValue into the message m. If an error occurs during unmarshalling this
+// will panic.
+func (kv KeyValue) MustUnmarshalValueProto(m proto.Message) {
+	if err := kv.UnmarshalValueProto(m); err != nil {
+		panic(fmt.Errorf("failed to unmarshal KV value as Protocol Buffers: %w", err))
+	}
+}
+
+// UnmarshalValue decodes the data of the KeyValue with the Codec registered with
+// the provided name or content type and stores the result in the value pointed
+// to by v. If the KeyValue is empty ErrKeyNotFound is returned.
+func (kv KeyValue) UnmarshalValue(codec string, v any) error {
+	if kv.base == nil {
+		return ErrKeyNotFound
+	}
+	c, err := LookupCodec(codec)
+	if err != nil {
+		return err
+	}
+	return c.Unmarshal(kv.base.Value, v)
+}
+
 // Unwrap returns the underlying KVPair
 func (kv KeyValue) Unwrap() *api.KVPair {
 	return kv.base
 }
 
+// WrapKVPair returns a KeyValue wrapping the provided KVPair. A nil KVPair
+// returns an empty KeyValue. This is primarily useful for implementations of
+// KV other than KVClient, such as fakes used in tests.
+func WrapKVPair(kv *api.KVPair) KeyValue {
+	return KeyValue{
+		base: kv,
+	}
+}
+
+// KV is the set of operations KVClient provides. Application code can depend on
+// KV rather than KVClient, allowing KVClient to be replaced with a fake in
+// tests, such as the one provided by the konsultest package.
+type KV interface {
+	Get(key string, allowStale bool, opts ...QueryOption) (KeyValue, error)
+	MustGet(key string, allowStale bool, opts ...QueryOption) KeyValue
+	GetOrDefault(key string, fallback []byte) ([]byte, error)
+	GetOrPut(key string, initial []byte) (KeyValue, error)
+	List(prefix string, allowStale bool, opts ...QueryOption) ([]KeyValue, error)
+	Keys(prefix string, separator string, opts ...QueryOption) ([]string, error)
+	Put(key string, value []byte, opts ...WriteOption) error
+	MustPut(key string, value []byte, opts ...WriteOption)
+	PutJSON(key string, v any, opts ...WriteOption) error
+	MustPutJSON(key string, v any, opts ...WriteOption)
+	PutYAML(key string, v any, opts ...WriteOption) error
+	MustPutYAML(key string, v any, opts ...WriteOption)
+	PutCAS(key string, value []byte, modifyIndex uint64) (bool, error)
+	Delete(key string, opts ...WriteOption) error
+	Acquire(key string, value []byte, sessionID string, opts ...WriteOption) (bool, error)
+	Release(key string, sessionID string, opts ...WriteOption) (bool, error)
+	DeleteCAS(key string, modifyIndex uint64) (bool, error)
+	DeleteTree(prefix string) error
+	DeleteTreeDryRun(prefix string) ([]string, error)
+	PutLarge(key string, value []byte) error
+	GetLarge(key string) ([]byte, error)
+	DeleteLarge(key string) error
+	GetMany(keys []string) (map[string]KeyValue, error)
+	PutMany(kvs map[string][]byte) error
+	Increment(key string, delta int64) (int64, error)
+}
+
+var _ KV = KVClient{}
+
 // KVClient is an opinionated wrapper around the official Consul API Client for
 // working with KVs in Consul.
 //
 // The zero-value of KVClient is not usable. Use NewKVClient to create and
 // initialize a new instance of KVClient.
 type KVClient struct {
-	client *api.Client
+	client      *api.Client
+	logger      hclog.Logger
+	metrics     Metrics
+	schemas     *Schemas
+	json        Codec
+	keys        KeyProvider
+	compression CompressionOptions
+	retry       RetryPolicy
+	breaker     *CircuitBreaker
+	history     *HistoryOptions
 }
 
-// NewKVClient creates and initializes a new KVClient
-func NewKVClient(c *api.Client) *KVClient {
+// KVClientOptions holds optional configuration properties for KVClient.
+type KVClientOptions struct {
+	// The logger used to log operations and failures at Debug level. If not
+	// provided KVClient doesn't log.
+	Logger Logger
+	// Optional configuration to redact sensitive values such as passwords and
+	// tokens before they are logged. Values stored in Consul can end up in
+	// error messages so configuring redaction is recommended when logging at
+	// Debug level.
+	Redact redact.Config
+	// An optional minimum level for messages logged by KVClient, allowing its
+	// verbosity to be tuned independently of other subsystems sharing the same
+	// logger. Messages below this level are discarded before reaching Logger.
+	// The zero-value hclog.NoLevel doesn't filter any messages.
+	LogLevel hclog.Level
+	// Optional instrumentation hooks invoked after each operation against the
+	// Consul KV store.
+	Metrics Metrics
+	// Optional validators of the values of keys. Values put in Consul are
+	// validated first, and invalid values are rejected with a
+	// *ValidationError without being written.
+	Schemas *Schemas
+	// Optional configuration of how PutJSON encodes values. If not provided
+	// the Codec registered as CodecJSON is used, which by default indents
+	// values with tabs.
+	JSON JSONOptions
+	// Optional keys used to encrypt values transparently. When provided values
+	// are encrypted before being put in Consul and decrypted when retrieved.
+	// Values that aren't encrypted are retrieved unchanged, allowing existing
+	// keys to be migrated gradually. Schemas validate the plaintext values.
+	Encryption KeyProvider
+	// Optional policy retrying operations failing with transient errors. By
+	// default operations aren't retried.
+	Retry RetryPolicy
+	// An optional CircuitBreaker failing operations fast with an error
+	// wrapping ErrCircuitOpen while Consul is unavailable. Rejected operations
+	// aren't retried.
+	CircuitBreaker *CircuitBreaker
+	// Optional compression of values, keeping large values under the size
+	// limit Consul imposes. Values are compressed before being encrypted.
+	Compression CompressionOptions
+	// Optional configuration keeping snapshots of the values of keys, so
+	// previous values can be retrieved with History. By default no history is
+	// kept.
+	History *HistoryOptions
+}
+
+// NewKVClient creates and initializes a new KVClient with the provided options.
+// A nil client will cause a panic, use TryNewKVClient to have an error returned
+// instead.
+//
+//	kv := konsul.NewKVClient(client,
+//		konsul.WithLogger(logger),
+//		konsul.WithMetrics(metrics))
+func NewKVClient(c *api.Client, opts ...KVClientOption) *KVClient {
+	client, err := TryNewKVClient(c, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// NewKVClientWithOptions creates and initializes a new KVClient with the
+// provided options. A nil client will cause a panic.
+//
+// Deprecated: KVClientOptions implements KVClientOption, use
+// NewKVClient(c, opts) instead.
+func NewKVClientWithOptions(c *api.Client, opts KVClientOptions) *KVClient {
+	return NewKVClient(c, opts)
+}
+
+// TryNewKVClient creates and initializes a new KVClient with the provided
+// options. If the client is nil an error wrapping ErrInvalidConfig is returned.
+func TryNewKVClient(c *api.Client, options ...KVClientOption) (*KVClient, error) {
 	if c == nil {
-		panic("a valid Consul API client must be provided")
+		return nil, invalidConfig("a valid Consul API client must be provided")
+	}
+	opts := BuildKVClientOptions(options...)
+	if err := opts.Compression.validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.Retry.validate(); err != nil {
+		return nil, err
 	}
-	return &KVClient{
-		client: c,
+	if err := opts.History.validate(); err != nil {
+		return nil, err
 	}
+	logger := hclog.NewNullLogger()
+	if opts.Logger != nil {
+		logger = withLevel(HclogAdapter(opts.Logger), opts.LogLevel)
+	}
+	if !opts.Redact.IsZero() {
+		logger = redact.Wrap(logger, opts.Redact)
+	}
+	client := &KVClient{
+		client:      c,
+		logger:      logger,
+		metrics:     metricsOrNop(opts.Metrics),
+		schemas:     opts.Schemas,
+		keys:        opts.Encryption,
+		compression: opts.Compression,
+		retry:       opts.Retry.withDefaults(),
+		breaker:     opts.CircuitBreaker,
+		history:     opts.History,
+	}
+	if !opts.JSON.isZero() {
+		client.json = configuredJSONCodec{opts: opts.JSON}
+	}
+	return client, nil
 }
 
 // Get retrieves a key-value from the Consul KV store. The KeyValue is returned
 // wrapped by an Option as the key may or may not exist in Consul. If an error
 // occurs communicating with Consul a non-nil error value will be returned.
-func (c KVClient) Get(key string, allowStale bool) (KeyValue, error) {
-	kv, _, err := c.client.KV().Get(key, &api.QueryOptions{
-		AllowStale: allowStale,
-	})
+//
+// The options customize the request, such as querying another datacenter or
+// namespace:
+//
+//	kv, err := client.Get("config/app", false, konsul.WithDatacenter("dc2"))
+func (c KVClient) Get(key string, allowStale bool, opts ...QueryOption) (KeyValue, error) {
+	kv, _, err := c.get(key, queryOptions(allowStale, opts))
 	// Error communicating with Consul
 	if err != nil {
+		c.logger.Debug("failed to retrieve KV from Consul", "key", key, "error", err)
 		return KeyValue{}, err
 	}
 	// Key doesn't exist
 	if kv == nil {
+		c.logger.Debug("KV doesn't exist in Consul", "key", key)
 		return KeyValue{}, nil
 	}
+	c.logger.Debug("retrieved KV from Consul", "key", key, "modifyIndex", kv.ModifyIndex)
 	return KeyValue{
 		base: kv,
 	}, nil
@@ -167,73 +390,160 @@ func (c KVClient) Get(key string, allowStale bool) (KeyValue, error) {
 
 // MustGet retrieves a key-value from Consul KV store. If an error occurs fetching
 // the key from Consul, or the key doesn't exist this will panic.
-func (c KVClient) MustGet(key string, allowStale bool) KeyValue {
-	kv, _, err := c.client.KV().Get(key, &api.QueryOptions{
-		AllowStale: allowStale,
-	})
+func (c KVClient) MustGet(key string, allowStale bool, opts ...QueryOption) KeyValue {
+	kv, err := c.Get(key, allowStale, opts...)
 	if err != nil {
 		panic(fmt.Errorf("error retrieving key %s from Consul: %w", key, err))
 	}
-	if kv == nil {
+	if kv.base == nil {
 		panic(fmt.Errorf("key %s doesn't exist", key))
 	}
-	return KeyValue{
-		base: kv,
+	return kv
+}
+
+// GetOrDefault retrieves the value of a key from the Consul KV store, returning
+// fallback if the key doesn't exist. If an error occurs communicating with
+// Consul a non-nil error value will be returned rather than the fallback.
+func (c KVClient) GetOrDefault(key string, fallback []byte) ([]byte, error) {
+	kv, err := c.Get(key, false)
+	if err != nil {
+		return nil, err
+	}
+	if kv.base == nil {
+		return fallback, nil
+	}
+	return kv.RawValue(), nil
+}
+
+// GetOrPut retrieves a key-value from the Consul KV store, atomically setting
+// its value to initial first if the key doesn't exist, which allows bootstrap
+// code running on multiple instances to seed defaults without racing. When
+// several clients seed the same key concurrently, only one value is written
+// and every client gets the key-value that was written.
+//
+// The value is written with a Check-And-Set operation only succeeding if the
+// key doesn't exist. If the key is repeatedly deleted and recreated by other
+// clients, an error wrapping ErrConcurrentModification is returned. If an error
+// occurs communicating with Consul a non-nil error value will be returned.
+func (c KVClient) GetOrPut(key string, initial []byte) (KeyValue, error) {
+	kv, err := c.Get(key, false)
+	for attempt := 1; err == nil && kv.base == nil && attempt <= casAttempts; attempt++ {
+		var ok bool
+		if ok, err = c.PutCAS(key, initial, 0); err != nil {
+			return KeyValue{}, err
+		}
+		if ok {
+			c.logger.Debug("seeded KV in Consul", "key", key)
+		}
+		// Read the key back, whether this client or another seeded it, so the
+		// indexes of the KeyValue are populated.
+		kv, err = c.Get(key, false)
+	}
+	if err != nil || kv.base != nil {
+		return kv, err
+	}
+	return KeyValue{}, &KVError{Op: OpGet, Key: key, Err: fmt.Errorf("%w: gave up seeding key after %d attempts",
+		ErrConcurrentModification, casAttempts)}
+}
+
+// List retrieves all the key-values under the prefix from the Consul KV store,
+// sorted by key. If no keys exist under the prefix an empty slice is returned.
+// If an error occurs communicating with Consul a non-nil error value will be
+// returned.
+func (c KVClient) List(prefix string, allowStale bool, opts ...QueryOption) ([]KeyValue, error) {
+	var pairs api.KVPairs
+	q := queryOptions(allowStale, opts)
+	err := c.do(q.Context(), OpList, prefix, func() (err error) {
+		pairs, _, err = c.client.KV().List(prefix, q)
+		return err
+	})
+	if err != nil {
+		c.logger.Debug("failed to list KVs from Consul", "prefix", prefix, "error", err)
+		return nil, &KVError{Op: OpList, Key: prefix, Err: err}
+	}
+	kvs := make([]KeyValue, len(pairs))
+	for i, pair := range pairs {
+		// Chunks of values stored by PutLarge are only decoded once reassembled.
+		if IsChunkKey(pair.Key) {
+			kvs[i] = KeyValue{base: pair}
+			continue
+		}
+		if pair.Value, err = c.decodeValue(pair.Value); err != nil {
+			c.logger.Debug("failed to decode KV from Consul", "key", pair.Key, "error", err)
+			return nil, &KVError{Op: OpList, Key: pair.Key, Err: err}
+		}
+		kvs[i] = KeyValue{base: pair}
+	}
+	c.logger.Debug("listed KVs from Consul", "prefix", prefix, "count", len(kvs))
+	return kvs, nil
+}
+
+// Keys retrieves the names of the keys under the prefix without their values,
+// sorted lexically, which is useful to browse the KV tree. If separator is
+// provided only the keys up to and including the first separator after the
+// prefix are returned, deduplicated, like listing a directory: with the
+// separator "/" the keys "config/app/db" and "config/app/cache" under the
+// prefix "config/" are returned as "config/app/". If an error occurs
+// communicating with Consul a non-nil error value will be returned.
+func (c KVClient) Keys(prefix string, separator string, opts ...QueryOption) ([]string, error) {
+	var keys []string
+	q := queryOptions(false, opts)
+	err := c.do(q.Context(), OpList, prefix, func() (err error) {
+		keys, _, err = c.client.KV().Keys(prefix, separator, q)
+		return err
+	})
+	if err != nil {
+		c.logger.Debug("failed to list keys from Consul", "prefix", prefix, "error", err)
+		return nil, &KVError{Op: OpList, Key: prefix, Err: err}
 	}
+	sort.Strings(keys)
+	return keys, nil
 }
 
 // Put sets a value for a provided key in Consul KV store. If the operation fails
 // a non-nil error value is returned.
-func (c KVClient) Put(key string, value []byte) error {
+//
+// The options customize the request, such as writing to another namespace or
+// with another ACL token:
+//
+//	err := client.Put("config/app", data, konsul.WithNamespace("team-a"))
+func (c KVClient) Put(key string, value []byte, opts ...WriteOption) error {
 	kv := &api.KVPair{
 		Key:   key,
 		Value: value,
 	}
-	_, err := c.client.KV().Put(kv, nil)
+	if flags, ok := WriteFlags(opts...); ok {
+		kv.Flags = uint64(flags)
+	}
+	_, err := c.put(kv, writeOptions(opts))
+	c.logPut(key, err)
 	return err
 }
 
 // MustPut sets a value for a provided key in Consul KV store. If the operation
 // fails this will panic.
-func (c KVClient) MustPut(key string, value []byte) {
-	kv := &api.KVPair{
-		Key:   key,
-		Value: value,
-	}
-	if _, err := c.client.KV().Put(kv, nil); err != nil {
+func (c KVClient) MustPut(key string, value []byte, opts ...WriteOption) {
+	if err := c.Put(key, value, opts...); err != nil {
 		panic(fmt.Errorf("failed to put KV with key %s in Consul: %w", key, err))
 	}
 }
 
 // PutJSON marshals the provided value as JSON and sets that value for the given
-// key in Consul KV store. If marshaling fails or putting the value in consul
-// fails this returns a non-nil error value.
-func (c KVClient) PutJSON(key string, v any) error {
-	data, err := json.MarshalIndent(v, "", "\t")
-	if err != nil {
-		return fmt.Errorf("error marshalling value to JSON: %w", err)
-	}
-	kv := &api.KVPair{
-		Key:   key,
-		Value: data,
+// key in Consul KV store. The encoding is configured with KVClientOptions.JSON.
+// If marshaling fails or putting the value in consul fails this returns a
+// non-nil error value.
+func (c KVClient) PutJSON(key string, v any, opts ...WriteOption) error {
+	if c.json != nil {
+		return c.putEncoded(key, CodecJSON, c.json, v, opts)
 	}
-	_, err = c.client.KV().Put(kv, nil)
-	return err
+	return c.PutEncoded(key, CodecJSON, v, opts...)
 }
 
 // MustPutJSON marshals the provided value as JSON and sets that value for the
 // given key in Consul KV store. If an error occurs during this operation this
 // will panic.
-func (c KVClient) MustPutJSON(key string, v any) {
-	data, err := json.MarshalIndent(v, "", "\t")
-	if err != nil {
-		panic(fmt.Errorf("error marshalling value to JSON: %w", err))
-	}
-	kv := &api.KVPair{
-		Key:   key,
-		Value: data,
-	}
-	if _, err := c.client.KV().Put(kv, nil); err != nil {
+func (c KVClient) MustPutJSON(key string, v any, opts ...WriteOption) {
+	if err := c.PutJSON(key, v, opts...); err != nil {
 		panic(fmt.Errorf("failed to put KV with key %s in Consul: %w", key, err))
 	}
 }
@@ -241,39 +551,283 @@ func (c KVClient) MustPutJSON(key string, v any) {
 // PutYAML marshals the provided value as YAML and sets that value for the given
 // key in Consul KV store. If marshaling fails or putting the value in consul
 // fails this returns a non-nil error value.
-func (c KVClient) PutYAML(key string, v any) error {
-	data, err := yaml.Marshal(v)
+func (c KVClient) PutYAML(key string, v any, opts ...WriteOption) error {
+	return c.PutEncoded(key, CodecYAML, v, opts...)
+}
+
+// MustPutYAML marshals the provided value as YAML and sets that value for the
+// given key in Consul KV store. If an error occurs during this operation this
+// will panic.
+func (c KVClient) MustPutYAML(key string, v any, opts ...WriteOption) {
+	if err := c.PutYAML(key, v, opts...); err != nil {
+		panic(fmt.Errorf("failed to put KV with key %s in Consul: %w", key, err))
+	}
+}
+
+// PutMsgpack marshals the provided value as MessagePack and sets that value for
+// the given key in Consul KV store. If marshaling fails or putting the value in
+// consul fails this returns a non-nil error value.
+func (c KVClient) PutMsgpack(key string, v any, opts ...WriteOption) error {
+	return c.PutEncoded(key, CodecMsgpack, v, opts...)
+}
+
+// MustPutMsgpack marshals the provided value as MessagePack and sets that value
+// for the given key in Consul KV store. If an error occurs during this
+// operation this will panic.
+func (c KVClient) MustPutMsgpack(key string, v any, opts ...WriteOption) {
+	if err := c.PutMsgpack(key, v, opts...); err != nil {
+		panic(fmt.Errorf("failed to put KV with key %s in Consul: %w", key, err))
+	}
+}
+
+// PutProto marshals the message in the Protocol Buffers binary format and sets
+// that value for the given key in Consul KV store. If marshaling fails or
+// putting the value in consul fails this returns a non-nil error value.
+func (c KVClient) PutProto(key string, m proto.Message, opts ...WriteOption) error {
+	return c.PutEncoded(key, CodecProto, m, opts...)
+}
+
+// MustPutProto marshals the message in the Protocol Buffers binary format and
+// sets that value for the given key in Consul KV store. If an error occurs
+// during this operation this will panic.
+func (c KVClient) MustPutProto(key string, m proto.Message, opts ...WriteOption) {
+	if err := c.PutProto(key, m, opts...); err != nil {
+		panic(fmt.Errorf("failed to put KV with key %s in Consul: %w", key, err))
+	}
+}
+
+// PutEncoded encodes the provided value with the Codec registered with the
+// provided name or content type and sets that value for the given key in Consul
+// KV store. If the Codec isn't registered, encoding fails, or putting the value
+// in Consul fails this returns a non-nil error value.
+func (c KVClient) PutEncoded(key string, codec string, v any, opts ...WriteOption) error {
+	enc, err := LookupCodec(codec)
 	if err != nil {
-		return fmt.Errorf("error marshalling value to YAML: %w", err)
+		return &KVError{Op: OpPut, Key: key, Err: err}
+	}
+	return c.putEncoded(key, codec, enc, v, opts)
+}
+
+func (c KVClient) putEncoded(key string, codec string, enc Codec, v any, opts []WriteOption) error {
+	data, err := enc.Marshal(v)
+	if err != nil {
+		c.logger.Debug("failed to encode value", "key", key, "codec", codec, "error", err)
+		return &KVError{Op: OpPut, Key: key, Err: fmt.Errorf("error encoding value with codec %s: %w", codec, err)}
 	}
 	kv := &api.KVPair{
 		Key:   key,
 		Value: data,
 	}
-	_, err = c.client.KV().Put(kv, nil)
+	if flags, ok := WriteFlags(opts...); ok {
+		kv.Flags = uint64(flags)
+	}
+	_, err = c.put(kv, writeOptions(opts))
+	c.logPut(key, err)
 	return err
 }
 
-// MustPutYAML marshals the provided value as YAML and sets that value for the
-// given key in Consul KV store. If an error occurs during this operation this
-// will panic.
-func (c KVClient) MustPutYAML(key string, v any) {
-	data, err := yaml.Marshal(v)
+// PutCAS sets a value for a provided key in Consul KV store using a
+// Check-And-Set operation. The value is only written if the ModifyIndex of the
+// key still matches modifyIndex, allowing optimistic concurrency when multiple
+// clients update the same key. A modifyIndex of 0 only writes the value if the
+// key doesn't exist.
+//
+// PutCAS returns true if the value was written, and false if the key was
+// modified since modifyIndex was read, in which case the caller should read the
+// key again and retry. If the operation fails a non-nil error value is
+// returned.
+func (c KVClient) PutCAS(key string, value []byte, modifyIndex uint64) (bool, error) {
+	kv := &api.KVPair{
+		Key:         key,
+		Value:       value,
+		ModifyIndex: modifyIndex,
+	}
+	if err := c.schemas.Validate(key, value); err != nil {
+		return false, &KVError{Op: OpPut, Key: key, Err: err}
+	}
+	var err error
+	if kv.Value, err = c.encodeValue(value); err != nil {
+		return false, &KVError{Op: OpPut, Key: key, Err: err}
+	}
+	if c.history != nil {
+		ok, err := c.putWithHistory(kv, true, nil)
+		if err != nil {
+			c.logger.Debug("failed to check-and-set KV in Consul", "key", key, "error", err)
+			return false, err
+		}
+		c.logger.Debug("check-and-set KV in Consul", "key", key, "modifyIndex", modifyIndex, "applied", ok)
+		return ok, nil
+	}
+	var ok bool
+	err = c.do(context.Background(), OpPut, key, func() (err error) {
+		ok, _, err = c.client.KV().CAS(kv, nil)
+		return err
+	})
 	if err != nil {
-		panic(fmt.Errorf("error marshalling value to YAML: %w", err))
+		c.logger.Debug("failed to check-and-set KV in Consul", "key", key, "error", err)
+		return false, &KVError{Op: OpPut, Key: key, Err: err}
 	}
+	c.logger.Debug("check-and-set KV in Consul", "key", key, "modifyIndex", modifyIndex, "applied", ok)
+	return ok, nil
+}
+
+// DeleteCAS removes a key/value from the Consul KV store using a Check-And-Set
+// operation. The key is only deleted if its ModifyIndex still matches
+// modifyIndex. DeleteCAS returns true if the key was deleted, and false if the
+// key was modified since modifyIndex was read. If the operation fails a
+// non-nil error value is returned.
+func (c KVClient) DeleteCAS(key string, modifyIndex uint64) (bool, error) {
 	kv := &api.KVPair{
-		Key:   key,
-		Value: data,
+		Key:         key,
+		ModifyIndex: modifyIndex,
 	}
-	if _, err := c.client.KV().Put(kv, nil); err != nil {
-		panic(fmt.Errorf("failed to put KV with key %s in Consul: %w", key, err))
+	var ok bool
+	err := c.do(context.Background(), OpDelete, key, func() (err error) {
+		ok, _, err = c.client.KV().DeleteCAS(kv, nil)
+		return err
+	})
+	if err != nil {
+		c.logger.Debug("failed to check-and-delete KV from Consul", "key", key, "error", err)
+		return false, &KVError{Op: OpDelete, Key: key, Err: err}
 	}
+	c.logger.Debug("check-and-delete KV from Consul", "key", key, "modifyIndex", modifyIndex, "applied", ok)
+	return ok, nil
 }
 
 // Delete removes a key/value from the Consul KV store. If this operation fails
 // a non-nil error value is returned.
-func (c KVClient) Delete(key string) error {
-	_, err := c.client.KV().Delete(key, nil)
-	return err
+func (c KVClient) Delete(key string, opts ...WriteOption) error {
+	_, err := c.delete(key, writeOptions(opts))
+	if err != nil {
+		c.logger.Debug("failed to delete KV from Consul", "key", key, "error", err)
+		return err
+	}
+	c.logger.Debug("deleted KV from Consul", "key", key)
+	return nil
+}
+
+// DeleteTree removes every key/value under the prefix from the Consul KV store,
+// including the prefix itself if it's a key. Since an empty prefix would
+// delete the entire KV store it's rejected with an error wrapping
+// ErrInvalidConfig. If this operation fails a non-nil error value is returned.
+//
+// Use DeleteTreeDryRun to retrieve the keys that would be deleted.
+func (c KVClient) DeleteTree(prefix string) error {
+	if prefix == "" {
+		return &KVError{Op: OpDelete, Err: invalidConfig("a prefix must be provided to delete a tree")}
+	}
+	err := c.do(context.Background(), OpDelete, prefix, func() (err error) {
+		_, err = c.client.KV().DeleteTree(prefix, nil)
+		return err
+	})
+	if err != nil {
+		c.logger.Debug("failed to delete KV tree from Consul", "prefix", prefix, "error", err)
+		return &KVError{Op: OpDelete, Key: prefix, Err: err}
+	}
+	c.logger.Debug("deleted KV tree from Consul", "prefix", prefix)
+	return nil
+}
+
+// DeleteTreeDryRun returns the keys DeleteTree would remove for the prefix,
+// sorted lexically, without deleting anything. If an error occurs
+// communicating with Consul a non-nil error value is returned.
+func (c KVClient) DeleteTreeDryRun(prefix string) ([]string, error) {
+	if prefix == "" {
+		return nil, &KVError{Op: OpDelete, Err: invalidConfig("a prefix must be provided to delete a tree")}
+	}
+	return c.Keys(prefix, "")
+}
+
+func (c KVClient) logPut(key string, err error) {
+	if err != nil {
+		c.logger.Debug("failed to put KV in Consul", "key", key, "error", err)
+		return
+	}
+	c.logger.Debug("put KV in Consul", "key", key)
+}
+
+func (c KVClient) get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	var kv *api.KVPair
+	var meta *api.QueryMeta
+	err := c.do(q.Context(), OpGet, key, func() (err error) {
+		kv, meta, err = c.client.KV().Get(key, q)
+		return err
+	})
+	if err != nil {
+		return nil, nil, &KVError{Op: OpGet, Key: key, Err: err}
+	}
+	if kv != nil {
+		if kv.Value, err = c.decodeValue(kv.Value); err != nil {
+			return nil, nil, &KVError{Op: OpGet, Key: key, Err: err}
+		}
+	}
+	return kv, meta, nil
+}
+
+func (c KVClient) put(kv *api.KVPair, w *api.WriteOptions) (*api.WriteMeta, error) {
+	if err := c.schemas.Validate(kv.Key, kv.Value); err != nil {
+		return nil, &KVError{Op: OpPut, Key: kv.Key, Err: err}
+	}
+	var err error
+	if kv.Value, err = c.encodeValue(kv.Value); err != nil {
+		return nil, &KVError{Op: OpPut, Key: kv.Key, Err: err}
+	}
+	if c.history != nil {
+		_, err := c.putWithHistory(kv, false, w)
+		return nil, err
+	}
+	var meta *api.WriteMeta
+	err = c.do(w.Context(), OpPut, kv.Key, func() (err error) {
+		meta, err = c.client.KV().Put(kv, w)
+		return err
+	})
+	if err != nil {
+		return nil, &KVError{Op: OpPut, Key: kv.Key, Err: err}
+	}
+	return meta, nil
+}
+
+func (c KVClient) delete(key string, w *api.WriteOptions) (*api.WriteMeta, error) {
+	var meta *api.WriteMeta
+	err := c.do(w.Context(), OpDelete, key, func() (err error) {
+		meta, err = c.client.KV().Delete(key, w)
+		return err
+	})
+	if err != nil {
+		return nil, &KVError{Op: OpDelete, Key: key, Err: err}
+	}
+	return meta, nil
+}
+
+// encodeValue transforms a value before it's stored in Consul, compressing
+// and then encrypting it when the KVClient is configured to.
+func (c KVClient) encodeValue(value []byte) ([]byte, error) {
+	value, err := compress(c.compression, value)
+	if err != nil {
+		return nil, err
+	}
+	if c.keys == nil {
+		return value, nil
+	}
+	return encrypt(c.keys, value)
+}
+
+// decodeValue reverses encodeValue on a value retrieved from Consul.
+func (c KVClient) decodeValue(value []byte) ([]byte, error) {
+	return decodeValue(c.keys, c.compression, value)
+}
+
+// decodeValue decrypts a value retrieved from Consul if keys are provided and
+// then decompresses it if compression is enabled.
+func decodeValue(keys KeyProvider, compression CompressionOptions, value []byte) ([]byte, error) {
+	var err error
+	if keys != nil {
+		if value, err = decrypt(keys, value); err != nil {
+			return nil, err
+		}
+	}
+	if compression.enabled() {
+		return decompress(value)
+	}
+	return value, nil
 }
@@ -0,0 +1,364 @@
+package konsul
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// ErrConcurrentModification is a sentinel error value indicating a key was
+// modified by another client while an operation spanning multiple requests was
+// in progress.
+var ErrConcurrentModification = errors.New("key modified concurrently")
+
+const (
+	// ChunkSize is the size in bytes of the chunks PutLarge splits values into.
+	// Values no larger than ChunkSize are stored in the key directly.
+	ChunkSize = 128 << 10
+
+	// chunksPerTxn bounds the chunks written per transaction so requests stay
+	// under the 512KB default limit Consul imposes on transactions once values
+	// are base64 encoded.
+	chunksPerTxn = 2
+
+	// chunkReadAttempts is the number of times GetLarge reads a chunked value
+	// when it's replaced while being read.
+	chunkReadAttempts = 3
+)
+
+// chunkManifestMagic prefixes the manifest stored in the key of a chunked
+// value. The leading NUL byte never appears in text formats such as JSON or
+// YAML.
+var chunkManifestMagic = []byte("\x00konsul-chunked\n")
+
+// chunkManifest describes the chunks of a value stored by PutLarge.
+type chunkManifest struct {
+	Generation string `json:"generation"`
+	Chunks     int    `json:"chunks"`
+	Size       int    `json:"size"`
+	SHA256     string `json:"sha256"`
+}
+
+func parseChunkManifest(value []byte) (chunkManifest, bool, error) {
+	var m chunkManifest
+	if !bytes.HasPrefix(value, chunkManifestMagic) {
+		return m, false, nil
+	}
+	if err := json.Unmarshal(value[len(chunkManifestMagic):], &m); err != nil {
+		return m, true, fmt.Errorf("invalid chunk manifest: %w", err)
+	}
+	return m, true, nil
+}
+
+func (m chunkManifest) marshal() []byte {
+	data, _ := json.Marshal(m)
+	return append(append([]byte(nil), chunkManifestMagic...), data...)
+}
+
+// chunkPrefix returns the prefix of the keys holding the chunks of the
+// generation of a value stored at key, or of every generation if generation is
+// empty.
+func chunkPrefix(key string, generation string) string {
+	if generation == "" {
+		return key + "/.chunks/"
+	}
+	return key + "/.chunks/" + generation + "/"
+}
+
+// IsChunkKey returns true if the key holds a chunk of a value stored by
+// PutLarge. Integrations listing keys under a prefix should skip these keys,
+// or use ReassembleChunks.
+func IsChunkKey(key string) bool {
+	return strings.Contains(key, "/.chunks/")
+}
+
+// ReassembleChunks returns the pairs listed under a prefix with the chunks of
+// values stored by PutLarge left out, and the manifests of those values
+// replaced with the values reassembled from the chunks. Values are otherwise
+// returned as stored, so they're still compressed or encrypted if they were
+// written that way. If the chunks of a value are missing or don't match its
+// manifest a non-nil error is returned.
+func ReassembleChunks(pairs api.KVPairs) (api.KVPairs, error) {
+	res := make(api.KVPairs, 0, len(pairs))
+	for _, pair := range pairs {
+		if pair == nil || IsChunkKey(pair.Key) {
+			continue
+		}
+		manifest, chunked, err := parseChunkManifest(pair.Value)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", pair.Key, err)
+		}
+		if chunked {
+			value, err := assembleChunks(pair.Key, manifest, pairs)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %w", pair.Key, err)
+			}
+			assembled := *pair
+			assembled.Value = value
+			pair = &assembled
+		}
+		res = append(res, pair)
+	}
+	return res, nil
+}
+
+func chunkKey(key string, generation string, i int) string {
+	return fmt.Sprintf("%s%06d", chunkPrefix(key, generation), i)
+}
+
+// PutLarge sets the value of the key like Put, splitting values larger than
+// ChunkSize across multiple keys so values exceeding the size limit Consul
+// imposes can be stored. Use GetLarge to retrieve values stored with PutLarge.
+//
+// The chunks are stored under the "/.chunks/" suffix of the key, and the key
+// holds a manifest of the chunks. Chunks are written first and the manifest is
+// then swapped in a transaction, which removes the chunks of the previous
+// value, so readers never observe a partially written value. If the key is
+// modified by another client while the chunks are written, the chunks are
+// removed and an error wrapping ErrConcurrentModification is returned.
+//
+// The value is validated against the Schemas of the KVClient and compressed
+// and encrypted as a whole, as configured, before being split.
+//
+// Watch and WatchPrefix reassemble chunked values before they're decoded, but
+// other readers of the key, such as Get, List, and the Consul CLI, observe the
+// manifest rather than the value.
+func (c KVClient) PutLarge(key string, value []byte) error {
+	if err := c.schemas.Validate(key, value); err != nil {
+		return &KVError{Op: OpPut, Key: key, Err: err}
+	}
+	encoded, err := c.encodeValue(value)
+	if err != nil {
+		return &KVError{Op: OpPut, Key: key, Err: err}
+	}
+
+	var current *api.KVPair
+	err = c.do(context.Background(), OpGet, key, func() (err error) {
+		current, _, err = c.client.KV().Get(key, nil)
+		return err
+	})
+	if err != nil {
+		return &KVError{Op: OpPut, Key: key, Err: err}
+	}
+	var index uint64
+	var previous chunkManifest
+	if current != nil {
+		index = current.ModifyIndex
+		if previous, _, err = parseChunkManifest(current.Value); err != nil {
+			c.logger.Warn("replacing invalid chunk manifest", "key", key, "error", err)
+		}
+	}
+
+	var generation string
+	final := c.Txn()
+	if len(encoded) <= ChunkSize {
+		final.add(&api.KVTxnOp{Verb: api.KVCAS, Key: key, Value: encoded, Index: index})
+	} else {
+		manifest, err := c.putChunks(key, encoded)
+		if err != nil {
+			return err
+		}
+		generation = manifest.Generation
+		final.add(&api.KVTxnOp{Verb: api.KVCAS, Key: key, Value: manifest.marshal(), Index: index})
+	}
+	if previous.Generation != "" {
+		final.add(&api.KVTxnOp{Verb: api.KVDeleteTree, Key: chunkPrefix(key, previous.Generation)})
+	}
+
+	result, err := final.Commit()
+	if err == nil && !result.Committed {
+		err = &KVError{Op: OpPut, Key: key, Err: ErrConcurrentModification}
+	}
+	if err != nil {
+		c.logger.Debug("failed to put large KV in Consul", "key", key, "error", err)
+		if generation != "" {
+			c.deleteChunks(key, generation)
+		}
+		return err
+	}
+	c.logger.Debug("put large KV in Consul", "key", key, "size", len(encoded))
+	return nil
+}
+
+// putChunks writes the value in chunks of a new generation and returns the
+// manifest describing them. If writing fails the chunks written are removed.
+func (c KVClient) putChunks(key string, value []byte) (chunkManifest, error) {
+	generation := make([]byte, 8)
+	if _, err := rand.Read(generation); err != nil {
+		return chunkManifest{}, &KVError{Op: OpPut, Key: key, Err: fmt.Errorf("error generating chunk generation: %w", err)}
+	}
+	sum := sha256.Sum256(value)
+	manifest := chunkManifest{
+		Generation: hex.EncodeToString(generation),
+		Chunks:     (len(value) + ChunkSize - 1) / ChunkSize,
+		Size:       len(value),
+		SHA256:     hex.EncodeToString(sum[:]),
+	}
+
+	for i := 0; i < manifest.Chunks; i += chunksPerTxn {
+		txn := c.Txn()
+		for j := i; j < i+chunksPerTxn && j < manifest.Chunks; j++ {
+			end := (j + 1) * ChunkSize
+			if end > len(value) {
+				end = len(value)
+			}
+			txn.add(&api.KVTxnOp{Verb: api.KVSet, Key: chunkKey(key, manifest.Generation, j), Value: value[j*ChunkSize : end]})
+		}
+		result, err := txn.Commit()
+		if err == nil && !result.Committed {
+			err = &KVError{Op: OpTxn, Key: key, Err: fmt.Errorf("failed to write chunks: %v", result.Errors)}
+		}
+		if err != nil {
+			c.logger.Debug("failed to write chunks of large KV to Consul", "key", key, "error", err)
+			c.deleteChunks(key, manifest.Generation)
+			return chunkManifest{}, err
+		}
+	}
+	return manifest, nil
+}
+
+// deleteChunks removes the chunks of the generation on a best-effort basis.
+func (c KVClient) deleteChunks(key string, generation string) {
+	err := c.do(context.Background(), OpDelete, key, func() (err error) {
+		_, err = c.client.KV().DeleteTree(chunkPrefix(key, generation), nil)
+		return err
+	})
+	if err != nil {
+		c.logger.Debug("failed to delete chunks of large KV from Consul", "key", key, "generation", generation, "error", err)
+	}
+}
+
+// GetLarge retrieves the value of a key stored with PutLarge, reassembling it
+// from its chunks, decrypting, and decompressing it as configured. Keys stored
+// without PutLarge are returned like Get. If the key doesn't exist a *KVError
+// matching ErrKeyNotFound is returned.
+func (c KVClient) GetLarge(key string) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		var pair *api.KVPair
+		err := c.do(context.Background(), OpGet, key, func() (err error) {
+			pair, _, err = c.client.KV().Get(key, nil)
+			return err
+		})
+		if err != nil {
+			return nil, &KVError{Op: OpGet, Key: key, Err: err}
+		}
+		if pair == nil {
+			return nil, &KVError{Op: OpGet, Key: key, Err: ErrKeyNotFound}
+		}
+
+		manifest, chunked, err := parseChunkManifest(pair.Value)
+		if err != nil {
+			return nil, &KVError{Op: OpGet, Key: key, Err: err}
+		}
+		value := pair.Value
+		if chunked {
+			value, err = c.getChunks(key, manifest)
+			if errors.Is(err, ErrConcurrentModification) && attempt < chunkReadAttempts {
+				c.logger.Debug("large KV replaced while reading chunks, retrying", "key", key)
+				continue
+			}
+			if err != nil {
+				return nil, &KVError{Op: OpGet, Key: key, Err: err}
+			}
+		}
+		if value, err = c.decodeValue(value); err != nil {
+			return nil, &KVError{Op: OpGet, Key: key, Err: err}
+		}
+		c.logger.Debug("retrieved large KV from Consul", "key", key, "modifyIndex", pair.ModifyIndex)
+		return value, nil
+	}
+}
+
+// getChunks reads and reassembles the chunks described by the manifest. If the
+// chunks are missing or don't match the manifest, as happens when the value is
+// replaced while being read, an error wrapping ErrConcurrentModification is
+// returned.
+func (c KVClient) getChunks(key string, manifest chunkManifest) ([]byte, error) {
+	prefix := chunkPrefix(key, manifest.Generation)
+	var pairs api.KVPairs
+	err := c.do(context.Background(), OpList, prefix, func() (err error) {
+		pairs, _, err = c.client.KV().List(prefix, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return assembleChunks(key, manifest, pairs)
+}
+
+// assembleChunks reassembles the chunks described by the manifest from the
+// pairs, ignoring pairs that aren't chunks of the manifest's generation. If the
+// chunks are missing or don't match the manifest an error wrapping
+// ErrConcurrentModification is returned.
+func assembleChunks(key string, manifest chunkManifest, pairs api.KVPairs) ([]byte, error) {
+	prefix := chunkPrefix(key, manifest.Generation)
+	chunks := make(api.KVPairs, 0, manifest.Chunks)
+	for _, pair := range pairs {
+		if pair != nil && strings.HasPrefix(pair.Key, prefix) {
+			chunks = append(chunks, pair)
+		}
+	}
+	if len(chunks) != manifest.Chunks {
+		return nil, fmt.Errorf("%w: expected %d chunks but found %d", ErrConcurrentModification, manifest.Chunks, len(chunks))
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Key < chunks[j].Key })
+
+	value := make([]byte, 0, manifest.Size)
+	for _, pair := range chunks {
+		value = append(value, pair.Value...)
+	}
+	sum := sha256.Sum256(value)
+	if hex.EncodeToString(sum[:]) != manifest.SHA256 {
+		return nil, fmt.Errorf("%w: checksum of chunks doesn't match manifest", ErrConcurrentModification)
+	}
+	return value, nil
+}
+
+// readChunks returns the key-value with its value reassembled from its chunks
+// if it holds the manifest of a value stored by PutLarge, reading the chunks
+// with the query options. Other key-values are returned as is.
+func readChunks(client *api.Client, kv *api.KVPair, q *api.QueryOptions) (*api.KVPair, error) {
+	manifest, chunked, err := parseChunkManifest(kv.Value)
+	if err != nil || !chunked {
+		return kv, err
+	}
+	pairs, _, err := client.KV().List(chunkPrefix(kv.Key, manifest.Generation), q)
+	if err != nil {
+		return nil, fmt.Errorf("error reading chunks: %w", err)
+	}
+	value, err := assembleChunks(kv.Key, manifest, pairs)
+	if err != nil {
+		return nil, err
+	}
+	resolved := *kv
+	resolved.Value = value
+	return &resolved, nil
+}
+
+// DeleteLarge removes a key stored with PutLarge along with its chunks in a
+// single transaction. If this operation fails a non-nil error value is
+// returned.
+func (c KVClient) DeleteLarge(key string) error {
+	result, err := c.Txn().
+		Delete(key).
+		add(&api.KVTxnOp{Verb: api.KVDeleteTree, Key: chunkPrefix(key, "")}).
+		Commit()
+	if err == nil && !result.Committed {
+		err = &KVError{Op: OpDelete, Key: key, Err: fmt.Errorf("failed to delete large KV: %v", result.Errors)}
+	}
+	if err != nil {
+		c.logger.Debug("failed to delete large KV from Consul", "key", key, "error", err)
+		return err
+	}
+	c.logger.Debug("deleted large KV from Consul", "key", key)
+	return nil
+}
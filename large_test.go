@@ -0,0 +1,39 @@
+package konsul
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestReassembleChunks(t *testing.T) {
+	value := []byte("chunked value")
+	sum := sha256.Sum256(value)
+	manifest := chunkManifest{Generation: "abc", Chunks: 2, Size: len(value), SHA256: hex.EncodeToString(sum[:])}
+	pairs := api.KVPairs{
+		{Key: "config/large", Value: manifest.marshal()},
+		{Key: chunkKey("config/large", "abc", 0), Value: value[:7]},
+		{Key: chunkKey("config/large", "abc", 1), Value: value[7:]},
+		{Key: "config/small", Value: []byte("small value")},
+	}
+
+	res, err := ReassembleChunks(pairs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("expected the chunks to be left out, got %d pairs", len(res))
+	}
+	if string(res[0].Value) != string(value) || string(res[1].Value) != "small value" {
+		t.Errorf("expected the reassembled and plain values, got %q and %q", res[0].Value, res[1].Value)
+	}
+	if !IsChunkKey(pairs[1].Key) {
+		t.Errorf("expected %s to be a chunk key", pairs[1].Key)
+	}
+
+	if _, err := ReassembleChunks(pairs[:2]); err == nil {
+		t.Error("expected an error when chunks are missing")
+	}
+}
@@ -0,0 +1,92 @@
+package konsul
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+// verifyNoLeaks fails the test if any goroutine started by it outlives it. It
+// must be called before newTestConsul so the check runs once the test server is
+// closed.
+func verifyNoLeaks(t *testing.T) {
+	t.Cleanup(func() {
+		goleak.VerifyNone(t)
+	})
+}
+
+func TestInstancer_CloseLeavesNoGoroutines(t *testing.T) {
+	verifyNoLeaks(t)
+	client := newTestConsul(t)
+
+	instancer, err := NewInstancer(InstancerConfig{Client: client, Service: "orders"})
+	if err != nil {
+		t.Fatalf("failed to create Instancer: %v", err)
+	}
+	updates, unsubscribe := instancer.Subscribe()
+	defer unsubscribe()
+	waitForInstances(t, updates)
+	instancer.Close()
+}
+
+func TestInstancer_CloseWithRestartLeavesNoGoroutines(t *testing.T) {
+	verifyNoLeaks(t)
+	client := newTestConsul(t)
+
+	instancer, err := NewInstancer(InstancerConfig{Client: client, Service: "orders"},
+		WithRestart(RetryPolicy{InitialBackoff: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("failed to create Instancer: %v", err)
+	}
+	updates, _ := instancer.Subscribe()
+	waitForInstances(t, updates)
+	instancer.Close()
+	if _, ok := <-updates; ok {
+		t.Errorf("expected subscription to be closed")
+	}
+}
+
+func TestSession_CloseLeavesNoGoroutines(t *testing.T) {
+	verifyNoLeaks(t)
+	client := newTestConsul(t)
+
+	session, err := NewKVClient(client).NewSession(SessionOptions{TTL: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if err := session.Close(); err != nil {
+		t.Fatalf("failed to close session: %v", err)
+	}
+}
+
+func TestWatchHandle_StopLeavesNoGoroutines(t *testing.T) {
+	verifyNoLeaks(t)
+	client := newTestConsul(t)
+
+	cfg := &partialTarget{}
+	handle, err := StartWatch(client, "config/app", cfg)
+	if err != nil {
+		t.Fatalf("failed to start watch: %v", err)
+	}
+	if !handle.IsRunning() {
+		t.Fatalf("expected watch to be running")
+	}
+	handle.Stop()
+	<-handle.Done()
+}
+
+func waitForInstances(t *testing.T, updates <-chan []Instance) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case instances := <-updates:
+			if len(instances) > 0 {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for instances")
+		}
+	}
+}
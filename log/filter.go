@@ -0,0 +1,116 @@
+package log
+
+import (
+	"github.com/hashicorp/go-hclog"
+)
+
+// Filter decorates the provided hclog.Logger discarding messages below the
+// minimum level of the provided LevelVar. Unlike SetLevel on the decorated
+// logger, the minimum level only applies to messages logged through the
+// returned hclog.Logger and loggers derived from it, so it can be used to tune
+// the verbosity of a single subsystem sharing an application's logger.
+//
+// The returned hclog.Logger implements LevelController, changing the minimum
+// level of the provided LevelVar. A nil logger or LevelVar will cause a panic.
+func Filter(logger hclog.Logger, lv *LevelVar) hclog.Logger {
+	if logger == nil {
+		panic("cannot filter nil hclog.Logger")
+	}
+	if lv == nil {
+		panic("cannot filter with nil LevelVar")
+	}
+	return &filter{
+		Logger: logger,
+		min:    lv,
+	}
+}
+
+type filter struct {
+	hclog.Logger
+	min *LevelVar
+}
+
+func (f *filter) Log(level hclog.Level, msg string, args ...any) {
+	if f.min.Enabled(level) {
+		f.Logger.Log(level, msg, args...)
+	}
+}
+
+func (f *filter) Trace(msg string, args ...any) {
+	if f.min.Enabled(hclog.Trace) {
+		f.Logger.Trace(msg, args...)
+	}
+}
+
+func (f *filter) Debug(msg string, args ...any) {
+	if f.min.Enabled(hclog.Debug) {
+		f.Logger.Debug(msg, args...)
+	}
+}
+
+func (f *filter) Info(msg string, args ...any) {
+	if f.min.Enabled(hclog.Info) {
+		f.Logger.Info(msg, args...)
+	}
+}
+
+func (f *filter) Warn(msg string, args ...any) {
+	if f.min.Enabled(hclog.Warn) {
+		f.Logger.Warn(msg, args...)
+	}
+}
+
+func (f *filter) Error(msg string, args ...any) {
+	if f.min.Enabled(hclog.Error) {
+		f.Logger.Error(msg, args...)
+	}
+}
+
+func (f *filter) IsTrace() bool {
+	return f.min.Enabled(hclog.Trace) && f.Logger.IsTrace()
+}
+
+func (f *filter) IsDebug() bool {
+	return f.min.Enabled(hclog.Debug) && f.Logger.IsDebug()
+}
+
+func (f *filter) IsInfo() bool {
+	return f.min.Enabled(hclog.Info) && f.Logger.IsInfo()
+}
+
+func (f *filter) IsWarn() bool {
+	return f.min.Enabled(hclog.Warn) && f.Logger.IsWarn()
+}
+
+func (f *filter) IsError() bool {
+	return f.min.Enabled(hclog.Error) && f.Logger.IsError()
+}
+
+func (f *filter) With(args ...any) hclog.Logger {
+	return &filter{
+		Logger: f.Logger.With(args...),
+		min:    f.min,
+	}
+}
+
+func (f *filter) Named(name string) hclog.Logger {
+	return &filter{
+		Logger: f.Logger.Named(name),
+		min:    f.min,
+	}
+}
+
+func (f *filter) ResetNamed(name string) hclog.Logger {
+	return &filter{
+		Logger: f.Logger.ResetNamed(name),
+		min:    f.min,
+	}
+}
+
+func (f *filter) SetMinLevel(level hclog.Level) {
+	f.min.SetMinLevel(level)
+}
+
+func (f *filter) MinLevel() hclog.Level {
+	return f.min.MinLevel()
+}
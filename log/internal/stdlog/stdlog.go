@@ -0,0 +1,77 @@
+// Package stdlog provides an io.Writer that adapts output from a standard library
+// log Logger to a hclog.Logger. It is shared by the logging wrappers to implement
+// the StandardWriter method of the hclog.Logger interface.
+package stdlog
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Regex to ignore characters commonly found in timestamp formats from the
+// beginning of inputs.
+var timestampRegexp = regexp.MustCompile(`^[\d\s\:\/\.\+-TZ]*`)
+
+// NewWriter returns an io.Writer that logs each write through the provided
+// hclog.Logger honoring the InferLevels, InferLevelsWithTimestamp and ForceLevel
+// options the same way hclog does.
+func NewWriter(logger hclog.Logger, opts *hclog.StandardLoggerOptions) io.Writer {
+	if opts == nil {
+		opts = &hclog.StandardLoggerOptions{}
+	}
+	return &writer{
+		logger: logger,
+		opts:   *opts,
+	}
+}
+
+type writer struct {
+	logger hclog.Logger
+	opts   hclog.StandardLoggerOptions
+}
+
+func (w *writer) Write(data []byte) (int, error) {
+	str := string(bytes.TrimRight(data, " \t\n"))
+
+	switch {
+	case w.opts.ForceLevel != hclog.NoLevel:
+		// Strip any level included in the line since the level is forced
+		_, str = pickLevel(str)
+		w.logger.Log(w.opts.ForceLevel, str)
+	case w.opts.InferLevels:
+		if w.opts.InferLevelsWithTimestamp {
+			idx := timestampRegexp.FindStringIndex(str)
+			str = str[idx[1]:]
+		}
+		level, str := pickLevel(str)
+		w.logger.Log(level, str)
+	default:
+		w.logger.Info(str)
+	}
+
+	return len(data), nil
+}
+
+// pickLevel detects, based on conventions, what log level the line is.
+func pickLevel(str string) (hclog.Level, string) {
+	switch {
+	case strings.HasPrefix(str, "[DEBUG]"):
+		return hclog.Debug, strings.TrimSpace(str[7:])
+	case strings.HasPrefix(str, "[TRACE]"):
+		return hclog.Trace, strings.TrimSpace(str[7:])
+	case strings.HasPrefix(str, "[INFO]"):
+		return hclog.Info, strings.TrimSpace(str[6:])
+	case strings.HasPrefix(str, "[WARN]"):
+		return hclog.Warn, strings.TrimSpace(str[6:])
+	case strings.HasPrefix(str, "[ERROR]"):
+		return hclog.Error, strings.TrimSpace(str[7:])
+	case strings.HasPrefix(str, "[ERR]"):
+		return hclog.Error, strings.TrimSpace(str[5:])
+	default:
+		return hclog.Info, str
+	}
+}
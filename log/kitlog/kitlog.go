@@ -0,0 +1,214 @@
+package kitlog
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/hashicorp/go-hclog"
+
+	klog "github.com/jkratz55/konsul/log"
+	"github.com/jkratz55/konsul/log/internal/stdlog"
+)
+
+// Wrapper is a type that wraps a go-kit Logger implementing the hclog.Logger
+// interface. Levels are mapped using the go-kit level package.
+//
+// go-kit loggers have no concept of a current level, so the Wrapper filters
+// messages below its own level. The level is shared by every logger derived
+// from the Wrapper and can be changed with SetLevel or SetMinLevel, which are
+// equivalent for the go-kit Wrapper.
+type Wrapper struct {
+	logger kitlog.Logger
+	min    *klog.LevelVar
+	name   string
+	args   []any
+}
+
+// Wrap wraps a go-kit Logger and returns a wrapper that implements the
+// hclog.Logger interface. Messages below the provided level are discarded.
+//
+// If a LevelVar is provided with the klog.WithMinLevel option it is set to the
+// provided level and used to filter messages.
+//
+// A nil logger will cause a panic.
+func Wrap(logger kitlog.Logger, lvl hclog.Level, opts ...klog.Option) hclog.Logger {
+	if logger == nil {
+		panic("cannot wrap nil go-kit log.Logger")
+	}
+	if lvl == hclog.NoLevel {
+		lvl = hclog.Info
+	}
+	o := klog.NewOptions(opts...)
+	o.MinLevel.SetMinLevel(lvl)
+	return Wrapper{
+		logger: logger,
+		min:    o.MinLevel,
+		name:   "",
+	}
+}
+
+func (w Wrapper) Log(level hclog.Level, msg string, args ...any) {
+	switch level {
+	case hclog.NoLevel, hclog.Trace:
+		w.Trace(msg, args...)
+	case hclog.Debug:
+		w.Debug(msg, args...)
+	case hclog.Info:
+		w.Info(msg, args...)
+	case hclog.Warn:
+		w.Warn(msg, args...)
+	case hclog.Error:
+		w.Error(msg, args...)
+	}
+}
+
+func (w Wrapper) Trace(msg string, args ...any) {
+	// go-kit doesn't have a Trace level, Debug is the closest level
+	if w.enabled(hclog.Trace) {
+		_ = level.Debug(w.logger).Log(w.keyvals(msg, args)...)
+	}
+}
+
+func (w Wrapper) Debug(msg string, args ...any) {
+	if w.enabled(hclog.Debug) {
+		_ = level.Debug(w.logger).Log(w.keyvals(msg, args)...)
+	}
+}
+
+func (w Wrapper) Info(msg string, args ...any) {
+	if w.enabled(hclog.Info) {
+		_ = level.Info(w.logger).Log(w.keyvals(msg, args)...)
+	}
+}
+
+func (w Wrapper) Warn(msg string, args ...any) {
+	if w.enabled(hclog.Warn) {
+		_ = level.Warn(w.logger).Log(w.keyvals(msg, args)...)
+	}
+}
+
+func (w Wrapper) Error(msg string, args ...any) {
+	if w.enabled(hclog.Error) {
+		_ = level.Error(w.logger).Log(w.keyvals(msg, args)...)
+	}
+}
+
+func (w Wrapper) IsTrace() bool {
+	return w.GetLevel() == hclog.Trace
+}
+
+func (w Wrapper) IsDebug() bool {
+	return w.GetLevel() == hclog.Debug
+}
+
+func (w Wrapper) IsInfo() bool {
+	return w.GetLevel() == hclog.Info
+}
+
+func (w Wrapper) IsWarn() bool {
+	return w.GetLevel() == hclog.Warn
+}
+
+func (w Wrapper) IsError() bool {
+	return w.GetLevel() == hclog.Error
+}
+
+func (w Wrapper) ImpliedArgs() []any {
+	args := make([]any, len(w.args))
+	copy(args, w.args)
+	return args
+}
+
+func (w Wrapper) With(args ...any) hclog.Logger {
+	implied := make([]any, 0, len(w.args)+len(args))
+	implied = append(implied, w.args...)
+	implied = append(implied, args...)
+	return Wrapper{
+		logger: kitlog.With(w.logger, normalize(args)...),
+		min:    w.min,
+		name:   w.name,
+		args:   implied,
+	}
+}
+
+func (w Wrapper) Name() string {
+	return w.name
+}
+
+func (w Wrapper) Named(name string) hclog.Logger {
+	var newName string
+	if w.name != "" {
+		newName = fmt.Sprintf("%s.%s", w.name, name)
+	} else {
+		newName = name
+	}
+	return Wrapper{
+		logger: w.logger,
+		min:    w.min,
+		name:   newName,
+		args:   w.args,
+	}
+}
+
+func (w Wrapper) ResetNamed(name string) hclog.Logger {
+	return Wrapper{
+		logger: w.logger,
+		min:    w.min,
+		name:   name,
+		args:   w.args,
+	}
+}
+
+func (w Wrapper) SetLevel(level hclog.Level) {
+	w.min.SetMinLevel(level)
+}
+
+func (w Wrapper) GetLevel() hclog.Level {
+	return w.min.MinLevel()
+}
+
+func (w Wrapper) SetMinLevel(level hclog.Level) {
+	w.min.SetMinLevel(level)
+}
+
+func (w Wrapper) MinLevel() hclog.Level {
+	return w.min.MinLevel()
+}
+
+func (w Wrapper) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
+	return log.New(w.StandardWriter(opts), "", 0)
+}
+
+// StandardWriter returns an io.Writer that logs each line through the wrapped
+// go-kit Logger. Lines are logged at Info level unless opts specifies
+// InferLevels or ForceLevel.
+func (w Wrapper) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	return stdlog.NewWriter(w, opts)
+}
+
+func (w Wrapper) enabled(level hclog.Level) bool {
+	return w.min.Enabled(level)
+}
+
+func (w Wrapper) keyvals(msg string, args []any) []any {
+	keyvals := make([]any, 0, len(args)+4)
+	keyvals = append(keyvals, "msg", msg)
+	if w.name != "" {
+		keyvals = append(keyvals, "logger", w.name)
+	}
+	return append(keyvals, normalize(args)...)
+}
+
+// normalize ensures the key/value pairs are even in length, since go-kit
+// loggers render a dangling key with a missing value marker.
+func normalize(args []any) []any {
+	if len(args)%2 == 0 {
+		return args
+	}
+	normalized := make([]any, 0, len(args)+1)
+	normalized = append(normalized, args[:len(args)-1]...)
+	return append(normalized, fmt.Sprintf("arg%d", len(args)-1), args[len(args)-1])
+}
@@ -0,0 +1,103 @@
+// Package log holds the abstractions shared by the logging wrappers in the
+// subpackages of log.
+package log
+
+import (
+	"sync/atomic"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// LevelController is implemented by the logging wrappers to control the
+// verbosity of the logs konsul and the Consul API emit at runtime.
+//
+// The minimum level is independent of the level of the wrapped logger. Messages
+// below the minimum level are discarded by the wrapper before they reach the
+// wrapped logger, while messages at or above it are still subject to the level
+// of the wrapped logger. This allows chatty logs such as watch and Instancer
+// refreshes to be silenced without changing the level of the application's
+// logger. The minimum level is shared by all loggers derived from a wrapper
+// through With, Named, and ResetNamed.
+type LevelController interface {
+	// SetMinLevel sets the minimum level of messages passed to the wrapped
+	// logger. hclog.NoLevel disables filtering.
+	SetMinLevel(level hclog.Level)
+	// MinLevel returns the current minimum level.
+	MinLevel() hclog.Level
+}
+
+// SetMinLevel sets the minimum level of the provided logger if it implements
+// LevelController. It returns false if the logger doesn't support controlling
+// the level.
+func SetMinLevel(logger hclog.Logger, level hclog.Level) bool {
+	lc, ok := logger.(LevelController)
+	if !ok {
+		return false
+	}
+	lc.SetMinLevel(level)
+	return true
+}
+
+// LevelVar is a LevelController that is safe for concurrent use. The zero-value
+// of LevelVar is hclog.NoLevel which allows all messages.
+//
+// A single LevelVar can be shared between multiple wrappers to control the
+// verbosity of all of them at once.
+type LevelVar struct {
+	level atomic.Int32
+}
+
+// NewLevelVar creates a LevelVar initialized to the provided level.
+func NewLevelVar(level hclog.Level) *LevelVar {
+	lv := &LevelVar{}
+	lv.SetMinLevel(level)
+	return lv
+}
+
+// SetMinLevel sets the minimum level.
+func (lv *LevelVar) SetMinLevel(level hclog.Level) {
+	lv.level.Store(int32(level))
+}
+
+// MinLevel returns the minimum level.
+func (lv *LevelVar) MinLevel() hclog.Level {
+	return hclog.Level(lv.level.Load())
+}
+
+// Enabled reports whether a message at the provided level should be passed to
+// the wrapped logger.
+func (lv *LevelVar) Enabled(level hclog.Level) bool {
+	return level >= lv.MinLevel()
+}
+
+// Options holds the configuration shared by the logging wrappers.
+type Options struct {
+	// The LevelVar backing the LevelController implementation of a wrapper. If
+	// not provided each wrapper gets its own LevelVar allowing all messages.
+	MinLevel *LevelVar
+}
+
+// Option configures optional behavior of a logging wrapper.
+type Option func(*Options)
+
+// WithMinLevel configures a wrapper to use the provided LevelVar to filter
+// messages. Passing the same LevelVar to multiple wrappers allows controlling
+// the verbosity of all of them at once.
+func WithMinLevel(lv *LevelVar) Option {
+	return func(o *Options) {
+		o.MinLevel = lv
+	}
+}
+
+// NewOptions applies the provided Option values and fills in defaults for any
+// fields left unset. It is intended to be used by the logging wrappers.
+func NewOptions(opts ...Option) Options {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.MinLevel == nil {
+		o.MinLevel = &LevelVar{}
+	}
+	return o
+}
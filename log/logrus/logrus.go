@@ -0,0 +1,249 @@
+package logrus
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/sirupsen/logrus"
+
+	klog "github.com/jkratz55/konsul/log"
+	"github.com/jkratz55/konsul/log/internal/stdlog"
+)
+
+// Wrapper is a type that wraps a logrus Entry implementing the hclog.Logger
+// interface.
+type Wrapper struct {
+	entry *logrus.Entry
+	min   *klog.LevelVar
+	name  string
+}
+
+// Wrap wraps a logrus Logger and returns a wrapper that implements the
+// hclog.Logger interface.
+//
+// A nil logger will cause a panic.
+func Wrap(logger *logrus.Logger, opts ...klog.Option) hclog.Logger {
+	if logger == nil {
+		panic("cannot wrap nil logrus.Logger")
+	}
+	o := klog.NewOptions(opts...)
+	return Wrapper{
+		entry: logrus.NewEntry(logger),
+		min:   o.MinLevel,
+		name:  "",
+	}
+}
+
+// WrapEntry wraps a logrus Entry and returns a wrapper that implements the
+// hclog.Logger interface. Any fields already present on the Entry are included
+// in every log message.
+//
+// A nil entry will cause a panic.
+func WrapEntry(entry *logrus.Entry, opts ...klog.Option) hclog.Logger {
+	if entry == nil {
+		panic("cannot wrap nil logrus.Entry")
+	}
+	o := klog.NewOptions(opts...)
+	return Wrapper{
+		entry: entry,
+		min:   o.MinLevel,
+		name:  "",
+	}
+}
+
+func (w Wrapper) Log(level hclog.Level, msg string, args ...any) {
+	switch level {
+	case hclog.NoLevel, hclog.Trace:
+		w.Trace(msg, args...)
+	case hclog.Debug:
+		w.Debug(msg, args...)
+	case hclog.Info:
+		w.Info(msg, args...)
+	case hclog.Warn:
+		w.Warn(msg, args...)
+	case hclog.Error:
+		w.Error(msg, args...)
+	}
+}
+
+func (w Wrapper) Trace(msg string, args ...any) {
+	if w.min.Enabled(hclog.Trace) {
+		w.withFields(args).Trace(msg)
+	}
+}
+
+func (w Wrapper) Debug(msg string, args ...any) {
+	if w.min.Enabled(hclog.Debug) {
+		w.withFields(args).Debug(msg)
+	}
+}
+
+func (w Wrapper) Info(msg string, args ...any) {
+	if w.min.Enabled(hclog.Info) {
+		w.withFields(args).Info(msg)
+	}
+}
+
+func (w Wrapper) Warn(msg string, args ...any) {
+	if w.min.Enabled(hclog.Warn) {
+		w.withFields(args).Warn(msg)
+	}
+}
+
+func (w Wrapper) Error(msg string, args ...any) {
+	if w.min.Enabled(hclog.Error) {
+		w.withFields(args).Error(msg)
+	}
+}
+
+func (w Wrapper) IsTrace() bool {
+	return w.entry.Logger.GetLevel() == logrus.TraceLevel
+}
+
+func (w Wrapper) IsDebug() bool {
+	return w.entry.Logger.GetLevel() == logrus.DebugLevel
+}
+
+func (w Wrapper) IsInfo() bool {
+	return w.entry.Logger.GetLevel() == logrus.InfoLevel
+}
+
+func (w Wrapper) IsWarn() bool {
+	return w.entry.Logger.GetLevel() == logrus.WarnLevel
+}
+
+func (w Wrapper) IsError() bool {
+	return w.entry.Logger.GetLevel() == logrus.ErrorLevel
+}
+
+func (w Wrapper) ImpliedArgs() []any {
+	args := make([]any, 0, len(w.entry.Data)*2)
+	for k, v := range w.entry.Data {
+		args = append(args, k, v)
+	}
+	return args
+}
+
+func (w Wrapper) With(args ...any) hclog.Logger {
+	return Wrapper{
+		entry: w.entry.WithFields(convertArgsToFields(args...)),
+		min:   w.min,
+		name:  w.name,
+	}
+}
+
+func (w Wrapper) Name() string {
+	return w.name
+}
+
+func (w Wrapper) Named(name string) hclog.Logger {
+	var newName string
+	if w.name != "" {
+		newName = fmt.Sprintf("%s.%s", w.name, name)
+	} else {
+		newName = name
+	}
+	return Wrapper{
+		entry: w.entry,
+		min:   w.min,
+		name:  newName,
+	}
+}
+
+func (w Wrapper) ResetNamed(name string) hclog.Logger {
+	return Wrapper{
+		entry: w.entry,
+		min:   w.min,
+		name:  name,
+	}
+}
+
+// SetLevel sets the level of the underlying logrus Logger. Since the level is
+// owned by the logrus Logger this affects every Wrapper and Entry created from
+// the same Logger.
+func (w Wrapper) SetLevel(level hclog.Level) {
+	switch level {
+	case hclog.Trace:
+		w.entry.Logger.SetLevel(logrus.TraceLevel)
+	case hclog.Debug:
+		w.entry.Logger.SetLevel(logrus.DebugLevel)
+	case hclog.Info:
+		w.entry.Logger.SetLevel(logrus.InfoLevel)
+	case hclog.Warn:
+		w.entry.Logger.SetLevel(logrus.WarnLevel)
+	case hclog.Error:
+		w.entry.Logger.SetLevel(logrus.ErrorLevel)
+	}
+}
+
+func (w Wrapper) GetLevel() hclog.Level {
+	switch w.entry.Logger.GetLevel() {
+	case logrus.TraceLevel:
+		return hclog.Trace
+	case logrus.DebugLevel:
+		return hclog.Debug
+	case logrus.InfoLevel:
+		return hclog.Info
+	case logrus.WarnLevel:
+		return hclog.Warn
+	case logrus.ErrorLevel, logrus.FatalLevel, logrus.PanicLevel:
+		// hclog has no concept of Panic or Fatal level so they get mapped to Error
+		return hclog.Error
+	default:
+		return hclog.NoLevel
+	}
+}
+
+// SetMinLevel sets the minimum level of messages passed to logrus without
+// changing the level of the logrus Logger itself.
+func (w Wrapper) SetMinLevel(level hclog.Level) {
+	w.min.SetMinLevel(level)
+}
+
+// MinLevel returns the minimum level of messages passed to logrus.
+func (w Wrapper) MinLevel() hclog.Level {
+	return w.min.MinLevel()
+}
+
+func (w Wrapper) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
+	return log.New(w.StandardWriter(opts), "", 0)
+}
+
+// StandardWriter returns an io.Writer that writes each line through logrus.
+// Lines are logged at Info level unless opts specifies InferLevels, in which
+// case prefixes such as [DEBUG] or [ERR] are parsed to determine the level, or
+// ForceLevel.
+func (w Wrapper) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	return stdlog.NewWriter(w, opts)
+}
+
+func (w Wrapper) withFields(args []any) *logrus.Entry {
+	entry := w.entry
+	if w.name != "" {
+		entry = entry.WithField("logger", w.name)
+	}
+	if len(args) > 0 {
+		entry = entry.WithFields(convertArgsToFields(args...))
+	}
+	return entry
+}
+
+func convertArgsToFields(args ...any) logrus.Fields {
+	fields := make(logrus.Fields, len(args)/2+1)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			fields[fmt.Sprintf("arg%d", i)] = args[i]
+			break
+		}
+		k, ok := args[i].(string)
+		if ok {
+			fields[k] = args[i+1]
+		} else {
+			fields[fmt.Sprintf("arg%d", i)] = args[i]
+			fields[fmt.Sprintf("arg%d", i+1)] = args[i+1]
+		}
+	}
+	return fields
+}
@@ -0,0 +1,272 @@
+// Package redact provides a hclog.Logger decorator that redacts sensitive values
+// such as passwords and tokens before they are logged. Values stored in Consul
+// KV occasionally end up in error messages and debug logs, and the decorator
+// prevents them from leaking into log pipelines.
+package redact
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/jkratz55/konsul/log/internal/stdlog"
+)
+
+// Replacement is the default value sensitive values are replaced with.
+const Replacement = "[REDACTED]"
+
+// DefaultFields are field names that commonly hold sensitive values.
+var DefaultFields = []string{
+	"password",
+	"passwd",
+	"secret",
+	"token",
+	"apikey",
+	"api_key",
+	"authorization",
+	"credentials",
+	"private_key",
+}
+
+// Config holds the configuration properties for redacting log entries.
+type Config struct {
+	// Names of fields whose values are always redacted. Names are matched
+	// ignoring case.
+	Fields []string
+	// Patterns matched against the message and string values of every entry.
+	// Any match is replaced with Replacement, keeping the first capture group
+	// of patterns that have one, such as "password=". Errors, byte slices, and
+	// types implementing fmt.Stringer are converted to strings before matching.
+	Patterns []*regexp.Regexp
+	// The value redacted values are replaced with. If not provided the
+	// Replacement constant is used.
+	Replacement string
+}
+
+// DefaultConfig returns a Config redacting the DefaultFields along with values
+// formatted as key=value or "key": "value" pairs for the same names.
+func DefaultConfig() Config {
+	names := strings.Join(DefaultFields, "|")
+	return Config{
+		Fields: DefaultFields,
+		Patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)((?:` + names + `)\s*[=:]\s*)("[^"]*"|[^\s,;&]+)`),
+			regexp.MustCompile(`(?i)("(?:` + names + `)"\s*:\s*)("[^"]*"|[^\s,}]+)`),
+		},
+	}
+}
+
+// IsZero reports whether the Config doesn't redact anything.
+func (c Config) IsZero() bool {
+	return len(c.Fields) == 0 && len(c.Patterns) == 0
+}
+
+// Wrap decorates the provided hclog.Logger redacting sensitive values based on
+// the provided Config. Loggers derived from the returned hclog.Logger through
+// With, Named, and ResetNamed redact values as well, including the args passed
+// to With.
+//
+// A nil logger will cause a panic.
+func Wrap(logger hclog.Logger, cfg Config) hclog.Logger {
+	if logger == nil {
+		panic("cannot wrap nil hclog.Logger")
+	}
+	if cfg.Replacement == "" {
+		cfg.Replacement = Replacement
+	}
+	return &Logger{
+		Logger: logger,
+		r:      newRedactor(cfg),
+	}
+}
+
+// Logger is a hclog.Logger that redacts sensitive values before passing them to
+// the hclog.Logger it decorates.
+type Logger struct {
+	hclog.Logger
+	r *redactor
+}
+
+func (l *Logger) Log(level hclog.Level, msg string, args ...any) {
+	l.Logger.Log(level, l.r.string(msg), l.r.args(args)...)
+}
+
+func (l *Logger) Trace(msg string, args ...any) {
+	l.Logger.Trace(l.r.string(msg), l.r.args(args)...)
+}
+
+func (l *Logger) Debug(msg string, args ...any) {
+	l.Logger.Debug(l.r.string(msg), l.r.args(args)...)
+}
+
+func (l *Logger) Info(msg string, args ...any) {
+	l.Logger.Info(l.r.string(msg), l.r.args(args)...)
+}
+
+func (l *Logger) Warn(msg string, args ...any) {
+	l.Logger.Warn(l.r.string(msg), l.r.args(args)...)
+}
+
+func (l *Logger) Error(msg string, args ...any) {
+	l.Logger.Error(l.r.string(msg), l.r.args(args)...)
+}
+
+func (l *Logger) With(args ...any) hclog.Logger {
+	return &Logger{
+		Logger: l.Logger.With(l.r.args(args)...),
+		r:      l.r,
+	}
+}
+
+func (l *Logger) Named(name string) hclog.Logger {
+	return &Logger{
+		Logger: l.Logger.Named(name),
+		r:      l.r,
+	}
+}
+
+func (l *Logger) ResetNamed(name string) hclog.Logger {
+	return &Logger{
+		Logger: l.Logger.ResetNamed(name),
+		r:      l.r,
+	}
+}
+
+// ImpliedArgs returns the key/value pairs passed to With on the wrapped logger
+// with sensitive values redacted.
+func (l *Logger) ImpliedArgs() []any {
+	return l.r.args(l.Logger.ImpliedArgs())
+}
+
+// StandardLogger returns a standard library log Logger whose output is redacted
+// before being passed to the wrapped logger. See StandardWriter.
+func (l *Logger) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
+	return log.New(l.StandardWriter(opts), "", 0)
+}
+
+// StandardWriter returns an io.Writer logging each line through Log, so the
+// line is redacted before being passed to the wrapped logger. Levels are
+// determined from opts like hclog does.
+func (l *Logger) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	return stdlog.NewWriter(l, opts)
+}
+
+// Value redacts sensitive values from v based on the provided Config. Maps and
+// slices, such as the result of unmarshalling JSON into an any, are walked
+// recursively redacting the values of map keys matching Fields and string
+// values matching Patterns. A redacted copy is returned and v isn't modified.
+func Value(cfg Config, v any) any {
+	if cfg.Replacement == "" {
+		cfg.Replacement = Replacement
+	}
+	return newRedactor(cfg).walk(v)
+}
+
+func newRedactor(cfg Config) *redactor {
+	fields := make(map[string]struct{}, len(cfg.Fields))
+	for _, f := range cfg.Fields {
+		fields[strings.ToLower(f)] = struct{}{}
+	}
+	return &redactor{
+		fields:      fields,
+		patterns:    cfg.Patterns,
+		replacement: cfg.Replacement,
+	}
+}
+
+type redactor struct {
+	fields      map[string]struct{}
+	patterns    []*regexp.Regexp
+	replacement string
+}
+
+// args returns a copy of the key/value pairs with sensitive values redacted.
+// The provided slice is never modified since callers may reuse it.
+func (r *redactor) args(args []any) []any {
+	if len(args) == 0 {
+		return args
+	}
+	redacted := make([]any, len(args))
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			redacted[i] = r.value(args[i])
+			break
+		}
+		redacted[i] = args[i]
+		if key, ok := args[i].(string); ok {
+			if _, sensitive := r.fields[strings.ToLower(key)]; sensitive {
+				redacted[i+1] = r.replacement
+				continue
+			}
+		}
+		redacted[i+1] = r.value(args[i+1])
+	}
+	return redacted
+}
+
+// value redacts a single value. Values that don't match any pattern are
+// returned as is so the wrapped logger can format them as usual.
+func (r *redactor) value(v any) any {
+	if len(r.patterns) == 0 {
+		return v
+	}
+	var s string
+	switch val := v.(type) {
+	case string:
+		s = val
+	case []byte:
+		s = string(val)
+	case error:
+		s = val.Error()
+	case fmt.Stringer:
+		s = val.String()
+	default:
+		return v
+	}
+	if redacted := r.string(s); redacted != s {
+		return redacted
+	}
+	return v
+}
+
+func (r *redactor) walk(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		redacted := make(map[string]any, len(val))
+		for k, child := range val {
+			if _, sensitive := r.fields[strings.ToLower(k)]; sensitive {
+				redacted[k] = r.replacement
+				continue
+			}
+			redacted[k] = r.walk(child)
+		}
+		return redacted
+	case []any:
+		redacted := make([]any, len(val))
+		for i, child := range val {
+			redacted[i] = r.walk(child)
+		}
+		return redacted
+	default:
+		return r.value(v)
+	}
+}
+
+func (r *redactor) string(s string) string {
+	for _, p := range r.patterns {
+		s = p.ReplaceAllStringFunc(s, func(match string) string {
+			// When the pattern has capture groups, the first group is
+			// considered a prefix to keep (such as "password=").
+			sub := p.FindStringSubmatch(match)
+			if len(sub) > 1 {
+				return sub[1] + r.replacement
+			}
+			return r.replacement
+		})
+	}
+	return s
+}
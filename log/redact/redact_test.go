@@ -0,0 +1,62 @@
+package redact
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestValue_Patterns(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		in      string
+		want    string
+	}{
+		{"no group", `token=\S+`, "auth token=abc123 ok", "auth [REDACTED] ok"},
+		{"one group", `(token=)\S+`, "auth token=abc123 ok", "auth token=[REDACTED] ok"},
+		{"two groups", `(token=)(\S+)`, "auth token=abc123 ok", "auth token=[REDACTED] ok"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Patterns: []*regexp.Regexp{regexp.MustCompile(tt.pattern)}}
+			if got := Value(cfg, tt.in); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValue_DefaultConfig(t *testing.T) {
+	got := Value(DefaultConfig(), map[string]any{
+		"password": "hunter2",
+		"dsn":      "postgres://db?password=hunter2&sslmode=disable",
+	})
+	want := map[string]any{
+		"password": Replacement,
+		"dsn":      "postgres://db?password=" + Replacement + "&sslmode=disable",
+	}
+	m := got.(map[string]any)
+	for k, v := range want {
+		if m[k] != v {
+			t.Errorf("expected %s to be %q, got %q", k, v, m[k])
+		}
+	}
+}
+
+func TestLogger_StandardLoggerRedacts(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := Config{Fields: []string{"password"}, Patterns: []*regexp.Regexp{regexp.MustCompile(`(token=)\S+`)}}
+	logger := Wrap(hclog.New(&hclog.LoggerOptions{Output: &buf}), cfg).With("password", "hunter2")
+
+	logger.StandardLogger(nil).Print("auth token=abc123")
+	if out := buf.String(); strings.Contains(out, "abc123") || !strings.Contains(out, "token=[REDACTED]") {
+		t.Errorf("expected the standard logger output to be redacted, got %q", out)
+	}
+	if args := logger.ImpliedArgs(); len(args) != 2 || args[1] != Replacement {
+		t.Errorf("expected the implied args to be redacted, got %v", args)
+	}
+}
@@ -0,0 +1,224 @@
+// Package sampling provides a hclog.Logger decorator that samples repetitive
+// messages. Consul churn can cause the same messages, such as Instancer
+// refreshes or watch retry errors, to be logged over and over which can flood
+// log pipelines.
+package sampling
+
+import (
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/jkratz55/konsul"
+	"github.com/jkratz55/konsul/log/internal/stdlog"
+)
+
+// Config holds the configuration properties for sampling messages.
+//
+// Messages are identified by their level, logger name, and message. Within
+// each Interval the First messages with the same identity are logged, after
+// which only every Thereafter-th message is logged. When a message is logged
+// after others with the same identity were dropped, the number of dropped
+// messages is included as the "suppressed" field.
+type Config struct {
+	// The window in which messages are counted. If not provided a default of
+	// one minute is used.
+	Interval time.Duration
+	// The number of messages with the same identity logged in each Interval
+	// before sampling kicks in. If not provided a default of 1 is used.
+	First int
+	// After First messages, only every Thereafter-th message is logged. If
+	// zero all messages after First are dropped for the rest of the Interval.
+	Thereafter int
+	// Messages at this level or higher are never sampled. If not provided all
+	// levels are sampled.
+	ExemptLevel hclog.Level
+	// The Clock used to determine the Interval messages are counted in. If not
+	// provided konsul.SystemClock is used.
+	Clock konsul.Clock
+}
+
+// Wrap decorates the provided hclog.Logger sampling repetitive messages based on
+// the provided Config. Loggers derived from the returned hclog.Logger through
+// With, Named, and ResetNamed share the same sampling state.
+//
+// A nil logger will cause a panic.
+func Wrap(logger hclog.Logger, cfg Config) hclog.Logger {
+	if logger == nil {
+		panic("cannot wrap nil hclog.Logger")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+	if cfg.First <= 0 {
+		cfg.First = 1
+	}
+	cfg.Clock = konsul.ClockOrSystem(cfg.Clock)
+	return &Logger{
+		Logger:  logger,
+		sampler: newSampler(cfg),
+	}
+}
+
+// Logger is a hclog.Logger that samples repetitive messages before passing them
+// to the hclog.Logger it decorates.
+type Logger struct {
+	hclog.Logger
+	sampler *sampler
+}
+
+func (l *Logger) Log(level hclog.Level, msg string, args ...any) {
+	if args, ok := l.sampler.sample(level, l.Logger.Name(), msg, args); ok {
+		l.Logger.Log(level, msg, args...)
+	}
+}
+
+func (l *Logger) Trace(msg string, args ...any) {
+	if args, ok := l.sampler.sample(hclog.Trace, l.Logger.Name(), msg, args); ok {
+		l.Logger.Trace(msg, args...)
+	}
+}
+
+func (l *Logger) Debug(msg string, args ...any) {
+	if args, ok := l.sampler.sample(hclog.Debug, l.Logger.Name(), msg, args); ok {
+		l.Logger.Debug(msg, args...)
+	}
+}
+
+func (l *Logger) Info(msg string, args ...any) {
+	if args, ok := l.sampler.sample(hclog.Info, l.Logger.Name(), msg, args); ok {
+		l.Logger.Info(msg, args...)
+	}
+}
+
+func (l *Logger) Warn(msg string, args ...any) {
+	if args, ok := l.sampler.sample(hclog.Warn, l.Logger.Name(), msg, args); ok {
+		l.Logger.Warn(msg, args...)
+	}
+}
+
+func (l *Logger) Error(msg string, args ...any) {
+	if args, ok := l.sampler.sample(hclog.Error, l.Logger.Name(), msg, args); ok {
+		l.Logger.Error(msg, args...)
+	}
+}
+
+func (l *Logger) With(args ...any) hclog.Logger {
+	return &Logger{
+		Logger:  l.Logger.With(args...),
+		sampler: l.sampler,
+	}
+}
+
+func (l *Logger) Named(name string) hclog.Logger {
+	return &Logger{
+		Logger:  l.Logger.Named(name),
+		sampler: l.sampler,
+	}
+}
+
+func (l *Logger) ResetNamed(name string) hclog.Logger {
+	return &Logger{
+		Logger:  l.Logger.ResetNamed(name),
+		sampler: l.sampler,
+	}
+}
+
+// StandardLogger returns a standard library log Logger whose output is sampled
+// before being passed to the wrapped logger. See StandardWriter.
+func (l *Logger) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
+	return log.New(l.StandardWriter(opts), "", 0)
+}
+
+// StandardWriter returns an io.Writer logging each line through Log, so lines
+// are sampled like other messages. Levels are determined from opts like hclog
+// does.
+func (l *Logger) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	return stdlog.NewWriter(l, opts)
+}
+
+type key struct {
+	level hclog.Level
+	name  string
+	msg   string
+}
+
+type counter struct {
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+type sampler struct {
+	cfg      Config
+	mu       sync.Mutex
+	counters map[key]*counter
+	lastGC   time.Time
+}
+
+func newSampler(cfg Config) *sampler {
+	return &sampler{
+		cfg:      cfg,
+		counters: make(map[key]*counter),
+		lastGC:   cfg.Clock.Now(),
+	}
+}
+
+// sample determines if a message should be logged. If the message should be
+// logged the args to log it with are returned, which include the number of
+// suppressed messages if any were dropped.
+func (s *sampler) sample(level hclog.Level, name, msg string, args []any) ([]any, bool) {
+	if s.cfg.ExemptLevel != hclog.NoLevel && level >= s.cfg.ExemptLevel {
+		return args, true
+	}
+
+	now := s.cfg.Clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.gc(now)
+
+	k := key{level: level, name: name, msg: msg}
+	c, ok := s.counters[k]
+	if !ok {
+		c = &counter{start: now}
+		s.counters[k] = c
+	}
+	if now.Sub(c.start) >= s.cfg.Interval {
+		c.start = now
+		c.count = 0
+	}
+	c.count++
+
+	allowed := c.count <= s.cfg.First ||
+		(s.cfg.Thereafter > 0 && (c.count-s.cfg.First)%s.cfg.Thereafter == 0)
+	if !allowed {
+		c.suppressed++
+		return nil, false
+	}
+	if c.suppressed > 0 {
+		withCount := make([]any, 0, len(args)+2)
+		withCount = append(withCount, args...)
+		withCount = append(withCount, "suppressed", c.suppressed)
+		args = withCount
+		c.suppressed = 0
+	}
+	return args, true
+}
+
+// gc removes counters that haven't seen a message in a while so that messages
+// that are only logged once don't accumulate forever.
+func (s *sampler) gc(now time.Time) {
+	if now.Sub(s.lastGC) < s.cfg.Interval*10 {
+		return
+	}
+	for k, c := range s.counters {
+		if now.Sub(c.start) >= s.cfg.Interval && c.suppressed == 0 {
+			delete(s.counters, k)
+		}
+	}
+	s.lastGC = now
+}
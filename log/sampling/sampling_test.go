@@ -0,0 +1,47 @@
+package sampling
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/jkratz55/konsul/konsultest"
+	"github.com/jkratz55/konsul/log/testlog"
+)
+
+func TestWrap_SamplesWithinInterval(t *testing.T) {
+	clock := konsultest.NewClock(time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC))
+	recorder := testlog.New()
+	logger := Wrap(recorder, Config{Interval: time.Minute, First: 2, Clock: clock})
+
+	for i := 0; i < 5; i++ {
+		logger.Info("refreshed instances")
+	}
+	recorder.AssertCount(t, hclog.Info, 2)
+
+	// The Interval elapses only once the Clock moves past it.
+	clock.Advance(59 * time.Second)
+	logger.Info("refreshed instances")
+	recorder.AssertCount(t, hclog.Info, 2)
+
+	clock.Advance(time.Second)
+	logger.Info("refreshed instances")
+	recorder.AssertCount(t, hclog.Info, 3)
+	entry := recorder.Entries()[2]
+	if got := entry.Fields["suppressed"]; got != 4 {
+		t.Errorf("expected 4 suppressed messages, got %v", got)
+	}
+}
+
+func TestLogger_StandardLoggerSamples(t *testing.T) {
+	clock := konsultest.NewClock(time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC))
+	recorder := testlog.New()
+	logger := Wrap(recorder, Config{Interval: time.Minute, First: 2, Clock: clock})
+
+	std := logger.StandardLogger(nil)
+	for i := 0; i < 5; i++ {
+		std.Print("refreshed instances")
+	}
+	recorder.AssertCount(t, hclog.Info, 2)
+}
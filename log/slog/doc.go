@@ -0,0 +1,4 @@
+// Package slog wraps a log/slog Logger to adapt it to the hclog.Logger
+// interface. It requires Go 1.21 or later since log/slog isn't available in
+// earlier releases.
+package slog
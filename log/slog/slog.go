@@ -0,0 +1,248 @@
+//go:build go1.21
+
+package slog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	klog "github.com/jkratz55/konsul/log"
+	"github.com/jkratz55/konsul/log/internal/stdlog"
+)
+
+// Wrapper is a type that wraps a slog Logger and adapts it to a hclog.Logger.
+type Wrapper struct {
+	logger *slog.Logger
+	level  *slog.LevelVar
+	min    *klog.LevelVar
+	name   string
+}
+
+// Wrap accepts a slog Logger and wraps it to adapt to a hclog.Logger.
+//
+// A nil logger will cause a panic.
+func Wrap(logger *slog.Logger, opts ...klog.Option) hclog.Logger {
+	if logger == nil {
+		panic("cannot wrap nil slog.Logger")
+	}
+	o := klog.NewOptions(opts...)
+	return Wrapper{
+		logger: logger,
+		min:    o.MinLevel,
+		name:   "",
+	}
+}
+
+// WrapLevelVar accepts a slog Logger and the LevelVar controlling it and wraps
+// it to adapt to a hclog.Logger. Unlike Wrap, calling SetLevel on the returned
+// hclog.Logger changes the level of the LevelVar so the levels of hclog and
+// slog are always in sync.
+//
+// The logger should be built with a Handler using the provided LevelVar, for
+// example via the Level field of slog.HandlerOptions, otherwise SetLevel will
+// have no effect on it.
+//
+// A nil logger or LevelVar will cause a panic.
+func WrapLevelVar(logger *slog.Logger, level *slog.LevelVar, opts ...klog.Option) hclog.Logger {
+	if logger == nil {
+		panic("cannot wrap nil slog.Logger")
+	}
+	if level == nil {
+		panic("cannot wrap with nil slog.LevelVar")
+	}
+	o := klog.NewOptions(opts...)
+	return Wrapper{
+		logger: logger,
+		level:  level,
+		min:    o.MinLevel,
+		name:   "",
+	}
+}
+
+func (w Wrapper) Log(level hclog.Level, msg string, args ...any) {
+	w.log(level, msg, args)
+}
+
+func (w Wrapper) Trace(msg string, args ...any) {
+	w.log(hclog.Trace, msg, args)
+}
+
+func (w Wrapper) Debug(msg string, args ...any) {
+	w.log(hclog.Debug, msg, args)
+}
+
+func (w Wrapper) Info(msg string, args ...any) {
+	w.log(hclog.Info, msg, args)
+}
+
+func (w Wrapper) Warn(msg string, args ...any) {
+	w.log(hclog.Warn, msg, args)
+}
+
+func (w Wrapper) Error(msg string, args ...any) {
+	w.log(hclog.Error, msg, args)
+}
+
+// log emits the message through the Handler of the slog Logger, recording the
+// caller of the Wrapper method as the source of the message.
+func (w Wrapper) log(level hclog.Level, msg string, args []any) {
+	if level == hclog.NoLevel {
+		level = hclog.Trace
+	}
+	if !w.min.Enabled(level) {
+		return
+	}
+	ctx := context.Background()
+	lvl := toSlog(level)
+	if !w.logger.Enabled(ctx, lvl) {
+		return
+	}
+	var pcs [1]uintptr
+	// Skip runtime.Callers, log, and the Wrapper method calling log.
+	runtime.Callers(3, pcs[:])
+	r := slog.NewRecord(time.Now(), lvl, msg, pcs[0])
+	if w.name != "" {
+		r.AddAttrs(slog.String("logger", w.name))
+	}
+	r.Add(args...)
+	_ = w.logger.Handler().Handle(ctx, r)
+}
+
+func (w Wrapper) IsTrace() bool {
+	return w.enabled(hclog.Trace)
+}
+
+func (w Wrapper) IsDebug() bool {
+	return w.enabled(hclog.Debug)
+}
+
+func (w Wrapper) IsInfo() bool {
+	return w.enabled(hclog.Info)
+}
+
+func (w Wrapper) IsWarn() bool {
+	return w.enabled(hclog.Warn)
+}
+
+func (w Wrapper) IsError() bool {
+	return w.enabled(hclog.Error)
+}
+
+// enabled reports whether messages at the provided level would be emitted.
+func (w Wrapper) enabled(level hclog.Level) bool {
+	return w.min.Enabled(level) && w.logger.Enabled(context.Background(), toSlog(level))
+}
+
+func (w Wrapper) ImpliedArgs() []any {
+	return []any{}
+}
+
+func (w Wrapper) With(args ...any) hclog.Logger {
+	return Wrapper{
+		logger: w.logger.With(args...),
+		level:  w.level,
+		min:    w.min,
+		name:   w.name,
+	}
+}
+
+func (w Wrapper) Name() string {
+	return w.name
+}
+
+func (w Wrapper) Named(name string) hclog.Logger {
+	var newName string
+	if w.name != "" {
+		newName = fmt.Sprintf("%s.%s", w.name, name)
+	} else {
+		newName = name
+	}
+	return Wrapper{
+		logger: w.logger,
+		level:  w.level,
+		min:    w.min,
+		name:   newName,
+	}
+}
+
+func (w Wrapper) ResetNamed(name string) hclog.Logger {
+	return Wrapper{
+		logger: w.logger,
+		level:  w.level,
+		min:    w.min,
+		name:   name,
+	}
+}
+
+// SetLevel changes the level of the LevelVar the Wrapper was created with
+// using WrapLevelVar. If the Wrapper was created with Wrap there is no way to
+// change the level of the slog Logger and SetLevel is a no-op.
+func (w Wrapper) SetLevel(level hclog.Level) {
+	if w.level == nil {
+		w.logger.Warn("SetLevel on Wrapper is a no-op, use WrapLevelVar to support changing levels")
+		return
+	}
+	if level == hclog.Off {
+		w.level.Set(slog.LevelError + 1)
+		return
+	}
+	w.level.Set(toSlog(level))
+}
+
+// GetLevel returns the most verbose level enabled by the slog Logger.
+func (w Wrapper) GetLevel() hclog.Level {
+	ctx := context.Background()
+	for _, level := range []hclog.Level{hclog.Debug, hclog.Info, hclog.Warn, hclog.Error} {
+		if w.logger.Enabled(ctx, toSlog(level)) {
+			return level
+		}
+	}
+	return hclog.Off
+}
+
+// SetMinLevel sets the minimum level of messages passed to the slog Logger
+// without changing the level of the slog Logger itself.
+func (w Wrapper) SetMinLevel(level hclog.Level) {
+	w.min.SetMinLevel(level)
+}
+
+// MinLevel returns the minimum level of messages passed to the slog Logger.
+func (w Wrapper) MinLevel() hclog.Level {
+	return w.min.MinLevel()
+}
+
+// StandardLogger returns a standard library log Logger that writes through the
+// wrapped slog Logger. See StandardWriter for how levels are determined.
+func (w Wrapper) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
+	return log.New(w.StandardWriter(opts), "", 0)
+}
+
+// StandardWriter returns an io.Writer that forwards each line into the wrapped
+// slog Logger. Lines are logged at Info level unless opts specifies
+// InferLevels, in which case prefixes such as [DEBUG] or [ERR] are parsed to
+// determine the level, or ForceLevel.
+func (w Wrapper) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	return stdlog.NewWriter(w, opts)
+}
+
+// toSlog maps the hclog level to the slog level. slog doesn't have a Trace
+// level so it gets mapped to Debug.
+func toSlog(level hclog.Level) slog.Level {
+	switch level {
+	case hclog.Info:
+		return slog.LevelInfo
+	case hclog.Warn:
+		return slog.LevelWarn
+	case hclog.Error:
+		return slog.LevelError
+	default:
+		return slog.LevelDebug
+	}
+}
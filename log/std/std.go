@@ -0,0 +1,57 @@
+package std
+
+import (
+	"log"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Wrap wraps a standard library log Logger and returns a hclog.Logger that
+// writes to it. Each message is written as a single line prefixed with its
+// level, followed by the message and any key/value pairs. Messages below the
+// provided level are discarded.
+//
+//	2023/02/14 10:32:01 [INFO]  konsul.instancer: Instances refreshed: service=db
+//
+// The flags and prefix of the log Logger are respected. Since hclog.Logger
+// supports changing the level at runtime, SetLevel on the returned logger works
+// as expected.
+//
+// A nil logger will cause a panic.
+func Wrap(logger *log.Logger, level hclog.Level) hclog.Logger {
+	if logger == nil {
+		panic("cannot wrap nil log.Logger")
+	}
+	if level == hclog.NoLevel {
+		level = hclog.Info
+	}
+	return hclog.FromStandardLogger(logger, &hclog.LoggerOptions{
+		Level: level,
+	})
+}
+
+// WrapWithLevelString is like Wrap but accepts the level as a string such as
+// "debug" or "WARN". This is convenient for small tools where the level comes
+// from a flag or an environment variable. Unrecognized values default to Info.
+func WrapWithLevelString(logger *log.Logger, level string) hclog.Logger {
+	return Wrap(logger, ParseLevel(level))
+}
+
+// ParseLevel parses a level name (trace, debug, info, warn, error) into a
+// hclog.Level ignoring case and surrounding whitespace. Unrecognized values
+// default to Info.
+func ParseLevel(level string) hclog.Level {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "trace":
+		return hclog.Trace
+	case "debug":
+		return hclog.Debug
+	case "warn", "warning":
+		return hclog.Warn
+	case "error", "err":
+		return hclog.Error
+	default:
+		return hclog.Info
+	}
+}
@@ -0,0 +1,293 @@
+// Package testlog provides a hclog.Logger that records log entries in memory so
+// tests can verify konsul emitted the expected warnings and errors.
+package testlog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Entry is a single log entry recorded by Logger.
+type Entry struct {
+	Level   hclog.Level
+	Name    string
+	Message string
+	// The key/value pairs of the entry including any implied args from With.
+	Fields map[string]any
+}
+
+// String returns a human readable representation of the Entry used in test
+// failure messages.
+func (e Entry) String() string {
+	return fmt.Sprintf("[%s] %s: %s %v", e.Level, e.Name, e.Message, e.Fields)
+}
+
+// Logger is a hclog.Logger that records every entry at or above its level.
+// Loggers derived through With, Named, and ResetNamed record into the same
+// set of entries so a single Logger can be handed to konsul and inspected
+// afterwards.
+//
+// Logger is safe for concurrent use.
+type Logger struct {
+	rec  *recorder
+	name string
+	args []any
+}
+
+// New creates a Logger that records entries at all levels.
+func New() *Logger {
+	return &Logger{
+		rec: &recorder{level: hclog.Trace},
+	}
+}
+
+type recorder struct {
+	mu      sync.Mutex
+	level   hclog.Level
+	entries []Entry
+}
+
+// Entries returns a copy of all the recorded entries in the order they were
+// logged.
+func (l *Logger) Entries() []Entry {
+	l.rec.mu.Lock()
+	defer l.rec.mu.Unlock()
+	entries := make([]Entry, len(l.rec.entries))
+	copy(entries, l.rec.entries)
+	return entries
+}
+
+// EntriesAt returns the recorded entries at the provided level.
+func (l *Logger) EntriesAt(level hclog.Level) []Entry {
+	entries := make([]Entry, 0)
+	for _, e := range l.Entries() {
+		if e.Level == level {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Find returns the recorded entries at the provided level whose message
+// contains substr.
+func (l *Logger) Find(level hclog.Level, substr string) []Entry {
+	entries := make([]Entry, 0)
+	for _, e := range l.EntriesAt(level) {
+		if strings.Contains(e.Message, substr) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Reset discards all recorded entries.
+func (l *Logger) Reset() {
+	l.rec.mu.Lock()
+	defer l.rec.mu.Unlock()
+	l.rec.entries = nil
+}
+
+// AssertLogged fails the test if no entry was recorded at the provided level
+// with a message containing substr. The first matching Entry is returned so
+// its fields can be inspected further.
+func (l *Logger) AssertLogged(t testing.TB, level hclog.Level, substr string) Entry {
+	t.Helper()
+	entries := l.Find(level, substr)
+	if len(entries) == 0 {
+		t.Errorf("expected a %s entry containing %q but none was logged, recorded entries: %v",
+			level, substr, l.Entries())
+		return Entry{}
+	}
+	return entries[0]
+}
+
+// AssertNotLogged fails the test if any entry was recorded at the provided
+// level with a message containing substr.
+func (l *Logger) AssertNotLogged(t testing.TB, level hclog.Level, substr string) {
+	t.Helper()
+	if entries := l.Find(level, substr); len(entries) > 0 {
+		t.Errorf("expected no %s entry containing %q but got %v", level, substr, entries)
+	}
+}
+
+// AssertCount fails the test if the number of entries recorded at the provided
+// level doesn't equal n.
+func (l *Logger) AssertCount(t testing.TB, level hclog.Level, n int) {
+	t.Helper()
+	if entries := l.EntriesAt(level); len(entries) != n {
+		t.Errorf("expected %d %s entries but got %d: %v", n, level, len(entries), entries)
+	}
+}
+
+// AssertNoErrors fails the test if any entry was recorded at Warn level or
+// above.
+func (l *Logger) AssertNoErrors(t testing.TB) {
+	t.Helper()
+	for _, e := range l.Entries() {
+		if e.Level >= hclog.Warn {
+			t.Errorf("expected no warnings or errors but got %s", e)
+		}
+	}
+}
+
+func (l *Logger) Log(level hclog.Level, msg string, args ...any) {
+	if level == hclog.NoLevel {
+		level = hclog.Trace
+	}
+	l.rec.mu.Lock()
+	defer l.rec.mu.Unlock()
+	if level < l.rec.level {
+		return
+	}
+	l.rec.entries = append(l.rec.entries, Entry{
+		Level:   level,
+		Name:    l.name,
+		Message: msg,
+		Fields:  fields(l.args, args),
+	})
+}
+
+func (l *Logger) Trace(msg string, args ...any) {
+	l.Log(hclog.Trace, msg, args...)
+}
+
+func (l *Logger) Debug(msg string, args ...any) {
+	l.Log(hclog.Debug, msg, args...)
+}
+
+func (l *Logger) Info(msg string, args ...any) {
+	l.Log(hclog.Info, msg, args...)
+}
+
+func (l *Logger) Warn(msg string, args ...any) {
+	l.Log(hclog.Warn, msg, args...)
+}
+
+func (l *Logger) Error(msg string, args ...any) {
+	l.Log(hclog.Error, msg, args...)
+}
+
+func (l *Logger) IsTrace() bool {
+	return l.GetLevel() == hclog.Trace
+}
+
+func (l *Logger) IsDebug() bool {
+	return l.GetLevel() == hclog.Debug
+}
+
+func (l *Logger) IsInfo() bool {
+	return l.GetLevel() == hclog.Info
+}
+
+func (l *Logger) IsWarn() bool {
+	return l.GetLevel() == hclog.Warn
+}
+
+func (l *Logger) IsError() bool {
+	return l.GetLevel() == hclog.Error
+}
+
+func (l *Logger) ImpliedArgs() []any {
+	args := make([]any, len(l.args))
+	copy(args, l.args)
+	return args
+}
+
+func (l *Logger) With(args ...any) hclog.Logger {
+	implied := make([]any, 0, len(l.args)+len(args))
+	implied = append(implied, l.args...)
+	implied = append(implied, args...)
+	return &Logger{
+		rec:  l.rec,
+		name: l.name,
+		args: implied,
+	}
+}
+
+func (l *Logger) Name() string {
+	return l.name
+}
+
+func (l *Logger) Named(name string) hclog.Logger {
+	newName := name
+	if l.name != "" {
+		newName = fmt.Sprintf("%s.%s", l.name, name)
+	}
+	return &Logger{
+		rec:  l.rec,
+		name: newName,
+		args: l.args,
+	}
+}
+
+func (l *Logger) ResetNamed(name string) hclog.Logger {
+	return &Logger{
+		rec:  l.rec,
+		name: name,
+		args: l.args,
+	}
+}
+
+// SetLevel sets the minimum level of entries recorded. The level is shared by
+// all loggers derived from the same Logger.
+func (l *Logger) SetLevel(level hclog.Level) {
+	l.rec.mu.Lock()
+	defer l.rec.mu.Unlock()
+	l.rec.level = level
+}
+
+func (l *Logger) GetLevel() hclog.Level {
+	l.rec.mu.Lock()
+	defer l.rec.mu.Unlock()
+	return l.rec.level
+}
+
+func (l *Logger) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
+	return log.New(l.StandardWriter(opts), "", 0)
+}
+
+// StandardWriter returns an io.Writer that records each line at Info level, or
+// the level specified by opts.ForceLevel.
+func (l *Logger) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	level := hclog.Info
+	if opts != nil && opts.ForceLevel != hclog.NoLevel {
+		level = opts.ForceLevel
+	}
+	return writer{logger: l, level: level}
+}
+
+type writer struct {
+	logger *Logger
+	level  hclog.Level
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	w.logger.Log(w.level, string(bytes.TrimRight(p, " \t\n")))
+	return len(p), nil
+}
+
+func fields(implied, args []any) map[string]any {
+	all := make([]any, 0, len(implied)+len(args))
+	all = append(all, implied...)
+	all = append(all, args...)
+	f := make(map[string]any, len(all)/2)
+	for i := 0; i < len(all); i += 2 {
+		if i+1 >= len(all) {
+			f[fmt.Sprintf("arg%d", i)] = all[i]
+			break
+		}
+		k, ok := all[i].(string)
+		if !ok {
+			k = fmt.Sprint(all[i])
+		}
+		f[k] = all[i+1]
+	}
+	return f
+}
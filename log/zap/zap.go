@@ -8,27 +8,72 @@ import (
 	"github.com/hashicorp/go-hclog"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+
+	klog "github.com/jkratz55/konsul/log"
+	"github.com/jkratz55/konsul/log/internal/stdlog"
 )
 
 // Wrapper is a type that wraps a zap Logger and adapts it to a hclog.Logger
 type Wrapper struct {
 	logger *zap.Logger
+	level  *zap.AtomicLevel
+	min    *klog.LevelVar
 	name   string
+	args   []any
 }
 
 // Wrap accepts a zap Logger and wraps it to adapt to a hclog.Logger.
 //
 // A nil logger will cause a panic.
-func Wrap(logger *zap.Logger) hclog.Logger {
+func Wrap(logger *zap.Logger, opts ...klog.Option) hclog.Logger {
 	if logger == nil {
 		panic("cannot wrap nil zap.Logger")
 	}
+	o := klog.NewOptions(opts...)
 	return Wrapper{
 		logger: logger.WithOptions(zap.AddCallerSkip(1)),
+		min:    o.MinLevel,
 		name:   "",
 	}
 }
 
+// WrapAtomic accepts a zap Logger and the AtomicLevel controlling it and wraps
+// it to adapt to a hclog.Logger. Unlike Wrap, calling SetLevel on the returned
+// hclog.Logger changes the level of the AtomicLevel so the levels of hclog and
+// zap are always in sync.
+//
+// The logger should be built with the provided AtomicLevel, for example via the
+// Level field of zap.Config, otherwise SetLevel will have no effect on it.
+//
+// A nil logger will cause a panic.
+func WrapAtomic(logger *zap.Logger, level zap.AtomicLevel, opts ...klog.Option) hclog.Logger {
+	if logger == nil {
+		panic("cannot wrap nil zap.Logger")
+	}
+	o := klog.NewOptions(opts...)
+	return Wrapper{
+		logger: logger.WithOptions(zap.AddCallerSkip(1)),
+		level:  &level,
+		min:    o.MinLevel,
+		name:   "",
+	}
+}
+
+// WrapConfig builds a zap Logger from the provided zap Config and wraps it to
+// adapt to a hclog.Logger. The Level of the Config backs SetLevel and GetLevel
+// of the returned hclog.Logger. If the Config doesn't specify a Level a new
+// AtomicLevel at Info level is used.
+func WrapConfig(cfg zap.Config, opts ...zap.Option) (hclog.Logger, error) {
+	if cfg.Level == (zap.AtomicLevel{}) {
+		cfg.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
+	}
+	logger, err := cfg.Build(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error building zap logger: %w", err)
+	}
+	return WrapAtomic(logger, cfg.Level), nil
+}
+
 func (w Wrapper) Log(level hclog.Level, msg string, args ...any) {
 	switch level {
 	// Zap doesn't have a Trace level so it gets mapped to Debug
@@ -45,23 +90,33 @@ func (w Wrapper) Log(level hclog.Level, msg string, args ...any) {
 
 func (w Wrapper) Trace(msg string, args ...any) {
 	// Zap doesn't have a Trace level, Debug is the closest level
-	w.logger.Debug(msg, convertArgsToZapFields(args...)...)
+	if w.min.Enabled(hclog.Trace) {
+		w.logger.Debug(msg, convertArgsToZapFields(args...)...)
+	}
 }
 
 func (w Wrapper) Debug(msg string, args ...any) {
-	w.logger.Debug(msg, convertArgsToZapFields(args...)...)
+	if w.min.Enabled(hclog.Debug) {
+		w.logger.Debug(msg, convertArgsToZapFields(args...)...)
+	}
 }
 
 func (w Wrapper) Info(msg string, args ...any) {
-	w.logger.Info(msg, convertArgsToZapFields(args...)...)
+	if w.min.Enabled(hclog.Info) {
+		w.logger.Info(msg, convertArgsToZapFields(args...)...)
+	}
 }
 
 func (w Wrapper) Warn(msg string, args ...any) {
-	w.logger.Warn(msg, convertArgsToZapFields(args...)...)
+	if w.min.Enabled(hclog.Warn) {
+		w.logger.Warn(msg, convertArgsToZapFields(args...)...)
+	}
 }
 
 func (w Wrapper) Error(msg string, args ...any) {
-	w.logger.Error(msg, convertArgsToZapFields(args...)...)
+	if w.min.Enabled(hclog.Error) {
+		w.logger.Error(msg, convertArgsToZapFields(args...)...)
+	}
 }
 
 func (w Wrapper) IsTrace() bool {
@@ -85,15 +140,26 @@ func (w Wrapper) IsError() bool {
 	return w.logger.Level() == zap.ErrorLevel
 }
 
+// ImpliedArgs returns the key/value pairs that have been passed to With on the
+// Wrapper and the loggers it was derived from.
 func (w Wrapper) ImpliedArgs() []any {
-	w.logger.Warn("ImpliedArgs in not implemented... this will always return nil")
-	return nil
+	args := make([]any, len(w.args))
+	copy(args, w.args)
+	return args
 }
 
 func (w Wrapper) With(args ...any) hclog.Logger {
+	// The implied args are copied rather than appended to avoid sharing the
+	// backing array between loggers derived from the same Wrapper.
+	implied := make([]any, 0, len(w.args)+len(args))
+	implied = append(implied, w.args...)
+	implied = append(implied, args...)
 	return Wrapper{
 		logger: w.logger.With(convertArgsToZapFields(args...)...),
+		level:  w.level,
+		min:    w.min,
 		name:   w.name,
+		args:   implied,
 	}
 }
 
@@ -110,19 +176,42 @@ func (w Wrapper) Named(name string) hclog.Logger {
 	}
 	return Wrapper{
 		logger: w.logger.Named(newName),
+		level:  w.level,
+		min:    w.min,
 		name:   newName,
+		args:   w.args,
 	}
 }
 
 func (w Wrapper) ResetNamed(name string) hclog.Logger {
 	return Wrapper{
 		logger: w.logger.Named(name),
+		level:  w.level,
+		min:    w.min,
 		name:   name,
+		args:   w.args,
 	}
 }
 
+// SetLevel changes the level of the AtomicLevel the Wrapper was created with
+// using WrapAtomic or WrapConfig. If the Wrapper was created with Wrap there is
+// no way to change the level of the zap Logger and SetLevel is a no-op.
 func (w Wrapper) SetLevel(level hclog.Level) {
-	w.logger.Warn("SetLevel on Wrapper is a no-op")
+	if w.level == nil {
+		w.logger.Warn("SetLevel on Wrapper is a no-op, use WrapAtomic to support changing levels")
+		return
+	}
+	switch level {
+	// Zap doesn't have a Trace level so it gets mapped to Debug
+	case hclog.Trace, hclog.Debug:
+		w.level.SetLevel(zap.DebugLevel)
+	case hclog.Info:
+		w.level.SetLevel(zap.InfoLevel)
+	case hclog.Warn:
+		w.level.SetLevel(zap.WarnLevel)
+	case hclog.Error:
+		w.level.SetLevel(zap.ErrorLevel)
+	}
 }
 
 func (w Wrapper) GetLevel() hclog.Level {
@@ -142,12 +231,30 @@ func (w Wrapper) GetLevel() hclog.Level {
 	}
 }
 
+// SetMinLevel sets the minimum level of messages passed to the zap Logger
+// without changing the level of the zap Logger itself.
+func (w Wrapper) SetMinLevel(level hclog.Level) {
+	w.min.SetMinLevel(level)
+}
+
+// MinLevel returns the minimum level of messages passed to the zap Logger.
+func (w Wrapper) MinLevel() hclog.Level {
+	return w.min.MinLevel()
+}
+
+// StandardLogger returns a standard library log Logger that writes through the
+// wrapped zap Logger. See StandardWriter for how levels are determined.
 func (w Wrapper) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
-	return log.New(w.StandardWriter(opts), "", log.LstdFlags)
+	return log.New(w.StandardWriter(opts), "", 0)
 }
 
+// StandardWriter returns an io.Writer that writes each line through the wrapped
+// zap Logger so the output uses the same encoding, sinks, and sampling as the
+// rest of the application. Lines are logged at Info level unless opts specifies
+// InferLevels, in which case prefixes such as [DEBUG] or [ERR] are parsed to
+// determine the level, or ForceLevel.
 func (w Wrapper) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
-	return hclog.DefaultOutput
+	return stdlog.NewWriter(w, opts)
 }
 
 func convertArgsToZapFields(args ...any) []zapcore.Field {
@@ -7,20 +7,26 @@ import (
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/rs/zerolog"
+
+	klog "github.com/jkratz55/konsul/log"
+	"github.com/jkratz55/konsul/log/internal/stdlog"
 )
 
 // Wrapper is a type that wraps a zerolog Logger implementing the hclog.Logger
 // interface.
 type Wrapper struct {
 	logger zerolog.Logger
+	min    *klog.LevelVar
 	name   string
 }
 
 // Wrap wraps a zerolog Logger and returns a wrapper that implements the
 // hclog.Logger interface.
-func Wrap(logger zerolog.Logger) hclog.Logger {
+func Wrap(logger zerolog.Logger, opts ...klog.Option) hclog.Logger {
+	o := klog.NewOptions(opts...)
 	return Wrapper{
 		logger: logger,
+		min:    o.MinLevel,
 		name:   "",
 	}
 }
@@ -41,6 +47,9 @@ func (w Wrapper) Log(level hclog.Level, msg string, args ...interface{}) {
 }
 
 func (w Wrapper) Trace(msg string, args ...any) {
+	if !w.min.Enabled(hclog.Trace) {
+		return
+	}
 	event := w.logger.Trace().Fields(args)
 	if w.name != "" {
 		event.Str("logger", w.name)
@@ -49,6 +58,9 @@ func (w Wrapper) Trace(msg string, args ...any) {
 }
 
 func (w Wrapper) Debug(msg string, args ...any) {
+	if !w.min.Enabled(hclog.Debug) {
+		return
+	}
 	event := w.logger.Debug().Fields(args)
 	if w.name != "" {
 		event.Str("logger", w.name)
@@ -57,6 +69,9 @@ func (w Wrapper) Debug(msg string, args ...any) {
 }
 
 func (w Wrapper) Info(msg string, args ...any) {
+	if !w.min.Enabled(hclog.Info) {
+		return
+	}
 	event := w.logger.Info().Fields(args)
 	if w.name != "" {
 		event.Str("logger", w.name)
@@ -65,6 +80,9 @@ func (w Wrapper) Info(msg string, args ...any) {
 }
 
 func (w Wrapper) Warn(msg string, args ...any) {
+	if !w.min.Enabled(hclog.Warn) {
+		return
+	}
 	event := w.logger.Warn().Fields(args)
 	if w.name != "" {
 		event.Str("logger", w.name)
@@ -73,6 +91,9 @@ func (w Wrapper) Warn(msg string, args ...any) {
 }
 
 func (w Wrapper) Error(msg string, args ...any) {
+	if !w.min.Enabled(hclog.Error) {
+		return
+	}
 	event := w.logger.Error().Fields(args)
 	if w.name != "" {
 		event.Str("logger", w.name)
@@ -107,6 +128,7 @@ func (w Wrapper) ImpliedArgs() []any {
 func (w Wrapper) With(args ...any) hclog.Logger {
 	return Wrapper{
 		logger: w.logger.With().Fields(args).Logger(),
+		min:    w.min,
 		name:   w.name,
 	}
 }
@@ -124,6 +146,7 @@ func (w Wrapper) Named(name string) hclog.Logger {
 	}
 	return Wrapper{
 		logger: w.logger,
+		min:    w.min,
 		name:   newName,
 	}
 }
@@ -131,6 +154,7 @@ func (w Wrapper) Named(name string) hclog.Logger {
 func (w Wrapper) ResetNamed(name string) hclog.Logger {
 	return Wrapper{
 		logger: w.logger,
+		min:    w.min,
 		name:   name,
 	}
 }
@@ -157,10 +181,27 @@ func (w Wrapper) GetLevel() hclog.Level {
 	}
 }
 
+// SetMinLevel sets the minimum level of messages passed to the zerolog Logger
+// without changing the level of the zerolog Logger itself.
+func (w Wrapper) SetMinLevel(level hclog.Level) {
+	w.min.SetMinLevel(level)
+}
+
+// MinLevel returns the minimum level of messages passed to the zerolog Logger.
+func (w Wrapper) MinLevel() hclog.Level {
+	return w.min.MinLevel()
+}
+
+// StandardLogger returns a standard library log Logger that writes through the
+// wrapped zerolog Logger. See StandardWriter for how levels are determined.
 func (w Wrapper) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
-	return log.New(w.StandardWriter(opts), "", log.LstdFlags)
+	return log.New(w.StandardWriter(opts), "", 0)
 }
 
+// StandardWriter returns an io.Writer that forwards each line into the wrapped
+// zerolog Logger. Lines are logged at Info level unless opts specifies
+// InferLevels, in which case prefixes such as [DEBUG] or [ERR] are parsed to
+// determine the level, or ForceLevel.
 func (w Wrapper) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
-	return hclog.DefaultOutput
+	return stdlog.NewWriter(w, opts)
 }
@@ -0,0 +1,211 @@
+package konsul
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/hashicorp/go-hclog"
+
+	klog "github.com/jkratz55/konsul/log"
+)
+
+// Logger is the minimal logging interface konsul uses to log its internal
+// behavior. hclog.Logger satisfies Logger as does *slog.Logger, so in many
+// cases an application's logger can be provided directly without needing one
+// of the wrappers in the log package.
+//
+// The Consul API requires a hclog.Logger. When the Logger provided to konsul
+// isn't a hclog.Logger it is adapted using HclogAdapter.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// HclogAdapter adapts a Logger to the hclog.Logger interface. If the provided
+// Logger is already a hclog.Logger it is returned as is. A nil Logger yields
+// hclog.Default().
+//
+// Since Logger has no concept of levels, Trace messages are logged at Debug
+// level and the level of the returned hclog.Logger cannot be changed. Names
+// and implied args are passed as key/value pairs with each message.
+func HclogAdapter(logger Logger) hclog.Logger {
+	if logger == nil {
+		return hclog.Default()
+	}
+	if l, ok := logger.(hclog.Logger); ok {
+		return l
+	}
+	return hclogAdapter{logger: logger}
+}
+
+// withLevel filters messages below the provided level so the verbosity of each
+// subsystem can be configured independently of the logger it shares with the
+// application. hclog.NoLevel leaves the logger as is.
+func withLevel(logger hclog.Logger, level hclog.Level) hclog.Logger {
+	if level == hclog.NoLevel {
+		return logger
+	}
+	return klog.Filter(logger, klog.NewLevelVar(level))
+}
+
+type hclogAdapter struct {
+	logger Logger
+	name   string
+	args   []any
+}
+
+func (a hclogAdapter) Log(level hclog.Level, msg string, args ...any) {
+	switch level {
+	case hclog.NoLevel, hclog.Trace:
+		a.Trace(msg, args...)
+	case hclog.Debug:
+		a.Debug(msg, args...)
+	case hclog.Info:
+		a.Info(msg, args...)
+	case hclog.Warn:
+		a.Warn(msg, args...)
+	case hclog.Error:
+		a.Error(msg, args...)
+	}
+}
+
+func (a hclogAdapter) Trace(msg string, args ...any) {
+	// Logger doesn't have a Trace level, Debug is the closest level
+	a.logger.Debug(msg, a.withArgs(args)...)
+}
+
+func (a hclogAdapter) Debug(msg string, args ...any) {
+	a.logger.Debug(msg, a.withArgs(args)...)
+}
+
+func (a hclogAdapter) Info(msg string, args ...any) {
+	a.logger.Info(msg, a.withArgs(args)...)
+}
+
+func (a hclogAdapter) Warn(msg string, args ...any) {
+	a.logger.Warn(msg, a.withArgs(args)...)
+}
+
+func (a hclogAdapter) Error(msg string, args ...any) {
+	a.logger.Error(msg, a.withArgs(args)...)
+}
+
+func (a hclogAdapter) IsTrace() bool {
+	return a.enabled(hclog.Trace)
+}
+
+func (a hclogAdapter) IsDebug() bool {
+	return a.enabled(hclog.Debug)
+}
+
+func (a hclogAdapter) IsInfo() bool {
+	return a.enabled(hclog.Info)
+}
+
+func (a hclogAdapter) IsWarn() bool {
+	return a.enabled(hclog.Warn)
+}
+
+func (a hclogAdapter) IsError() bool {
+	return a.enabled(hclog.Error)
+}
+
+// enabled reports whether messages at the provided level would be emitted,
+// asking the adapted Logger if it implements LevelController.
+func (a hclogAdapter) enabled(level hclog.Level) bool {
+	if lc, ok := a.logger.(klog.LevelController); ok {
+		return level >= lc.MinLevel() && level >= a.GetLevel()
+	}
+	return level >= a.GetLevel()
+}
+
+func (a hclogAdapter) ImpliedArgs() []any {
+	args := make([]any, len(a.args))
+	copy(args, a.args)
+	return args
+}
+
+func (a hclogAdapter) With(args ...any) hclog.Logger {
+	implied := make([]any, 0, len(a.args)+len(args))
+	implied = append(implied, a.args...)
+	implied = append(implied, args...)
+	return hclogAdapter{
+		logger: a.logger,
+		name:   a.name,
+		args:   implied,
+	}
+}
+
+func (a hclogAdapter) Name() string {
+	return a.name
+}
+
+func (a hclogAdapter) Named(name string) hclog.Logger {
+	newName := name
+	if a.name != "" {
+		newName = fmt.Sprintf("%s.%s", a.name, name)
+	}
+	return hclogAdapter{
+		logger: a.logger,
+		name:   newName,
+		args:   a.args,
+	}
+}
+
+func (a hclogAdapter) ResetNamed(name string) hclog.Logger {
+	return hclogAdapter{
+		logger: a.logger,
+		name:   name,
+		args:   a.args,
+	}
+}
+
+// SetLevel is a no-op since the level is owned by the adapted Logger.
+func (a hclogAdapter) SetLevel(level hclog.Level) {}
+
+// GetLevel always returns hclog.Debug since that is the most verbose level the
+// adapted Logger supports. IsTrace therefore returns false, while IsDebug and
+// above report whether the adapted Logger would emit the message.
+func (a hclogAdapter) GetLevel() hclog.Level {
+	return hclog.Debug
+}
+
+func (a hclogAdapter) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
+	return log.New(a.StandardWriter(opts), "", 0)
+}
+
+// StandardWriter returns an io.Writer that logs each line through the adapted
+// Logger at Info level, or the level specified by opts.ForceLevel.
+func (a hclogAdapter) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	level := hclog.Info
+	if opts != nil && opts.ForceLevel != hclog.NoLevel {
+		level = opts.ForceLevel
+	}
+	return adapterWriter{logger: a, level: level}
+}
+
+func (a hclogAdapter) withArgs(args []any) []any {
+	if a.name == "" && len(a.args) == 0 {
+		return args
+	}
+	all := make([]any, 0, len(a.args)+len(args)+2)
+	if a.name != "" {
+		all = append(all, "logger", a.name)
+	}
+	all = append(all, a.args...)
+	return append(all, args...)
+}
+
+type adapterWriter struct {
+	logger hclog.Logger
+	level  hclog.Level
+}
+
+func (w adapterWriter) Write(p []byte) (int, error) {
+	w.logger.Log(w.level, string(bytes.TrimRight(p, " \t\n")))
+	return len(p), nil
+}
@@ -0,0 +1,40 @@
+package konsul
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+
+	klog "github.com/jkratz55/konsul/log"
+)
+
+// levelLogger is a Logger controlling its level through a LevelVar.
+type levelLogger struct {
+	*klog.LevelVar
+}
+
+func (levelLogger) Debug(string, ...any) {}
+func (levelLogger) Info(string, ...any)  {}
+func (levelLogger) Warn(string, ...any)  {}
+func (levelLogger) Error(string, ...any) {}
+
+func TestHclogAdapter_IsLevel(t *testing.T) {
+	tests := []struct {
+		name   string
+		logger Logger
+		want   [5]bool // IsTrace, IsDebug, IsInfo, IsWarn, IsError
+	}{
+		{"no level", levelLogger{klog.NewLevelVar(hclog.NoLevel)}, [5]bool{false, true, true, true, true}},
+		{"info", levelLogger{klog.NewLevelVar(hclog.Info)}, [5]bool{false, false, true, true, true}},
+		{"error", levelLogger{klog.NewLevelVar(hclog.Error)}, [5]bool{false, false, false, false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := HclogAdapter(tt.logger)
+			got := [5]bool{a.IsTrace(), a.IsDebug(), a.IsInfo(), a.IsWarn(), a.IsError()}
+			if got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package konsul
+
+import (
+	"time"
+)
+
+// Metrics is a set of instrumentation hooks invoked by KVClient, Watch, and
+// Instancer. Implementations record the events with a metrics library of their
+// choice, such as the metrics/prometheus package.
+//
+// Implementations must be safe for concurrent use and should return quickly as
+// the hooks are invoked inline.
+type Metrics interface {
+	// KVOperation is invoked after KVClient completes an operation against the
+	// Consul KV store such as get, put, or delete. The error is nil when the
+	// operation succeeded.
+	KVOperation(op string, key string, duration time.Duration, err error)
+	// WatchUpdate is invoked each time Watch handles a change to the watched
+	// key. The error is nil when the new value was applied successfully.
+	WatchUpdate(key string, err error)
+	// InstancesChanged is invoked each time Instancer refreshes the instances
+	// of the service it's monitoring.
+	InstancesChanged(service string, instances int)
+}
+
+// WatchMetrics is an optional extension of Metrics recording the latency of
+// handling watched changes, allowing slow targets or handlers to be detected.
+// Metrics implementations also implementing WatchMetrics are invoked by Watch
+// and WatchPrefix, without requiring every implementation to record latency.
+type WatchMetrics interface {
+	// WatchLatency is invoked each time Watch handles a change to the watched
+	// key, or WatchPrefix changes under the watched prefix, with the time
+	// taken to decode, validate, and apply the change.
+	WatchLatency(key string, duration time.Duration)
+}
+
+// KV operation names passed to Metrics.KVOperation.
+const (
+	OpGet     = "get"
+	OpList    = "list"
+	OpPut     = "put"
+	OpDelete  = "delete"
+	OpTxn     = "txn"
+	OpAcquire = "acquire"
+	OpRelease = "release"
+)
+
+// nopMetrics is the Metrics implementation used when one isn't provided.
+type nopMetrics struct{}
+
+func (nopMetrics) KVOperation(string, string, time.Duration, error) {}
+
+func (nopMetrics) WatchUpdate(string, error) {}
+
+func (nopMetrics) InstancesChanged(string, int) {}
+
+// observeWatchLatency records the latency of handling a change if the Metrics
+// implement WatchMetrics.
+func observeWatchLatency(m Metrics, key string, duration time.Duration) {
+	if wm, ok := m.(WatchMetrics); ok {
+		wm.WatchLatency(key, duration)
+	}
+}
+
+func metricsOrNop(m Metrics) Metrics {
+	if m == nil {
+		return nopMetrics{}
+	}
+	return m
+}
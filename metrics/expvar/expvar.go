@@ -0,0 +1,102 @@
+// Package expvar implements konsul's instrumentation hooks by publishing
+// counters and gauges with the standard library expvar package, giving minimal
+// services basic visibility into konsul without a metrics stack. The values are
+// served by the expvar handler at /debug/vars.
+package expvar
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/jkratz55/konsul"
+)
+
+var (
+	_ konsul.Metrics      = (*Metrics)(nil)
+	_ konsul.WatchMetrics = (*Metrics)(nil)
+)
+
+// Metrics is a konsul.Metrics implementation publishing konsul's state with
+// expvar. The following variables are published under the name provided to
+// Publish:
+//
+//	kvOps             number of KV operations by op
+//	kvErrors          number of failed KV operations by op
+//	watchUpdates      number of KV changes applied by Watch by key
+//	watchFailures     number of KV changes Watch failed to apply by key
+//	watchLastSuccess  unix time Watch last applied a change by key
+//	watchLatency      seconds Watch took to handle the last change by key
+//	instances         current number of instances by service
+//	instanceChanges   number of Instancer refreshes by service
+type Metrics struct {
+	root             *expvar.Map
+	kvOps            *expvar.Map
+	kvErrors         *expvar.Map
+	watchUpdates     *expvar.Map
+	watchFailures    *expvar.Map
+	watchLastSuccess *expvar.Map
+	watchLatency     *expvar.Map
+	instances        *expvar.Map
+	instanceChanges  *expvar.Map
+}
+
+// Publish creates a Metrics and publishes its variables with expvar under the
+// provided name. If name is empty "konsul" is used.
+//
+// Like expvar.Publish, this will panic if the name is already in use.
+func Publish(name string) *Metrics {
+	if name == "" {
+		name = "konsul"
+	}
+	m := &Metrics{
+		root:             expvar.NewMap(name),
+		kvOps:            new(expvar.Map).Init(),
+		kvErrors:         new(expvar.Map).Init(),
+		watchUpdates:     new(expvar.Map).Init(),
+		watchFailures:    new(expvar.Map).Init(),
+		watchLastSuccess: new(expvar.Map).Init(),
+		watchLatency:     new(expvar.Map).Init(),
+		instances:        new(expvar.Map).Init(),
+		instanceChanges:  new(expvar.Map).Init(),
+	}
+	m.root.Set("kvOps", m.kvOps)
+	m.root.Set("kvErrors", m.kvErrors)
+	m.root.Set("watchUpdates", m.watchUpdates)
+	m.root.Set("watchFailures", m.watchFailures)
+	m.root.Set("watchLastSuccess", m.watchLastSuccess)
+	m.root.Set("watchLatency", m.watchLatency)
+	m.root.Set("instances", m.instances)
+	m.root.Set("instanceChanges", m.instanceChanges)
+	return m
+}
+
+func (m *Metrics) KVOperation(op string, _ string, _ time.Duration, err error) {
+	m.kvOps.Add(op, 1)
+	if err != nil {
+		m.kvErrors.Add(op, 1)
+	}
+}
+
+func (m *Metrics) WatchUpdate(key string, err error) {
+	if err != nil {
+		m.watchFailures.Add(key, 1)
+		return
+	}
+	m.watchUpdates.Add(key, 1)
+	last := new(expvar.Int)
+	last.Set(time.Now().Unix())
+	m.watchLastSuccess.Set(key, last)
+}
+
+func (m *Metrics) WatchLatency(key string, duration time.Duration) {
+	latency := new(expvar.Float)
+	latency.Set(duration.Seconds())
+	m.watchLatency.Set(key, latency)
+}
+
+func (m *Metrics) InstancesChanged(service string, instances int) {
+	n := new(expvar.Int)
+	n.Set(int64(instances))
+	m.instances.Set(service, n)
+	m.instanceChanges.Add(service, 1)
+}
@@ -0,0 +1,168 @@
+// Package otel implements konsul's instrumentation hooks with the OpenTelemetry
+// metric API, allowing applications standardized on the OpenTelemetry SDK to
+// collect konsul telemetry without a Prometheus bridge.
+//
+//	metrics, err := kotel.New(otel.GetMeterProvider().Meter("github.com/jkratz55/konsul"))
+//	if err != nil {
+//		panic(err)
+//	}
+//	kv := konsul.NewKVClient(client, konsul.WithMetrics(metrics))
+package otel
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/instrument"
+
+	"github.com/jkratz55/konsul"
+)
+
+var (
+	_ konsul.Metrics      = (*Metrics)(nil)
+	_ konsul.WatchMetrics = (*Metrics)(nil)
+)
+
+// Metrics is a konsul.Metrics implementation that records the events of the
+// konsul subsystems with OpenTelemetry instruments.
+//
+// The following instruments are created:
+//
+//	konsul.kv.operation.duration        histogram of KV operation latency (op)
+//	konsul.kv.operation.errors          count of failed KV operations (op)
+//	konsul.watch.updates                count of KV changes applied by Watch (key)
+//	konsul.watch.failures               count of KV changes Watch failed to apply (key)
+//	konsul.watch.last_success.age       seconds since Watch last applied a change (key)
+//	konsul.watch.latency                histogram of the latency of handling KV changes (key)
+//	konsul.instancer.instances          current number of instances of a service (service)
+//	konsul.instancer.changes            count of Instancer refreshes (service)
+//
+// Keys are not used as an attribute on the KV operation instruments to avoid
+// unbounded cardinality, since applications often read many distinct keys.
+type Metrics struct {
+	kvDuration      instrument.Float64Histogram
+	kvErrors        instrument.Int64Counter
+	watchUpdates    instrument.Int64Counter
+	watchFailures   instrument.Int64Counter
+	watchLatency    instrument.Float64Histogram
+	instanceChanges instrument.Int64Counter
+
+	mu          sync.Mutex
+	lastSuccess map[string]time.Time
+	instances   map[string]int64
+}
+
+// New creates the instruments with the provided Meter and returns a Metrics
+// recording to them. If any of the instruments cannot be created a non-nil
+// error is returned.
+//
+// A nil Meter will cause a panic.
+func New(meter metric.Meter) (*Metrics, error) {
+	if meter == nil {
+		panic("cannot provide nil metric.Meter, illegal use of api")
+	}
+
+	m := &Metrics{
+		lastSuccess: make(map[string]time.Time),
+		instances:   make(map[string]int64),
+	}
+
+	var err error
+	if m.kvDuration, err = meter.Float64Histogram("konsul.kv.operation.duration",
+		instrument.WithUnit("s"),
+		instrument.WithDescription("Latency of operations against the Consul KV store.")); err != nil {
+		return nil, fmt.Errorf("error creating instrument: %w", err)
+	}
+	if m.kvErrors, err = meter.Int64Counter("konsul.kv.operation.errors",
+		instrument.WithDescription("Number of failed operations against the Consul KV store.")); err != nil {
+		return nil, fmt.Errorf("error creating instrument: %w", err)
+	}
+	if m.watchUpdates, err = meter.Int64Counter("konsul.watch.updates",
+		instrument.WithDescription("Number of KV changes successfully applied by Watch.")); err != nil {
+		return nil, fmt.Errorf("error creating instrument: %w", err)
+	}
+	if m.watchFailures, err = meter.Int64Counter("konsul.watch.failures",
+		instrument.WithDescription("Number of KV changes Watch failed to apply.")); err != nil {
+		return nil, fmt.Errorf("error creating instrument: %w", err)
+	}
+	if m.watchLatency, err = meter.Float64Histogram("konsul.watch.latency",
+		instrument.WithUnit("s"),
+		instrument.WithDescription("Latency of decoding, validating, and applying KV changes by Watch.")); err != nil {
+		return nil, fmt.Errorf("error creating instrument: %w", err)
+	}
+	if m.instanceChanges, err = meter.Int64Counter("konsul.instancer.changes",
+		instrument.WithDescription("Number of times Instancer refreshed the instances of a service.")); err != nil {
+		return nil, fmt.Errorf("error creating instrument: %w", err)
+	}
+	if _, err = meter.Float64ObservableGauge("konsul.watch.last_success.age",
+		instrument.WithUnit("s"),
+		instrument.WithDescription("Seconds since Watch last successfully applied a change to a key."),
+		instrument.WithFloat64Callback(m.observeLastSuccess)); err != nil {
+		return nil, fmt.Errorf("error creating instrument: %w", err)
+	}
+	if _, err = meter.Int64ObservableGauge("konsul.instancer.instances",
+		instrument.WithDescription("Current number of instances of a service yielded by Instancer."),
+		instrument.WithInt64Callback(m.observeInstances)); err != nil {
+		return nil, fmt.Errorf("error creating instrument: %w", err)
+	}
+
+	return m, nil
+}
+
+func (m *Metrics) KVOperation(op string, _ string, duration time.Duration, err error) {
+	ctx := context.Background()
+	attr := attribute.String("op", op)
+	m.kvDuration.Record(ctx, duration.Seconds(), attr)
+	if err != nil {
+		m.kvErrors.Add(ctx, 1, attr)
+	}
+}
+
+func (m *Metrics) WatchUpdate(key string, err error) {
+	ctx := context.Background()
+	attr := attribute.String("key", key)
+	if err != nil {
+		m.watchFailures.Add(ctx, 1, attr)
+		return
+	}
+	m.watchUpdates.Add(ctx, 1, attr)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastSuccess[key] = time.Now()
+}
+
+func (m *Metrics) WatchLatency(key string, duration time.Duration) {
+	m.watchLatency.Record(context.Background(), duration.Seconds(), attribute.String("key", key))
+}
+
+func (m *Metrics) InstancesChanged(service string, instances int) {
+	m.instanceChanges.Add(context.Background(), 1, attribute.String("service", service))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.instances[service] = int64(instances)
+}
+
+func (m *Metrics) observeLastSuccess(_ context.Context, o instrument.Float64Observer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for key, t := range m.lastSuccess {
+		o.Observe(now.Sub(t).Seconds(), attribute.String("key", key))
+	}
+	return nil
+}
+
+func (m *Metrics) observeInstances(_ context.Context, o instrument.Int64Observer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for service, n := range m.instances {
+		o.Observe(n, attribute.String("service", service))
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// Package prometheus implements konsul's instrumentation hooks with Prometheus
+// collectors.
+//
+// A single Metrics value can be shared by every KVClient, Watch, and Instancer
+// in an application:
+//
+//	metrics := kprom.MustRegister(prometheus.DefaultRegisterer)
+//	kv := konsul.NewKVClient(client, konsul.WithMetrics(metrics))
+package prometheus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jkratz55/konsul"
+)
+
+const namespace = "konsul"
+
+var (
+	_ konsul.Metrics      = (*Metrics)(nil)
+	_ konsul.WatchMetrics = (*Metrics)(nil)
+)
+
+// Metrics is a konsul.Metrics implementation that records the events of the
+// konsul subsystems with Prometheus collectors.
+//
+// The following metrics are exposed:
+//
+//	konsul_kv_operation_duration_seconds{op}       histogram of KV operation latency
+//	konsul_kv_operation_errors_total{op}           count of failed KV operations
+//	konsul_watch_updates_total{key}                count of KV changes applied by Watch
+//	konsul_watch_failures_total{key}               count of KV changes Watch failed to apply
+//	konsul_watch_last_success_age_seconds{key}     seconds since Watch last applied a change
+//	konsul_watch_latency_seconds{key}              histogram of the latency of handling KV changes
+//	konsul_instancer_instances{service}            current number of instances of a service
+//	konsul_instancer_changes_total{service}        count of Instancer refreshes
+//
+// Keys are not used as a label on the KV operation metrics to avoid unbounded
+// cardinality, since applications often read many distinct keys.
+type Metrics struct {
+	kvDuration       *prometheus.HistogramVec
+	kvErrors         *prometheus.CounterVec
+	watchUpdates     *prometheus.CounterVec
+	watchFailures    *prometheus.CounterVec
+	watchLastSuccess *lastSuccessCollector
+	watchLatency     *prometheus.HistogramVec
+	instances        *prometheus.GaugeVec
+	instanceChanges  *prometheus.CounterVec
+}
+
+// New creates a Metrics without registering its collectors. Use Register or
+// MustRegister unless the collectors need to be registered manually.
+func New() *Metrics {
+	return &Metrics{
+		kvDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "kv",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of operations against the Consul KV store.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"op"}),
+		kvErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kv",
+			Name:      "operation_errors_total",
+			Help:      "Number of failed operations against the Consul KV store.",
+		}, []string{"op"}),
+		watchUpdates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "watch",
+			Name:      "updates_total",
+			Help:      "Number of KV changes successfully applied by Watch.",
+		}, []string{"key"}),
+		watchFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "watch",
+			Name:      "failures_total",
+			Help:      "Number of KV changes Watch failed to apply.",
+		}, []string{"key"}),
+		watchLastSuccess: newLastSuccessCollector(),
+		watchLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "watch",
+			Name:      "latency_seconds",
+			Help:      "Latency of decoding, validating, and applying KV changes by Watch.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"key"}),
+		instances: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "instancer",
+			Name:      "instances",
+			Help:      "Current number of instances of a service yielded by Instancer.",
+		}, []string{"service"}),
+		instanceChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "instancer",
+			Name:      "changes_total",
+			Help:      "Number of times Instancer refreshed the instances of a service.",
+		}, []string{"service"}),
+	}
+}
+
+// Register creates a Metrics and registers its collectors with the provided
+// Registerer. If registering any of the collectors fails a non-nil error is
+// returned.
+func Register(reg prometheus.Registerer) (*Metrics, error) {
+	m := New()
+	for _, c := range m.Collectors() {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// MustRegister is like Register but panics if registering the collectors
+// fails.
+func MustRegister(reg prometheus.Registerer) *Metrics {
+	m := New()
+	reg.MustRegister(m.Collectors()...)
+	return m
+}
+
+// Collectors returns all the collectors backing Metrics.
+func (m *Metrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.kvDuration,
+		m.kvErrors,
+		m.watchUpdates,
+		m.watchFailures,
+		m.watchLastSuccess,
+		m.watchLatency,
+		m.instances,
+		m.instanceChanges,
+	}
+}
+
+func (m *Metrics) KVOperation(op string, _ string, duration time.Duration, err error) {
+	m.kvDuration.WithLabelValues(op).Observe(duration.Seconds())
+	if err != nil {
+		m.kvErrors.WithLabelValues(op).Inc()
+	}
+}
+
+func (m *Metrics) WatchUpdate(key string, err error) {
+	if err != nil {
+		m.watchFailures.WithLabelValues(key).Inc()
+		return
+	}
+	m.watchUpdates.WithLabelValues(key).Inc()
+	m.watchLastSuccess.set(key, time.Now())
+}
+
+func (m *Metrics) WatchLatency(key string, duration time.Duration) {
+	m.watchLatency.WithLabelValues(key).Observe(duration.Seconds())
+}
+
+func (m *Metrics) InstancesChanged(service string, instances int) {
+	m.instances.WithLabelValues(service).Set(float64(instances))
+	m.instanceChanges.WithLabelValues(service).Inc()
+}
+
+// lastSuccessCollector reports the age of the last successful update of each
+// watched key. The age is computed at collection time so it keeps growing when
+// a watch silently stops receiving updates.
+type lastSuccessCollector struct {
+	desc  *prometheus.Desc
+	mu    sync.Mutex
+	times map[string]time.Time
+}
+
+func newLastSuccessCollector() *lastSuccessCollector {
+	return &lastSuccessCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "last_success_age_seconds"),
+			"Seconds since Watch last successfully applied a change to a key.",
+			[]string{"key"}, nil),
+		times: make(map[string]time.Time),
+	}
+}
+
+func (c *lastSuccessCollector) set(key string, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.times[key] = t
+}
+
+func (c *lastSuccessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *lastSuccessCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for key, t := range c.times {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, now.Sub(t).Seconds(), key)
+	}
+}
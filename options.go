@@ -0,0 +1,425 @@
+package konsul
+
+import (
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/jkratz55/konsul/log/redact"
+)
+
+// KVClientOption configures a KVClient created by NewKVClient or
+// TryNewKVClient.
+//
+// KVClientOptions implements KVClientOption, replacing any options applied
+// before it, so existing code passing a KVClientOptions struct continues to
+// work.
+type KVClientOption interface {
+	applyKVClient(opts *KVClientOptions)
+}
+
+// WatchOption configures the behavior of Watch.
+//
+// WatchOptions implements WatchOption, replacing any options applied before it,
+// so existing code passing a WatchOptions struct continues to work.
+type WatchOption interface {
+	applyWatch(opts *WatchOptions)
+}
+
+// InstancerOption configures an Instancer created by NewInstancer. Options are
+// applied to the InstancerConfig before it's validated.
+type InstancerOption interface {
+	applyInstancer(config *InstancerConfig)
+}
+
+func (o KVClientOptions) applyKVClient(opts *KVClientOptions) {
+	*opts = o
+}
+
+func (o WatchOptions) applyWatch(opts *WatchOptions) {
+	*opts = o
+}
+
+// BuildKVClientOptions applies the options in order to the zero-value of
+// KVClientOptions and returns the result.
+func BuildKVClientOptions(opts ...KVClientOption) KVClientOptions {
+	var o KVClientOptions
+	for _, opt := range opts {
+		if opt != nil {
+			opt.applyKVClient(&o)
+		}
+	}
+	return o
+}
+
+// BuildWatchOptions applies the options in order to the zero-value of
+// WatchOptions and returns the result. It's useful for implementations of
+// Watcher, such as fakes, that need to honor the options.
+func BuildWatchOptions(opts ...WatchOption) WatchOptions {
+	var o WatchOptions
+	for _, opt := range opts {
+		if opt != nil {
+			opt.applyWatch(&o)
+		}
+	}
+	return o
+}
+
+// KVClientOptionFunc is an adapter to allow the use of ordinary functions as a
+// KVClientOption.
+type KVClientOptionFunc func(opts *KVClientOptions)
+
+func (f KVClientOptionFunc) applyKVClient(opts *KVClientOptions) {
+	f(opts)
+}
+
+// WatchOptionFunc is an adapter to allow the use of ordinary functions as a
+// WatchOption.
+type WatchOptionFunc func(opts *WatchOptions)
+
+func (f WatchOptionFunc) applyWatch(opts *WatchOptions) {
+	f(opts)
+}
+
+// InstancerOptionFunc is an adapter to allow the use of ordinary functions as
+// an InstancerOption.
+type InstancerOptionFunc func(config *InstancerConfig)
+
+func (f InstancerOptionFunc) applyInstancer(config *InstancerConfig) {
+	f(config)
+}
+
+// Option is an option shared by KVClient, Watch, and Instancer. It implements
+// KVClientOption, WatchOption, and InstancerOption, although not every Option
+// affects all of them, in which case it's ignored. See the documentation of the
+// function returning the Option for the components it applies to.
+type Option struct {
+	kv        func(opts *KVClientOptions)
+	watch     func(opts *WatchOptions)
+	instancer func(config *InstancerConfig)
+}
+
+var (
+	_ KVClientOption  = Option{}
+	_ WatchOption     = Option{}
+	_ InstancerOption = Option{}
+)
+
+func (o Option) applyKVClient(opts *KVClientOptions) {
+	if o.kv != nil {
+		o.kv(opts)
+	}
+}
+
+func (o Option) applyWatch(opts *WatchOptions) {
+	if o.watch != nil {
+		o.watch(opts)
+	}
+}
+
+func (o Option) applyInstancer(config *InstancerConfig) {
+	if o.instancer != nil {
+		o.instancer(config)
+	}
+}
+
+// WithLogger sets the Logger of KVClient, Watch, and Instancer.
+func WithLogger(logger Logger) Option {
+	return Option{
+		kv:        func(opts *KVClientOptions) { opts.Logger = logger },
+		watch:     func(opts *WatchOptions) { opts.Logger = logger },
+		instancer: func(config *InstancerConfig) { config.Logger = logger },
+	}
+}
+
+// WithLogLevel sets the minimum level of messages logged by KVClient, Watch,
+// and Instancer.
+func WithLogLevel(level hclog.Level) Option {
+	return Option{
+		kv:        func(opts *KVClientOptions) { opts.LogLevel = level },
+		watch:     func(opts *WatchOptions) { opts.LogLevel = level },
+		instancer: func(config *InstancerConfig) { config.LogLevel = level },
+	}
+}
+
+// WithMetrics sets the instrumentation hooks of KVClient, Watch, and Instancer.
+func WithMetrics(metrics Metrics) Option {
+	return Option{
+		kv:        func(opts *KVClientOptions) { opts.Metrics = metrics },
+		watch:     func(opts *WatchOptions) { opts.Metrics = metrics },
+		instancer: func(config *InstancerConfig) { config.Metrics = metrics },
+	}
+}
+
+// WithRedact sets the redaction of sensitive values logged by KVClient and
+// Watch. It doesn't apply to Instancer.
+func WithRedact(cfg redact.Config) Option {
+	return Option{
+		kv:    func(opts *KVClientOptions) { opts.Redact = cfg },
+		watch: func(opts *WatchOptions) { opts.Redact = cfg },
+	}
+}
+
+// WithSchemas sets the validators of the values of keys written by KVClient and
+// received by Watch. It doesn't apply to Instancer.
+func WithSchemas(schemas *Schemas) Option {
+	return Option{
+		kv:    func(opts *KVClientOptions) { opts.Schemas = schemas },
+		watch: func(opts *WatchOptions) { opts.Schemas = schemas },
+	}
+}
+
+// WithEncryption configures KVClient to encrypt values put in Consul and
+// decrypt values retrieved with the keys, and Watch to decrypt the values of
+// the watched key.
+func WithEncryption(keys KeyProvider) Option {
+	return Option{
+		kv:    func(opts *KVClientOptions) { opts.Encryption = keys },
+		watch: func(opts *WatchOptions) { opts.Encryption = keys },
+	}
+}
+
+// WithCompression configures KVClient to compress values put in Consul with
+// the algorithm and decompress values retrieved, and Watch to decompress the
+// values of the watched key.
+func WithCompression(algorithm Compression) Option {
+	return Option{
+		kv:    func(opts *KVClientOptions) { opts.Compression.Algorithm = algorithm },
+		watch: func(opts *WatchOptions) { opts.Compression.Algorithm = algorithm },
+	}
+}
+
+// WithRetry configures KVClient to retry operations failing with transient
+// errors according to the policy.
+func WithRetry(policy RetryPolicy) KVClientOption {
+	return KVClientOptionFunc(func(o *KVClientOptions) {
+		o.Retry = policy
+	})
+}
+
+// WithCircuitBreaker sets the CircuitBreaker of KVClient and Instancer. It
+// doesn't apply to Watch.
+func WithCircuitBreaker(breaker *CircuitBreaker) Option {
+	return Option{
+		kv:        func(opts *KVClientOptions) { opts.CircuitBreaker = breaker },
+		instancer: func(config *InstancerConfig) { config.CircuitBreaker = breaker },
+	}
+}
+
+// WithHistory configures KVClient to keep snapshots of the values it writes,
+// retrievable with History.
+func WithHistory(opts HistoryOptions) KVClientOption {
+	return KVClientOptionFunc(func(o *KVClientOptions) {
+		o.History = &opts
+	})
+}
+
+// WithJSON configures how KVClient encodes values with PutJSON.
+func WithJSON(opts JSONOptions) KVClientOption {
+	return KVClientOptionFunc(func(o *KVClientOptions) {
+		o.JSON = opts
+	})
+}
+
+// WithValidate sets the callback Watch validates changes with before they're
+// passed to cfg. Changes it rejects are never applied.
+func WithValidate(fn func(key string, value []byte) error) WatchOption {
+	return WatchOptionFunc(func(o *WatchOptions) {
+		o.Validate = fn
+	})
+}
+
+// WithFailFast configures Watch to retrieve the key before watching it and
+// return an error if the key doesn't exist or its value cannot be applied.
+func WithFailFast() WatchOption {
+	return WatchOptionFunc(func(o *WatchOptions) {
+		o.FailFast = true
+	})
+}
+
+// WithOnInitialFetch sets the callback invoked with the outcome of retrieving
+// the key before Watch starts watching it.
+func WithOnInitialFetch(fn func(key string, err error)) WatchOption {
+	return WatchOptionFunc(func(o *WatchOptions) {
+		o.OnInitialFetch = fn
+	})
+}
+
+// WithOnChange sets the callback Watch invokes with the old and new values of
+// the key each time it handles a change.
+func WithOnChange(fn WatchChangeFunc) WatchOption {
+	return WatchOptionFunc(func(o *WatchOptions) {
+		o.OnChange = fn
+	})
+}
+
+// WithCacheFile configures Watch to persist the last good value of the key to
+// the file at path and load it at startup when Consul is unreachable.
+func WithCacheFile(path string) WatchOption {
+	return WatchOptionFunc(func(o *WatchOptions) {
+		o.CacheFile = path
+	})
+}
+
+// WithRollbackOnFailure configures Watch to refresh cfg with the last value
+// successfully applied when it fails to unmarshal a change.
+func WithRollbackOnFailure() WatchOption {
+	return WatchOptionFunc(func(o *WatchOptions) {
+		o.RollbackOnFailure = true
+	})
+}
+
+// WithPanicOnUnmarshalFailure configures Watch to panic if a change to the key
+// cannot be unmarshalled.
+func WithPanicOnUnmarshalFailure() WatchOption {
+	return WatchOptionFunc(func(opts *WatchOptions) {
+		opts.PanicOnUnmarshalFailure = true
+	})
+}
+
+// WithDecoder sets the function WatchInto decodes values into its target with.
+func WithDecoder(fn DecoderFunc) WatchOption {
+	return WatchOptionFunc(func(o *WatchOptions) {
+		o.Decoder = fn
+	})
+}
+
+// WithErrors configures Watch to report failures on the channel rather than
+// panicking or only logging them.
+func WithErrors(errs chan<- error) WatchOption {
+	return WatchOptionFunc(func(o *WatchOptions) {
+		o.Errors = errs
+	})
+}
+
+// WithWatchNotification sets the callback invoked by Watch each time a change
+// to the key is handled.
+func WithWatchNotification(fn WatchNotificationFunc) WatchOption {
+	return WatchOptionFunc(func(opts *WatchOptions) {
+		opts.WatchNotification = fn
+	})
+}
+
+// WithTag limits the instances considered by Instancer to those with the tag.
+func WithTag(tag string) InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.Tag = tag
+	})
+}
+
+// WithTags limits the instances considered by Instancer to those with all the
+// tags.
+func WithTags(tags ...string) InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.Tags = append(config.Tags, tags...)
+	})
+}
+
+// WithAnyTags limits the instances considered by Instancer to those with at
+// least one of the tags.
+func WithAnyTags(tags ...string) InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.AnyTags = append(config.AnyTags, tags...)
+	})
+}
+
+// WithTagExpression limits the instances considered by Instancer to those whose
+// tags match the expression, see InstancerConfig.TagExpression.
+func WithTagExpression(expr string) InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.TagExpression = expr
+	})
+}
+
+// WithPassingOnly configures Instancer to only consider passing/healthy
+// instances.
+func WithPassingOnly() InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.PassingOnly = true
+	})
+}
+
+// WithAllowStale allows Instancer to query any Consul server rather than only
+// the leader.
+func WithAllowStale() InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.AllowStale = true
+	})
+}
+
+// WithPeer configures Instancer to yield the instances of the service imported
+// from the cluster peer.
+func WithPeer(peer string) InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.Peer = peer
+	})
+}
+
+// WithFilter narrows the instances considered by Instancer server-side with the
+// Consul filter expression.
+func WithFilter(expression string) InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.Filter = expression
+	})
+}
+
+// WithBackend sets the backend Instancer uses to receive changes to the
+// instances of the service.
+func WithBackend(backend WatchBackend) InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.Backend = backend
+	})
+}
+
+// WithOnPlanError sets the callback invoked by Instancer if its Restart policy
+// gives up refreshing the instances.
+func WithOnPlanError(fn func(err error)) InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.OnPlanError = fn
+	})
+}
+
+// WithOnError sets the callback invoked by Instancer each time refreshing the
+// instances fails.
+func WithOnError(fn func(err error)) InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.OnError = fn
+	})
+}
+
+// WithRestart configures Instancer to retry failed refreshes of the instances
+// by recreating its watch plan with backoff according to the policy.
+func WithRestart(policy RetryPolicy) InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.Restart = &policy
+	})
+}
+
+// WithBalancer sets the Balancer selecting the instance yielded by Instancer.
+func WithBalancer(balancer Balancer) InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.Balancer = balancer
+	})
+}
+
+// WithRejectDuplicateListeners configures Instancer to reject listeners that
+// are already registered with ErrDuplicateListener.
+func WithRejectDuplicateListeners() InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.RejectDuplicateListeners = true
+	})
+}
+
+// WithPanicOnPlanError configures Instancer to panic if its restart policy
+// gives up refreshing the instances, see InstancerConfig.PanicOnPlanError.
+func WithPanicOnPlanError() InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.PanicOnPlanError = true
+	})
+}
+
+// WithHealthPolicy sets the policy determining which instances Instancer
+// considers based on the status of their health checks.
+func WithHealthPolicy(policy HealthPolicy) InstancerOption {
+	return InstancerOptionFunc(func(config *InstancerConfig) {
+		config.HealthPolicy = policy
+	})
+}
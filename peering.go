@@ -0,0 +1,123 @@
+package konsul
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// PeeringClient is an opinionated wrapper around the official Consul API Client
+// for working with cluster peering in Consul.
+//
+// The zero-value of PeeringClient is not usable. Use NewPeeringClient to create
+// and initialize a new instance of PeeringClient.
+type PeeringClient struct {
+	client *api.Client
+}
+
+// NewPeeringClient creates and initializes a new PeeringClient. A nil client
+// will cause a panic, use TryNewPeeringClient to have an error returned instead.
+func NewPeeringClient(c *api.Client) *PeeringClient {
+	client, err := TryNewPeeringClient(c)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// TryNewPeeringClient creates and initializes a new PeeringClient. If the client
+// is nil an error wrapping ErrInvalidConfig is returned.
+func TryNewPeeringClient(c *api.Client) (*PeeringClient, error) {
+	if c == nil {
+		return nil, invalidConfig("a valid Consul API client must be provided")
+	}
+	return &PeeringClient{
+		client: c,
+	}, nil
+}
+
+// GenerateToken generates a peering token for the remote peer with the given
+// name. The token is opaque and should be handed to the remote cluster which
+// uses it to call Establish. If the operation fails a non-nil error value is
+// returned.
+func (c PeeringClient) GenerateToken(ctx context.Context, peerName string) (string, error) {
+	if strings.TrimSpace(peerName) == "" {
+		return "", invalidConfig("a peer name must be specified")
+	}
+	resp, _, err := c.client.Peerings().GenerateToken(ctx, api.PeeringGenerateTokenRequest{
+		PeerName: peerName,
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("error generating peering token for peer %s: %w", peerName, err)
+	}
+	return resp.PeeringToken, nil
+}
+
+// Establish establishes a peering with a remote cluster using a token generated
+// by the remote cluster. The peerName is the local alias for the peering. If the
+// operation fails a non-nil error value is returned.
+func (c PeeringClient) Establish(ctx context.Context, peerName string, token string) error {
+	if strings.TrimSpace(peerName) == "" {
+		return invalidConfig("a peer name must be specified")
+	}
+	_, _, err := c.client.Peerings().Establish(ctx, api.PeeringEstablishRequest{
+		PeerName:     peerName,
+		PeeringToken: token,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error establishing peering with peer %s: %w", peerName, err)
+	}
+	return nil
+}
+
+// List returns all the peerings of the local cluster.
+func (c PeeringClient) List(ctx context.Context) ([]*api.Peering, error) {
+	peerings, _, err := c.client.Peerings().List(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error listing peerings: %w", err)
+	}
+	return peerings, nil
+}
+
+// Get retrieves a peering by name. If the peering doesn't exist the returned
+// Peering will be nil along with a nil error value.
+func (c PeeringClient) Get(ctx context.Context, peerName string) (*api.Peering, error) {
+	peering, _, err := c.client.Peerings().Read(ctx, peerName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error reading peering %s: %w", peerName, err)
+	}
+	return peering, nil
+}
+
+// Delete marks a peering for deletion. If this operation fails a non-nil error
+// value is returned.
+func (c PeeringClient) Delete(ctx context.Context, peerName string) error {
+	_, err := c.client.Peerings().Delete(ctx, peerName, nil)
+	if err != nil {
+		return fmt.Errorf("error deleting peering %s: %w", peerName, err)
+	}
+	return nil
+}
+
+// ImportedServices returns the names of the services the given peer has exported
+// to the local cluster, sorted alphabetically. The services returned can be
+// targeted with an Instancer by setting the Peer field on InstancerConfig.
+func (c PeeringClient) ImportedServices(ctx context.Context, peerName string) ([]string, error) {
+	if strings.TrimSpace(peerName) == "" {
+		return nil, invalidConfig("a peer name must be specified")
+	}
+	q := &api.QueryOptions{Peer: peerName}
+	services, _, err := c.client.Catalog().Services(q.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving services imported from peer %s: %w", peerName, err)
+	}
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,267 @@
+// Package pflag binds the values of pflag flags, as used by cobra, to keys in
+// Consul KV store. Flags marked dynamic are kept up to date as the keys change,
+// allowing CLI daemons to expose runtime tunables managed in Consul.
+//
+//	cmd.Flags().Int("workers", 4, "number of workers")
+//	_ = kpflag.MarkDynamic(cmd.Flags(), "workers")
+//	cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+//		b := kpflag.New(client, cmd.Flags(), "config/daemon", kpflag.Options{})
+//		if err := b.Load(); err != nil {
+//			return err
+//		}
+//		return b.Watch()
+//	}
+package pflag
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/api/watch"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/pflag"
+
+	"github.com/jkratz55/konsul"
+)
+
+// DynamicAnnotation is the flag annotation marking a flag as dynamic.
+const DynamicAnnotation = "konsul_dynamic"
+
+// MarkDynamic marks the flag with the provided name as dynamic. The values of
+// dynamic flags are updated by Binder.Watch each time their key changes.
+func MarkDynamic(fs *pflag.FlagSet, name string) error {
+	return fs.SetAnnotation(name, DynamicAnnotation, []string{"true"})
+}
+
+// IsDynamic returns true if the flag was marked dynamic with MarkDynamic.
+func IsDynamic(f *pflag.Flag) bool {
+	_, ok := f.Annotations[DynamicAnnotation]
+	return ok
+}
+
+// Options holds optional configuration properties for Binder.
+type Options struct {
+	// Determines how Consul client interacts with Consul servers. When true any
+	// Consul server can be queried. Otherwise, all queries go to the leader.
+	AllowStale bool
+	// Maps the name of a flag to the key under the prefix holding its value.
+	// If not provided the flag name is used as is, so the flag "workers" is
+	// read from the key "<prefix>/workers".
+	KeyFunc func(name string) string
+	// An optional callback invoked each time Watch updates a dynamic flag.
+	OnChange func(f *pflag.Flag)
+	// The logger used to log updates and errors while watching. If not
+	// provided a default logger will be used.
+	Logger konsul.Logger
+}
+
+// Binder binds the flags of a pflag FlagSet to keys under a prefix in Consul
+// KV store.
+//
+// Flags set explicitly on the command line always take precedence and are
+// never overwritten by values from Consul.
+//
+// The zero-value of Binder is not usable. Use New to create and initialize a
+// Binder.
+type Binder struct {
+	client   *api.Client
+	fs       *pflag.FlagSet
+	prefix   string
+	stale    bool
+	keyFunc  func(string) string
+	onChange func(*pflag.Flag)
+	logger   hclog.Logger
+
+	mu      sync.RWMutex
+	plan    *watch.Plan
+	running sync.WaitGroup
+}
+
+// New creates and initializes a Binder for the provided FlagSet and prefix. A
+// nil client or FlagSet will cause a panic.
+func New(client *api.Client, fs *pflag.FlagSet, prefix string, opts Options) *Binder {
+	if client == nil {
+		panic("cannot provide nil consul api.Client, illegal use of api")
+	}
+	if fs == nil {
+		panic("cannot provide nil pflag.FlagSet, illegal use of api")
+	}
+	if opts.KeyFunc == nil {
+		opts.KeyFunc = func(name string) string {
+			return name
+		}
+	}
+	return &Binder{
+		client:   client,
+		fs:       fs,
+		prefix:   strings.TrimSuffix(prefix, "/") + "/",
+		stale:    opts.AllowStale,
+		keyFunc:  opts.KeyFunc,
+		onChange: opts.OnChange,
+		logger:   konsul.HclogAdapter(opts.Logger),
+	}
+}
+
+// Load sets the value of every flag not set on the command line from the key
+// under the prefix named after the flag. Flags without a corresponding key are
+// left unchanged.
+//
+// If the keys cannot be retrieved from Consul, or a value is invalid for its
+// flag, a non-nil error is returned.
+func (b *Binder) Load() error {
+	pairs, _, err := b.client.KV().List(b.prefix, &api.QueryOptions{
+		AllowStale: b.stale,
+	})
+	if err != nil {
+		return fmt.Errorf("error listing keys with prefix %s from Consul: %w", b.prefix, err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err = b.apply(pairs, false)
+	return err
+}
+
+// Watch watches the prefix and updates the values of dynamic flags not set on
+// the command line as their keys change. Watch doesn't block, use Stop to stop
+// watching.
+//
+// Values are applied while holding a lock, so dynamic flags should be read
+// with Value or Lookup to avoid data races.
+func (b *Binder) Watch() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.plan != nil {
+		return fmt.Errorf("binder is already watching prefix %s", b.prefix)
+	}
+	plan, err := watch.Parse(map[string]any{
+		"type":   "keyprefix",
+		"prefix": b.prefix,
+		"stale":  b.stale,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to parse watch plan: %w", err)
+	}
+	plan.Handler = func(_ uint64, raw any) {
+		pairs, ok := raw.(api.KVPairs)
+		if !ok {
+			b.logger.Error(fmt.Sprintf("expected type api.KVPairs but got %T", raw))
+			return
+		}
+		b.mu.Lock()
+		changed, err := b.apply(pairs, true)
+		b.mu.Unlock()
+		if err != nil {
+			b.logger.Error("failed to update dynamic flag", "error", err)
+		}
+		for _, f := range changed {
+			b.logger.Info("dynamic flag updated from Consul",
+				"flag", f.Name,
+				"value", f.Value.String())
+			if b.onChange != nil {
+				b.onChange(f)
+			}
+		}
+	}
+	b.plan = plan
+
+	b.running.Add(1)
+	go func() {
+		defer b.running.Done()
+		if err := plan.RunWithClientAndHclog(b.client, b.logger); err != nil {
+			b.logger.Error("watch plan stopped with an error, no longer receiving updates",
+				"prefix", b.prefix,
+				"error", err)
+		}
+	}()
+	return nil
+}
+
+// Stop stops watching the prefix, waiting for the goroutine executing the
+// watch to exit.
+func (b *Binder) Stop() {
+	b.mu.Lock()
+	plan := b.plan
+	b.mu.Unlock()
+	if plan != nil {
+		plan.Stop()
+	}
+	b.running.Wait()
+}
+
+// Value returns the string representation of the current value of the flag
+// with the provided name and true, or false if the flag doesn't exist. Value is
+// safe to call while watching.
+func (b *Binder) Value(name string) (string, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	f := b.fs.Lookup(name)
+	if f == nil {
+		return "", false
+	}
+	return f.Value.String(), true
+}
+
+// Lookup invokes fn with the FlagSet while holding a read lock, allowing typed
+// getters such as FlagSet.GetInt to be used safely while watching.
+func (b *Binder) Lookup(fn func(fs *pflag.FlagSet)) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	fn(b.fs)
+}
+
+// apply sets the flags from the KVPairs and returns the flags whose value
+// changed. When dynamicOnly is true only flags marked dynamic are set. All
+// valid values are applied and the first error is returned.
+func (b *Binder) apply(pairs api.KVPairs, dynamicOnly bool) ([]*pflag.Flag, error) {
+	pairs, err := konsul.ReassembleChunks(pairs)
+	if err != nil {
+		return nil, fmt.Errorf("error reassembling chunked values under prefix %s: %w", b.prefix, err)
+	}
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		values[strings.TrimPrefix(pair.Key, b.prefix)] = strings.TrimSpace(string(pair.Value))
+	}
+
+	var changed []*pflag.Flag
+	var firstErr error
+	b.fs.VisitAll(func(f *pflag.Flag) {
+		if f.Changed || (dynamicOnly && !IsDynamic(f)) {
+			return
+		}
+		value, ok := values[b.keyFunc(f.Name)]
+		if !ok || value == current(f) {
+			return
+		}
+		if err := set(f, value); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("invalid value %q for flag --%s from key %s: %w",
+					value, f.Name, b.prefix+b.keyFunc(f.Name), err)
+			}
+			return
+		}
+		changed = append(changed, f)
+	})
+	return changed, firstErr
+}
+
+// current returns the value of the flag in the same form it's stored in Consul.
+func current(f *pflag.Flag) string {
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		return strings.Join(sv.GetSlice(), ",")
+	}
+	return f.Value.String()
+}
+
+// set sets the value of the flag. Slice values are replaced rather than
+// appended to since pflag slice values append on every Set after the first.
+func set(f *pflag.Flag, value string) error {
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		if value == "" {
+			return sv.Replace([]string{})
+		}
+		return sv.Replace(strings.Split(value, ","))
+	}
+	return f.Value.Set(value)
+}
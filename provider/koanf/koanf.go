@@ -0,0 +1,218 @@
+// Package koanf implements a koanf Provider backed by Consul KV store, allowing
+// applications using koanf to layer configuration stored in Consul with files,
+// environment variables, and other sources.
+//
+// Provider satisfies koanf's Provider interface structurally so this package
+// doesn't depend on koanf.
+//
+//	k := koanf.New(".")
+//	p := kkoanf.New(kkoanf.Config{Client: client, Key: "config/app"})
+//	if err := k.Load(p, json.Parser()); err != nil {
+//		panic(err)
+//	}
+//	p.Watch(func(_ any, err error) {
+//		if err == nil {
+//			_ = k.Load(p, json.Parser())
+//		}
+//	})
+package koanf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/api/watch"
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/jkratz55/konsul"
+)
+
+// Config holds the configuration properties to create a Provider.
+type Config struct {
+	// The Consul api Client to use to communicate with Consul. This is a required
+	// field. Providing a nil value will lead to a panic.
+	Client *api.Client
+	// The key holding the configuration. The value is returned by ReadBytes to
+	// be parsed by a koanf Parser. Exactly one of Key or Prefix must be set.
+	Key string
+	// The prefix of the keys holding the configuration. The keys under the
+	// prefix are returned by Read as a nested map where each segment of the key
+	// after the prefix separated by "/" is a level in the map. Exactly one of
+	// Key or Prefix must be set.
+	Prefix string
+	// Determines how Consul client interacts with Consul servers. When true any
+	// Consul server can be queried. Otherwise, all queries go to the leader.
+	AllowStale bool
+	// The logger used to log events and errors while watching Consul. If not
+	// provided a default logger will be used.
+	Logger konsul.Logger
+}
+
+func (c *Config) validate() {
+	if c.Client == nil {
+		panic("cannot provide nil consul api.Client, illegal use of api")
+	}
+	if (c.Key == "") == (c.Prefix == "") {
+		panic("exactly one of Key or Prefix must be provided, illegal use of api")
+	}
+}
+
+// Provider is a koanf Provider reading configuration from Consul KV store.
+//
+// The zero-value of Provider is not usable. Use New to create and initialize a
+// Provider.
+type Provider struct {
+	client *api.Client
+	kv     *konsul.KVClient
+	logger hclog.Logger
+	key    string
+	prefix string
+	stale  bool
+
+	mu      sync.Mutex
+	plan    *watch.Plan
+	running sync.WaitGroup
+}
+
+// New creates and initializes a Provider with the provided configuration. If
+// the configuration is invalid (misusing the API) this will panic.
+func New(config Config) *Provider {
+	config.validate()
+	logger := konsul.HclogAdapter(config.Logger)
+	return &Provider{
+		client: config.Client,
+		kv: konsul.NewKVClient(config.Client, konsul.KVClientOptions{
+			Logger: logger,
+		}),
+		logger: logger,
+		key:    config.Key,
+		prefix: config.Prefix,
+		stale:  config.AllowStale,
+	}
+}
+
+// ReadBytes returns the value of the configured key. If the Provider was
+// configured with a prefix an error is returned, use Read instead.
+func (p *Provider) ReadBytes() ([]byte, error) {
+	if p.key == "" {
+		return nil, errors.New("konsul koanf provider configured with a prefix does not support ReadBytes")
+	}
+	kv, err := p.kv.Get(p.key, p.stale)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving key %s from Consul: %w", p.key, err)
+	}
+	if kv.Unwrap() == nil {
+		return nil, fmt.Errorf("key %s: %w", p.key, konsul.ErrKeyNotFound)
+	}
+	return kv.RawValue(), nil
+}
+
+// Read returns the keys under the configured prefix as a nested map. If the
+// Provider was configured with a key an error is returned, use ReadBytes
+// instead.
+func (p *Provider) Read() (map[string]any, error) {
+	if p.prefix == "" {
+		return nil, errors.New("konsul koanf provider configured with a key does not support Read")
+	}
+	pairs, _, err := p.client.KV().List(p.prefix, &api.QueryOptions{
+		AllowStale: p.stale,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing keys with prefix %s from Consul: %w", p.prefix, err)
+	}
+	pairs, err = konsul.ReassembleChunks(pairs)
+	if err != nil {
+		return nil, fmt.Errorf("error reassembling chunked values under prefix %s: %w", p.prefix, err)
+	}
+	return toMap(p.prefix, pairs), nil
+}
+
+// Watch watches the configured key or prefix and invokes cb each time it
+// changes. The event passed to cb is always nil. If watching fails cb is
+// invoked with the error and Watch stops. Watch doesn't block.
+//
+// Watch can only be called once per Provider. Use Stop to stop watching.
+func (p *Provider) Watch(cb func(event any, err error)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.plan != nil {
+		return errors.New("konsul koanf provider is already watching")
+	}
+
+	params := map[string]any{
+		"stale": p.stale,
+	}
+	if p.key != "" {
+		params["type"] = "key"
+		params["key"] = p.key
+	} else {
+		params["type"] = "keyprefix"
+		params["prefix"] = p.prefix
+	}
+	plan, err := watch.Parse(params)
+	if err != nil {
+		return fmt.Errorf("failed to parse watch plan: %w", err)
+	}
+
+	// The first invocation of the handler is the current state which has
+	// already been loaded by the application so it isn't reported.
+	first := true
+	plan.Handler = func(_ uint64, _ any) {
+		if first {
+			first = false
+			return
+		}
+		cb(nil, nil)
+	}
+	p.plan = plan
+
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		if err := plan.RunWithClientAndHclog(p.client, p.logger); err != nil {
+			p.logger.Error("watch plan stopped with an error, no longer receiving updates",
+				"error", err)
+			cb(nil, err)
+		}
+	}()
+	return nil
+}
+
+// Stop stops watching the configured key or prefix, waiting for the goroutine
+// executing the watch to exit.
+func (p *Provider) Stop() {
+	p.mu.Lock()
+	plan := p.plan
+	p.mu.Unlock()
+	if plan != nil {
+		plan.Stop()
+	}
+	p.running.Wait()
+}
+
+// toMap converts the KVPairs under the prefix to a nested map. Keys ending in
+// "/" are folders in Consul and are skipped.
+func toMap(prefix string, pairs api.KVPairs) map[string]any {
+	root := make(map[string]any)
+	for _, pair := range pairs {
+		path := strings.Trim(strings.TrimPrefix(pair.Key, prefix), "/")
+		if path == "" || strings.HasSuffix(pair.Key, "/") {
+			continue
+		}
+		segments := strings.Split(path, "/")
+		m := root
+		for _, seg := range segments[:len(segments)-1] {
+			child, ok := m[seg].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				m[seg] = child
+			}
+			m = child
+		}
+		m[segments[len(segments)-1]] = string(pair.Value)
+	}
+	return root
+}
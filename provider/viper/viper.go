@@ -0,0 +1,195 @@
+// Package viper implements Viper's remote configuration provider backed by
+// konsul, allowing applications using Viper to consume configuration stored in
+// Consul KV store.
+//
+// The provider is enabled by calling Register in place of the blank import of
+// the viper/remote package. Remote providers are added to Viper as usual with
+// the "consul" provider, where the path is the key in Consul KV store. The
+// endpoint is ignored since the Consul api Client provided to Register is used.
+//
+//	kviper.Register(client, kviper.Options{})
+//	v := viper.New()
+//	_ = v.AddRemoteProvider("consul", "localhost:8500", "config/app")
+//	v.SetConfigType("json")
+//	err := v.ReadRemoteConfig()
+package viper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/api/watch"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+
+	"github.com/jkratz55/konsul"
+	"github.com/jkratz55/konsul/internal/blocking"
+)
+
+// Options holds optional configuration properties for RemoteConfig.
+type Options struct {
+	// The logger used to log events and errors while watching keys in Consul.
+	// If not provided a default logger will be used.
+	Logger konsul.Logger
+	// Determines how Consul client interacts with Consul servers. When true any
+	// Consul server can be queried. Otherwise, all queries go to the leader.
+	AllowStale bool
+}
+
+// RemoteConfig implements Viper's remote configuration provider using konsul's
+// KVClient to fetch keys and Consul watch plans to watch keys for changes.
+//
+// The zero-value of RemoteConfig is not usable. Use New to create and
+// initialize a RemoteConfig.
+type RemoteConfig struct {
+	client *api.Client
+	kv     *konsul.KVClient
+	logger hclog.Logger
+	stale  bool
+
+	mu      sync.Mutex
+	indexes map[string]uint64
+}
+
+// New creates and initializes a RemoteConfig using the provided Consul api
+// Client. A nil client will cause a panic.
+func New(client *api.Client, opts Options) *RemoteConfig {
+	if client == nil {
+		panic("cannot provide nil consul api.Client, illegal use of api")
+	}
+	logger := konsul.HclogAdapter(opts.Logger)
+	return &RemoteConfig{
+		client: client,
+		kv: konsul.NewKVClient(client, konsul.KVClientOptions{
+			Logger: logger,
+		}),
+		logger:  logger,
+		stale:   opts.AllowStale,
+		indexes: make(map[string]uint64),
+	}
+}
+
+// Register creates a RemoteConfig and sets it as Viper's remote configuration
+// provider. Since Viper holds the remote configuration provider in a package
+// level variable, this affects every Viper instance.
+func Register(client *api.Client, opts Options) *RemoteConfig {
+	rc := New(client, opts)
+	viper.RemoteConfig = rc
+	return rc
+}
+
+// Get fetches the value of the key at the path of the RemoteProvider. If the key
+// doesn't exist konsul.ErrKeyNotFound is returned.
+func (r *RemoteConfig) Get(rp viper.RemoteProvider) (io.Reader, error) {
+	kv, err := r.kv.Get(rp.Path(), r.stale)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving key %s from Consul: %w", rp.Path(), err)
+	}
+	if kv.Unwrap() == nil {
+		return nil, fmt.Errorf("key %s: %w", rp.Path(), konsul.ErrKeyNotFound)
+	}
+	r.setIndex(rp.Path(), kv.ModifyIndex())
+	return bytes.NewReader(kv.RawValue()), nil
+}
+
+// Watch blocks until the key at the path of the RemoteProvider changes since it
+// was last retrieved with Get or Watch and returns the new value. The first
+// call for a key that hasn't been retrieved returns immediately.
+func (r *RemoteConfig) Watch(rp viper.RemoteProvider) (io.Reader, error) {
+	key := rp.Path()
+	for {
+		var index blocking.Index
+		index.Seed(r.getIndex(key))
+		kv, meta, err := r.client.KV().Get(key, &api.QueryOptions{
+			AllowStale: r.stale,
+			WaitIndex:  index.Wait(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error watching key %s in Consul: %w", key, err)
+		}
+		changed := index.Update(meta.LastIndex)
+		r.setIndex(key, index.Wait())
+		if !changed {
+			continue
+		}
+		if kv == nil {
+			return nil, fmt.Errorf("key %s: %w", key, konsul.ErrKeyNotFound)
+		}
+		return bytes.NewReader(kv.Value), nil
+	}
+}
+
+// WatchChannel watches the key at the path of the RemoteProvider and sends the
+// value on the returned channel each time it changes, starting with the current
+// value. Sending on or closing the returned quit channel stops watching the key.
+//
+// Viper reads every value sent on the channel, including errors, as new
+// configuration. To avoid wiping out the configuration errors are logged rather
+// than sent, and the value channel is never closed.
+func (r *RemoteConfig) WatchChannel(rp viper.RemoteProvider) (<-chan *viper.RemoteResponse, chan bool) {
+	key := rp.Path()
+	respc := make(chan *viper.RemoteResponse)
+	quit := make(chan bool)
+
+	plan, err := watch.Parse(map[string]any{
+		"type":  "key",
+		"key":   key,
+		"stale": r.stale,
+	})
+	if err != nil {
+		// Parsing a key watch only fails on invalid params which would be a bug
+		// in this package.
+		panic(fmt.Errorf("failed to parse watch plan: %w", err))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	plan.Handler = func(_ uint64, raw any) {
+		if raw == nil {
+			r.logger.Warn("watched key doesn't exist in Consul", "key", key)
+			return
+		}
+		kv, ok := raw.(*api.KVPair)
+		if !ok {
+			r.logger.Error(fmt.Sprintf("expected type *api.KVPair but got %T", raw))
+			return
+		}
+		select {
+		case respc <- &viper.RemoteResponse{Value: kv.Value}:
+		case <-ctx.Done():
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := plan.RunWithClientAndHclog(r.client, r.logger); err != nil {
+			r.logger.Error("watch plan stopped with an error, no longer receiving updates",
+				"key", key,
+				"error", err)
+		}
+	}()
+	go func() {
+		<-quit
+		cancel()
+		plan.Stop()
+		<-done
+	}()
+
+	return respc, quit
+}
+
+func (r *RemoteConfig) getIndex(key string) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.indexes[key]
+}
+
+func (r *RemoteConfig) setIndex(key string, index uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.indexes[key] = index
+}
@@ -0,0 +1,208 @@
+// Package proxy provides an httputil.ReverseProxy backed by konsul service
+// discovery, allowing small API gateways to be built directly on Instancer.
+//
+// An instance of the service is selected for each request. If connecting to
+// the selected instance fails, the request is retried against the next
+// instance since the request never reached the upstream service.
+//
+//	instancer, _ := konsul.NewInstancer(konsul.InstancerConfig{
+//		Client:      client,
+//		Service:     "orders",
+//		PassingOnly: true,
+//	})
+//	http.Handle("/orders/", proxy.New(proxy.Config{Balancer: instancer}))
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/jkratz55/konsul"
+)
+
+// ErrNoInstances is returned when there are no instances of the service to
+// proxy a request to.
+var ErrNoInstances = errors.New("no instances available")
+
+// Balancer yields an instance of a service as host:port for each call.
+// *konsul.Instancer implements Balancer.
+type Balancer interface {
+	Instance() (string, bool)
+}
+
+var _ Balancer = (*konsul.Instancer)(nil)
+
+// Config holds the configuration properties to create a reverse proxy.
+type Config struct {
+	// Selects the instance each request is proxied to. This is a required
+	// field. Providing a nil value will lead to a panic.
+	Balancer Balancer
+	// The scheme used to connect to instances. If not provided "http" is used.
+	Scheme string
+	// The maximum number of additional instances a request is retried against
+	// when connecting to an instance fails. If not provided 2 is used. Use a
+	// negative value to disable retries.
+	MaxRetries int
+	// The RoundTripper used to send requests to instances. If not provided
+	// http.DefaultTransport is used.
+	Transport http.RoundTripper
+	// An optional function to further modify requests after the instance has
+	// been selected, such as to strip a path prefix or set headers.
+	Director func(req *http.Request)
+	// The logger used to log retries and errors. If not provided a default
+	// logger will be used.
+	Logger konsul.Logger
+}
+
+func (c *Config) validate() {
+	if c.Balancer == nil {
+		panic("cannot provide nil Balancer, illegal use of api")
+	}
+	if c.Scheme == "" {
+		c.Scheme = "http"
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = 2
+	}
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	}
+	if c.Transport == nil {
+		c.Transport = http.DefaultTransport
+	}
+}
+
+// New creates a reverse proxy forwarding requests to instances yielded by the
+// Balancer. Requests are answered with 503 Service Unavailable when there are
+// no instances, and 502 Bad Gateway when the request cannot be proxied. If the
+// configuration is invalid (misusing the API) this will panic.
+//
+// The returned ReverseProxy can be further customized, such as by setting
+// ModifyResponse, before it's used.
+func New(config Config) *httputil.ReverseProxy {
+	config.validate()
+	logger := konsul.HclogAdapter(config.Logger)
+
+	director := func(req *http.Request) {
+		req.URL.Scheme = config.Scheme
+		instance, ok := config.Balancer.Instance()
+		if !ok {
+			*req = *req.WithContext(context.WithValue(req.Context(), noInstancesKey{}, true))
+		}
+		req.URL.Host = instance
+		if _, ok := req.Header["User-Agent"]; !ok {
+			// Prevent the default User-Agent from being set, matching
+			// httputil.NewSingleHostReverseProxy.
+			req.Header.Set("User-Agent", "")
+		}
+		if config.Director != nil {
+			config.Director(req)
+		}
+	}
+
+	return &httputil.ReverseProxy{
+		Director: director,
+		Transport: &Transport{
+			Balancer:   config.Balancer,
+			Next:       config.Transport,
+			MaxRetries: config.MaxRetries,
+			logger:     logger,
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			if errors.Is(err, ErrNoInstances) {
+				logger.Warn("no instances available to proxy request",
+					"path", req.URL.Path)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			logger.Error("failed to proxy request",
+				"host", req.URL.Host,
+				"path", req.URL.Path,
+				"error", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+}
+
+type noInstancesKey struct{}
+
+// Transport is an http.RoundTripper retrying requests against the next instance
+// yielded by the Balancer when connecting to an instance fails. Requests with a
+// body are only retried if the body can be replayed using Request.GetBody.
+//
+// Transport is used by New but can also be used directly with an http.Client
+// or a custom ReverseProxy.
+type Transport struct {
+	// Selects the instance requests are retried against. This is a required
+	// field.
+	Balancer Balancer
+	// The RoundTripper used to send requests. If nil http.DefaultTransport is
+	// used.
+	Next http.RoundTripper
+	// The maximum number of additional instances a request is retried against.
+	MaxRetries int
+
+	logger hclog.Logger
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if none, _ := req.Context().Value(noInstancesKey{}).(bool); none || req.URL.Host == "" {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, ErrNoInstances
+	}
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	// The RoundTripper closes the body even on errors, so a body that can't be
+	// replayed prevents retries.
+	canRetry := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	resp, err := next.RoundTrip(req)
+	for attempt := 0; err != nil && canRetry && isDialError(err) && attempt < t.MaxRetries; attempt++ {
+		failed := req.URL.Host
+		instance, ok := t.Balancer.Instance()
+		if !ok {
+			break
+		}
+		if t.logger != nil {
+			t.logger.Warn("failed to connect to instance, retrying against next instance",
+				"failed", failed,
+				"instance", instance,
+				"error", err)
+		}
+
+		retry := req.Clone(req.Context())
+		retry.URL.Host = instance
+		if req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, fmt.Errorf("error replaying request body: %w", bodyErr)
+			}
+			retry.Body = body
+		}
+		req = retry
+		resp, err = next.RoundTrip(req)
+	}
+	return resp, err
+}
+
+// isDialError returns true if the error occurred while connecting, meaning the
+// request was never sent.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "dial"
+	}
+	return false
+}
@@ -0,0 +1,348 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/jkratz55/konsul"
+	"github.com/jkratz55/konsul/internal/blocking"
+)
+
+// metaVersion is the key of the service metadata holding the Service version.
+const metaVersion = "version"
+
+// Options holds optional configuration properties for ConsulRegistry.
+type Options struct {
+	// Determines how Consul client interacts with Consul servers. When true any
+	// Consul server can be queried. Otherwise, all queries go to the leader.
+	AllowStale bool
+	// The logger used to log registrations. If not provided a default logger
+	// will be used.
+	Logger konsul.Logger
+}
+
+// ConsulRegistry is a Registry backed by Consul. Services are registered with
+// the local Consul agent and discovered through Consul's health and catalog
+// endpoints.
+//
+// The version of a Service is stored in the service metadata with the key
+// "version" along with the metadata of the Service and Node.
+type ConsulRegistry struct {
+	client *api.Client
+	stale  bool
+	logger hclog.Logger
+}
+
+var _ Registry = (*ConsulRegistry)(nil)
+
+// NewConsulRegistry creates and initializes a ConsulRegistry using the provided
+// Consul api Client. A nil client will cause a panic.
+func NewConsulRegistry(client *api.Client, opts Options) *ConsulRegistry {
+	if client == nil {
+		panic("cannot provide nil consul api.Client, illegal use of api")
+	}
+	return &ConsulRegistry{
+		client: client,
+		stale:  opts.AllowStale,
+		logger: konsul.HclogAdapter(opts.Logger),
+	}
+}
+
+func (r *ConsulRegistry) Register(svc *Service, opts ...RegisterOption) error {
+	if svc == nil || svc.Name == "" {
+		return fmt.Errorf("%w: a service with a name must be provided", konsul.ErrInvalidConfig)
+	}
+	var o RegisterOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	for _, node := range svc.Nodes {
+		host, portStr, err := net.SplitHostPort(node.Address)
+		if err != nil {
+			return fmt.Errorf("invalid address %s for node %s: %w", node.Address, node.ID, err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return fmt.Errorf("invalid port %s for node %s: %w", portStr, node.ID, err)
+		}
+
+		meta := make(map[string]string, len(svc.Metadata)+len(node.Metadata)+1)
+		for k, v := range svc.Metadata {
+			meta[k] = v
+		}
+		for k, v := range node.Metadata {
+			meta[k] = v
+		}
+		meta[metaVersion] = svc.Version
+
+		reg := &api.AgentServiceRegistration{
+			ID:      node.ID,
+			Name:    svc.Name,
+			Address: host,
+			Port:    port,
+			Meta:    meta,
+		}
+		if o.TTL > 0 {
+			reg.Check = &api.AgentServiceCheck{
+				CheckID:                        ttlCheckID(node.ID),
+				TTL:                            o.TTL.String(),
+				DeregisterCriticalServiceAfter: (o.TTL * 10).String(),
+			}
+		}
+		if err := r.client.Agent().ServiceRegister(reg); err != nil {
+			return fmt.Errorf("error registering node %s of service %s: %w", node.ID, svc.Name, err)
+		}
+		if o.TTL > 0 {
+			if err := r.client.Agent().PassTTL(ttlCheckID(node.ID), ""); err != nil {
+				return fmt.Errorf("error passing TTL check of node %s: %w", node.ID, err)
+			}
+		}
+		r.logger.Debug("registered node with Consul",
+			"service", svc.Name,
+			"node", node.ID)
+	}
+	return nil
+}
+
+func (r *ConsulRegistry) Deregister(svc *Service) error {
+	if svc == nil {
+		return fmt.Errorf("%w: a service must be provided", konsul.ErrInvalidConfig)
+	}
+	for _, node := range svc.Nodes {
+		if err := r.client.Agent().ServiceDeregister(node.ID); err != nil {
+			return fmt.Errorf("error deregistering node %s of service %s: %w", node.ID, svc.Name, err)
+		}
+		r.logger.Debug("deregistered node from Consul",
+			"service", svc.Name,
+			"node", node.ID)
+	}
+	return nil
+}
+
+func (r *ConsulRegistry) GetService(name string) ([]*Service, error) {
+	entries, _, err := r.client.Health().Service(name, "", true, &api.QueryOptions{
+		AllowStale: r.stale,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving service %s from Consul: %w", name, err)
+	}
+	if len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+	return toServices(name, entries), nil
+}
+
+func (r *ConsulRegistry) ListServices() ([]*Service, error) {
+	names, _, err := r.client.Catalog().Services(&api.QueryOptions{
+		AllowStale: r.stale,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing services from Consul: %w", err)
+	}
+	services := make([]*Service, 0, len(names))
+	for name := range names {
+		services = append(services, &Service{Name: name})
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+	return services, nil
+}
+
+func (r *ConsulRegistry) Watch(service string) (Watcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &consulWatcher{
+		registry: r,
+		service:  service,
+		ctx:      ctx,
+		cancel:   cancel,
+		known:    make(map[string][]*Service),
+	}, nil
+}
+
+func (r *ConsulRegistry) String() string {
+	return "consul"
+}
+
+func ttlCheckID(nodeID string) string {
+	return "service:" + nodeID + ":ttl"
+}
+
+// toServices groups the service entries by version.
+func toServices(name string, entries []*api.ServiceEntry) []*Service {
+	byVersion := make(map[string]*Service)
+	versions := make([]string, 0)
+	for _, entry := range entries {
+		meta := make(map[string]string, len(entry.Service.Meta))
+		for k, v := range entry.Service.Meta {
+			meta[k] = v
+		}
+		version := meta[metaVersion]
+		delete(meta, metaVersion)
+
+		svc, ok := byVersion[version]
+		if !ok {
+			svc = &Service{
+				Name:     name,
+				Version:  version,
+				Metadata: make(map[string]string),
+			}
+			byVersion[version] = svc
+			versions = append(versions, version)
+		}
+
+		addr := entry.Node.Address
+		if entry.Service.Address != "" {
+			addr = entry.Service.Address
+		}
+		svc.Nodes = append(svc.Nodes, &Node{
+			ID:       entry.Service.ID,
+			Address:  net.JoinHostPort(addr, strconv.Itoa(entry.Service.Port)),
+			Metadata: meta,
+		})
+	}
+	sort.Strings(versions)
+	services := make([]*Service, 0, len(versions))
+	for _, v := range versions {
+		svc := byVersion[v]
+		sort.Slice(svc.Nodes, func(i, j int) bool {
+			return svc.Nodes[i].ID < svc.Nodes[j].ID
+		})
+		services = append(services, svc)
+	}
+	return services
+}
+
+// consulWatcher performs blocking queries against Consul each time Next is
+// called and there are no pending results. When watching a single service the
+// healthy instances of the service are queried, otherwise the catalog of
+// services is queried.
+type consulWatcher struct {
+	registry *ConsulRegistry
+	service  string
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	mu      sync.Mutex
+	index   blocking.Index
+	known   map[string][]*Service
+	pending []*Result
+}
+
+func (w *consulWatcher) Next() (*Result, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.pending) == 0 {
+		if w.ctx.Err() != nil {
+			return nil, ErrWatcherStopped
+		}
+		if err := w.poll(); err != nil {
+			if w.ctx.Err() != nil {
+				return nil, ErrWatcherStopped
+			}
+			return nil, err
+		}
+	}
+	res := w.pending[0]
+	w.pending = w.pending[1:]
+	return res, nil
+}
+
+func (w *consulWatcher) Stop() {
+	w.cancel()
+}
+
+func (w *consulWatcher) poll() error {
+	opts := (&api.QueryOptions{
+		AllowStale: w.registry.stale,
+		WaitIndex:  w.index.Wait(),
+	}).WithContext(w.ctx)
+
+	current := make(map[string][]*Service)
+	var meta *api.QueryMeta
+	if w.service != "" {
+		entries, m, err := w.registry.client.Health().Service(w.service, "", true, opts)
+		if err != nil {
+			return err
+		}
+		meta = m
+		if len(entries) > 0 {
+			current[w.service] = toServices(w.service, entries)
+		}
+	} else {
+		names, m, err := w.registry.client.Catalog().Services(opts)
+		if err != nil {
+			return err
+		}
+		meta = m
+		for name := range names {
+			current[name] = []*Service{{Name: name}}
+		}
+	}
+
+	w.index.Update(meta.LastIndex)
+	w.diff(current)
+	return nil
+}
+
+// diff queues a Result for each service created, updated, or deleted since the
+// last poll.
+func (w *consulWatcher) diff(current map[string][]*Service) {
+	names := make([]string, 0, len(current)+len(w.known))
+	for name := range current {
+		names = append(names, name)
+	}
+	for name := range w.known {
+		if _, ok := current[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		prev, existed := w.known[name]
+		cur, exists := current[name]
+		switch {
+		case !existed && exists:
+			for _, svc := range cur {
+				w.pending = append(w.pending, &Result{Action: Create, Service: svc})
+			}
+		case existed && !exists:
+			for _, svc := range prev {
+				w.pending = append(w.pending, &Result{Action: Delete, Service: svc})
+			}
+		case w.service != "" && !equal(prev, cur):
+			// Only single service watches know the Nodes of a service, so
+			// updates can't be detected when watching all services.
+			for _, svc := range cur {
+				w.pending = append(w.pending, &Result{Action: Update, Service: svc})
+			}
+		}
+	}
+	w.known = current
+}
+
+func equal(a, b []*Service) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Version != b[i].Version || len(a[i].Nodes) != len(b[i].Nodes) {
+			return false
+		}
+		for j := range a[i].Nodes {
+			if a[i].Nodes[j].ID != b[i].Nodes[j].ID || a[i].Nodes[j].Address != b[i].Nodes[j].Address {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+// Package registry exposes service registration and discovery in Consul through
+// a small, konsul-specific Registry interface inspired by the go-micro
+// registry.Registry.
+//
+// Registry is not a drop-in replacement for go-micro's interface: it omits
+// Init, Options, endpoints, and the per-call options of go-micro, and this
+// package intentionally doesn't import go-micro to avoid forcing its
+// dependencies on every konsul user. Frameworks programming against go-micro's
+// interface need an adapter converting between the types.
+package registry
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is a sentinel error value indicating the service doesn't exist.
+var ErrNotFound = errors.New("service not found")
+
+// ErrWatcherStopped is returned by Watcher.Next once the Watcher is stopped.
+var ErrWatcherStopped = errors.New("watcher stopped")
+
+// Registry registers services and discovers the instances of services.
+type Registry interface {
+	// Register registers every Node of the Service.
+	Register(svc *Service, opts ...RegisterOption) error
+	// Deregister deregisters every Node of the Service.
+	Deregister(svc *Service) error
+	// GetService returns the healthy Nodes of the service grouped by version.
+	// If the service has no healthy Nodes ErrNotFound is returned.
+	GetService(name string) ([]*Service, error)
+	// ListServices returns all known services without their Nodes.
+	ListServices() ([]*Service, error)
+	// Watch watches a service for changes. If service is empty all services
+	// are watched.
+	Watch(service string) (Watcher, error)
+	// String returns the name of the Registry implementation.
+	String() string
+}
+
+// Service is a named and versioned service made up of one or more Nodes.
+type Service struct {
+	Name     string            `json:"name"`
+	Version  string            `json:"version"`
+	Metadata map[string]string `json:"metadata"`
+	Nodes    []*Node           `json:"nodes"`
+}
+
+// Node is a single instance of a Service.
+type Node struct {
+	// The unique ID of the instance. When registering it's used as the service
+	// ID in Consul.
+	ID string `json:"id"`
+	// The address of the instance in host:port form.
+	Address  string            `json:"address"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// Action describes the change a Result represents.
+type Action string
+
+const (
+	Create Action = "create"
+	Update Action = "update"
+	Delete Action = "delete"
+)
+
+// Result is a change to a service yielded by a Watcher.
+type Result struct {
+	Action  Action
+	Service *Service
+}
+
+// Watcher yields changes to watched services.
+type Watcher interface {
+	// Next blocks until the next change is available. Once the Watcher is
+	// stopped ErrWatcherStopped is returned.
+	Next() (*Result, error)
+	// Stop stops watching and unblocks any call to Next.
+	Stop()
+}
+
+// RegisterOptions holds optional configuration properties for Register.
+type RegisterOptions struct {
+	// When set, a TTL check is registered with each Node and marked passing
+	// upon registration. The application must re-register before the TTL
+	// expires to remain healthy.
+	TTL time.Duration
+}
+
+// RegisterOption configures optional behavior of Register.
+type RegisterOption func(*RegisterOptions)
+
+// WithTTL registers a TTL check with each Node of the Service.
+func WithTTL(ttl time.Duration) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.TTL = ttl
+	}
+}
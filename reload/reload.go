@@ -0,0 +1,388 @@
+// Package reload provides a managed reload pipeline for application components
+// configured from a key in Consul KV store.
+//
+// Components implementing Reconfigurable register with a Manager watching the
+// key. Each time the key changes the value is decoded once and the components
+// are reconfigured in order. A component failing to reconfigure, or panicking,
+// doesn't prevent the remaining components from being reconfigured, and the
+// outcome of every component is reported.
+//
+//	m := reload.NewManager(reload.Config[AppConfig]{
+//		Client: client,
+//		Key:    "config/app",
+//	})
+//	m.Register("http", 0, httpServer)
+//	m.Register("cache", 10, cache)
+//	go func() {
+//		if err := m.Run(); err != nil {
+//			panic(err)
+//		}
+//	}()
+package reload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/api/watch"
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/jkratz55/konsul"
+)
+
+// Reconfigurable is implemented by application components that can apply a new
+// configuration at runtime.
+type Reconfigurable[T any] interface {
+	Reconfigure(ctx context.Context, cfg T) error
+}
+
+// ReconfigureFunc is an adapter to allow the use of ordinary functions as a
+// Reconfigurable.
+type ReconfigureFunc[T any] func(ctx context.Context, cfg T) error
+
+// Reconfigure calls f(ctx, cfg).
+func (f ReconfigureFunc[T]) Reconfigure(ctx context.Context, cfg T) error {
+	return f(ctx, cfg)
+}
+
+// Result is the outcome of reconfiguring a single component.
+type Result struct {
+	Component string
+	Duration  time.Duration
+	Err       error
+}
+
+// Report is the outcome of applying a change to the key to all registered
+// components.
+type Report struct {
+	Key string
+	// The modify index of the key in Consul.
+	Index uint64
+	// True if the value was discarded because a more recent value of the key
+	// had already been applied, such as when a Reload races with a change
+	// received by Run. No components were reconfigured.
+	Skipped bool
+	// Non-nil if the value of the key couldn't be decoded, in which case no
+	// components were reconfigured.
+	DecodeErr error
+	// The results of the components in the order they were reconfigured.
+	Results []Result
+}
+
+// Err returns a non-nil error if the value couldn't be decoded or any component
+// failed to reconfigure.
+func (r Report) Err() error {
+	if r.DecodeErr != nil {
+		return fmt.Errorf("error decoding value of key %s: %w", r.Key, r.DecodeErr)
+	}
+	var failed []string
+	var first error
+	for _, res := range r.Results {
+		if res.Err != nil {
+			if first == nil {
+				first = res.Err
+			}
+			failed = append(failed, res.Component)
+		}
+	}
+	if first == nil {
+		return nil
+	}
+	return fmt.Errorf("components %s failed to reconfigure: %w", strings.Join(failed, ", "), first)
+}
+
+// Config holds the configuration properties to create a Manager.
+type Config[T any] struct {
+	// The Consul api Client to use to communicate with Consul. This is a required
+	// field. Providing a nil value will lead to a panic.
+	Client *api.Client
+	// The key holding the configuration. This is a required field.
+	Key string
+	// Determines how Consul client interacts with Consul servers. When true any
+	// Consul server can be queried. Otherwise, all queries go to the leader.
+	AllowStale bool
+	// Decodes the value of the key. If not provided the value is decoded as JSON.
+	Decode func(data []byte) (T, error)
+	// The maximum time a single component is given to reconfigure. The context
+	// passed to Reconfigure is cancelled once it elapses. If not provided there
+	// is no timeout.
+	Timeout time.Duration
+	// An optional callback invoked with the Report of each change.
+	OnReport func(Report)
+	// The logger used to log events and errors. If not provided a default logger
+	// will be used.
+	Logger konsul.Logger
+}
+
+func (c *Config[T]) validate() {
+	if c.Client == nil {
+		panic("cannot provide nil consul api.Client, illegal use of api")
+	}
+	if strings.TrimSpace(c.Key) == "" {
+		panic("a key must be specified, illegal use of api")
+	}
+	if c.Decode == nil {
+		c.Decode = func(data []byte) (T, error) {
+			var cfg T
+			err := json.Unmarshal(data, &cfg)
+			return cfg, err
+		}
+	}
+}
+
+type component[T any] struct {
+	name  string
+	order int
+	seq   int
+	c     Reconfigurable[T]
+}
+
+// Manager watches a key and reconfigures the registered components each time
+// it changes.
+//
+// The zero-value of Manager is not usable. Use NewManager to create and
+// initialize a Manager.
+type Manager[T any] struct {
+	client   *api.Client
+	key      string
+	stale    bool
+	decode   func([]byte) (T, error)
+	timeout  time.Duration
+	onReport func(Report)
+	logger   hclog.Logger
+
+	mu         sync.Mutex
+	components []component[T]
+	current    T
+	loaded     bool
+	plan       *watch.Plan
+	// Set when Stop is called while the Manager isn't running, so a Run racing
+	// with Stop returns rather than watching indefinitely.
+	stopped bool
+
+	// applyMu serializes applying changes, so components are never
+	// reconfigured concurrently by Reload and Run.
+	applyMu sync.Mutex
+	// The modify index of the last value applied, guarded by applyMu.
+	lastIndex uint64
+}
+
+// NewManager creates and initializes a Manager with the provided configuration.
+// If the configuration is invalid (misusing the API) this will panic.
+func NewManager[T any](config Config[T]) *Manager[T] {
+	config.validate()
+	return &Manager[T]{
+		client:   config.Client,
+		key:      config.Key,
+		stale:    config.AllowStale,
+		decode:   config.Decode,
+		timeout:  config.Timeout,
+		onReport: config.OnReport,
+		logger:   konsul.HclogAdapter(config.Logger),
+	}
+}
+
+// Register registers a component to be reconfigured each time the key changes.
+// Components are reconfigured in ascending order, and components with the same
+// order are reconfigured in the order they were registered.
+//
+// Registering a component after the configuration has been loaded doesn't
+// reconfigure it until the next change. Registering a nil component or a
+// component with an empty name will panic.
+func (m *Manager[T]) Register(name string, order int, c Reconfigurable[T]) {
+	if c == nil || name == "" {
+		panic("a component with a name must be provided, illegal use of api")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.components = append(m.components, component[T]{
+		name:  name,
+		order: order,
+		seq:   len(m.components),
+		c:     c,
+	})
+	sort.SliceStable(m.components, func(i, j int) bool {
+		if m.components[i].order != m.components[j].order {
+			return m.components[i].order < m.components[j].order
+		}
+		return m.components[i].seq < m.components[j].seq
+	})
+}
+
+// Current returns the last successfully decoded configuration and true, or
+// false if the configuration hasn't been loaded yet.
+func (m *Manager[T]) Current() (T, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current, m.loaded
+}
+
+// Reload retrieves the key from Consul and reconfigures all registered
+// components immediately. If a more recent value of the key is applied while
+// the key is retrieved, the retrieved value is discarded and the Report is
+// marked Skipped. A non-nil error is returned if the key cannot be
+// retrieved or doesn't exist, otherwise the Report of the reload is returned.
+func (m *Manager[T]) Reload(ctx context.Context) (Report, error) {
+	opts := (&api.QueryOptions{
+		AllowStale: m.stale,
+	}).WithContext(ctx)
+	kv, _, err := m.client.KV().Get(m.key, opts)
+	if err != nil {
+		return Report{}, fmt.Errorf("error retrieving key %s from Consul: %w", m.key, err)
+	}
+	if kv == nil {
+		return Report{}, fmt.Errorf("key %s not found", m.key)
+	}
+	return m.apply(ctx, kv), nil
+}
+
+// Run watches the key and reconfigures the registered components on each
+// change.
+//
+// Run is blocking and in nearly all use cases it should be called on a new
+// goroutine. It only returns when Stop is called or on an error, in which case
+// the components are no longer reconfigured. If Stop is called before Run
+// starts watching, Run returns nil without watching. Once Run returns it can be
+// called again.
+func (m *Manager[T]) Run() error {
+	plan, err := watch.Parse(map[string]any{
+		"type":  "key",
+		"key":   m.key,
+		"stale": m.stale,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to parse watch plan: %w", err)
+	}
+	plan.Handler = func(_ uint64, raw any) {
+		if raw == nil {
+			return
+		}
+		kv, ok := raw.(*api.KVPair)
+		if !ok {
+			m.logger.Error(fmt.Sprintf("expected type *api.KVPair but got %T", raw))
+			return
+		}
+		m.apply(context.Background(), kv)
+	}
+
+	m.mu.Lock()
+	if m.stopped {
+		m.stopped = false
+		m.mu.Unlock()
+		return nil
+	}
+	if m.plan != nil {
+		m.mu.Unlock()
+		return fmt.Errorf("manager is already watching key %s", m.key)
+	}
+	m.plan = plan
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		m.plan = nil
+		m.mu.Unlock()
+	}()
+	return plan.RunWithClientAndHclog(m.client, m.logger)
+}
+
+// Stop stops watching the key. If the Manager isn't running, the next call to
+// Run returns without watching.
+func (m *Manager[T]) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.plan != nil {
+		m.plan.Stop()
+		return
+	}
+	m.stopped = true
+}
+
+func (m *Manager[T]) apply(ctx context.Context, kv *api.KVPair) Report {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	report := Report{
+		Key:   m.key,
+		Index: kv.ModifyIndex,
+	}
+	if kv.ModifyIndex < m.lastIndex {
+		report.Skipped = true
+		m.logger.Debug(fmt.Sprintf("discarding value of key %s older than the value applied", m.key),
+			"index", kv.ModifyIndex,
+			"applied", m.lastIndex)
+		return report
+	}
+	m.lastIndex = kv.ModifyIndex
+
+	cfg, err := m.decode(kv.Value)
+	if err != nil {
+		report.DecodeErr = err
+		m.logger.Error(fmt.Sprintf("failed to decode value for key %s to type %T", m.key, cfg),
+			"error", err)
+		m.report(report)
+		return report
+	}
+
+	m.mu.Lock()
+	m.current = cfg
+	m.loaded = true
+	components := make([]component[T], len(m.components))
+	copy(components, m.components)
+	m.mu.Unlock()
+
+	for _, c := range components {
+		start := time.Now()
+		err := m.reconfigure(ctx, c, cfg)
+		res := Result{
+			Component: c.name,
+			Duration:  time.Since(start),
+			Err:       err,
+		}
+		if err != nil {
+			m.logger.Error("component failed to reconfigure",
+				"key", m.key,
+				"component", c.name,
+				"error", err)
+		} else {
+			m.logger.Debug("component reconfigured",
+				"key", m.key,
+				"component", c.name,
+				"duration", res.Duration)
+		}
+		report.Results = append(report.Results, res)
+	}
+	if report.Err() == nil {
+		m.logger.Info(fmt.Sprintf("successfully reconfigured %d components for key %s", len(components), m.key))
+	}
+	m.report(report)
+	return report
+}
+
+// reconfigure reconfigures a single component, isolating the remaining
+// components from panics.
+func (m *Manager[T]) reconfigure(ctx context.Context, c component[T], cfg T) (err error) {
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while reconfiguring: %v", r)
+		}
+	}()
+	return c.c.Reconfigure(ctx, cfg)
+}
+
+func (m *Manager[T]) report(r Report) {
+	if m.onReport != nil {
+		m.onReport(r)
+	}
+}
@@ -0,0 +1,507 @@
+// Package render renders Go templates with data from Consul to files on disk
+// and re-renders them whenever the data they use changes, covering simple
+// consul-template use cases without running another binary.
+//
+// Templates have access to the following functions in addition to the text/
+// template builtins:
+//
+//	key "path"                 value of the key, an error if it doesn't exist
+//	keyOrDefault "path" "def"  value of the key or the default if it doesn't exist
+//	ls "prefix"                the keys under the prefix as []KeyPair
+//	service "name"             the healthy instances of the service as []ServiceInstance
+//
+// The keys and services a template uses are discovered while rendering and
+// watched with blocking queries, so a template only reacts to the data it uses.
+package render
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/jkratz55/konsul"
+	"github.com/jkratz55/konsul/internal/blocking"
+)
+
+// KeyPair is a key and its value as returned by the ls template function. The
+// key is relative to the prefix.
+type KeyPair struct {
+	Key   string
+	Value string
+}
+
+// ServiceInstance is a healthy instance of a service as returned by the service
+// template function.
+type ServiceInstance struct {
+	ID      string
+	Name    string
+	Address string
+	Port    int
+	Tags    []string
+	Meta    map[string]string
+}
+
+// HostPort returns the address and port of the instance joined as host:port.
+func (s ServiceInstance) HostPort() string {
+	return net.JoinHostPort(s.Address, strconv.Itoa(s.Port))
+}
+
+// Template describes a template and where it's rendered to.
+type Template struct {
+	// The template text. Either Source or SourcePath must be provided.
+	Source string
+	// The path of a file holding the template text. Either Source or
+	// SourcePath must be provided.
+	SourcePath string
+	// The path of the file the template is rendered to. This is a required
+	// field.
+	Destination string
+	// The permissions of the rendered file. If not provided 0644 is used.
+	Perms fs.FileMode
+	// An optional command executed each time the rendered file changes, such as
+	// a command to reload nginx. The first element is the program and the
+	// remaining elements its arguments.
+	Command []string
+	// The maximum time Command is allowed to run. If not provided 30 seconds is
+	// used.
+	CommandTimeout time.Duration
+}
+
+// Config holds the configuration properties to create a Renderer.
+type Config struct {
+	// The Consul api Client to use to communicate with Consul. This is a required
+	// field. Providing a nil value will lead to a panic.
+	Client *api.Client
+	// The templates to render. At least one template is required.
+	Templates []Template
+	// Determines how Consul client interacts with Consul servers. When true any
+	// Consul server can be queried. Otherwise, all queries go to the leader.
+	AllowStale bool
+	// The time to wait for further changes before re-rendering, coalescing
+	// bursts of changes into a single render. If not provided 100ms is used.
+	Wait time.Duration
+	// The logger used to log events and errors. If not provided a default logger
+	// will be used.
+	Logger konsul.Logger
+	// The Clock used to coalesce changes and delay retries. If not provided
+	// konsul.SystemClock is used.
+	Clock konsul.Clock
+}
+
+func (c *Config) validate() {
+	if c.Client == nil {
+		panic("cannot provide nil consul api.Client, illegal use of api")
+	}
+	if len(c.Templates) == 0 {
+		panic("at least one template must be provided, illegal use of api")
+	}
+	for i := range c.Templates {
+		t := &c.Templates[i]
+		if (t.Source == "") == (t.SourcePath == "") {
+			panic("exactly one of Source or SourcePath must be provided, illegal use of api")
+		}
+		if strings.TrimSpace(t.Destination) == "" {
+			panic("a template destination must be specified, illegal use of api")
+		}
+		if t.Perms == 0 {
+			t.Perms = 0644
+		}
+		if t.CommandTimeout == 0 {
+			t.CommandTimeout = 30 * time.Second
+		}
+	}
+	if c.Wait == 0 {
+		c.Wait = 100 * time.Millisecond
+	}
+}
+
+// dependency kinds tracked while rendering.
+const (
+	depKey       = "key"
+	depKeyPrefix = "keyprefix"
+	depService   = "service"
+)
+
+type dependency struct {
+	kind string
+	name string
+}
+
+func (d dependency) String() string {
+	return d.kind + ":" + d.name
+}
+
+// Renderer renders templates with data from Consul and re-renders them as the
+// data changes.
+//
+// The zero-value of Renderer is not usable. Use New to create and initialize a
+// Renderer.
+type Renderer struct {
+	client    *api.Client
+	templates []Template
+	stale     bool
+	wait      time.Duration
+	logger    hclog.Logger
+	clock     konsul.Clock
+
+	mu       sync.Mutex
+	watching map[dependency]context.CancelFunc
+	trigger  chan struct{}
+	pollers  sync.WaitGroup
+}
+
+// New creates and initializes a Renderer with the provided configuration. If
+// the configuration is invalid (misusing the API) this will panic.
+func New(config Config) *Renderer {
+	config.validate()
+	return &Renderer{
+		client:    config.Client,
+		templates: config.Templates,
+		stale:     config.AllowStale,
+		wait:      config.Wait,
+		logger:    konsul.HclogAdapter(config.Logger),
+		clock:     konsul.ClockOrSystem(config.Clock),
+		watching:  make(map[dependency]context.CancelFunc),
+		trigger:   make(chan struct{}, 1),
+	}
+}
+
+// Render renders all templates once, executing the command of each template
+// whose rendered file changed. All templates are rendered even if one fails,
+// and the first error is returned.
+func (r *Renderer) Render(ctx context.Context) error {
+	_, err := r.render(ctx)
+	return err
+}
+
+// Run renders all templates and re-renders them each time the data they use
+// changes until the context is cancelled.
+//
+// Run is blocking and in nearly all use cases it should be called on a new
+// goroutine. Errors rendering templates after the first render are logged, and
+// the templates are rendered again on the next change. If the first render
+// fails Run returns the error.
+func (r *Renderer) Run(ctx context.Context) error {
+	defer r.unwatchAll()
+
+	deps, err := r.render(ctx)
+	if err != nil {
+		return err
+	}
+	r.watch(ctx, deps)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-r.trigger:
+		}
+
+		// Coalesce bursts of changes into a single render.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-r.clock.After(r.wait):
+		}
+		select {
+		case <-r.trigger:
+		default:
+		}
+
+		deps, err := r.render(ctx)
+		if err != nil {
+			r.logger.Error("failed to render templates", "error", err)
+		}
+		r.watch(ctx, deps)
+	}
+}
+
+// render renders all templates, returning the dependencies they used along with
+// the lowest index of the data rendered for each.
+func (r *Renderer) render(ctx context.Context) (map[dependency]uint64, error) {
+	deps := make(map[dependency]uint64)
+	var firstErr error
+	for _, t := range r.templates {
+		if err := r.renderTemplate(ctx, t, deps); err != nil {
+			r.logger.Error("failed to render template",
+				"destination", t.Destination,
+				"error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return deps, firstErr
+}
+
+func (r *Renderer) renderTemplate(ctx context.Context, t Template, deps map[dependency]uint64) error {
+	source := t.Source
+	if t.SourcePath != "" {
+		data, err := os.ReadFile(t.SourcePath)
+		if err != nil {
+			return fmt.Errorf("error reading template %s: %w", t.SourcePath, err)
+		}
+		source = string(data)
+	}
+
+	name := t.SourcePath
+	if name == "" {
+		name = t.Destination
+	}
+	tmpl, err := template.New(filepath.Base(name)).Funcs(r.funcs(ctx, deps)).Parse(source)
+	if err != nil {
+		return fmt.Errorf("error parsing template for %s: %w", t.Destination, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return fmt.Errorf("error executing template for %s: %w", t.Destination, err)
+	}
+
+	changed, err := writeFile(t.Destination, buf.Bytes(), t.Perms)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	r.logger.Info("rendered template", "destination", t.Destination)
+
+	if len(t.Command) == 0 {
+		return nil
+	}
+	cmdCtx, cancel := context.WithTimeout(ctx, t.CommandTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(cmdCtx, t.Command[0], t.Command[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error executing command %q for %s: %w: %s",
+			strings.Join(t.Command, " "), t.Destination, err, bytes.TrimSpace(out))
+	}
+	r.logger.Debug("executed command",
+		"destination", t.Destination,
+		"command", strings.Join(t.Command, " "))
+	return nil
+}
+
+func (r *Renderer) funcs(ctx context.Context, deps map[dependency]uint64) template.FuncMap {
+	opts := func() *api.QueryOptions {
+		return (&api.QueryOptions{AllowStale: r.stale}).WithContext(ctx)
+	}
+	// use records the dependency along with the index of the data rendered, so
+	// the dependency is watched for changes since. If the data is retrieved
+	// more than once the lowest index is kept.
+	use := func(dep dependency, meta *api.QueryMeta) {
+		var index uint64
+		if meta != nil {
+			index = meta.LastIndex
+		}
+		if prev, ok := deps[dep]; !ok || index < prev {
+			deps[dep] = index
+		}
+	}
+	return template.FuncMap{
+		"key": func(key string) (string, error) {
+			kv, meta, err := r.client.KV().Get(key, opts())
+			use(dependency{kind: depKey, name: key}, meta)
+			if err != nil {
+				return "", fmt.Errorf("error retrieving key %s from Consul: %w", key, err)
+			}
+			if kv == nil {
+				return "", fmt.Errorf("key %s not found", key)
+			}
+			return string(kv.Value), nil
+		},
+		"keyOrDefault": func(key string, def string) (string, error) {
+			kv, meta, err := r.client.KV().Get(key, opts())
+			use(dependency{kind: depKey, name: key}, meta)
+			if err != nil {
+				return "", fmt.Errorf("error retrieving key %s from Consul: %w", key, err)
+			}
+			if kv == nil {
+				return def, nil
+			}
+			return string(kv.Value), nil
+		},
+		"ls": func(prefix string) ([]KeyPair, error) {
+			prefix = strings.TrimSuffix(prefix, "/") + "/"
+			pairs, meta, err := r.client.KV().List(prefix, opts())
+			use(dependency{kind: depKeyPrefix, name: prefix}, meta)
+			if err != nil {
+				return nil, fmt.Errorf("error listing keys with prefix %s from Consul: %w", prefix, err)
+			}
+			pairs, err = konsul.ReassembleChunks(pairs)
+			if err != nil {
+				return nil, fmt.Errorf("error reassembling chunked values under prefix %s: %w", prefix, err)
+			}
+			res := make([]KeyPair, 0, len(pairs))
+			for _, pair := range pairs {
+				if strings.HasSuffix(pair.Key, "/") {
+					continue
+				}
+				res = append(res, KeyPair{
+					Key:   strings.TrimPrefix(pair.Key, prefix),
+					Value: string(pair.Value),
+				})
+			}
+			return res, nil
+		},
+		"service": func(name string) ([]ServiceInstance, error) {
+			entries, meta, err := r.client.Health().Service(name, "", true, opts())
+			use(dependency{kind: depService, name: name}, meta)
+			if err != nil {
+				return nil, fmt.Errorf("error retrieving service %s from Consul: %w", name, err)
+			}
+			return toInstances(entries), nil
+		},
+	}
+}
+
+// watch starts watching new dependencies for changes since the index they were
+// rendered at and stops watching dependencies no longer used by any template.
+func (r *Renderer) watch(ctx context.Context, deps map[dependency]uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for dep, cancel := range r.watching {
+		if _, ok := deps[dep]; !ok {
+			cancel()
+			delete(r.watching, dep)
+		}
+	}
+	for dep, index := range deps {
+		if _, ok := r.watching[dep]; ok {
+			continue
+		}
+		depCtx, cancel := context.WithCancel(ctx)
+		r.watching[dep] = cancel
+		r.pollers.Add(1)
+		go r.poll(depCtx, dep, index)
+	}
+}
+
+// unwatchAll stops watching all dependencies and waits for the goroutines
+// watching them to exit.
+func (r *Renderer) unwatchAll() {
+	r.mu.Lock()
+	for dep, cancel := range r.watching {
+		cancel()
+		delete(r.watching, dep)
+	}
+	r.mu.Unlock()
+	r.pollers.Wait()
+}
+
+// poll performs blocking queries for the dependency, triggering a render each
+// time its index changes since the index it was rendered at.
+func (r *Renderer) poll(ctx context.Context, dep dependency, rendered uint64) {
+	defer r.pollers.Done()
+	var index blocking.Index
+	index.Seed(rendered)
+	for {
+		opts := (&api.QueryOptions{
+			AllowStale: r.stale,
+			WaitIndex:  index.Wait(),
+		}).WithContext(ctx)
+
+		var meta *api.QueryMeta
+		var err error
+		switch dep.kind {
+		case depKey:
+			_, meta, err = r.client.KV().Get(dep.name, opts)
+		case depKeyPrefix:
+			_, meta, err = r.client.KV().List(dep.name, opts)
+		case depService:
+			_, meta, err = r.client.Health().Service(dep.name, "", true, opts)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			r.logger.Error("error watching dependency, retrying",
+				"dependency", dep.String(),
+				"error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.clock.After(5 * time.Second):
+			}
+			continue
+		}
+
+		if index.Update(meta.LastIndex) {
+			r.logger.Debug("dependency changed", "dependency", dep.String())
+			select {
+			case r.trigger <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func toInstances(entries []*api.ServiceEntry) []ServiceInstance {
+	instances := make([]ServiceInstance, 0, len(entries))
+	for _, entry := range entries {
+		addr := entry.Node.Address
+		if entry.Service.Address != "" {
+			addr = entry.Service.Address
+		}
+		instances = append(instances, ServiceInstance{
+			ID:      entry.Service.ID,
+			Name:    entry.Service.Service,
+			Address: addr,
+			Port:    entry.Service.Port,
+			Tags:    entry.Service.Tags,
+			Meta:    entry.Service.Meta,
+		})
+	}
+	// Sort for a stable output so the rendered file only changes when the
+	// instances do.
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+	return instances
+}
+
+// writeFile atomically writes the data to path if it differs from the current
+// content of the file, and reports whether the file changed.
+func writeFile(path string, data []byte, perms fs.FileMode) (bool, error) {
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		return false, nil
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, fmt.Errorf("error creating directory %s: %w", dir, err)
+	}
+	tmp, err := os.CreateTemp(dir, ".konsul-*")
+	if err != nil {
+		return false, fmt.Errorf("error creating temporary file for %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return false, fmt.Errorf("error writing temporary file for %s: %w", path, err)
+	}
+	if err := tmp.Chmod(perms); err != nil {
+		_ = tmp.Close()
+		return false, fmt.Errorf("error setting permissions of %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return false, fmt.Errorf("error closing temporary file for %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return false, fmt.Errorf("error renaming temporary file to %s: %w", path, err)
+	}
+	return true, nil
+}
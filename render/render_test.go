@@ -0,0 +1,164 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"go.uber.org/goleak"
+)
+
+func TestRenderer_RunLeavesNoGoroutines(t *testing.T) {
+	t.Cleanup(func() {
+		goleak.VerifyNone(t)
+	})
+	client := newTestConsul(t)
+
+	dest := filepath.Join(t.TempDir(), "app.conf")
+	renderer := New(Config{
+		Client: client,
+		Templates: []Template{{
+			Source:      `debug={{ key "config/app" }}`,
+			Destination: dest,
+		}},
+		Logger: hclog.NewNullLogger(),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- renderer.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, err := os.ReadFile(dest); err == nil {
+			if string(data) != "debug=true" {
+				t.Fatalf("unexpected rendered file %q", data)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for template to be rendered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRenderer_RunRendersChangeBeforeWatching(t *testing.T) {
+	// The value changes after it's rendered but before the dependency is
+	// watched, so the change must not be mistaken for the rendered value.
+	var mu sync.Mutex
+	index := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wait, _ := strconv.Atoi(r.URL.Query().Get("index"))
+		mu.Lock()
+		current := index
+		if current == 1 {
+			index = 2
+		}
+		mu.Unlock()
+		if wait >= current {
+			<-r.Context().Done()
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		w.Header().Set("X-Consul-Index", strconv.Itoa(current))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode([]*api.KVPair{{
+			Key:         key,
+			Value:       []byte(strconv.Itoa(current)),
+			ModifyIndex: uint64(current),
+		}})
+	}))
+	transport := &http.Transport{}
+	defer func() {
+		transport.CloseIdleConnections()
+		srv.Close()
+	}()
+	client, err := api.NewClient(&api.Config{
+		Address:   strings.TrimPrefix(srv.URL, "http://"),
+		Transport: transport,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "app.conf")
+	renderer := New(Config{
+		Client: client,
+		Templates: []Template{{
+			Source:      `version={{ key "config/app" }}`,
+			Destination: dest,
+		}},
+		Logger: hclog.NewNullLogger(),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- renderer.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, err := os.ReadFile(dest); err == nil && string(data) == "version=2" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the change to be rendered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newTestConsul starts an HTTP server answering KV queries with a fixed value,
+// blocking queries blocking until they're cancelled, and returns a Consul api
+// Client configured to communicate with it.
+func newTestConsul(t *testing.T) *api.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if index := r.URL.Query().Get("index"); index != "" && index != "0" {
+			<-r.Context().Done()
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		w.Header().Set("X-Consul-Index", "1")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode([]*api.KVPair{{Key: key, Value: []byte("true"), ModifyIndex: 1}})
+	}))
+
+	transport := &http.Transport{}
+	client, err := api.NewClient(&api.Config{
+		Address:   strings.TrimPrefix(srv.URL, "http://"),
+		Transport: transport,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(func() {
+		transport.CloseIdleConnections()
+		srv.Close()
+	})
+	return client
+}
@@ -0,0 +1,174 @@
+package konsul
+
+import (
+	"context"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// QueryOption customizes a single read request to Consul, such as the
+// datacenter, namespace, or ACL token used, overriding the defaults of the
+// Consul api Client.
+type QueryOption interface {
+	applyQuery(q *api.QueryOptions)
+}
+
+// QueryOptionFunc is an adapter to allow the use of ordinary functions as a
+// QueryOption.
+type QueryOptionFunc func(q *api.QueryOptions)
+
+func (f QueryOptionFunc) applyQuery(q *api.QueryOptions) {
+	f(q)
+}
+
+// WriteOption customizes a single write request to Consul, such as the
+// datacenter, namespace, or ACL token used, overriding the defaults of the
+// Consul api Client.
+type WriteOption interface {
+	applyWrite(w *api.WriteOptions)
+}
+
+// WriteOptionFunc is an adapter to allow the use of ordinary functions as a
+// WriteOption.
+type WriteOptionFunc func(w *api.WriteOptions)
+
+func (f WriteOptionFunc) applyWrite(w *api.WriteOptions) {
+	f(w)
+}
+
+// RequestOption customizes a single request to Consul. It implements both
+// QueryOption and WriteOption so it can be provided to reads and writes alike.
+// The options scoping requests, such as WithDatacenter, also implement
+// WatchOption, scoping the queries of Watch and WatchPrefix the same way.
+type RequestOption struct {
+	query func(q *api.QueryOptions)
+	write func(w *api.WriteOptions)
+	watch func(o *WatchOptions)
+}
+
+var (
+	_ QueryOption = RequestOption{}
+	_ WriteOption = RequestOption{}
+	_ WatchOption = RequestOption{}
+)
+
+func (o RequestOption) applyQuery(q *api.QueryOptions) {
+	if o.query != nil {
+		o.query(q)
+	}
+}
+
+func (o RequestOption) applyWrite(w *api.WriteOptions) {
+	if o.write != nil {
+		o.write(w)
+	}
+}
+
+func (o RequestOption) applyWatch(opts *WatchOptions) {
+	if o.watch != nil {
+		o.watch(opts)
+	}
+}
+
+// WithDatacenter sends the request to the provided datacenter rather than the
+// datacenter of the Consul agent.
+func WithDatacenter(dc string) RequestOption {
+	return RequestOption{
+		query: func(q *api.QueryOptions) { q.Datacenter = dc },
+		write: func(w *api.WriteOptions) { w.Datacenter = dc },
+		watch: func(o *WatchOptions) { o.Datacenter = dc },
+	}
+}
+
+// WithNamespace sends the request to the provided namespace. Namespaces require
+// Consul Enterprise.
+func WithNamespace(ns string) RequestOption {
+	return RequestOption{
+		query: func(q *api.QueryOptions) { q.Namespace = ns },
+		write: func(w *api.WriteOptions) { w.Namespace = ns },
+		watch: func(o *WatchOptions) { o.Namespace = ns },
+	}
+}
+
+// WithPartition sends the request to the provided admin partition. Admin
+// partitions require Consul Enterprise.
+func WithPartition(partition string) RequestOption {
+	return RequestOption{
+		query: func(q *api.QueryOptions) { q.Partition = partition },
+		write: func(w *api.WriteOptions) { w.Partition = partition },
+		watch: func(o *WatchOptions) { o.Partition = partition },
+	}
+}
+
+// WithToken sends the request with the provided ACL token rather than the token
+// of the Consul api Client.
+func WithToken(token string) RequestOption {
+	return RequestOption{
+		query: func(q *api.QueryOptions) { q.Token = token },
+		write: func(w *api.WriteOptions) { w.Token = token },
+		watch: func(o *WatchOptions) { o.Token = token },
+	}
+}
+
+// WithContext binds the request to the context, aborting it if the context is
+// cancelled, including while waiting to retry it with the RetryPolicy of the
+// KVClient.
+func WithContext(ctx context.Context) RequestOption {
+	return RequestOption{
+		query: func(q *api.QueryOptions) { *q = *q.WithContext(ctx) },
+		write: func(w *api.WriteOptions) { *w = *w.WithContext(ctx) },
+	}
+}
+
+// Consistency is the consistency mode of a read request. See
+// https://developer.hashicorp.com/consul/api-docs/features/consistency
+type Consistency int
+
+const (
+	// ConsistencyDefault reads from the leader, which is strongly consistent in
+	// nearly all cases.
+	ConsistencyDefault Consistency = iota
+	// ConsistencyStale allows any Consul server to serve the read, which is
+	// faster and more scalable but may return stale values.
+	ConsistencyStale
+	// ConsistencyConsistent verifies the leader with a quorum of servers before
+	// serving the read, which is fully consistent but slower.
+	ConsistencyConsistent
+)
+
+// WithConsistency sets the consistency mode of the read request, overriding the
+// allowStale argument of the operation.
+func WithConsistency(c Consistency) QueryOption {
+	return QueryOptionFunc(func(q *api.QueryOptions) {
+		q.AllowStale = c == ConsistencyStale
+		q.RequireConsistent = c == ConsistencyConsistent
+	})
+}
+
+// queryOptions creates the QueryOptions of a request from allowStale and the
+// options provided by the caller.
+func queryOptions(allowStale bool, opts []QueryOption) *api.QueryOptions {
+	q := &api.QueryOptions{AllowStale: allowStale}
+	for _, opt := range opts {
+		if opt != nil {
+			opt.applyQuery(q)
+		}
+	}
+	return q
+}
+
+// writeOptions creates the WriteOptions of a request from the options provided
+// by the caller, or nil if none were provided so the defaults of the Consul api
+// Client are used.
+func writeOptions(opts []WriteOption) *api.WriteOptions {
+	if len(opts) == 0 {
+		return nil
+	}
+	w := &api.WriteOptions{}
+	for _, opt := range opts {
+		if opt != nil {
+			opt.applyWrite(w)
+		}
+	}
+	return w
+}
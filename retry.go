@@ -0,0 +1,129 @@
+package konsul
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy configures how KVClient retries operations failing with
+// transient errors, such as network failures or Consul being unavailable or
+// without a leader, so they don't propagate straight to application code.
+//
+// Retries are disabled by default. Setting MaxAttempts above 1 enables them
+// with exponential backoff:
+//
+//	kv := konsul.NewKVClient(client, konsul.WithRetry(konsul.RetryPolicy{
+//		MaxAttempts: 5,
+//	}))
+//
+// Check-And-Set operations and transactions are retried as well. If an attempt
+// is applied by Consul but its response is lost, the retry reports the
+// operation as not applied since the index no longer matches.
+type RetryPolicy struct {
+	// The maximum number of attempts of an operation, including the first.
+	// Values below 2 disable retries. As the Restart policy of an Instancer
+	// it's the maximum number of consecutive failed refreshes instead, zero
+	// retrying indefinitely.
+	MaxAttempts int
+	// The backoff before the first retry. If not provided it defaults to
+	// 100ms.
+	InitialBackoff time.Duration
+	// The maximum backoff between retries. If not provided it defaults to 5s.
+	MaxBackoff time.Duration
+	// The factor the backoff is multiplied by after each retry. If not
+	// provided it defaults to 2.
+	Multiplier float64
+	// The fraction of the backoff randomly added or removed to spread retries
+	// of concurrent clients, between 0 and 1. If not provided it defaults to
+	// 0.2. Use a negative value to disable jitter.
+	Jitter float64
+	// An optional func classifying errors as retryable. If not provided
+	// IsTransient is used.
+	Retryable func(err error) bool
+	// The Clock used to wait between retries. If not provided SystemClock is
+	// used.
+	Clock Clock
+}
+
+func (p RetryPolicy) validate() error {
+	if p.MaxAttempts < 0 {
+		return invalidConfig("retry MaxAttempts cannot be negative")
+	}
+	if p.InitialBackoff < 0 || p.MaxBackoff < 0 {
+		return invalidConfig("retry backoff cannot be negative")
+	}
+	if p.Multiplier != 0 && p.Multiplier < 1 {
+		return invalidConfig(fmt.Sprintf("retry Multiplier must be at least 1, got %v", p.Multiplier))
+	}
+	if p.Jitter > 1 {
+		return invalidConfig(fmt.Sprintf("retry Jitter cannot be greater than 1, got %v", p.Jitter))
+	}
+	return nil
+}
+
+// withDefaults returns the policy with defaults applied to the properties that
+// weren't provided.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = 100 * time.Millisecond
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = 5 * time.Second
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = 2
+	}
+	if p.Jitter == 0 {
+		p.Jitter = 0.2
+	}
+	if p.Retryable == nil {
+		p.Retryable = IsTransient
+	}
+	p.Clock = ClockOrSystem(p.Clock)
+	return p
+}
+
+// backoff returns the time to wait before the retry following the attempt,
+// starting at 1.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// do runs the operation, retrying it according to the RetryPolicy of the
+// KVClient while it fails with a retryable error. Each attempt is recorded
+// with Metrics and the CircuitBreaker, which may reject it. Waiting before a
+// retry is aborted once the context is done, returning the error of the
+// context.
+func (c KVClient) do(ctx context.Context, op string, key string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		if err := c.breaker.Allow(); err != nil {
+			c.logger.Debug("KV operation rejected by circuit breaker", "op", op, "key", key)
+			return err
+		}
+		start := time.Now()
+		err := fn()
+		c.metrics.KVOperation(op, key, time.Since(start), err)
+		c.breaker.Record(err)
+		if err == nil || attempt >= c.retry.MaxAttempts || !c.retry.Retryable(err) {
+			return err
+		}
+		backoff := c.retry.backoff(attempt)
+		c.logger.Debug("retrying KV operation after transient error",
+			"op", op, "key", key, "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-c.retry.Clock.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
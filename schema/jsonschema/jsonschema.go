@@ -0,0 +1,106 @@
+// Package jsonschema implements konsul.Validator with JSON Schema, allowing a
+// schema to be attached to keys so both writes through KVClient and changes
+// received by Watch are validated against it.
+//
+//	v := jsonschema.MustCompile([]byte(`{
+//		"type": "object",
+//		"required": ["port"],
+//		"properties": {"port": {"type": "integer", "minimum": 1}}
+//	}`), jsonschema.Options{})
+//	schemas := &konsul.Schemas{}
+//	schemas.Set("config/app", v)
+//
+// Drafts 4, 6, 7, 2019-09, and 2020-12 of JSON Schema are supported. The draft
+// is determined by the $schema keyword, defaulting to 2020-12.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+
+	"github.com/jkratz55/konsul"
+)
+
+// Format is the encoding of the values validated by a Validator.
+type Format int
+
+const (
+	// JSON values are decoded as JSON before being validated.
+	JSON Format = iota
+	// YAML values are decoded as YAML before being validated, allowing values
+	// stored as YAML to be validated with a JSON Schema.
+	YAML
+)
+
+// Options holds optional configuration properties for Compile.
+type Options struct {
+	// The encoding of the values validated. If not provided JSON is used.
+	Format Format
+}
+
+// Validator validates values against a compiled JSON Schema.
+type Validator struct {
+	schema *jsonschema.Schema
+	format Format
+}
+
+var _ konsul.Validator = (*Validator)(nil)
+
+// Compile compiles the JSON Schema. If the schema is invalid a non-nil error is
+// returned.
+func Compile(schema []byte, opts Options) (*Validator, error) {
+	const url = "konsul://schema.json"
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(url, bytes.NewReader(schema)); err != nil {
+		return nil, fmt.Errorf("error loading JSON Schema: %w", err)
+	}
+	s, err := compiler.Compile(url)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling JSON Schema: %w", err)
+	}
+	return &Validator{
+		schema: s,
+		format: opts.Format,
+	}, nil
+}
+
+// MustCompile compiles the JSON Schema. If the schema is invalid this will
+// panic.
+func MustCompile(schema []byte, opts Options) *Validator {
+	v, err := Compile(schema, opts)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Validate decodes the value and validates it against the schema. If the value
+// cannot be decoded or doesn't conform to the schema a non-nil error is
+// returned.
+func (v *Validator) Validate(value []byte) error {
+	if v.format == YAML {
+		// The YAML value is converted to JSON so it's decoded to the types the
+		// schema validation expects.
+		var doc any
+		if err := yaml.Unmarshal(value, &doc); err != nil {
+			return fmt.Errorf("error decoding value as YAML: %w", err)
+		}
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("error converting YAML value to JSON: %w", err)
+		}
+		value = data
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return fmt.Errorf("error decoding value as JSON: %w", err)
+	}
+	return v.schema.Validate(doc)
+}
@@ -0,0 +1,290 @@
+package konsul
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+)
+
+// ErrSessionLost is returned by the methods of Session after its session has
+// been invalidated by Consul or couldn't be renewed before its TTL expired. Any
+// keys acquired with the session have been released.
+var ErrSessionLost = errors.New("session lost")
+
+// Acquire sets the value of the key and locks it with the session if the key
+// isn't already locked by another session, allowing keys to be used as
+// lightweight locks. Acquire returns true if the key was acquired, and false if
+// it's held by another session. Acquiring a key already held by the session
+// updates its value. If the operation fails a non-nil error value is returned.
+//
+// The session must be created beforehand, use NewSession to have a session
+// created and renewed automatically.
+func (c KVClient) Acquire(key string, value []byte, sessionID string, opts ...WriteOption) (bool, error) {
+	if sessionID == "" {
+		return false, &KVError{Op: OpAcquire, Key: key, Err: invalidConfig("a session ID must be provided")}
+	}
+	if err := c.schemas.Validate(key, value); err != nil {
+		return false, &KVError{Op: OpAcquire, Key: key, Err: err}
+	}
+	value, err := c.encodeValue(value)
+	if err != nil {
+		return false, &KVError{Op: OpAcquire, Key: key, Err: err}
+	}
+	kv := &api.KVPair{
+		Key:     key,
+		Value:   value,
+		Session: sessionID,
+	}
+	if flags, ok := WriteFlags(opts...); ok {
+		kv.Flags = uint64(flags)
+	}
+	var ok bool
+	w := writeOptions(opts)
+	err = c.do(w.Context(), OpAcquire, key, func() (err error) {
+		ok, _, err = c.client.KV().Acquire(kv, w)
+		return err
+	})
+	if err != nil {
+		c.logger.Debug("failed to acquire KV in Consul", "key", key, "session", sessionID, "error", err)
+		return false, &KVError{Op: OpAcquire, Key: key, Err: err}
+	}
+	c.logger.Debug("acquire KV in Consul", "key", key, "session", sessionID, "acquired", ok)
+	return ok, nil
+}
+
+// Release unlocks the key held by the session so other sessions can acquire
+// it. Like the Consul API, releasing a key clears its value. Release returns
+// true if the key was released, and false if it isn't held by the session. If
+// the operation fails a non-nil error value is returned.
+func (c KVClient) Release(key string, sessionID string, opts ...WriteOption) (bool, error) {
+	if sessionID == "" {
+		return false, &KVError{Op: OpRelease, Key: key, Err: invalidConfig("a session ID must be provided")}
+	}
+	kv := &api.KVPair{
+		Key:     key,
+		Session: sessionID,
+	}
+	var ok bool
+	w := writeOptions(opts)
+	err := c.do(w.Context(), OpRelease, key, func() (err error) {
+		ok, _, err = c.client.KV().Release(kv, w)
+		return err
+	})
+	if err != nil {
+		c.logger.Debug("failed to release KV in Consul", "key", key, "session", sessionID, "error", err)
+		return false, &KVError{Op: OpRelease, Key: key, Err: err}
+	}
+	c.logger.Debug("release KV in Consul", "key", key, "session", sessionID, "released", ok)
+	return ok, nil
+}
+
+// SessionOptions holds optional configuration properties for NewSession.
+type SessionOptions struct {
+	// An optional human-readable name of the session, useful to identify the
+	// holder of a key in the Consul UI.
+	Name string
+	// The TTL of the session. The session is renewed every half TTL, and is
+	// invalidated by Consul if it isn't renewed before the TTL expires, such as
+	// when the process crashes. Consul requires a TTL between 10s and 24h. If
+	// not provided the TTL defaults to 15s.
+	TTL time.Duration
+	// The time Consul prevents keys released by an invalidated session from
+	// being acquired again, giving the previous holder time to notice it lost
+	// them. If not provided Consul uses its default of 15s.
+	LockDelay time.Duration
+	// What happens to keys held by the session when it's invalidated, either
+	// api.SessionBehaviorRelease or api.SessionBehaviorDelete. Keys are released
+	// by default. Keys are always released when the session is closed.
+	Behavior string
+	// Optional write options used to create, renew, and destroy the session,
+	// such as the datacenter, namespace, or ACL token.
+	Write []WriteOption
+	// The Clock used to schedule renewals. If not provided SystemClock is used.
+	Clock Clock
+}
+
+func (o SessionOptions) validate() error {
+	if o.TTL != 0 && (o.TTL < 10*time.Second || o.TTL > 24*time.Hour) {
+		return invalidConfig(fmt.Sprintf("session TTL must be between 10s and 24h, got %s", o.TTL))
+	}
+	if o.LockDelay < 0 {
+		return invalidConfig("session LockDelay cannot be negative")
+	}
+	switch o.Behavior {
+	case "", api.SessionBehaviorRelease, api.SessionBehaviorDelete:
+	default:
+		return invalidConfig(fmt.Sprintf("unknown session behavior %q", o.Behavior))
+	}
+	return nil
+}
+
+// Session is a Consul session renewed automatically in the background, used to
+// acquire keys as locks. The session is renewed until Close is called, which
+// destroys the session and releases the keys it holds.
+//
+//	session, err := kv.NewSession(konsul.SessionOptions{Name: "scheduler"})
+//	if err != nil {
+//		return err
+//	}
+//	defer session.Close()
+//
+//	acquired, err := session.Acquire("locks/scheduler", []byte(hostname))
+//
+// If the session can't be renewed before its TTL expires, or is invalidated by
+// Consul, the channel returned by Lost is closed and the keys it held should be
+// considered lost.
+//
+// The zero-value of Session is not usable. Use KVClient.NewSession to create a
+// Session.
+type Session struct {
+	kv     KVClient
+	id     string
+	ttl    time.Duration
+	write  []WriteOption
+	clock  Clock
+	logger hclog.Logger
+
+	lost      chan struct{}
+	lostOnce  sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewSession creates a Consul session with a TTL and starts renewing it in the
+// background. If the options are invalid an error wrapping ErrInvalidConfig is
+// returned. If the session cannot be created a non-nil error value is returned.
+func (c KVClient) NewSession(opts SessionOptions) (*Session, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.TTL == 0 {
+		opts.TTL = 15 * time.Second
+	}
+	behavior := opts.Behavior
+	if behavior == "" {
+		behavior = api.SessionBehaviorRelease
+	}
+
+	id, _, err := c.client.Session().Create(&api.SessionEntry{
+		Name:      opts.Name,
+		TTL:       opts.TTL.String(),
+		LockDelay: opts.LockDelay,
+		Behavior:  behavior,
+	}, writeOptions(opts.Write))
+	if err != nil {
+		c.logger.Debug("failed to create session in Consul", "name", opts.Name, "error", err)
+		return nil, fmt.Errorf("failed to create session in Consul: %w", err)
+	}
+	c.logger.Debug("created session in Consul", "name", opts.Name, "session", id)
+
+	s := &Session{
+		kv:     c,
+		id:     id,
+		ttl:    opts.TTL,
+		write:  opts.Write,
+		clock:  ClockOrSystem(opts.Clock),
+		logger: c.logger.With("session", id),
+		lost:   make(chan struct{}),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go s.renew()
+	return s, nil
+}
+
+// ID returns the ID of the Consul session.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Lost returns a channel that's closed when the session is invalidated by
+// Consul or couldn't be renewed before its TTL expired. The channel is not
+// closed by Close.
+func (s *Session) Lost() <-chan struct{} {
+	return s.lost
+}
+
+// Acquire sets the value of the key and locks it with the session. It returns
+// true if the key was acquired, and false if it's held by another session. If
+// the session has been lost ErrSessionLost is returned.
+func (s *Session) Acquire(key string, value []byte) (bool, error) {
+	if s.isLost() {
+		return false, &KVError{Op: OpAcquire, Key: key, Err: ErrSessionLost}
+	}
+	return s.kv.Acquire(key, value, s.id, s.write...)
+}
+
+// Release unlocks the key held by the session, clearing its value. It returns
+// true if the key was released, and false if it isn't held by the session.
+func (s *Session) Release(key string) (bool, error) {
+	return s.kv.Release(key, s.id, s.write...)
+}
+
+// Close stops renewing the session and destroys it, releasing every key it
+// holds. Calling Close more than once returns the result of the first call.
+func (s *Session) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+		if _, err := s.kv.client.Session().Destroy(s.id, writeOptions(s.write)); err != nil {
+			s.logger.Debug("failed to destroy session in Consul", "error", err)
+			s.closeErr = fmt.Errorf("failed to destroy session %s in Consul: %w", s.id, err)
+			return
+		}
+		s.logger.Debug("destroyed session in Consul")
+	})
+	return s.closeErr
+}
+
+// renew renews the session every half TTL until Close is called or the session
+// is lost. Failed renewals are retried on the next tick as long as the TTL
+// since the last successful renewal hasn't expired.
+func (s *Session) renew() {
+	defer close(s.done)
+	ticker := s.clock.NewTicker(s.ttl / 2)
+	defer ticker.Stop()
+	renewed := s.clock.Now()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C():
+		}
+
+		entry, _, err := s.kv.client.Session().Renew(s.id, writeOptions(s.write))
+		switch {
+		case err == nil && entry == nil:
+			s.logger.Warn("session was invalidated by Consul")
+			s.markLost()
+			return
+		case err != nil:
+			s.logger.Error("failed to renew session", "error", err)
+			if s.clock.Now().Sub(renewed) >= s.ttl {
+				s.logger.Warn("session TTL expired before it could be renewed")
+				s.markLost()
+				return
+			}
+		default:
+			renewed = s.clock.Now()
+		}
+	}
+}
+
+func (s *Session) markLost() {
+	s.lostOnce.Do(func() { close(s.lost) })
+}
+
+func (s *Session) isLost() bool {
+	select {
+	case <-s.lost:
+		return true
+	default:
+		return false
+	}
+}
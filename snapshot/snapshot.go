@@ -0,0 +1,280 @@
+// Package snapshot writes the keys under a prefix in Consul KV store to a
+// directory tree on disk and optionally keeps it in sync as the keys change.
+// This allows tools that strictly require files, such as nginx includes or JVM
+// sidecars, to be fed configuration from Consul by a Go process.
+//
+// Each key is written to a file at the path of the key relative to the prefix,
+// so with the prefix "config/nginx" the key "config/nginx/conf.d/app.conf" is
+// written to "<dir>/conf.d/app.conf". Files are written atomically by writing
+// to a temporary file and renaming it.
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/api/watch"
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/jkratz55/konsul"
+)
+
+// Config holds the configuration properties to create a Writer.
+type Config struct {
+	// The Consul api Client to use to communicate with Consul. This is a required
+	// field. Providing a nil value will lead to a panic.
+	Client *api.Client
+	// The prefix of the keys to write. This is a required field.
+	Prefix string
+	// The directory the keys are written to. It's created if it doesn't exist.
+	// This is a required field.
+	Dir string
+	// Determines how Consul client interacts with Consul servers. When true any
+	// Consul server can be queried. Otherwise, all queries go to the leader.
+	AllowStale bool
+	// The permissions of written files. If not provided 0644 is used.
+	FileMode fs.FileMode
+	// The permissions of created directories. If not provided 0755 is used.
+	DirMode fs.FileMode
+	// An optional callback invoked after each sync while watching. The error is
+	// nil when the sync succeeded. Useful to signal a process to reload its
+	// configuration.
+	OnSync func(err error)
+	// The logger used to log events and errors while syncing. If not provided
+	// a default logger will be used.
+	Logger konsul.Logger
+}
+
+func (c *Config) validate() {
+	if c.Client == nil {
+		panic("cannot provide nil consul api.Client, illegal use of api")
+	}
+	if strings.TrimSpace(c.Prefix) == "" {
+		panic("a prefix must be specified, illegal use of api")
+	}
+	if strings.TrimSpace(c.Dir) == "" {
+		panic("a directory must be specified, illegal use of api")
+	}
+	if c.FileMode == 0 {
+		c.FileMode = 0644
+	}
+	if c.DirMode == 0 {
+		c.DirMode = 0755
+	}
+}
+
+// Writer writes the keys under a prefix to a directory.
+//
+// Files previously written by the Writer are removed when their key is deleted
+// from Consul. Files in the directory that weren't written by the Writer are
+// left alone.
+//
+// The zero-value of Writer is not usable. Use NewWriter to create and
+// initialize a Writer.
+type Writer struct {
+	client   *api.Client
+	prefix   string
+	dir      string
+	stale    bool
+	fileMode fs.FileMode
+	dirMode  fs.FileMode
+	onSync   func(error)
+	logger   hclog.Logger
+
+	mu      sync.Mutex
+	written map[string]struct{}
+	plan    *watch.Plan
+	// Set when Stop is called while the Writer isn't watching, so a Watch
+	// racing with Stop returns rather than watching indefinitely.
+	stopped bool
+}
+
+// NewWriter creates and initializes a Writer with the provided configuration. If
+// the configuration is invalid (misusing the API) this will panic.
+func NewWriter(config Config) *Writer {
+	config.validate()
+	return &Writer{
+		client:   config.Client,
+		prefix:   strings.TrimSuffix(config.Prefix, "/") + "/",
+		dir:      filepath.Clean(config.Dir),
+		stale:    config.AllowStale,
+		fileMode: config.FileMode,
+		dirMode:  config.DirMode,
+		onSync:   config.OnSync,
+		logger:   konsul.HclogAdapter(config.Logger),
+		written:  make(map[string]struct{}),
+	}
+}
+
+// FS returns an fs.FS of the directory the keys are written to.
+func (w *Writer) FS() fs.FS {
+	return os.DirFS(w.dir)
+}
+
+// Sync retrieves the keys under the prefix and writes them to the directory
+// once. Files whose content hasn't changed aren't rewritten.
+func (w *Writer) Sync() error {
+	pairs, _, err := w.client.KV().List(w.prefix, &api.QueryOptions{
+		AllowStale: w.stale,
+	})
+	if err != nil {
+		return fmt.Errorf("error listing keys with prefix %s from Consul: %w", w.prefix, err)
+	}
+	return w.write(pairs)
+}
+
+// Watch writes the keys under the prefix to the directory and keeps it in sync
+// as the keys change.
+//
+// Watch is blocking and in nearly all use cases it should be called on a new
+// goroutine. It only returns when Stop is called or on an error, in which case
+// the directory is no longer kept in sync. If Stop is called before Watch
+// starts watching, Watch returns nil without watching. Once Watch returns it
+// can be called again to resume keeping the directory in sync.
+func (w *Writer) Watch() error {
+	plan, err := watch.Parse(map[string]any{
+		"type":   "keyprefix",
+		"prefix": w.prefix,
+		"stale":  w.stale,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to parse watch plan: %w", err)
+	}
+	plan.Handler = func(_ uint64, raw any) {
+		pairs, ok := raw.(api.KVPairs)
+		if !ok && raw != nil {
+			w.logger.Error(fmt.Sprintf("expected type api.KVPairs but got %T", raw))
+			return
+		}
+		err := w.write(pairs)
+		if err != nil {
+			w.logger.Error("failed to write snapshot", "dir", w.dir, "error", err)
+		} else {
+			w.logger.Info("snapshot written", "dir", w.dir, "keys", len(pairs))
+		}
+		if w.onSync != nil {
+			w.onSync(err)
+		}
+	}
+
+	w.mu.Lock()
+	if w.stopped {
+		w.stopped = false
+		w.mu.Unlock()
+		return nil
+	}
+	if w.plan != nil {
+		w.mu.Unlock()
+		return fmt.Errorf("writer is already watching prefix %s", w.prefix)
+	}
+	w.plan = plan
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		w.plan = nil
+		w.mu.Unlock()
+	}()
+	return plan.RunWithClientAndHclog(w.client, w.logger)
+}
+
+// Stop stops watching the prefix. If the Writer isn't watching, the next call
+// to Watch returns without watching.
+func (w *Writer) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.plan != nil {
+		w.plan.Stop()
+		return
+	}
+	w.stopped = true
+}
+
+func (w *Writer) write(pairs api.KVPairs) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Values stored by PutLarge are written as a single file rather than as
+	// their manifest and chunks.
+	pairs, err := konsul.ReassembleChunks(pairs)
+	if err != nil {
+		return fmt.Errorf("error reassembling chunked values under prefix %s: %w", w.prefix, err)
+	}
+
+	if err := os.MkdirAll(w.dir, w.dirMode); err != nil {
+		return fmt.Errorf("error creating directory %s: %w", w.dir, err)
+	}
+
+	current := make(map[string]struct{}, len(pairs))
+	for _, pair := range pairs {
+		// Keys ending in "/" are folders in Consul
+		if strings.HasSuffix(pair.Key, "/") {
+			continue
+		}
+		path, err := w.path(pair.Key)
+		if err != nil {
+			return err
+		}
+		if err := w.writeFile(path, pair.Value); err != nil {
+			return err
+		}
+		current[path] = struct{}{}
+	}
+
+	for path := range w.written {
+		if _, ok := current[path]; ok {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing file %s: %w", path, err)
+		}
+	}
+	w.written = current
+	return nil
+}
+
+// path returns the path of the file for the key, ensuring it's within the
+// directory.
+func (w *Writer) path(key string) (string, error) {
+	rel := filepath.FromSlash(strings.TrimPrefix(key, w.prefix))
+	path := filepath.Join(w.dir, rel)
+	if !strings.HasPrefix(path, w.dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("key %s resolves to a path outside of directory %s", key, w.dir)
+	}
+	return path, nil
+}
+
+func (w *Writer) writeFile(path string, data []byte) error {
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), w.dirMode); err != nil {
+		return fmt.Errorf("error creating directory %s: %w", filepath.Dir(path), err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".konsul-*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file for %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("error writing temporary file for %s: %w", path, err)
+	}
+	if err := tmp.Chmod(w.fileMode); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("error setting permissions of %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing temporary file for %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error renaming temporary file to %s: %w", path, err)
+	}
+	return nil
+}
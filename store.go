@@ -0,0 +1,59 @@
+package konsul
+
+import (
+	"encoding"
+	"sync/atomic"
+)
+
+// Store holds a configuration of type T that Watch replaces atomically on
+// change. Each change is decoded into a fresh T and the pointer held by the
+// Store is swapped, so request goroutines calling Load never observe a value
+// being modified, unlike unmarshalling into a struct they're reading:
+//
+//	store := konsul.NewStore[AppConfig](konsul.CodecJSON)
+//	go func() {
+//		if err := konsul.Watch(client, "config/app", store); err != nil {
+//			panic(err)
+//		}
+//	}()
+//	...
+//	cfg := store.Load()
+//
+// Values returned by Load must be treated as immutable. Store is safe for
+// concurrent use.
+type Store[T any] struct {
+	codec string
+	value atomic.Pointer[T]
+}
+
+var _ encoding.BinaryUnmarshaler = (*Store[struct{}])(nil)
+
+// NewStore creates an empty Store decoding values with the Codec registered
+// with the provided name or content type. When codec is empty the format of
+// each value is detected with DetectCodec.
+func NewStore[T any](codec string) *Store[T] {
+	return &Store[T]{codec: codec}
+}
+
+// Load returns the current value, or nil if no value was stored yet.
+func (s *Store[T]) Load() *T {
+	return s.value.Load()
+}
+
+// Store replaces the current value, such as to provide a default before the
+// key is watched.
+func (s *Store[T]) Store(v *T) {
+	s.value.Store(v)
+}
+
+// UnmarshalBinary decodes the data into a new T and replaces the current value
+// with it. If decoding fails the current value is kept and the error is
+// returned.
+func (s *Store[T]) UnmarshalBinary(data []byte) error {
+	v := new(T)
+	if err := Decoder(s.codec, v).UnmarshalBinary(data); err != nil {
+		return err
+	}
+	s.value.Store(v)
+	return nil
+}
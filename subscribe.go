@@ -0,0 +1,122 @@
+package konsul
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Instance is an instance of a service yielded by Instancer.
+type Instance struct {
+	// The address of the instance, in the host:port form yielded by
+	// Instancer.Instance.
+	Address string
+	// The ID of the service instance.
+	ID string
+	// The name of the node the instance is registered on.
+	Node string
+	// The tags of the instance.
+	Tags []string
+	// The metadata of the instance.
+	Meta map[string]string
+	// The aggregated status of the health checks of the instance and its node,
+	// one of api.HealthPassing, api.HealthWarning, api.HealthCritical, or
+	// api.HealthMaint, the worst status taking precedence.
+	Status string
+}
+
+// newInstance creates the Instance of the service entry.
+func newInstance(entry *api.ServiceEntry) Instance {
+	addr := entry.Node.Address
+	if entry.Service.Address != "" {
+		addr = entry.Service.Address
+	}
+	return Instance{
+		Address: fmt.Sprintf("%s:%d", addr, entry.Service.Port),
+		ID:      entry.Service.ID,
+		Node:    entry.Node.Node,
+		Tags:    entry.Service.Tags,
+		Meta:    entry.Service.Meta,
+		Status:  entry.Checks.AggregatedStatus(),
+	}
+}
+
+// Subscribe returns a channel receiving the instances each time they change,
+// for consumers integrating instance updates into select loops rather than
+// implementing InstanceListener:
+//
+//	updates, unsubscribe := instancer.Subscribe()
+//	defer unsubscribe()
+//	for {
+//		select {
+//		case instances := <-updates:
+//			...
+//		case <-ctx.Done():
+//			return
+//		}
+//	}
+//
+// The channel receives the current instances immediately. Only the latest
+// instances are kept if the channel isn't drained fast enough, so receivers
+// never observe outdated instances. The instances are shared by every
+// subscriber and must not be modified.
+//
+// The returned function unsubscribes and closes the channel. The channel is
+// also closed once the Instancer is closed, and is returned closed if the
+// Instancer has been closed already.
+func (i *Instancer) Subscribe() (<-chan []Instance, func()) {
+	ch := make(chan []Instance, 1)
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if i.closed.Load() {
+		close(ch)
+		return ch, func() {}
+	}
+	i.subSeq++
+	id := i.subSeq
+	i.subs[id] = ch
+	ch <- i.details
+
+	return ch, func() {
+		i.mutex.Lock()
+		defer i.mutex.Unlock()
+		if _, ok := i.subs[id]; ok {
+			delete(i.subs, id)
+			close(ch)
+		}
+	}
+}
+
+// publish sends the instances to the subscribers, replacing instances that
+// weren't received yet. The caller must hold the mutex.
+func (i *Instancer) publish(instances []Instance) {
+	i.details = instances
+	for _, ch := range i.subs {
+		sendLatest(ch, instances)
+	}
+}
+
+// sendLatest sends the instances to the channel, replacing instances that
+// weren't received yet.
+func sendLatest(ch chan []Instance, instances []Instance) {
+	for {
+		select {
+		case ch <- instances:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// closeSubscriptions closes the channels of every subscriber. The caller must
+// hold the mutex.
+func (i *Instancer) closeSubscriptions() {
+	for id, ch := range i.subs {
+		delete(i.subs, id)
+		close(ch)
+	}
+}
@@ -0,0 +1,156 @@
+package konsul
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tags returns the tags every instance must have.
+func (ic *InstancerConfig) tags() []string {
+	var tags []string
+	if ic.Tag != "" {
+		tags = append(tags, ic.Tag)
+	}
+	return append(tags, ic.Tags...)
+}
+
+// filter returns the filter expression evaluated by Consul, combining Filter
+// with the expressions matching AnyTags and TagExpression. An error is returned
+// if TagExpression is invalid.
+func (ic *InstancerConfig) filter() (string, error) {
+	var exprs []string
+	if strings.TrimSpace(ic.Filter) != "" {
+		exprs = append(exprs, ic.Filter)
+	}
+	if len(ic.AnyTags) > 0 {
+		matches := make([]string, len(ic.AnyTags))
+		for i, tag := range ic.AnyTags {
+			matches[i] = tagFilter(tag)
+		}
+		exprs = append(exprs, strings.Join(matches, " or "))
+	}
+	if strings.TrimSpace(ic.TagExpression) != "" {
+		expr, err := parseTagExpression(ic.TagExpression)
+		if err != nil {
+			return "", err
+		}
+		exprs = append(exprs, expr)
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	for i, expr := range exprs {
+		exprs[i] = "(" + expr + ")"
+	}
+	return strings.Join(exprs, " and "), nil
+}
+
+// tagFilter returns the filter expression matching instances with the tag.
+func tagFilter(tag string) string {
+	return strconv.Quote(tag) + " in Service.Tags"
+}
+
+// parseTagExpression parses a tag expression and returns the equivalent Consul
+// filter expression. A tag expression combines tags with & (and), | (or), !
+// (not), and parentheses, & taking precedence over |:
+//
+//	v2 & (us-east | us-west) & !canary
+//
+// Tags are any sequence of characters other than whitespace, operators, and
+// parentheses.
+func parseTagExpression(expr string) (string, error) {
+	p := &tagParser{input: expr}
+	out, err := p.or()
+	if err != nil {
+		return "", fmt.Errorf("invalid tag expression %q: %w", expr, err)
+	}
+	if p.skipSpace(); p.pos < len(p.input) {
+		return "", fmt.Errorf("invalid tag expression %q: unexpected %q at offset %d",
+			expr, p.input[p.pos], p.pos)
+	}
+	return out, nil
+}
+
+// tagParser is a recursive descent parser for tag expressions.
+type tagParser struct {
+	input string
+	pos   int
+}
+
+func (p *tagParser) or() (string, error) {
+	left, err := p.and()
+	if err != nil {
+		return "", err
+	}
+	for p.accept('|') {
+		right, err := p.and()
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " or " + right + ")"
+	}
+	return left, nil
+}
+
+func (p *tagParser) and() (string, error) {
+	left, err := p.unary()
+	if err != nil {
+		return "", err
+	}
+	for p.accept('&') {
+		right, err := p.unary()
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " and " + right + ")"
+	}
+	return left, nil
+}
+
+func (p *tagParser) unary() (string, error) {
+	if p.accept('!') {
+		operand, err := p.unary()
+		if err != nil {
+			return "", err
+		}
+		return "not (" + operand + ")", nil
+	}
+	if p.accept('(') {
+		expr, err := p.or()
+		if err != nil {
+			return "", err
+		}
+		if !p.accept(')') {
+			return "", fmt.Errorf("missing ) at offset %d", p.pos)
+		}
+		return expr, nil
+	}
+	start := p.pos
+	for p.pos < len(p.input) && !strings.ContainsRune(" \t\n\r&|!()", rune(p.input[p.pos])) {
+		p.pos++
+	}
+	if p.pos == start {
+		if p.pos == len(p.input) {
+			return "", fmt.Errorf("missing tag at end of expression")
+		}
+		return "", fmt.Errorf("missing tag at offset %d", p.pos)
+	}
+	return tagFilter(p.input[start:p.pos]), nil
+}
+
+// accept consumes the next character if it's c, skipping whitespace.
+func (p *tagParser) accept(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.input) && p.input[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *tagParser) skipSpace() {
+	for p.pos < len(p.input) && strings.ContainsRune(" \t\n\r", rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
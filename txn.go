@@ -0,0 +1,220 @@
+package konsul
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// MaxTxnOps is the maximum number of operations Consul allows in a single
+// transaction.
+const MaxTxnOps = 64
+
+// Txn builds a transaction of KV operations that Consul applies atomically:
+// either every operation is applied or none are. Operations are applied in the
+// order they're added.
+//
+//	result, err := kv.Txn().
+//		Put("config/app/db", db).
+//		Put("config/app/cache", cache).
+//		CheckIndex("config/app/version", version).
+//		Commit()
+//
+// Txn is not safe for concurrent use. Use KVClient.Txn to create a Txn.
+type Txn struct {
+	client KVClient
+	ops    api.TxnOps
+	err    error
+	// The indexes of the operations whose values are recorded in the history
+	// of their key when the KVClient keeps history.
+	recorded []int
+}
+
+// TxnError describes why an operation of a transaction caused it to be rolled
+// back.
+type TxnError struct {
+	// The index of the operation in the order it was added to the Txn.
+	OpIndex int
+	// The reason given by Consul.
+	What string
+}
+
+func (e TxnError) Error() string {
+	return fmt.Sprintf("operation %d: %s", e.OpIndex, e.What)
+}
+
+// TxnResult is the outcome of a committed transaction.
+type TxnResult struct {
+	// True if the transaction was applied, or false if it was rolled back, in
+	// which case Errors describes why.
+	Committed bool
+	// The key-values returned by the operations of the transaction. Values are
+	// only populated for Get operations, and deleted keys aren't included.
+	KeyValues []KeyValue
+	// The operations that caused the transaction to be rolled back.
+	Errors []TxnError
+}
+
+// Get returns the KeyValue returned for the key and true, or false if the
+// transaction didn't return the key.
+func (r *TxnResult) Get(key string) (KeyValue, bool) {
+	for _, kv := range r.KeyValues {
+		if kv.Key() == key {
+			return kv, true
+		}
+	}
+	return KeyValue{}, false
+}
+
+// Txn creates an empty transaction of KV operations.
+func (c KVClient) Txn() *Txn {
+	return &Txn{client: c}
+}
+
+// Get adds an operation retrieving the key. The transaction is rolled back if
+// the key doesn't exist.
+func (t *Txn) Get(key string) *Txn {
+	return t.add(&api.KVTxnOp{Verb: api.KVGet, Key: key})
+}
+
+// Put adds an operation setting the value of the key. The value is validated
+// against the Schemas of the KVClient. When the KVClient keeps history a
+// snapshot of the value is written in the same transaction, counting toward
+// MaxTxnOps.
+func (t *Txn) Put(key string, value []byte) *Txn {
+	t.recorded = append(t.recorded, len(t.ops))
+	return t.add(&api.KVTxnOp{Verb: api.KVSet, Key: key, Value: t.encode(key, value)})
+}
+
+// PutCAS adds an operation setting the value of the key if its ModifyIndex
+// matches modifyIndex, or if modifyIndex is 0 and the key doesn't exist. The
+// transaction is rolled back if it doesn't match. Like Put, a snapshot of the
+// value is written when the KVClient keeps history.
+func (t *Txn) PutCAS(key string, value []byte, modifyIndex uint64) *Txn {
+	t.recorded = append(t.recorded, len(t.ops))
+	return t.add(&api.KVTxnOp{Verb: api.KVCAS, Key: key, Value: t.encode(key, value), Index: modifyIndex})
+}
+
+// Delete adds an operation deleting the key.
+func (t *Txn) Delete(key string) *Txn {
+	return t.add(&api.KVTxnOp{Verb: api.KVDelete, Key: key})
+}
+
+// DeleteCAS adds an operation deleting the key if its ModifyIndex matches
+// modifyIndex. The transaction is rolled back if it doesn't match.
+func (t *Txn) DeleteCAS(key string, modifyIndex uint64) *Txn {
+	return t.add(&api.KVTxnOp{Verb: api.KVDeleteCAS, Key: key, Index: modifyIndex})
+}
+
+// CheckIndex adds an operation rolling back the transaction unless the
+// ModifyIndex of the key matches modifyIndex.
+func (t *Txn) CheckIndex(key string, modifyIndex uint64) *Txn {
+	return t.add(&api.KVTxnOp{Verb: api.KVCheckIndex, Key: key, Index: modifyIndex})
+}
+
+// CheckNotExists adds an operation rolling back the transaction if the key
+// exists.
+func (t *Txn) CheckNotExists(key string) *Txn {
+	return t.add(&api.KVTxnOp{Verb: api.KVCheckNotExists, Key: key})
+}
+
+// Len returns the number of operations in the transaction.
+func (t *Txn) Len() int {
+	return len(t.ops)
+}
+
+// encode validates the value against the Schemas of the KVClient and
+// transforms it to be stored in Consul, recording the first error so it's
+// returned by Commit.
+func (t *Txn) encode(key string, value []byte) []byte {
+	if err := t.client.schemas.Validate(key, value); err != nil {
+		if t.err == nil {
+			t.err = err
+		}
+		return value
+	}
+	encoded, err := t.client.encodeValue(value)
+	if err != nil {
+		if t.err == nil {
+			t.err = err
+		}
+		return value
+	}
+	return encoded
+}
+
+func (t *Txn) add(op *api.KVTxnOp) *Txn {
+	t.ops = append(t.ops, &api.TxnOp{KV: op})
+	return t
+}
+
+// Commit applies the transaction. If the transaction is rolled back the
+// returned TxnResult isn't Committed and holds the errors of the operations
+// responsible, along with a nil error value. A non-nil error value is returned
+// if the transaction is invalid, such as exceeding MaxTxnOps or putting a value
+// rejected by the Schemas of the KVClient, or if an error occurs communicating
+// with Consul.
+func (t *Txn) Commit() (*TxnResult, error) {
+	if t.err != nil {
+		return nil, &KVError{Op: OpTxn, Err: t.err}
+	}
+	if len(t.ops) == 0 {
+		return &TxnResult{Committed: true}, nil
+	}
+	ops, indexes, snapshots := t.ops, []int(nil), map[string]bool(nil)
+	if t.client.history != nil && len(t.recorded) > 0 {
+		ops, indexes, snapshots = t.client.withSnapshots(t.ops, t.recorded)
+	}
+	if len(ops) > MaxTxnOps {
+		return nil, &KVError{Op: OpTxn, Err: invalidConfig(
+			fmt.Sprintf("transaction has %d operations, at most %d are allowed", len(ops), MaxTxnOps))}
+	}
+
+	var ok bool
+	var resp *api.TxnResponse
+	err := t.client.do(context.Background(), OpTxn, "", func() (err error) {
+		ok, resp, _, err = t.client.client.Txn().Txn(ops, nil)
+		return err
+	})
+	if err != nil {
+		t.client.logger.Debug("failed to apply transaction in Consul", "ops", len(t.ops), "error", err)
+		return nil, &KVError{Op: OpTxn, Err: err}
+	}
+
+	result := &TxnResult{Committed: ok}
+	for _, r := range resp.Results {
+		if r != nil && r.KV != nil && !snapshots[r.KV.Key] {
+			if r.KV.Value, err = t.client.decodeValue(r.KV.Value); err != nil {
+				return nil, &KVError{Op: OpTxn, Key: r.KV.Key, Err: err}
+			}
+			result.KeyValues = append(result.KeyValues, KeyValue{base: r.KV})
+		}
+	}
+	for _, e := range resp.Errors {
+		// Errors are reported for the operation a snapshot was written for.
+		opIndex := e.OpIndex
+		if indexes != nil && opIndex < len(indexes) {
+			opIndex = indexes[opIndex]
+		}
+		result.Errors = append(result.Errors, TxnError{OpIndex: opIndex, What: e.What})
+	}
+	t.client.logger.Debug("applied transaction in Consul", "ops", len(t.ops), "committed", ok)
+	if ok && snapshots != nil {
+		t.pruneHistory()
+	}
+	return result, nil
+}
+
+// pruneHistory deletes the oldest snapshots of the keys written by the
+// transaction beyond the Retain of the history of the KVClient.
+func (t *Txn) pruneHistory() {
+	pruned := make(map[string]bool, len(t.recorded))
+	for _, i := range t.recorded {
+		key := t.ops[i].KV.Key
+		if !pruned[key] {
+			pruned[key] = true
+			t.client.pruneHistory(key, nil)
+		}
+	}
+}
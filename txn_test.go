@@ -0,0 +1,101 @@
+package konsul
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// newTestTxnClient returns a KVClient keeping history whose transactions are
+// answered by respond, recording the operations of each transaction in ops.
+func newTestTxnClient(t *testing.T, ops *api.TxnOps, respond func(w http.ResponseWriter, ops api.TxnOps)) *KVClient {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/txn" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(ops); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		respond(w, *ops)
+	}))
+	t.Cleanup(srv.Close)
+	client, err := api.NewClient(&api.Config{Address: strings.TrimPrefix(srv.URL, "http://")})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return NewKVClient(client, WithHistory(HistoryOptions{}))
+}
+
+func TestTxn_CommitRecordsHistory(t *testing.T) {
+	var ops api.TxnOps
+	kv := newTestTxnClient(t, &ops, func(w http.ResponseWriter, ops api.TxnOps) {
+		resp := api.TxnResponse{}
+		for _, op := range ops {
+			resp.Results = append(resp.Results, &api.TxnResult{KV: &api.KVPair{Key: op.KV.Key, ModifyIndex: 2}})
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+
+	result, err := kv.Txn().
+		Put("config/app/db", []byte("db")).
+		CheckIndex("config/app/version", 1).
+		PutCAS("config/app/cache", []byte("cache"), 1).
+		Commit()
+	if err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if !result.Committed {
+		t.Fatalf("expected transaction to be committed")
+	}
+
+	var keys []string
+	for _, op := range ops {
+		keys = append(keys, op.KV.Key)
+	}
+	if len(ops) != 5 {
+		t.Fatalf("expected 5 operations, got %v", keys)
+	}
+	for _, i := range []int{1, 4} {
+		snapshot, value := ops[i].KV, ops[i-1].KV
+		if snapshot.Verb != api.KVSet || !strings.HasPrefix(snapshot.Key, value.Key+historySegment) ||
+			string(snapshot.Value) != string(value.Value) {
+			t.Errorf("expected snapshot of %s at operation %d, got %s", value.Key, i, snapshot.Key)
+		}
+	}
+	for _, kv := range result.KeyValues {
+		if strings.Contains(kv.Key(), historySegment) {
+			t.Errorf("expected snapshot %s to be omitted from the result", kv.Key())
+		}
+	}
+}
+
+func TestTxn_CommitReportsErrorsOfRecordedOperations(t *testing.T) {
+	var ops api.TxnOps
+	kv := newTestTxnClient(t, &ops, func(w http.ResponseWriter, ops api.TxnOps) {
+		w.WriteHeader(http.StatusConflict)
+		// The operations are db, its snapshot, version, cache, and its snapshot.
+		_ = json.NewEncoder(w).Encode(api.TxnResponse{Errors: api.TxnErrors{{OpIndex: 3, What: "index mismatch"}}})
+	})
+
+	result, err := kv.Txn().
+		Put("config/app/db", []byte("db")).
+		CheckIndex("config/app/version", 1).
+		PutCAS("config/app/cache", []byte("cache"), 1).
+		Commit()
+	if err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if result.Committed {
+		t.Fatalf("expected transaction to be rolled back")
+	}
+	if len(result.Errors) != 1 || result.Errors[0].OpIndex != 2 {
+		t.Errorf("expected error of operation 2, got %v", result.Errors)
+	}
+}
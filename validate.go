@@ -0,0 +1,100 @@
+package konsul
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Validator validates the value of a key, such as against a JSON Schema. A nil
+// error indicates the value is valid.
+type Validator interface {
+	Validate(value []byte) error
+}
+
+// ValidatorFunc is an adapter to allow the use of ordinary functions as a
+// Validator.
+type ValidatorFunc func(value []byte) error
+
+// Validate calls f(value).
+func (f ValidatorFunc) Validate(value []byte) error {
+	return f(value)
+}
+
+// ValidationError is returned when the value of a key is rejected by its
+// Validator.
+type ValidationError struct {
+	Key string
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid value for key %s: %s", e.Key, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Schemas associates keys with the Validator of their values. The same Schemas
+// can be provided to KVClient and Watch so that both writes and incoming
+// changes are validated against the same schema, rejecting invalid
+// configuration before it reaches application code.
+//
+// Validators are attached to an exact key or, when the key ends with "/", to
+// every key under the prefix. The Validator of the longest matching key is
+// used.
+//
+// The zero-value of Schemas is ready to use.
+type Schemas struct {
+	mu         sync.RWMutex
+	validators map[string]Validator
+}
+
+// Set attaches the Validator to the key. A nil Validator removes the Validator
+// attached to the key.
+func (s *Schemas) Set(key string, v Validator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v == nil {
+		delete(s.validators, key)
+		return
+	}
+	if s.validators == nil {
+		s.validators = make(map[string]Validator)
+	}
+	s.validators[key] = v
+}
+
+// Validate validates the value with the Validator attached to the key. If no
+// Validator is attached to the key nil is returned. If the value is invalid a
+// *ValidationError is returned.
+func (s *Schemas) Validate(key string, value []byte) error {
+	if s == nil {
+		return nil
+	}
+	v := s.lookup(key)
+	if v == nil {
+		return nil
+	}
+	if err := v.Validate(value); err != nil {
+		return &ValidationError{Key: key, Err: err}
+	}
+	return nil
+}
+
+func (s *Schemas) lookup(key string) Validator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if v, ok := s.validators[key]; ok {
+		return v
+	}
+	var match string
+	var validator Validator
+	for prefix, v := range s.validators {
+		if strings.HasSuffix(prefix, "/") && strings.HasPrefix(key, prefix) && len(prefix) > len(match) {
+			match, validator = prefix, v
+		}
+	}
+	return validator
+}
@@ -0,0 +1,143 @@
+package konsul
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Value holds the latest value of a key watched by WatchTyped, decoded into a
+// T. Each change is decoded into a fresh T and swapped atomically like Store,
+// so values returned by Load must be treated as immutable.
+//
+// Value is safe for concurrent use. The zero-value of Value is not usable, use
+// WatchTyped to create a Value.
+type Value[T any] struct {
+	value   atomic.Pointer[T]
+	changes chan *T
+	ready   atomic.Bool
+	handle  *WatchHandle
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// WatchTyped watches a key and returns a Value holding its latest value decoded
+// into a T, turning the common pattern of watching configuration into a struct
+// into a single call:
+//
+//	cfg, err := konsul.WatchTyped[AppConfig](ctx, client, "config/app")
+//	if err != nil {
+//		return err
+//	}
+//	defer cfg.Close()
+//	...
+//	debug := cfg.Load().Debug
+//
+// The key is retrieved before WatchTyped returns, so Load never returns nil. If
+// the key doesn't exist or its value cannot be decoded a *WatchError is
+// returned, matching ErrKeyNotFound when the key doesn't exist. The key is
+// then watched on a new goroutine until the context is cancelled or Close is
+// called.
+//
+// Values are decoded with WatchOptions.Decoder if provided, otherwise the
+// format of each value is detected with DetectCodec.
+func WatchTyped[T any](ctx context.Context, client *api.Client, key string, opts ...WatchOption) (*Value[T], error) {
+	if client == nil {
+		return nil, invalidConfig("cannot provide nil consul api.Client")
+	}
+	v := &Value[T]{
+		changes: make(chan *T, 1),
+		closed:  make(chan struct{}),
+	}
+	decode := BuildWatchOptions(opts...).Decoder
+	if decode == nil {
+		decode = func(data []byte, t any) error {
+			return Decoder("", t).UnmarshalBinary(data)
+		}
+	}
+	handle, err := StartWatch(client, key, &valueTarget[T]{value: v, decode: decode},
+		append(append([]WatchOption{}, opts...), WithFailFast())...)
+	if err != nil {
+		return nil, err
+	}
+	v.handle = handle
+	v.ready.Store(true)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			v.Close()
+		case <-v.closed:
+		}
+	}()
+	return v, nil
+}
+
+// Load returns the latest value of the key.
+func (v *Value[T]) Load() *T {
+	return v.value.Load()
+}
+
+// Changes returns a channel receiving the new value each time the key changes.
+// Only the latest value is kept if the channel isn't drained fast enough, so
+// receivers never observe outdated values. The channel is shared by every
+// caller and is closed once the Value is closed.
+func (v *Value[T]) Changes() <-chan *T {
+	return v.changes
+}
+
+// Err returns the error of the last change to the key, or the error the watch
+// failed with, like WatchHandle.LastError.
+func (v *Value[T]) Err() error {
+	return v.handle.LastError()
+}
+
+// Close stops watching the key and closes the Changes channel. The value last
+// loaded remains available with Load. Closing a Value more than once has no
+// effect.
+func (v *Value[T]) Close() {
+	v.once.Do(func() {
+		close(v.closed)
+		v.handle.Stop()
+		close(v.changes)
+	})
+}
+
+// publish swaps the current value and sends it to the Changes channel,
+// replacing a value that wasn't received yet. The value of the initial fetch
+// isn't sent since it isn't a change.
+func (v *Value[T]) publish(t *T) {
+	v.value.Store(t)
+	if !v.ready.Load() {
+		return
+	}
+	for {
+		select {
+		case v.changes <- t:
+			return
+		default:
+		}
+		select {
+		case <-v.changes:
+		default:
+		}
+	}
+}
+
+// valueTarget decodes the values of the key into a new T and publishes them to
+// the Value.
+type valueTarget[T any] struct {
+	value  *Value[T]
+	decode DecoderFunc
+}
+
+func (t *valueTarget[T]) UnmarshalBinary(data []byte) error {
+	v := new(T)
+	if err := t.decode(data, v); err != nil {
+		return err
+	}
+	t.value.publish(v)
+	return nil
+}
@@ -0,0 +1,499 @@
+// Package vault resolves references to Vault secrets found in values stored in
+// Consul KV store, bridging the common split of configuration in Consul and
+// secrets in Vault.
+//
+// A reference has the form "vault:<path>#<field>", for example a KV holding
+//
+//	{"dsn": "postgres://app:vault:secret/data/db#password@db:5432/app"}
+//
+// has the reference replaced with the "password" field of the secret at
+// "secret/data/db" before it's unmarshalled. For KV version 2 secret engines,
+// fields nested under "data" are resolved automatically.
+//
+// The package doesn't depend on a Vault client. Instead a SecretReader is
+// supplied, which is trivially implemented with the official Vault client:
+//
+//	type reader struct{ c *vaultapi.Client }
+//
+//	func (r reader) ReadSecret(ctx context.Context, path string) (*vault.Secret, error) {
+//		s, err := r.c.Logical().ReadWithContext(ctx, path)
+//		if err != nil || s == nil {
+//			return nil, err
+//		}
+//		return &vault.Secret{
+//			Data:          s.Data,
+//			LeaseDuration: time.Duration(s.LeaseDuration) * time.Second,
+//		}, nil
+//	}
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+
+	"github.com/jkratz55/konsul"
+)
+
+// ErrSecretNotFound is returned when a referenced secret or field doesn't
+// exist.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// reference matches vault:<path>#<field> references.
+var reference = regexp.MustCompile(`vault:([A-Za-z0-9_\-./]+)#([A-Za-z0-9_\-.]+)`)
+
+// Secret is a secret read from Vault.
+type Secret struct {
+	// The data of the secret.
+	Data map[string]any
+	// The duration the secret is valid for. Secrets are read again from Vault
+	// when two thirds of the lease duration has elapsed. A zero value uses the
+	// DefaultTTL of the Resolver.
+	LeaseDuration time.Duration
+}
+
+// SecretReader reads secrets from Vault.
+type SecretReader interface {
+	ReadSecret(ctx context.Context, path string) (*Secret, error)
+}
+
+// Options holds optional configuration properties for Resolver.
+type Options struct {
+	// The duration secrets without a lease are cached for. If not provided 5
+	// minutes is used.
+	DefaultTTL time.Duration
+	// The interval at which cached secrets are checked for renewal by Run. If
+	// not provided 10 seconds is used.
+	CheckInterval time.Duration
+	// The timeout reading the secrets referenced by a value passed to a
+	// Target, such as by konsul.Watch, which doesn't provide a context. If not
+	// provided 10 seconds is used.
+	Timeout time.Duration
+	// The logger used to log renewals and errors. If not provided a default
+	// logger will be used.
+	Logger konsul.Logger
+	// The Clock used to schedule renewals. If not provided konsul.SystemClock
+	// is used.
+	Clock konsul.Clock
+}
+
+type cachedSecret struct {
+	data    map[string]any
+	renewAt time.Time
+}
+
+// Resolver resolves references to Vault secrets and caches the secrets for the
+// duration of their lease.
+//
+// The zero-value of Resolver is not usable. Use NewResolver to create and
+// initialize a Resolver.
+type Resolver struct {
+	reader        SecretReader
+	defaultTTL    time.Duration
+	checkInterval time.Duration
+	timeout       time.Duration
+	logger        hclog.Logger
+	clock         konsul.Clock
+
+	mu      sync.Mutex
+	cache   map[string]*cachedSecret
+	targets []*Target
+}
+
+// NewResolver creates and initializes a Resolver reading secrets with the
+// provided SecretReader. A nil SecretReader will cause a panic.
+func NewResolver(reader SecretReader, opts Options) *Resolver {
+	if reader == nil {
+		panic("cannot provide nil SecretReader, illegal use of api")
+	}
+	if opts.DefaultTTL == 0 {
+		opts.DefaultTTL = 5 * time.Minute
+	}
+	if opts.CheckInterval == 0 {
+		opts.CheckInterval = 10 * time.Second
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	return &Resolver{
+		reader:        reader,
+		defaultTTL:    opts.DefaultTTL,
+		checkInterval: opts.CheckInterval,
+		timeout:       opts.Timeout,
+		logger:        konsul.HclogAdapter(opts.Logger),
+		clock:         konsul.ClockOrSystem(opts.Clock),
+		cache:         make(map[string]*cachedSecret),
+	}
+}
+
+// Resolve returns the value with every reference replaced with the value of the
+// referenced secret field. Secrets are read from Vault unless they're cached.
+//
+// JSON, YAML, and TOML documents are decoded and references are only replaced
+// within strings, which are escaped when the document is encoded again, so
+// secrets containing quotes or newlines cannot corrupt the document or inject
+// keys. Other values, such as plain text, have references replaced verbatim.
+//
+// If a secret cannot be read, or a referenced field doesn't exist, a non-nil
+// error is returned.
+func (r *Resolver) Resolve(ctx context.Context, value []byte) ([]byte, error) {
+	if !reference.Match(value) {
+		return value, nil
+	}
+	replace := func(s string) (string, error) {
+		return r.replace(ctx, s)
+	}
+	switch konsul.DetectCodec(value) {
+	case konsul.CodecJSON:
+		return resolveJSON(value, replace)
+	case konsul.CodecTOML:
+		return resolveTOML(value, replace)
+	default:
+		return resolveYAML(value, replace)
+	}
+}
+
+// replace returns s with every reference replaced with the value of the
+// referenced secret field.
+func (r *Resolver) replace(ctx context.Context, s string) (string, error) {
+	var resolveErr error
+	resolved := reference.ReplaceAllStringFunc(s, func(match string) string {
+		if resolveErr != nil {
+			return match
+		}
+		groups := reference.FindStringSubmatch(match)
+		path, field := groups[1], groups[2]
+		secret, err := r.secret(ctx, path)
+		if err != nil {
+			resolveErr = err
+			return match
+		}
+		v, ok := lookup(secret, field)
+		if !ok {
+			resolveErr = fmt.Errorf("field %s of secret %s: %w", field, path, ErrSecretNotFound)
+			return match
+		}
+		return v
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+	return resolved, nil
+}
+
+func resolveJSON(value []byte, replace func(string) (string, error)) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(value))
+	// Numbers are kept as written rather than converted to float64.
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("error decoding JSON value: %w", err)
+	}
+	doc, err := resolveStrings(doc, replace)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, fmt.Errorf("error encoding JSON value: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func resolveTOML(value []byte, replace func(string) (string, error)) ([]byte, error) {
+	var doc map[string]any
+	if err := toml.Unmarshal(value, &doc); err != nil {
+		return nil, fmt.Errorf("error decoding TOML value: %w", err)
+	}
+	resolved, err := resolveStrings(doc, replace)
+	if err != nil {
+		return nil, err
+	}
+	out, err := toml.Marshal(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding TOML value: %w", err)
+	}
+	return out, nil
+}
+
+// resolveYAML replaces references in the string scalars of YAML mappings and
+// sequences. Values that aren't YAML collections, such as plain text, have
+// references replaced verbatim since there's nothing to escape.
+func resolveYAML(value []byte, replace func(string) (string, error)) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(value, &doc); err != nil || len(doc.Content) == 0 ||
+		(doc.Content[0].Kind != yaml.MappingNode && doc.Content[0].Kind != yaml.SequenceNode) {
+		resolved, err := replace(string(value))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(resolved), nil
+	}
+	if err := resolveNode(&doc, replace); err != nil {
+		return nil, err
+	}
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding YAML value: %w", err)
+	}
+	return out, nil
+}
+
+// resolveNode replaces references in the string scalars of the node. The
+// encoder quotes scalars as needed, including secrets that would otherwise be
+// read as another type.
+func resolveNode(node *yaml.Node, replace func(string) (string, error)) error {
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!str" {
+		resolved, err := replace(node.Value)
+		if err != nil {
+			return err
+		}
+		node.Value = resolved
+		return nil
+	}
+	for _, child := range node.Content {
+		if err := resolveNode(child, replace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveStrings replaces references in the strings of a decoded document.
+func resolveStrings(v any, replace func(string) (string, error)) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return replace(v)
+	case map[string]any:
+		for key, elem := range v {
+			resolved, err := resolveStrings(elem, replace)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = resolved
+		}
+	case []any:
+		for i, elem := range v {
+			resolved, err := resolveStrings(elem, replace)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+	}
+	return v, nil
+}
+
+// Unmarshaler returns a Target resolving references in values before passing
+// them to cfg. The Target can be passed to konsul.Watch in place of cfg. When
+// Run is executing, cfg is updated with the last value each time a secret it
+// references changes.
+//
+// The Resolver holds on to the Target until it's closed, so Close should be
+// called once the Target is no longer used.
+func (r *Resolver) Unmarshaler(cfg encoding.BinaryUnmarshaler) *Target {
+	if cfg == nil {
+		panic("cannot provide nil encoding.BinaryUnmarshaler, illegal use of api")
+	}
+	t := &Target{resolver: r, cfg: cfg}
+	r.mu.Lock()
+	r.targets = append(r.targets, t)
+	r.mu.Unlock()
+	return t
+}
+
+// Run periodically reads cached secrets due for renewal from Vault and
+// re-applies the last value of every Target referencing a secret whose data
+// changed, until the context is cancelled.
+//
+// Run is blocking and in nearly all use cases it should be called on a new
+// goroutine.
+func (r *Resolver) Run(ctx context.Context) error {
+	ticker := r.clock.NewTicker(r.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C():
+			r.renew(ctx)
+		}
+	}
+}
+
+func (r *Resolver) renew(ctx context.Context) {
+	r.mu.Lock()
+	due := make([]string, 0)
+	for path, cached := range r.cache {
+		if !r.clock.Now().Before(cached.renewAt) {
+			due = append(due, path)
+		}
+	}
+	r.mu.Unlock()
+
+	changed := make(map[string]struct{})
+	for _, path := range due {
+		r.mu.Lock()
+		previous := r.cache[path].data
+		r.mu.Unlock()
+
+		secret, err := r.read(ctx, path)
+		if err != nil {
+			// The cached secret is kept and renewal is retried on the next check.
+			r.logger.Error("failed to renew secret", "path", path, "error", err)
+			continue
+		}
+		if !reflect.DeepEqual(previous, secret) {
+			r.logger.Info("secret changed", "path", path)
+			changed[path] = struct{}{}
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	targets := make([]*Target, len(r.targets))
+	copy(targets, r.targets)
+	r.mu.Unlock()
+	for _, t := range targets {
+		if t.references(changed) {
+			if err := t.reapply(ctx); err != nil {
+				r.logger.Error(fmt.Sprintf("failed to re-apply secrets to type %T", t.cfg),
+					"error", err)
+			}
+		}
+	}
+}
+
+// secret returns the data of the secret at path from the cache, reading it
+// from Vault if it isn't cached.
+func (r *Resolver) secret(ctx context.Context, path string) (map[string]any, error) {
+	r.mu.Lock()
+	cached, ok := r.cache[path]
+	r.mu.Unlock()
+	if ok {
+		return cached.data, nil
+	}
+	return r.read(ctx, path)
+}
+
+func (r *Resolver) read(ctx context.Context, path string) (map[string]any, error) {
+	secret, err := r.reader.ReadSecret(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secret %s from Vault: %w", path, err)
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("secret %s: %w", path, ErrSecretNotFound)
+	}
+	ttl := secret.LeaseDuration
+	if ttl <= 0 {
+		ttl = r.defaultTTL
+	}
+	r.mu.Lock()
+	r.cache[path] = &cachedSecret{
+		data:    secret.Data,
+		renewAt: r.clock.Now().Add(ttl * 2 / 3),
+	}
+	r.mu.Unlock()
+	return secret.Data, nil
+}
+
+// lookup returns the field of the secret as a string, falling back to the
+// "data" map used by KV version 2 secret engines.
+func lookup(secret map[string]any, field string) (string, bool) {
+	if v, ok := secret[field]; ok {
+		return fmt.Sprint(v), true
+	}
+	if data, ok := secret["data"].(map[string]any); ok {
+		if v, ok := data[field]; ok {
+			return fmt.Sprint(v), true
+		}
+	}
+	return "", false
+}
+
+// Target resolves references to Vault secrets before passing values to a
+// wrapped encoding.BinaryUnmarshaler. Target implements
+// encoding.BinaryUnmarshaler so it can be used with konsul.Watch.
+//
+// Use Resolver.Unmarshaler to create a Target.
+type Target struct {
+	resolver *Resolver
+	cfg      encoding.BinaryUnmarshaler
+
+	mu    sync.Mutex
+	value []byte
+	paths map[string]struct{}
+}
+
+// UnmarshalBinary resolves the references in data and passes the result to the
+// wrapped encoding.BinaryUnmarshaler.
+func (t *Target) UnmarshalBinary(data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.value = append([]byte(nil), data...)
+	t.paths = make(map[string]struct{})
+	for _, groups := range reference.FindAllSubmatch(data, -1) {
+		t.paths[string(groups[1])] = struct{}{}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.resolver.timeout)
+	defer cancel()
+	return t.apply(ctx)
+}
+
+// Close removes the Target from its Resolver, so it's no longer updated by Run
+// and can be garbage collected. The wrapped encoding.BinaryUnmarshaler keeps
+// the last value applied. Calling Close more than once has no effect.
+func (t *Target) Close() {
+	r := t.resolver
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, target := range r.targets {
+		if target == t {
+			copy(r.targets[i:], r.targets[i+1:])
+			// Clear the last element so the Target can be garbage collected.
+			r.targets[len(r.targets)-1] = nil
+			r.targets = r.targets[:len(r.targets)-1]
+			return
+		}
+	}
+}
+
+func (t *Target) reapply(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.apply(ctx)
+}
+
+func (t *Target) apply(ctx context.Context) error {
+	resolved, err := t.resolver.Resolve(ctx, t.value)
+	if err != nil {
+		return err
+	}
+	return t.cfg.UnmarshalBinary(resolved)
+}
+
+func (t *Target) references(paths map[string]struct{}) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for path := range paths {
+		if _, ok := t.paths[path]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -1,13 +1,19 @@
 package konsul
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding"
+	"errors"
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/api/watch"
 	"github.com/hashicorp/go-hclog"
+
+	"github.com/jkratz55/konsul/log/redact"
 )
 
 // WatchNotificationFunc is a callback function that can optionally be invoked
@@ -16,17 +22,139 @@ import (
 // value is passed.
 type WatchNotificationFunc func(key string, err error)
 
+// WatchChange describes a change to a watched key, allowing applications to log
+// or audit exactly what changed.
+type WatchChange struct {
+	Key string
+	// The value of the key last applied to cfg before the change, or nil if no
+	// value was applied yet.
+	Old []byte
+	// The value of the key after the change, or nil if it couldn't be decoded.
+	New []byte
+	// The ModifyIndex of the key after the change.
+	ModifyIndex uint64
+	// The error handling the change failed with, or nil if cfg was refreshed
+	// with the new value.
+	Err error
+}
+
+// WatchChangeFunc is a callback function that can optionally be invoked by
+// Watch each time it handles a change to the key, like WatchNotificationFunc,
+// with the previous and new values of the key.
+type WatchChangeFunc func(change WatchChange)
+
 // WatchOptions holds configuration properties customizing the behavior of Watch.
 type WatchOptions struct {
 	// The logger used to log events and errors while watching a KV in Consul.
-	// If not provided a default logger will be used.
-	Logger hclog.Logger
+	// If not provided a default logger will be used. Any Logger can be used,
+	// including hclog.Logger and *slog.Logger.
+	Logger Logger
 	// Flag to control if the Watch function should panic if it cannot successfully
 	// unmarshall and update the target type on a KV change event. When true Watch
-	// will panic the call to UnmarshalBinary returns an error.
+	// will panic the call to UnmarshalBinary returns an error. Values that fail
+	// to be decrypted, decompressed, or validated never reach UnmarshalBinary
+	// and are only reported.
 	PanicOnUnmarshalFailure bool
+	// An optional channel failures are reported on, giving applications a
+	// structured way to decide between shutting down and running degraded.
+	// Changes that fail to be applied are sent, as well as a *WatchError for
+	// each failed query, which the watch retries with backoff, including
+	// watches running on background goroutines such as those started by
+	// StartWatch. Errors are sent without blocking the watch and are dropped
+	// if the channel is full, so a buffered channel should be provided. When
+	// provided Watch never panics, regardless of PanicOnUnmarshalFailure.
+	Errors chan<- error
 	// An optional callback func that get invoked everytime a KV change is detected.
 	WatchNotification WatchNotificationFunc
+	// An optional callback func invoked everytime a KV change is detected, like
+	// WatchNotification, with the old and new values of the key. The values
+	// are decoded, and may hold secrets that shouldn't be logged.
+	OnChange WatchChangeFunc
+	// Optional configuration to redact sensitive values such as passwords and
+	// tokens before they are logged. Unmarshalling errors can include parts of
+	// the KV value so configuring redaction is recommended for KVs holding
+	// secrets.
+	Redact redact.Config
+	// An optional minimum level for messages logged by Watch, allowing its
+	// verbosity to be tuned independently of other subsystems sharing the same
+	// logger. Messages below this level are discarded before reaching Logger.
+	// The zero-value hclog.NoLevel doesn't filter any messages.
+	LogLevel hclog.Level
+	// Optional instrumentation hooks invoked each time a change to the key is
+	// handled.
+	Metrics Metrics
+	// Optional validators of the values of keys. Changes with a value rejected
+	// by the Validator of the key are handled like unmarshalling failures, and
+	// the value is never passed to cfg.
+	Schemas *Schemas
+	// Optional keys used to decrypt values encrypted by a KVClient configured
+	// with the same keys. Values that aren't encrypted are passed to cfg
+	// unchanged. Values that cannot be decrypted are handled like
+	// unmarshalling failures.
+	Encryption KeyProvider
+	// Optional compression configuration. When an Algorithm is configured,
+	// values compressed by a KVClient are decompressed before being passed to
+	// cfg.
+	Compression CompressionOptions
+	// An optional function decoding values into the target of WatchInto,
+	// taking precedence over its codec, for formats without a registered Codec
+	// or types requiring custom decoding.
+	Decoder DecoderFunc
+	// An optional callback validating the decoded value of a change before
+	// it's passed to cfg, such as by decoding it into a candidate config and
+	// checking its invariants. When it returns an error the change is
+	// rejected: cfg keeps its previous value, and the rejection is reported
+	// to WatchNotification as a *ValidationError. It's invoked after the
+	// Schemas validated the value.
+	Validate func(key string, value []byte) error
+	// When true Watch retrieves the key before watching it and returns a
+	// *WatchError if the key doesn't exist, matching ErrKeyNotFound, or if its
+	// value cannot be applied, so applications can tell "no config yet" from
+	// "config loaded" at startup. Otherwise, Watch waits for the first change.
+	FailFast bool
+	// An optional callback invoked with the outcome of retrieving the key
+	// before it's watched, with a nil error once cfg is refreshed with its
+	// value. When provided the key is retrieved first even if FailFast is
+	// false, in which case Watch continues watching the key on failure.
+	OnInitialFetch func(key string, err error)
+	// An optional path of a file the last value successfully applied to cfg is
+	// written to. When the key cannot be retrieved from Consul at startup, such
+	// as during an outage, cfg is refreshed with the value in the file instead,
+	// allowing services to boot with stale but valid configuration, and Watch
+	// keeps watching the key until Consul is reachable. The value is written as
+	// stored in Consul, so encrypted values remain encrypted on disk.
+	CacheFile string
+	// An optional datacenter the key is watched in, rather than the datacenter
+	// of the agent the client is connected to.
+	Datacenter string
+	// An optional namespace the key is watched in. Namespaces are only
+	// available in Consul Enterprise.
+	Namespace string
+	// An optional admin partition the key is watched in. Partitions are only
+	// available in Consul Enterprise.
+	Partition string
+	// An optional ACL token the key is watched with, rather than the token of
+	// the client.
+	Token string
+	// When true and cfg fails to unmarshal a change, cfg is refreshed again
+	// with the last value successfully applied, so it isn't left partially
+	// updated by a failed unmarshal. The failure is reported to
+	// WatchNotification as a *RollbackError holding the last good value.
+	RollbackOnFailure bool
+}
+
+// validate validates the value of a change with the Schemas and the Validate
+// callback.
+func (o WatchOptions) validate(key string, value []byte) error {
+	if err := o.Schemas.Validate(key, value); err != nil {
+		return err
+	}
+	if o.Validate != nil {
+		if err := o.Validate(key, value); err != nil {
+			return &ValidationError{Key: key, Err: err}
+		}
+	}
+	return nil
 }
 
 // Watch watches a key in Consul's KV store and automatically refreshes a type
@@ -43,20 +171,24 @@ type WatchOptions struct {
 //
 // Watch is blocking and in nearly all use cases it should be called on a new
 // goroutine. Watch is intended to execute for the entire lifecycle of the
-// application. It doesn't provide a mechanism to stop watching a key. It will
+// application. Use WatchContext to stop watching a key, otherwise Watch will
 // only return on an error, and if it returns with an error the application will
 // no longer receive updates when a KV changes. In many cases the caller may want
 // to panic to prevent unexpected behavior since the configuration will not be
 // updated as expected.
 //
+// Watch is configured with WatchOption values. For compatibility WatchOptions
+// implements WatchOption, so a WatchOptions struct can be provided instead.
+//
+// Values stored with PutLarge are reassembled from their chunks before they're
+// decoded and passed to cfg.
+//
 // Example:
 //
 //	 cfg := &AppConfig{}
 //		go func() {
-//			err = konsul.Watch(client, "config/app", cfg, konsul.WatchOptions{
-//				Logger:                  kzap.Wrap(logger),
-//				PanicOnUnmarshalFailure: false,
-//			})
+//			err = konsul.Watch(client, "config/app", cfg,
+//				konsul.WithLogger(kzap.Wrap(logger)))
 //			// If Watch returns an error we aren't getting KV updates anymore so we'll
 //			// panic rather than running in a potentially weird state where we aren't
 //			// getting updates.
@@ -65,15 +197,172 @@ type WatchOptions struct {
 //			}
 //		}()
 func Watch(client *api.Client, key string, cfg encoding.BinaryUnmarshaler,
-	opts WatchOptions) error {
+	options ...WatchOption) error {
+
+	if client == nil {
+		return invalidConfig("cannot provide nil consul api.Client")
+	}
+	w, err := newKeyWatch(client, key, cfg, BuildWatchOptions(options...))
+	if err != nil {
+		return err
+	}
+	return w.run(context.Background(), client)
+}
+
+// WatchInto watches a key like Watch, decoding the value of the key into v on
+// change with the Codec registered with the provided name or content type, so
+// any struct pointer can be refreshed using its json, yaml, or toml struct tags
+// without implementing encoding.BinaryUnmarshaler:
+//
+//	cfg := &AppConfig{}
+//	err := konsul.WatchInto(client, "config/app", cfg, konsul.CodecJSON)
+//
+// When WatchOptions.Decoder is provided values are decoded into v with it
+// instead. Otherwise, when codec is empty and v implements
+// encoding.BinaryUnmarshaler or encoding.TextUnmarshaler its method is used,
+// and the format of each value is detected with DetectCodec if it implements
+// neither. If v isn't a non-nil pointer an error wrapping ErrInvalidConfig is
+// returned.
+func WatchInto(client *api.Client, key string, v any, codec string, options ...WatchOption) error {
+	if rv := reflect.ValueOf(v); rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return invalidConfig(fmt.Sprintf("v must be a non-nil pointer, got %T", v))
+	}
+	return Watch(client, key, target(v, codec, BuildWatchOptions(options...)), options...)
+}
+
+// target returns the encoding.BinaryUnmarshaler refreshing v with the values of
+// a key watched by WatchInto.
+func target(v any, codec string, opts WatchOptions) encoding.BinaryUnmarshaler {
+	if opts.Decoder != nil {
+		return DecodeWith(opts.Decoder, v)
+	}
+	if codec == "" {
+		switch t := v.(type) {
+		case encoding.BinaryUnmarshaler:
+			return t
+		case encoding.TextUnmarshaler:
+			return FromText(t)
+		}
+	}
+	return Decoder(codec, v)
+}
+
+// WatchContext watches a key like Watch until the context is cancelled, at which
+// point the watch is stopped and WatchContext returns nil. This allows watches
+// to be torn down during graceful shutdown or at the end of a test:
+//
+//	ctx, cancel := context.WithCancel(context.Background())
+//	defer cancel()
+//	go func() {
+//		if err := konsul.WatchContext(ctx, client, "config/app", cfg); err != nil {
+//			panic(err)
+//		}
+//	}()
+//
+// If the context is already cancelled WatchContext returns nil without
+// watching the key.
+func WatchContext(ctx context.Context, client *api.Client, key string, cfg encoding.BinaryUnmarshaler,
+	options ...WatchOption) error {
+
+	if client == nil {
+		return invalidConfig("cannot provide nil consul api.Client")
+	}
+	w, err := newKeyWatch(client, key, cfg, BuildWatchOptions(options...))
+	if err != nil {
+		return err
+	}
+	return w.run(ctx, client)
+}
+
+// runWatchPlan runs the plan until the context is cancelled. The plan retries
+// failed queries indefinitely with backoff rather than stopping, so each failed
+// query is sent to errs, if provided, as a *WatchError instead.
+func runWatchPlan(ctx context.Context, client *api.Client, key string, plan *watch.Plan, logger hclog.Logger,
+	errs chan<- error) error {
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	reportQueryErrors(plan, key, func(err error) {
+		sendWatchError(errs, logger, err)
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			logger.Debug("context cancelled, stopping watch", "key", key)
+			stopPlan(plan)
+		case <-done:
+		}
+	}()
+	if err := plan.RunWithClientAndHclog(client, logger); err != nil && ctx.Err() == nil {
+		return &WatchError{Key: key, Err: err}
+	}
+	return nil
+}
+
+// reportQueryErrors wraps the Watcher of the plan to invoke report with a
+// *WatchError each time a query fails, before the plan backs off and retries
+// it. Queries aborted by stopping the plan aren't reported.
+func reportQueryErrors(plan *watch.Plan, key string, report func(err error)) {
+	watcher := plan.Watcher
+	plan.Watcher = func(p *watch.Plan) (watch.BlockingParamVal, any, error) {
+		val, result, err := watcher(p)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			report(&WatchError{Key: key, Err: err})
+		}
+		return val, result, err
+	}
+}
+
+// sendWatchError sends the error to errs without blocking the watch, dropping
+// it if errs is full. It has no effect if errs is nil.
+func sendWatchError(errs chan<- error, logger hclog.Logger, err error) {
+	if errs == nil {
+		return
+	}
+	select {
+	case errs <- err:
+	default:
+		logger.Warn("dropped watch error since the Errors channel is full", "error", err)
+	}
+}
+
+// keyWatch refreshes cfg with the value of a key on change.
+type keyWatch struct {
+	client  *api.Client
+	key     string
+	cfg     encoding.BinaryUnmarshaler
+	opts    WatchOptions
+	logger  hclog.Logger
+	metrics Metrics
+	plan    *watch.Plan
 
-	// If a logger is provided in the options it will be used but if one isn't
-	// provided a default once is created.
-	logger := hclog.Default()
-	if opts.Logger != nil {
-		logger = opts.Logger
+	// The fields below are only accessed by the initial fetch and the handler
+	// of the plan, which the plan invokes sequentially.
+
+	// The ModifyIndex of the last value applied.
+	lastIndex uint64
+	// The SHA-256 hash of the raw last value successfully applied, if applied
+	// is true, used to skip changes with identical content. applied is reset
+	// when a failed change leaves cfg partially refreshed.
+	lastHash [sha256.Size]byte
+	applied  bool
+	// The last value successfully applied to cfg, restored on failure when
+	// RollbackOnFailure is enabled.
+	lastGood []byte
+}
+
+// newKeyWatch creates the watch plan refreshing cfg with the value of the key
+// on change.
+func newKeyWatch(client *api.Client, key string, cfg encoding.BinaryUnmarshaler, opts WatchOptions) (*keyWatch, error) {
+	if cfg == nil {
+		return nil, invalidConfig("cannot provide nil encoding.BinaryUnmarshaler")
 	}
 
+	logger := watchLogger(opts)
+
 	// If the cfg argument isn't a pointer log out a warning as this is likely not
 	// going to work as the caller intends.
 	if reflect.ValueOf(cfg).Type().Kind() != reflect.Pointer {
@@ -85,39 +374,276 @@ func Watch(client *api.Client, key string, cfg encoding.BinaryUnmarshaler,
 		"key":  key},
 	)
 	if err != nil {
-		return fmt.Errorf("failed to parse watch plan: %w", err)
+		return nil, fmt.Errorf("failed to parse watch plan: %w", err)
 	}
-
-	plan.Handler = func(u uint64, raw any) {
-		if raw == nil {
-			return
-		}
-		kv, ok := raw.(*api.KVPair)
-		if !ok {
-			logger.Error(fmt.Sprintf("expected type *api.KVPair but got %T", raw))
-			if opts.WatchNotification != nil {
-				opts.WatchNotification(key, fmt.Errorf("expected type *api.KVPair but got %T", raw))
+	if opts.scoped() {
+		scopePlan(plan, opts, func(q *api.QueryOptions) (any, *api.QueryMeta, error) {
+			pair, meta, err := client.KV().Get(key, q)
+			if pair == nil {
+				return nil, meta, err
 			}
-			return
-		}
+			return pair, meta, err
+		})
+	}
 
-		err := cfg.UnmarshalBinary(kv.Value)
-		if err != nil {
-			logger.Error(fmt.Sprintf("failed to unmarshall value for key %s to type %T", key, cfg),
-				"error", err)
-			if opts.WatchNotification != nil {
-				opts.WatchNotification(key, err)
-			}
-			if opts.PanicOnUnmarshalFailure {
-				panic(err)
+	w := &keyWatch{
+		client:  client,
+		key:     key,
+		cfg:     cfg,
+		opts:    opts,
+		logger:  logger,
+		metrics: metricsOrNop(opts.Metrics),
+		plan:    plan,
+	}
+	plan.Handler = w.handle
+	return w, nil
+}
+
+// run retrieves the key first if an initial fetch is configured, and then runs
+// the plan until it fails or the context is cancelled.
+func (w *keyWatch) run(ctx context.Context, client *api.Client) error {
+	if err := w.fetch(client); err != nil {
+		return err
+	}
+	return runWatchPlan(ctx, client, w.key, w.plan, w.logger, w.opts.Errors)
+}
+
+// fetch retrieves the key and applies its value before the key is watched when
+// FailFast, OnInitialFetch, or CacheFile is configured, falling back to the
+// cached value if the key cannot be retrieved. The error is only returned when
+// FailFast is enabled.
+func (w *keyWatch) fetch(client *api.Client) error {
+	if !w.opts.FailFast && w.opts.OnInitialFetch == nil && w.opts.CacheFile == "" {
+		return nil
+	}
+	kv, _, err := client.KV().Get(w.key, w.opts.queryOptions())
+	switch {
+	case err != nil:
+		err = &WatchError{Key: w.key, Err: err}
+		if w.opts.CacheFile != "" {
+			if cerr := w.loadCache(); cerr != nil {
+				w.logger.Warn(fmt.Sprintf("failed to load cached value of key %s", w.key), "error", cerr)
+			} else {
+				err = nil
 			}
+		}
+	case kv == nil:
+		err = &WatchError{Key: w.key, Err: ErrKeyNotFound}
+	default:
+		if kv, err = readChunks(client, kv, w.opts.queryOptions()); err != nil {
+			err = &WatchError{Key: w.key, Err: err}
+		} else if _, _, err = w.apply(kv); err != nil {
+			err = &WatchError{Key: w.key, Err: err}
 		} else {
-			logger.Info(fmt.Sprintf("successfully refreshed type %T for key %s", cfg, key))
-			if opts.WatchNotification != nil {
-				opts.WatchNotification(key, nil)
-			}
+			w.lastIndex = kv.ModifyIndex
+			w.lastHash, w.applied = sha256.Sum256(kv.Value), true
+			w.persist(kv.Value)
+		}
+	}
+	if err != nil {
+		w.logger.Warn(fmt.Sprintf("initial fetch of key %s failed", w.key), "error", err)
+		sendWatchError(w.opts.Errors, w.logger, err)
+	}
+	if w.opts.OnInitialFetch != nil {
+		w.opts.OnInitialFetch(w.key, err)
+	}
+	if w.opts.FailFast {
+		return err
+	}
+	return nil
+}
+
+// handle is the handler of the plan, invoked with the key-value each time the
+// key changes.
+func (w *keyWatch) handle(index uint64, raw any) {
+	if raw == nil {
+		return
+	}
+	kv, ok := raw.(*api.KVPair)
+	if !ok {
+		err := fmt.Errorf("expected type *api.KVPair but got %T", raw)
+		w.logger.Error(err.Error())
+		w.report(WatchChange{Key: w.key, Err: err})
+		sendWatchError(w.opts.Errors, w.logger, err)
+		return
+	}
+	// The first query of the plan returns the value applied by the initial
+	// fetch, unless the key changed since.
+	if w.lastIndex != 0 && kv.ModifyIndex == w.lastIndex {
+		return
+	}
+	// Values stored by PutLarge are reassembled from their chunks, which are
+	// written before the manifest so they're complete once it changes.
+	assembled, err := readChunks(w.client, kv, w.opts.queryOptions())
+	if err != nil {
+		w.logger.Error(fmt.Sprintf("failed to read chunks of key %s", w.key), "error", err)
+		w.report(WatchChange{Key: w.key, Old: w.lastGood, ModifyIndex: kv.ModifyIndex, Err: err})
+		sendWatchError(w.opts.Errors, w.logger, err)
+		return
+	}
+	kv = assembled
+	// The watch can fire with the same value after reconnecting, or when the
+	// key is written with its current value, which is skipped rather than
+	// unmarshalled and reported again.
+	hash := sha256.Sum256(kv.Value)
+	if w.applied && hash == w.lastHash {
+		w.lastIndex = kv.ModifyIndex
+		w.logger.Debug(fmt.Sprintf("skipping change of key %s identical to the value applied", w.key))
+		return
+	}
+
+	old := w.lastGood
+	start := time.Now()
+	value, unmarshalFailed, err := w.apply(kv)
+	observeWatchLatency(w.metrics, w.key, time.Since(start))
+	w.lastIndex = kv.ModifyIndex
+	if err == nil {
+		w.logger.Info(fmt.Sprintf("successfully refreshed type %T for key %s", w.cfg, w.key))
+		w.lastHash, w.applied = hash, true
+		w.persist(kv.Value)
+	}
+	w.report(WatchChange{Key: w.key, Old: old, New: value, ModifyIndex: kv.ModifyIndex, Err: err})
+	if err != nil {
+		sendWatchError(w.opts.Errors, w.logger, err)
+		// Values failing to be decoded or validated never reach cfg, so they're
+		// only reported.
+		if unmarshalFailed && w.opts.Errors == nil && w.opts.PanicOnUnmarshalFailure {
+			panic(err)
+		}
+	}
+}
+
+// report records the outcome of handling a change and invokes the callbacks.
+func (w *keyWatch) report(change WatchChange) {
+	w.metrics.WatchUpdate(w.key, change.Err)
+	if w.opts.WatchNotification != nil {
+		w.opts.WatchNotification(w.key, change.Err)
+	}
+	if w.opts.OnChange != nil {
+		w.opts.OnChange(change)
+	}
+}
+
+// apply decodes and validates the value of the key-value and refreshes cfg
+// with it, rolling back to the last good value on failure if configured. The
+// decoded value is returned, unless it couldn't be decoded, along with true if
+// the error is due to cfg failing to unmarshal the value rather than the value
+// failing to be decoded or validated.
+func (w *keyWatch) apply(kv *api.KVPair) (value []byte, unmarshalFailed bool, err error) {
+	value, err = decodeValue(w.opts.Encryption, w.opts.Compression, kv.Value)
+	if err != nil {
+		w.logger.Error(fmt.Sprintf("failed to decode value for key %s", w.key),
+			"error", err)
+		return nil, false, err
+	}
+	if err := w.opts.validate(w.key, value); err != nil {
+		w.logger.Error(fmt.Sprintf("rejected invalid value for key %s", w.key),
+			"error", err)
+		return value, false, err
+	}
+	if err := w.cfg.UnmarshalBinary(value); err != nil {
+		w.logger.Error(fmt.Sprintf("failed to unmarshall value for key %s to type %T", w.key, w.cfg),
+			"error", err)
+		restored := false
+		if w.opts.RollbackOnFailure && w.lastGood != nil {
+			rerr := rollback(w.key, w.cfg, w.lastGood, err)
+			w.logger.Warn(fmt.Sprintf("rolled back type %T to last known good value of key %s", w.cfg, w.key),
+				"error", rerr)
+			err, restored = rerr, rerr.RollbackErr == nil
+		}
+		// The failed unmarshal may have partially refreshed cfg, which no longer
+		// holds the last value applied unless it was rolled back, so a change
+		// back to that value must be applied rather than skipped as identical.
+		if !restored {
+			w.applied = false
 		}
+		return value, true, err
+	}
+	w.lastGood = value
+	return value, false, nil
+}
+
+// rollback refreshes cfg with the last good value after it failed to unmarshal
+// a change, returning the *RollbackError to report.
+func rollback(key string, cfg encoding.BinaryUnmarshaler, lastGood []byte, err error) *RollbackError {
+	return &RollbackError{
+		Key:         key,
+		Err:         err,
+		LastGood:    lastGood,
+		RollbackErr: cfg.UnmarshalBinary(lastGood),
+	}
+}
+
+// watchLogger returns the logger configured by the options. If a logger is
+// provided in the options it will be used but if one isn't provided a default
+// one is created.
+func watchLogger(opts WatchOptions) hclog.Logger {
+	logger := withLevel(HclogAdapter(opts.Logger), opts.LogLevel)
+	if !opts.Redact.IsZero() {
+		logger = redact.Wrap(logger, opts.Redact)
+	}
+	return logger
+}
+
+// Watcher watches keys in Consul's KV store. Application code can depend on
+// Watcher rather than calling Watch directly, allowing the watch layer to be
+// replaced with a fake in tests, such as the one provided by the konsultest
+// package.
+type Watcher interface {
+	// Watch watches a key and refreshes cfg with the value of the key on
+	// change. See the Watch function for details.
+	Watch(key string, cfg encoding.BinaryUnmarshaler, opts ...WatchOption) error
+}
+
+// ContextWatcher is a Watcher that can also watch keys until a context is
+// cancelled, allowing watches to be stopped on shutdown.
+type ContextWatcher interface {
+	Watcher
+	// WatchContext watches a key like Watch until the context is cancelled.
+	// See the WatchContext function for details.
+	WatchContext(ctx context.Context, key string, cfg encoding.BinaryUnmarshaler, opts ...WatchOption) error
+}
+
+// PrefixWatcher watches prefixes in Consul's KV store, allowing the watch layer
+// to be replaced with a fake in tests like Watcher.
+type PrefixWatcher interface {
+	// WatchPrefix watches every key under a prefix and invokes the handler
+	// with the changes. See the WatchPrefix function for details.
+	WatchPrefix(prefix string, handler PrefixHandler, opts ...WatchOption) error
+}
+
+// ClientWatcher is a Watcher watching keys with the Watch function using the
+// provided Consul api Client.
+type ClientWatcher struct {
+	client *api.Client
+}
+
+var (
+	_ Watcher        = ClientWatcher{}
+	_ ContextWatcher = ClientWatcher{}
+	_ PrefixWatcher  = ClientWatcher{}
+)
+
+// NewWatcher creates a Watcher using the provided Consul api Client. If the
+// client is nil an error wrapping ErrInvalidConfig is returned.
+func NewWatcher(client *api.Client) (ClientWatcher, error) {
+	if client == nil {
+		return ClientWatcher{}, invalidConfig("cannot provide nil consul api.Client")
 	}
+	return ClientWatcher{client: client}, nil
+}
+
+// Watch watches a key with the Watch function.
+func (w ClientWatcher) Watch(key string, cfg encoding.BinaryUnmarshaler, opts ...WatchOption) error {
+	return Watch(w.client, key, cfg, opts...)
+}
+
+// WatchPrefix watches every key under a prefix with the WatchPrefix function.
+func (w ClientWatcher) WatchPrefix(prefix string, handler PrefixHandler, opts ...WatchOption) error {
+	return WatchPrefix(w.client, prefix, handler, opts...)
+}
 
-	return plan.RunWithClientAndHclog(client, logger)
+// WatchContext watches a key with the WatchContext function.
+func (w ClientWatcher) WatchContext(ctx context.Context, key string, cfg encoding.BinaryUnmarshaler, opts ...WatchOption) error {
+	return WatchContext(ctx, w.client, key, cfg, opts...)
 }
@@ -0,0 +1,114 @@
+package konsul
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+)
+
+// partialTarget records every value it's refreshed with before rejecting the
+// invalid ones, like a type left partially refreshed by a failed unmarshal.
+type partialTarget struct {
+	value string
+}
+
+func (t *partialTarget) UnmarshalBinary(data []byte) error {
+	t.value = string(data)
+	if t.value == "bad" {
+		return errors.New("bad value")
+	}
+	return nil
+}
+
+func newTestKeyWatch(t *testing.T, cfg *partialTarget, opts ...WatchOption) *keyWatch {
+	t.Helper()
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	options := BuildWatchOptions(append([]WatchOption{WithLogger(hclog.NewNullLogger())}, opts...)...)
+	w, err := newKeyWatch(client, "config/app", cfg, options)
+	if err != nil {
+		t.Fatalf("failed to create watch: %v", err)
+	}
+	return w
+}
+
+func TestKeyWatch_RevertAfterFailedChange(t *testing.T) {
+	cfg := &partialTarget{}
+	w := newTestKeyWatch(t, cfg, WithErrors(make(chan error, 10)))
+
+	w.handle(1, &api.KVPair{Key: "config/app", Value: []byte("good"), ModifyIndex: 1})
+	w.handle(2, &api.KVPair{Key: "config/app", Value: []byte("bad"), ModifyIndex: 2})
+	if cfg.value != "bad" {
+		t.Fatalf("expected target to be partially refreshed, got %q", cfg.value)
+	}
+	w.handle(3, &api.KVPair{Key: "config/app", Value: []byte("good"), ModifyIndex: 3})
+	if cfg.value != "good" {
+		t.Errorf("expected revert to be applied, got %q", cfg.value)
+	}
+}
+
+func TestKeyWatch_RevertAfterRolledBackChange(t *testing.T) {
+	cfg := &partialTarget{}
+	w := newTestKeyWatch(t, cfg, WithErrors(make(chan error, 10)), WithRollbackOnFailure())
+
+	w.handle(1, &api.KVPair{Key: "config/app", Value: []byte("good"), ModifyIndex: 1})
+	w.handle(2, &api.KVPair{Key: "config/app", Value: []byte("bad"), ModifyIndex: 2})
+	if cfg.value != "good" {
+		t.Fatalf("expected target to be rolled back, got %q", cfg.value)
+	}
+	if !w.applied {
+		t.Errorf("expected rolled back value to remain applied")
+	}
+}
+
+func TestKeyWatch_PanicOnUnmarshalFailureIgnoresValidation(t *testing.T) {
+	cfg := &partialTarget{}
+	w := newTestKeyWatch(t, cfg, WithPanicOnUnmarshalFailure(), WithValidate(func(string, []byte) error {
+		return errors.New("invalid")
+	}))
+
+	w.handle(1, &api.KVPair{Key: "config/app", Value: []byte("good"), ModifyIndex: 1})
+	if cfg.value != "" {
+		t.Errorf("expected invalid value to be rejected, got %q", cfg.value)
+	}
+}
+
+func TestKeyWatch_PanicOnUnmarshalFailure(t *testing.T) {
+	cfg := &partialTarget{}
+	w := newTestKeyWatch(t, cfg, WithPanicOnUnmarshalFailure())
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected unmarshal failure to panic")
+		}
+	}()
+	w.handle(1, &api.KVPair{Key: "config/app", Value: []byte("bad"), ModifyIndex: 1})
+}
+
+func TestPrefixChange_ReassemblesChunkedValues(t *testing.T) {
+	value := []byte("chunked value")
+	sum := sha256.Sum256(value)
+	manifest := chunkManifest{Generation: "abc", Chunks: 2, Size: len(value), SHA256: hex.EncodeToString(sum[:])}
+	pairs := api.KVPairs{
+		{Key: "config/large", Value: manifest.marshal(), ModifyIndex: 3},
+		{Key: chunkKey("config/large", "abc", 0), Value: value[:7], ModifyIndex: 1},
+		{Key: chunkKey("config/large", "abc", 1), Value: value[7:], ModifyIndex: 2},
+	}
+
+	change, err := prefixChange("config/", pairs, make(map[string]uint64), WatchOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(change.Changed) != 1 {
+		t.Fatalf("expected only the chunked key to change, got %d keys", len(change.Changed))
+	}
+	if got := string(change.Changed[0].RawValue()); got != string(value) {
+		t.Errorf("expected the reassembled value, got %q", got)
+	}
+}
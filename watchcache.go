@@ -0,0 +1,63 @@
+package konsul
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// writeCacheFile atomically replaces the cache file with the value, so a crash
+// while writing never leaves a partially written value to load at startup. The
+// file is only readable by the owner since values may hold secrets.
+func writeCacheFile(path string, value []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace cache file: %w", err)
+	}
+	return nil
+}
+
+// persist writes the raw value of the key to the cache file, if configured.
+// Failures are logged rather than failing the change since the cache is only
+// used at startup.
+func (w *keyWatch) persist(raw []byte) {
+	if w.opts.CacheFile == "" {
+		return
+	}
+	if err := writeCacheFile(w.opts.CacheFile, raw); err != nil {
+		w.logger.Warn(fmt.Sprintf("failed to cache value of key %s", w.key), "error", err)
+	}
+}
+
+// loadCache refreshes cfg with the value in the cache file, after the key
+// couldn't be retrieved from Consul at startup.
+func (w *keyWatch) loadCache() error {
+	raw, err := os.ReadFile(w.opts.CacheFile)
+	if err != nil {
+		return fmt.Errorf("failed to read cache file: %w", err)
+	}
+	if _, _, err := w.apply(&api.KVPair{Key: w.key, Value: raw}); err != nil {
+		return fmt.Errorf("failed to apply cached value: %w", err)
+	}
+	w.lastHash, w.applied = sha256.Sum256(raw), true
+	w.logger.Warn(fmt.Sprintf("refreshed type %T with cached value of key %s, which may be stale", w.cfg, w.key),
+		"file", w.opts.CacheFile)
+	return nil
+}
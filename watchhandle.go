@@ -0,0 +1,174 @@
+package konsul
+
+import (
+	"encoding"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/api/watch"
+)
+
+// WatchHandle controls a watch started by StartWatch, providing lifecycle
+// control and introspection over a long-running watch, unlike the blocking
+// Watch function.
+//
+// WatchHandle is safe for concurrent use. The zero-value of WatchHandle is not
+// usable, use StartWatch to create a WatchHandle.
+type WatchHandle struct {
+	client *api.Client
+	key    string
+	cfg    encoding.BinaryUnmarshaler
+	opts   WatchOptions
+
+	mu        sync.Mutex
+	plan      *watch.Plan
+	done      chan struct{}
+	running   bool
+	lastIndex uint64
+	lastErr   error
+}
+
+// StartWatch watches a key like Watch on a new goroutine and returns a
+// WatchHandle controlling the watch:
+//
+//	w, err := konsul.StartWatch(client, "config/app", cfg)
+//	if err != nil {
+//		return err
+//	}
+//	defer w.Stop()
+//
+// If the client is nil or the options are invalid an error wrapping
+// ErrInvalidConfig is returned.
+func StartWatch(client *api.Client, key string, cfg encoding.BinaryUnmarshaler, options ...WatchOption) (*WatchHandle, error) {
+	if client == nil {
+		return nil, invalidConfig("cannot provide nil consul api.Client")
+	}
+	w := &WatchHandle{
+		client: client,
+		key:    key,
+		cfg:    cfg,
+		opts:   BuildWatchOptions(options...),
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.start(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Stop stops watching the key and waits for the watch to stop. Stopping a watch
+// that isn't running has no effect.
+func (w *WatchHandle) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stop()
+}
+
+// Restart stops the watch if it's running and starts watching the key again,
+// such as after it was stopped. The target is refreshed with the current
+// value of the key once the watch starts.
+func (w *WatchHandle) Restart() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stop()
+	return w.start()
+}
+
+// IsRunning returns true if the key is being watched, or false if the watch was
+// stopped. Failed queries don't stop the watch, they're retried with backoff
+// and reported by LastError.
+func (w *WatchHandle) IsRunning() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.running
+}
+
+// LastIndex returns the index of the last change to the key received, or 0 if
+// no change was received yet.
+func (w *WatchHandle) LastIndex() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastIndex
+}
+
+// LastError returns the error of the last change to the key, or the
+// *WatchError of the last failed query. It returns nil once a change is handled
+// successfully.
+func (w *WatchHandle) LastError() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastErr
+}
+
+// Done returns a channel closed once the current watch stops because Stop or
+// Restart was called.
+func (w *WatchHandle) Done() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.done
+}
+
+// start creates and runs a new watch plan. The caller must hold w.mu.
+func (w *WatchHandle) start() error {
+	opts := w.opts
+	notify := opts.WatchNotification
+	opts.WatchNotification = func(key string, err error) {
+		w.mu.Lock()
+		w.lastErr = err
+		w.mu.Unlock()
+		if notify != nil {
+			notify(key, err)
+		}
+	}
+	kw, err := newKeyWatch(w.client, w.key, w.cfg, opts)
+	if err != nil {
+		return err
+	}
+	if err := kw.fetch(w.client); err != nil {
+		return err
+	}
+	plan, logger := kw.plan, kw.logger
+	reportQueryErrors(plan, w.key, func(err error) {
+		w.mu.Lock()
+		w.lastErr = err
+		w.mu.Unlock()
+		sendWatchError(w.opts.Errors, logger, err)
+	})
+	handler := plan.Handler
+	plan.Handler = func(index uint64, raw any) {
+		w.mu.Lock()
+		w.lastIndex = index
+		w.mu.Unlock()
+		handler(index, raw)
+	}
+
+	done := make(chan struct{})
+	w.plan, w.done, w.running = plan, done, true
+	go func() {
+		defer close(done)
+		if err := plan.RunWithClientAndHclog(w.client, logger); err != nil {
+			logger.Error("watch stopped", "key", w.key, "error", err)
+		}
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.plan == plan {
+			w.running = false
+		}
+	}()
+	return nil
+}
+
+// stop stops the current watch plan and waits for it to return. The caller must
+// hold w.mu, which is released while waiting, so a plan started concurrently
+// meanwhile is stopped as well.
+func (w *WatchHandle) stop() {
+	for w.plan != nil {
+		plan, done := w.plan, w.done
+		w.plan, w.running = nil, false
+		stopPlan(plan)
+		w.mu.Unlock()
+		<-done
+		w.mu.Lock()
+	}
+}
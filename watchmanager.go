@@ -0,0 +1,214 @@
+package konsul
+
+import (
+	"encoding"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// WatchStatus is the status of a key watched by a WatchManager.
+type WatchStatus struct {
+	Key string
+	// True if the key is being watched.
+	Running bool
+	// The index of the last change to the key received, or 0 if no change was
+	// received yet.
+	LastIndex uint64
+	// The error of the last change to the key, or the error the watch failed
+	// with.
+	LastError error
+}
+
+// WatchManager watches many keys, each refreshing its own target, under shared
+// lifecycle control, rather than managing a goroutine and error path per key:
+//
+//	m, err := konsul.NewWatchManager(client, konsul.WithLogger(logger))
+//	m.Register("config/app/db", dbConfig)
+//	m.Register("config/app/cache", cacheConfig)
+//	if err := m.StartAll(); err != nil {
+//		return err
+//	}
+//	defer m.StopAll()
+//
+// WatchManager is safe for concurrent use. The zero-value of WatchManager is not
+// usable, use NewWatchManager to create a WatchManager.
+type WatchManager struct {
+	client *api.Client
+	opts   []WatchOption
+
+	mu       sync.Mutex
+	started  bool
+	bindings map[string]*watchBinding
+}
+
+type watchBinding struct {
+	cfg    encoding.BinaryUnmarshaler
+	opts   []WatchOption
+	handle *WatchHandle
+}
+
+// NewWatchManager creates a WatchManager watching keys with the client. The
+// options apply to every key, and options provided to Register take precedence
+// over them. If the client is nil an error wrapping ErrInvalidConfig is
+// returned.
+func NewWatchManager(client *api.Client, opts ...WatchOption) (*WatchManager, error) {
+	if client == nil {
+		return nil, invalidConfig("cannot provide nil consul api.Client")
+	}
+	return &WatchManager{
+		client:   client,
+		opts:     opts,
+		bindings: make(map[string]*watchBinding),
+	}, nil
+}
+
+// Register binds the key to the target refreshed with its value on change. If
+// the WatchManager was started the key is watched immediately, otherwise once
+// StartAll is called. If the key is already registered or cfg is nil an error
+// wrapping ErrInvalidConfig is returned.
+func (m *WatchManager) Register(key string, cfg encoding.BinaryUnmarshaler, opts ...WatchOption) error {
+	if cfg == nil {
+		return invalidConfig("cannot provide nil encoding.BinaryUnmarshaler")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.bindings[key]; ok {
+		return invalidConfig(fmt.Sprintf("key %s is already registered", key))
+	}
+	b := &watchBinding{cfg: cfg, opts: append(append([]WatchOption{}, m.opts...), opts...)}
+	m.bindings[key] = b
+	if m.started {
+		return m.start(key, b)
+	}
+	return nil
+}
+
+// Unregister stops watching the key and removes it from the WatchManager.
+// Unregistering a key that isn't registered has no effect.
+func (m *WatchManager) Unregister(key string) {
+	m.mu.Lock()
+	var handle *WatchHandle
+	if b, ok := m.bindings[key]; ok {
+		handle = b.handle
+		delete(m.bindings, key)
+	}
+	m.mu.Unlock()
+	if handle != nil {
+		handle.Stop()
+	}
+}
+
+// StartAll starts watching every registered key that isn't being watched,
+// including keys whose watch failed, and keys registered afterwards are
+// watched immediately. Keys are started even if others fail to start, and the
+// first error is returned.
+func (m *WatchManager) StartAll() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started = true
+	var first error
+	for _, key := range m.keys() {
+		if err := m.start(key, m.bindings[key]); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// StopAll stops watching every registered key and waits for the watches to
+// stop. Keys remain registered and are watched again by StartAll.
+func (m *WatchManager) StopAll() {
+	m.mu.Lock()
+	m.started = false
+	handles := make([]*WatchHandle, 0, len(m.bindings))
+	for _, b := range m.bindings {
+		if b.handle != nil {
+			handles = append(handles, b.handle)
+		}
+	}
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, h := range handles {
+		wg.Add(1)
+		go func(h *WatchHandle) {
+			defer wg.Done()
+			h.Stop()
+		}(h)
+	}
+	wg.Wait()
+}
+
+// Status returns the status of the key and true, or false if the key isn't
+// registered.
+func (m *WatchManager) Status(key string) (WatchStatus, bool) {
+	m.mu.Lock()
+	b, ok := m.bindings[key]
+	var handle *WatchHandle
+	if ok {
+		handle = b.handle
+	}
+	m.mu.Unlock()
+	if !ok {
+		return WatchStatus{}, false
+	}
+	return watchStatus(key, handle), true
+}
+
+// Statuses returns the status of every registered key, sorted by key.
+func (m *WatchManager) Statuses() []WatchStatus {
+	m.mu.Lock()
+	keys := m.keys()
+	handles := make([]*WatchHandle, len(keys))
+	for i, key := range keys {
+		handles[i] = m.bindings[key].handle
+	}
+	m.mu.Unlock()
+
+	statuses := make([]WatchStatus, len(keys))
+	for i, key := range keys {
+		statuses[i] = watchStatus(key, handles[i])
+	}
+	return statuses
+}
+
+// start watches the key if it isn't being watched. The caller must hold m.mu.
+func (m *WatchManager) start(key string, b *watchBinding) error {
+	if b.handle == nil {
+		handle, err := StartWatch(m.client, key, b.cfg, b.opts...)
+		if err != nil {
+			return err
+		}
+		b.handle = handle
+		return nil
+	}
+	if b.handle.IsRunning() {
+		return nil
+	}
+	return b.handle.Restart()
+}
+
+// keys returns the registered keys sorted. The caller must hold m.mu.
+func (m *WatchManager) keys() []string {
+	keys := make([]string, 0, len(m.bindings))
+	for key := range m.bindings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// watchStatus returns the status of the key watched by the handle, which is nil
+// if the key was never started.
+func watchStatus(key string, h *WatchHandle) WatchStatus {
+	status := WatchStatus{Key: key}
+	if h != nil {
+		status.Running = h.IsRunning()
+		status.LastIndex = h.LastIndex()
+		status.LastError = h.LastError()
+	}
+	return status
+}
@@ -0,0 +1,244 @@
+package konsul
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/api/watch"
+	"github.com/hashicorp/go-hclog"
+)
+
+// PrefixChange is the set of changes to the keys under a prefix watched by
+// WatchPrefix.
+type PrefixChange struct {
+	// The prefix being watched.
+	Prefix string
+	// The key-values created or modified since the last change, sorted by key.
+	// On the first change every key under the prefix is included.
+	Changed []KeyValue
+	// The keys deleted since the last change, sorted.
+	Deleted []string
+}
+
+// Keys returns the keys of the key-values that changed, sorted.
+func (c PrefixChange) Keys() []string {
+	keys := make([]string, len(c.Changed))
+	for i, kv := range c.Changed {
+		keys[i] = kv.Key()
+	}
+	return keys
+}
+
+// Relative returns the key with the watched prefix removed.
+func (c PrefixChange) Relative(key string) string {
+	return strings.TrimPrefix(key, c.Prefix)
+}
+
+// PrefixHandler handles the changes to the keys under a prefix watched by
+// WatchPrefix. A non-nil error is handled like an unmarshalling failure of
+// Watch.
+type PrefixHandler func(change PrefixChange) error
+
+// WatchPrefix watches every key under a prefix in Consul's KV store and invokes
+// the handler with the key-values that changed each time keys under the prefix
+// are created, modified, or deleted. This is useful for configuration split
+// across many keys under one prefix, which Watch would require a watch per key
+// for.
+//
+// Use Targets to refresh a type per key, like Watch does:
+//
+//	err := konsul.WatchPrefix(client, "config/app/", konsul.Targets(map[string]encoding.BinaryUnmarshaler{
+//		"config/app/db":    dbConfig,
+//		"config/app/cache": cacheConfig,
+//	}))
+//
+// WatchPrefix is configured with the same WatchOptions as Watch: values are
+// decrypted, decompressed, and validated against the Schemas before being
+// passed to the handler. Keys whose value cannot be decoded or is invalid are
+// left out of the change and reported like unmarshalling failures. Values
+// stored with PutLarge are reassembled from their chunks, which are never
+// passed to the handler themselves.
+//
+// WatchPrefix is blocking and retries failed queries indefinitely with backoff,
+// reporting them on WatchOptions.Errors, so it only returns an error if the
+// watch cannot be created. Use WatchPrefixContext to stop watching the prefix.
+func WatchPrefix(client *api.Client, prefix string, handler PrefixHandler, options ...WatchOption) error {
+	return WatchPrefixContext(context.Background(), client, prefix, handler, options...)
+}
+
+// WatchPrefixContext watches a prefix like WatchPrefix until the context is
+// cancelled, at which point the watch is stopped and WatchPrefixContext
+// returns nil.
+func WatchPrefixContext(ctx context.Context, client *api.Client, prefix string, handler PrefixHandler,
+	options ...WatchOption) error {
+
+	if client == nil {
+		return invalidConfig("cannot provide nil consul api.Client")
+	}
+	opts := BuildWatchOptions(options...)
+	plan, logger, err := newPrefixWatchPlan(client, prefix, handler, opts)
+	if err != nil {
+		return err
+	}
+	return runWatchPlan(ctx, client, prefix, plan, logger, opts.Errors)
+}
+
+// Targets returns a PrefixHandler refreshing a type per key with the value of
+// the key on change, mapping the keys under a watched prefix into a registry of
+// targets. Keys without a target are ignored, and deleted keys leave their
+// target unchanged. If refreshing targets fails the other targets are still
+// refreshed and the first error is returned.
+func Targets(targets map[string]encoding.BinaryUnmarshaler) PrefixHandler {
+	return func(change PrefixChange) error {
+		var first error
+		for _, kv := range change.Changed {
+			target, ok := targets[kv.Key()]
+			if !ok {
+				continue
+			}
+			if err := target.UnmarshalBinary(kv.RawValue()); err != nil && first == nil {
+				first = fmt.Errorf("failed to unmarshall value for key %s to type %T: %w", kv.Key(), target, err)
+			}
+		}
+		return first
+	}
+}
+
+// newPrefixWatchPlan creates the watch plan invoking the handler with the
+// changes to the keys under the prefix, along with the logger the plan should
+// be run with.
+func newPrefixWatchPlan(client *api.Client, prefix string, handler PrefixHandler, opts WatchOptions) (*watch.Plan, hclog.Logger, error) {
+	if handler == nil {
+		return nil, nil, invalidConfig("cannot provide nil PrefixHandler")
+	}
+	logger := watchLogger(opts)
+	metrics := metricsOrNop(opts.Metrics)
+
+	plan, err := watch.Parse(map[string]any{
+		"type":   "keyprefix",
+		"prefix": prefix},
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse watch plan: %w", err)
+	}
+	if opts.scoped() {
+		scopePlan(plan, opts, func(q *api.QueryOptions) (any, *api.QueryMeta, error) {
+			pairs, meta, err := client.KV().List(prefix, q)
+			return pairs, meta, err
+		})
+	}
+
+	// The ModifyIndex of each key last handled, used to determine which keys
+	// changed. The plan invokes the handler sequentially.
+	indexes := make(map[string]uint64)
+	plan.Handler = func(u uint64, raw any) {
+		pairs, ok := raw.(api.KVPairs)
+		if !ok && raw != nil {
+			err := fmt.Errorf("expected type api.KVPairs but got %T", raw)
+			logger.Error(err.Error())
+			metrics.WatchUpdate(prefix, err)
+			if opts.WatchNotification != nil {
+				opts.WatchNotification(prefix, err)
+			}
+			return
+		}
+
+		start := time.Now()
+		change, err := prefixChange(prefix, pairs, indexes, opts)
+		if len(change.Changed) == 0 && len(change.Deleted) == 0 && err == nil {
+			return
+		}
+		if err != nil {
+			logger.Error(fmt.Sprintf("failed to decode values under prefix %s", prefix), "error", err)
+		}
+		herr := handler(change)
+		if herr != nil {
+			logger.Error(fmt.Sprintf("failed to handle changes under prefix %s", prefix), "error", herr)
+			if err == nil {
+				err = herr
+			}
+		}
+		observeWatchLatency(metrics, prefix, time.Since(start))
+		if err != nil {
+			metrics.WatchUpdate(prefix, err)
+			if opts.WatchNotification != nil {
+				opts.WatchNotification(prefix, err)
+			}
+			sendWatchError(opts.Errors, logger, err)
+			// Values failing to be decoded or validated are left out of the
+			// change and only reported, like Watch.
+			if herr != nil && opts.Errors == nil && opts.PanicOnUnmarshalFailure {
+				panic(herr)
+			}
+			return
+		}
+		logger.Info(fmt.Sprintf("successfully handled changes under prefix %s", prefix),
+			"changed", len(change.Changed), "deleted", len(change.Deleted))
+		metrics.WatchUpdate(prefix, nil)
+		if opts.WatchNotification != nil {
+			opts.WatchNotification(prefix, nil)
+		}
+	}
+
+	return plan, logger, nil
+}
+
+// prefixChange determines the keys that changed since the indexes were
+// recorded, decoding their values, and updates the indexes. Keys whose value
+// cannot be decoded are left out and the first error is returned, so they're
+// decoded again on the next change.
+func prefixChange(prefix string, pairs api.KVPairs, indexes map[string]uint64, opts WatchOptions) (PrefixChange, error) {
+	change := PrefixChange{Prefix: prefix}
+	var first error
+	seen := make(map[string]struct{}, len(pairs))
+	for _, pair := range pairs {
+		if pair == nil || IsChunkKey(pair.Key) {
+			continue
+		}
+		seen[pair.Key] = struct{}{}
+		if index, ok := indexes[pair.Key]; ok && index == pair.ModifyIndex {
+			continue
+		}
+		value, err := prefixValue(pair, pairs)
+		if err == nil {
+			value, err = decodeValue(opts.Encryption, opts.Compression, value)
+		}
+		if err == nil {
+			err = opts.validate(pair.Key, value)
+		}
+		if err != nil {
+			if first == nil {
+				first = fmt.Errorf("key %s: %w", pair.Key, err)
+			}
+			continue
+		}
+		indexes[pair.Key] = pair.ModifyIndex
+		decoded := *pair
+		decoded.Value = value
+		change.Changed = append(change.Changed, KeyValue{base: &decoded})
+	}
+	for key := range indexes {
+		if _, ok := seen[key]; !ok {
+			delete(indexes, key)
+			change.Deleted = append(change.Deleted, key)
+		}
+	}
+	sort.Slice(change.Changed, func(i, j int) bool { return change.Changed[i].Key() < change.Changed[j].Key() })
+	sort.Strings(change.Deleted)
+	return change, first
+}
+
+// prefixValue returns the raw value of the pair, reassembling it from the
+// chunks listed along with it if it was stored by PutLarge.
+func prefixValue(pair *api.KVPair, pairs api.KVPairs) ([]byte, error) {
+	manifest, chunked, err := parseChunkManifest(pair.Value)
+	if err != nil || !chunked {
+		return pair.Value, err
+	}
+	return assembleChunks(pair.Key, manifest, pairs)
+}
@@ -0,0 +1,62 @@
+package konsul
+
+import (
+	"context"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/api/watch"
+
+	"github.com/jkratz55/konsul/internal/blocking"
+)
+
+// cancelExempt is the exempt parameter of a watch plan holding the function
+// cancelling the in-flight query of a scoped plan.
+const cancelExempt = "konsul_cancel"
+
+// scoped returns true if the options scope the watch to a datacenter,
+// namespace, partition, or ACL token other than the defaults of the client.
+func (o WatchOptions) scoped() bool {
+	return o.Datacenter != "" || o.Namespace != "" || o.Partition != "" || o.Token != ""
+}
+
+// queryOptions returns the QueryOptions scoping queries of the watched key to
+// the configured datacenter, namespace, partition, and ACL token.
+func (o WatchOptions) queryOptions() *api.QueryOptions {
+	return &api.QueryOptions{
+		Datacenter: o.Datacenter,
+		Namespace:  o.Namespace,
+		Partition:  o.Partition,
+		Token:      o.Token,
+	}
+}
+
+// scopePlan replaces the Watcher of the plan with one performing the blocking
+// query itself, since the watches provided by the Consul watch package only use
+// the defaults of the client they're run with. The in-flight query is aborted
+// when the plan is stopped with stopPlan.
+func scopePlan(plan *watch.Plan, opts WatchOptions, query func(q *api.QueryOptions) (any, *api.QueryMeta, error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	plan.Exempt[cancelExempt] = cancel
+
+	// The watch Plan tracks the last index internally but doesn't expose it to
+	// custom watchers, so the index is tracked here to perform blocking queries.
+	var index blocking.Index
+	plan.Watcher = func(_ *watch.Plan) (watch.BlockingParamVal, any, error) {
+		q := opts.queryOptions()
+		q.WaitIndex = index.Wait()
+		result, meta, err := query(q.WithContext(ctx))
+		if err != nil {
+			return nil, nil, err
+		}
+		index.Update(meta.LastIndex)
+		return watch.WaitIndexVal(meta.LastIndex), result, nil
+	}
+}
+
+// stopPlan stops the plan, aborting its in-flight query if it's scoped.
+func stopPlan(plan *watch.Plan) {
+	if cancel, ok := plan.Exempt[cancelExempt].(context.CancelFunc); ok {
+		cancel()
+	}
+	plan.Stop()
+}
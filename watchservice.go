@@ -0,0 +1,99 @@
+package konsul
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+)
+
+// ServiceHandler handles the entries of a service watched by WatchService.
+type ServiceHandler func(entries []*api.ServiceEntry)
+
+// WatchService watches the entries of a service registered in Consul and
+// invokes the handler with the current entries each time they change, for
+// applications that need the raw service change events, such as the health
+// checks or metadata of each instance, rather than the addresses yielded by
+// Instancer:
+//
+//	err := konsul.WatchService(client, "orders", func(entries []*api.ServiceEntry) {
+//		...
+//	}, konsul.WithPassingOnly())
+//
+// WatchService is configured with the same options as Instancer, such as
+// WithTag, WithTags, WithPassingOnly, WithPeer, WithFilter, and WithBackend.
+// OnPlanError, OnError, and Restart are ignored since the error is returned
+// instead, and HealthPolicy is ignored since every entry is provided along
+// with its health checks. If the service isn't provided or the options are
+// invalid an error wrapping ErrInvalidConfig is returned.
+//
+// WatchService is blocking and retries failed queries indefinitely with
+// backoff, so it only returns an error if the watch cannot be created. Use
+// WatchServiceContext to stop watching the service.
+func WatchService(client *api.Client, service string, handler ServiceHandler, opts ...InstancerOption) error {
+	return WatchServiceContext(context.Background(), client, service, handler, opts...)
+}
+
+// WatchServiceContext watches a service like WatchService until the context is
+// cancelled, at which point the watch is stopped and WatchServiceContext
+// returns nil.
+func WatchServiceContext(ctx context.Context, client *api.Client, service string, handler ServiceHandler,
+	opts ...InstancerOption) error {
+
+	config := InstancerConfig{Client: client, Service: service}
+	for _, opt := range opts {
+		if opt != nil {
+			opt.applyInstancer(&config)
+		}
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if handler == nil {
+		return invalidConfig("cannot provide nil ServiceHandler")
+	}
+	if config.Logger == nil {
+		config.Logger = hclog.Default()
+	}
+	logger := withLevel(HclogAdapter(config.Logger), config.LogLevel)
+	metrics := metricsOrNop(config.Metrics)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	plan, err := newServicePlan(ctx, config)
+	if err != nil {
+		return err
+	}
+	plan.Handler = func(_ uint64, data any) {
+		entries, ok := data.([]*api.ServiceEntry)
+		if !ok {
+			logger.Error(fmt.Sprintf("handler received unexpected type, expected []*api.ServiceEntry but got %T", data))
+			return
+		}
+		logger.Debug("service entries changed", "service", service, "entries", len(entries))
+		metrics.InstancesChanged(service, len(entries))
+		handler(entries)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			plan.Stop()
+		case <-done:
+		}
+	}()
+	if err := plan.RunWithClientAndHclog(client, logger); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		logger.Error("plan encountered an error while executing", "error", err, "service", service)
+		return &DiscoveryError{
+			Service: service,
+			Err:     fmt.Errorf("plan stopped running due to error: %w", err),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package konsul
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/api/watch"
+)
+
+// CheckHandler handles the health checks watched by WatchChecks.
+type CheckHandler func(checks []*api.HealthCheck)
+
+// NodeHandler handles the nodes watched by WatchNodes.
+type NodeHandler func(nodes []*api.Node)
+
+// EventHandler handles the user events watched by WatchEvents.
+type EventHandler func(events []*api.UserEvent)
+
+// CheckFilter limits the health checks watched by WatchChecks. At most one of
+// Service and State can be set. The zero-value watches every health check.
+type CheckFilter struct {
+	// The service to watch the health checks of.
+	Service string
+	// The state of the health checks to watch, such as api.HealthCritical, or
+	// api.HealthAny.
+	State string
+}
+
+func (f CheckFilter) validate() error {
+	if f.Service != "" && f.State != "" {
+		return invalidConfig("cannot filter health checks by both service and state")
+	}
+	return nil
+}
+
+// WatchChecks watches the health checks in Consul matching the filter and
+// invokes the handler with the current checks each time they change.
+//
+// WatchChecks is configured with the same WatchOptions as Watch, although only
+// the Logger, LogLevel, Metrics, and WatchNotification apply, with the watch
+// reported as "checks". WatchChecks is blocking and retries failed queries
+// indefinitely with backoff, reporting them on WatchOptions.Errors as a
+// *WatchError, so it only returns an error if the watch cannot be created. Use
+// WatchChecksContext to stop watching the checks.
+func WatchChecks(client *api.Client, filter CheckFilter, handler CheckHandler, opts ...WatchOption) error {
+	return WatchChecksContext(context.Background(), client, filter, handler, opts...)
+}
+
+// WatchChecksContext watches health checks like WatchChecks until the context
+// is cancelled, at which point the watch is stopped and WatchChecksContext
+// returns nil.
+func WatchChecksContext(ctx context.Context, client *api.Client, filter CheckFilter, handler CheckHandler,
+	opts ...WatchOption) error {
+
+	if err := filter.validate(); err != nil {
+		return err
+	}
+	if handler == nil {
+		return invalidConfig("cannot provide nil CheckHandler")
+	}
+	params := map[string]any{"type": "checks"}
+	if filter.Service != "" {
+		params["service"] = filter.Service
+	}
+	if filter.State != "" {
+		params["state"] = filter.State
+	}
+	return runTypedWatch(ctx, client, "checks", params, handler, BuildWatchOptions(opts...))
+}
+
+// WatchNodes watches the nodes registered in Consul's catalog and invokes the
+// handler with the current nodes each time they change.
+//
+// WatchNodes is configured like WatchChecks, with the watch reported as
+// "nodes". WatchNodes is blocking like WatchChecks. Use WatchNodesContext to
+// stop watching the nodes.
+func WatchNodes(client *api.Client, handler NodeHandler, opts ...WatchOption) error {
+	return WatchNodesContext(context.Background(), client, handler, opts...)
+}
+
+// WatchNodesContext watches nodes like WatchNodes until the context is
+// cancelled, at which point the watch is stopped and WatchNodesContext returns
+// nil.
+func WatchNodesContext(ctx context.Context, client *api.Client, handler NodeHandler, opts ...WatchOption) error {
+	if handler == nil {
+		return invalidConfig("cannot provide nil NodeHandler")
+	}
+	return runTypedWatch(ctx, client, "nodes", map[string]any{"type": "nodes"}, handler,
+		BuildWatchOptions(opts...))
+}
+
+// WatchEvents watches the user events fired in Consul with the name, or every
+// user event if the name is empty, and invokes the handler with the events
+// fired since the handler was last invoked. The first invocation includes the
+// recent events Consul still holds.
+//
+// WatchEvents is configured like WatchChecks, with the watch reported as
+// "event/<name>". WatchEvents is blocking like WatchChecks. Use
+// WatchEventsContext to stop watching the events.
+func WatchEvents(client *api.Client, name string, handler EventHandler, opts ...WatchOption) error {
+	return WatchEventsContext(context.Background(), client, name, handler, opts...)
+}
+
+// WatchEventsContext watches user events like WatchEvents until the context is
+// cancelled, at which point the watch is stopped and WatchEventsContext returns
+// nil.
+func WatchEventsContext(ctx context.Context, client *api.Client, name string, handler EventHandler,
+	opts ...WatchOption) error {
+
+	if handler == nil {
+		return invalidConfig("cannot provide nil EventHandler")
+	}
+	params := map[string]any{"type": "event"}
+	if name != "" {
+		params["name"] = name
+	}
+	return runTypedWatch(ctx, client, "event/"+name, params, handler, BuildWatchOptions(opts...))
+}
+
+// runTypedWatch runs a watch plan of the type described by params, invoking the
+// handler with each result of type T, until the context is cancelled.
+func runTypedWatch[T any](ctx context.Context, client *api.Client, name string, params map[string]any,
+	handler func(T), opts WatchOptions) error {
+
+	if client == nil {
+		return invalidConfig("cannot provide nil consul api.Client")
+	}
+	logger := watchLogger(opts)
+	metrics := metricsOrNop(opts.Metrics)
+
+	plan, err := watch.Parse(params)
+	if err != nil {
+		return fmt.Errorf("failed to parse watch plan: %w", err)
+	}
+	plan.Handler = func(_ uint64, raw any) {
+		result, ok := raw.(T)
+		if !ok {
+			var want T
+			err := fmt.Errorf("expected type %T but got %T", want, raw)
+			logger.Error(err.Error())
+			metrics.WatchUpdate(name, err)
+			if opts.WatchNotification != nil {
+				opts.WatchNotification(name, err)
+			}
+			sendWatchError(opts.Errors, logger, err)
+			return
+		}
+		handler(result)
+		logger.Debug(fmt.Sprintf("successfully handled change of %s", name))
+		metrics.WatchUpdate(name, nil)
+		if opts.WatchNotification != nil {
+			opts.WatchNotification(name, nil)
+		}
+	}
+	return runWatchPlan(ctx, client, name, plan, logger, opts.Errors)
+}
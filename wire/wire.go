@@ -0,0 +1,92 @@
+// Package wire provides google/wire provider sets for konsul components,
+// standardizing how konsul is integrated in large codebases.
+//
+// ProviderSet requires an *api.Config and provides the Consul api Client,
+// KVClient, Watcher, and Registry along with their interfaces:
+//
+//	func initializeApp() (*App, func(), error) {
+//		wire.Build(
+//			api.DefaultConfig,
+//			wire.Value(konsul.KVClientOptions{}),
+//			wire.Value(registry.Options{}),
+//			konsulwire.ProviderSet,
+//			newApp,
+//		)
+//		return nil, nil, nil
+//	}
+//
+// Providers returning a cleanup function, such as NewInstancer, have the
+// cleanup invoked by the cleanup function returned by the generated injector.
+package wire
+
+import (
+	"fmt"
+
+	"github.com/google/wire"
+	"github.com/hashicorp/consul/api"
+
+	"github.com/jkratz55/konsul"
+	"github.com/jkratz55/konsul/registry"
+)
+
+// ProviderSet provides a Consul api Client, a *konsul.KVClient bound to
+// konsul.KV, a konsul.ClientWatcher bound to konsul.Watcher, and a
+// *registry.ConsulRegistry bound to registry.Registry. It requires an
+// *api.Config, konsul.KVClientOptions, and registry.Options.
+var ProviderSet = wire.NewSet(
+	NewClient,
+	NewKVClient,
+	wire.Bind(new(konsul.KV), new(*konsul.KVClient)),
+	konsul.NewWatcher,
+	wire.Bind(new(konsul.Watcher), new(konsul.ClientWatcher)),
+	registry.NewConsulRegistry,
+	wire.Bind(new(registry.Registry), new(*registry.ConsulRegistry)),
+)
+
+// NewClient creates a Consul api Client from the provided configuration.
+func NewClient(cfg *api.Config) (*api.Client, error) {
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Consul client: %w", err)
+	}
+	return client, nil
+}
+
+// NewKVClient creates a KVClient with the provided options.
+func NewKVClient(client *api.Client, opts konsul.KVClientOptions) *konsul.KVClient {
+	return konsul.NewKVClient(client, opts)
+}
+
+// NewInstancer creates an Instancer with the provided configuration, using the
+// Consul api Client if the Client of the configuration is nil. The returned
+// cleanup function closes the Instancer.
+//
+// Since wire identifies dependencies by type, applications using multiple
+// Instancers should wrap NewInstancer in providers returning distinct types.
+func NewInstancer(client *api.Client, config konsul.InstancerConfig) (*konsul.Instancer, func(), error) {
+	if config.Client == nil {
+		config.Client = client
+	}
+	instancer, err := konsul.NewInstancer(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return instancer, instancer.Close, nil
+}
+
+// Registration is a service registered by NewRegistration.
+type Registration struct {
+	Service *registry.Service
+}
+
+// NewRegistration registers the service with the Registry and returns a
+// cleanup function deregistering it, tying the registration to the lifetime of
+// the injected application.
+func NewRegistration(reg registry.Registry, svc *registry.Service) (*Registration, func(), error) {
+	if err := reg.Register(svc); err != nil {
+		return nil, nil, err
+	}
+	return &Registration{Service: svc}, func() {
+		_ = reg.Deregister(svc)
+	}, nil
+}
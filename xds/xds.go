@@ -0,0 +1,285 @@
+// Package xds serves the state of Instancers as an Envoy endpoint discovery
+// service (EDS), letting konsul act as a lightweight control-plane data source
+// for Envoy sidecars or edge proxies in environments not running Consul Connect.
+//
+// The exporter implements the xDS REST-JSON protocol, so Envoy is configured
+// with an api_type of REST pointing at the handler:
+//
+//	eds_config:
+//	  api_config_source:
+//	    api_type: REST
+//	    transport_api_version: V3
+//	    cluster_names: [konsul_xds]
+//	    refresh_delay: 1s
+//
+// Each Instancer is exported as a ClusterLoadAssignment named after the cluster
+// it's added as. Clients sending the version they last received can optionally
+// long-poll, receiving a response as soon as the endpoints change.
+package xds
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/jkratz55/konsul"
+)
+
+// TypeURL is the xDS type URL of the resources served by Exporter.
+const TypeURL = "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment"
+
+// DiscoveryRequest is the JSON form of an xDS v3 DiscoveryRequest. Only the
+// fields used by Exporter are included.
+type DiscoveryRequest struct {
+	VersionInfo   string          `json:"version_info,omitempty"`
+	Node          json.RawMessage `json:"node,omitempty"`
+	ResourceNames []string        `json:"resource_names,omitempty"`
+	TypeURL       string          `json:"type_url,omitempty"`
+	ResponseNonce string          `json:"response_nonce,omitempty"`
+}
+
+// DiscoveryResponse is the JSON form of an xDS v3 DiscoveryResponse.
+type DiscoveryResponse struct {
+	VersionInfo string                  `json:"version_info"`
+	Resources   []ClusterLoadAssignment `json:"resources"`
+	TypeURL     string                  `json:"type_url"`
+	Nonce       string                  `json:"nonce"`
+}
+
+// ClusterLoadAssignment is the JSON form of an Envoy v3 ClusterLoadAssignment
+// as an Any resource.
+type ClusterLoadAssignment struct {
+	Type        string                `json:"@type"`
+	ClusterName string                `json:"cluster_name"`
+	Endpoints   []LocalityLbEndpoints `json:"endpoints"`
+}
+
+// LocalityLbEndpoints is the JSON form of an Envoy v3 LocalityLbEndpoints.
+type LocalityLbEndpoints struct {
+	LbEndpoints []LbEndpoint `json:"lb_endpoints"`
+}
+
+// LbEndpoint is the JSON form of an Envoy v3 LbEndpoint.
+type LbEndpoint struct {
+	Endpoint     Endpoint `json:"endpoint"`
+	HealthStatus string   `json:"health_status"`
+}
+
+// Endpoint is the JSON form of an Envoy v3 Endpoint.
+type Endpoint struct {
+	Address Address `json:"address"`
+}
+
+// Address is the JSON form of an Envoy v3 Address holding a SocketAddress.
+type Address struct {
+	SocketAddress SocketAddress `json:"socket_address"`
+}
+
+// SocketAddress is the JSON form of an Envoy v3 SocketAddress.
+type SocketAddress struct {
+	Address   string `json:"address"`
+	PortValue int    `json:"port_value"`
+}
+
+// Options holds optional configuration properties for Exporter.
+type Options struct {
+	// The maximum time a request is held open waiting for the endpoints to
+	// change when the client already has the current version. If not provided
+	// requests are answered immediately.
+	LongPollTimeout time.Duration
+	// The logger used to log updates and errors. If not provided a default
+	// logger will be used.
+	Logger konsul.Logger
+	// The Clock used to time out long polls. If not provided
+	// konsul.SystemClock is used.
+	Clock konsul.Clock
+}
+
+// Exporter is an http.Handler serving the instances of Instancers as Envoy
+// ClusterLoadAssignment resources.
+//
+// The zero-value of Exporter is not usable. Use NewExporter to create and
+// initialize an Exporter.
+type Exporter struct {
+	pollTimeout time.Duration
+	logger      hclog.Logger
+	clock       konsul.Clock
+
+	mu       sync.RWMutex
+	clusters map[string][]string
+	version  uint64
+	changed  chan struct{}
+}
+
+// NewExporter creates and initializes an Exporter with the provided options.
+func NewExporter(opts Options) *Exporter {
+	return &Exporter{
+		pollTimeout: opts.LongPollTimeout,
+		logger:      konsul.HclogAdapter(opts.Logger),
+		clock:       konsul.ClockOrSystem(opts.Clock),
+		clusters:    make(map[string][]string),
+		changed:     make(chan struct{}),
+	}
+}
+
+// AddInstancer exports the instances of the Instancer as the cluster with the
+// provided name. The exported endpoints are updated each time the instances
+// change. A nil Instancer or an empty cluster name will panic.
+func (e *Exporter) AddInstancer(cluster string, instancer *konsul.Instancer) {
+	if instancer == nil || cluster == "" {
+		panic("an Instancer and cluster name must be provided, illegal use of api")
+	}
+	instancer.RegisterListener(listener{exporter: e, cluster: cluster})
+}
+
+// Update sets the endpoints of the cluster, as host:port, directly. This allows
+// endpoints from sources other than Instancer to be exported.
+func (e *Exporter) Update(cluster string, instances []string) {
+	sorted := make([]string, len(instances))
+	copy(sorted, instances)
+	sort.Strings(sorted)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if current, ok := e.clusters[cluster]; ok && equal(current, sorted) {
+		return
+	}
+	e.clusters[cluster] = sorted
+	e.version++
+	close(e.changed)
+	e.changed = make(chan struct{})
+	e.logger.Debug("exported endpoints updated",
+		"cluster", cluster,
+		"endpoints", len(sorted),
+		"version", e.version)
+}
+
+// ServeHTTP handles xDS REST-JSON discovery requests for endpoints.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req DiscoveryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid discovery request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if req.TypeURL != "" && req.TypeURL != TypeURL {
+		http.Error(w, fmt.Sprintf("unsupported type url %s", req.TypeURL), http.StatusBadRequest)
+		return
+	}
+
+	e.mu.RLock()
+	version, changed := e.version, e.changed
+	e.mu.RUnlock()
+
+	if e.pollTimeout > 0 && req.VersionInfo == strconv.FormatUint(version, 10) {
+		timer := e.clock.NewTimer(e.pollTimeout)
+		defer timer.Stop()
+		select {
+		case <-changed:
+		case <-timer.C():
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	resp := e.response(req.ResourceNames)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		e.logger.Error("failed to write discovery response", "error", err)
+	}
+}
+
+// response builds a DiscoveryResponse for the requested clusters, or all
+// clusters if names is empty.
+func (e *Exporter) response(names []string) DiscoveryResponse {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if len(names) == 0 {
+		names = make([]string, 0, len(e.clusters))
+		for name := range e.clusters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	resources := make([]ClusterLoadAssignment, 0, len(names))
+	for _, name := range names {
+		instances, ok := e.clusters[name]
+		if !ok {
+			continue
+		}
+		endpoints := make([]LbEndpoint, 0, len(instances))
+		for _, instance := range instances {
+			host, portStr, err := net.SplitHostPort(instance)
+			if err != nil {
+				e.logger.Warn("skipping invalid instance address",
+					"cluster", name,
+					"instance", instance,
+					"error", err)
+				continue
+			}
+			port, err := strconv.Atoi(portStr)
+			if err != nil {
+				e.logger.Warn("skipping invalid instance port",
+					"cluster", name,
+					"instance", instance,
+					"error", err)
+				continue
+			}
+			endpoints = append(endpoints, LbEndpoint{
+				Endpoint: Endpoint{
+					Address: Address{
+						SocketAddress: SocketAddress{Address: host, PortValue: port},
+					},
+				},
+				HealthStatus: "HEALTHY",
+			})
+		}
+		resources = append(resources, ClusterLoadAssignment{
+			Type:        TypeURL,
+			ClusterName: name,
+			Endpoints:   []LocalityLbEndpoints{{LbEndpoints: endpoints}},
+		})
+	}
+
+	version := strconv.FormatUint(e.version, 10)
+	return DiscoveryResponse{
+		VersionInfo: version,
+		Resources:   resources,
+		TypeURL:     TypeURL,
+		Nonce:       version,
+	}
+}
+
+type listener struct {
+	exporter *Exporter
+	cluster  string
+}
+
+func (l listener) OnChange(instances []string) {
+	l.exporter.Update(l.cluster, instances)
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}